@@ -0,0 +1,61 @@
+package ign
+
+import (
+  "encoding/json"
+  "fmt"
+  "time"
+)
+
+// Access log formats. See Server.AccessLogFormat.
+const (
+  // AccessLogText writes tab separated lines:
+  // method uri route status size duration client_ip
+  AccessLogText = "text"
+  // AccessLogJSON writes a JSON object per request.
+  AccessLogJSON = "json"
+  // AccessLogFields passes the request information as structured fields to
+  // the Logger. Useful with structured loggers (eg. logrus or zerolog).
+  AccessLogFields = "fields"
+)
+
+// accessLogEntry contains the information logged for each request.
+type accessLogEntry struct {
+  Method string `json:"method"`
+  URI string `json:"uri"`
+  Route string `json:"route"`
+  Status int `json:"status"`
+  Size int `json:"size"`
+  Duration time.Duration `json:"-"`
+  DurationMs float64 `json:"duration_ms"`
+  // Client IP address. See ClientIP.
+  ClientIP string `json:"client_ip"`
+}
+
+// logAccess writes an access log entry using the server's access log format.
+func logAccess(e accessLogEntry) {
+  format := AccessLogText
+  if gServer != nil && gServer.AccessLogFormat != "" {
+    format = gServer.AccessLogFormat
+  }
+  e.DurationMs = float64(e.Duration) / float64(time.Millisecond)
+
+  switch format {
+  case AccessLogJSON:
+    line, _ := json.Marshal(e)
+    gLogger.Info(string(line), nil)
+  case AccessLogFields:
+    gLogger.Info("Request", Fields{
+      "method": e.Method,
+      "uri": e.URI,
+      "route": e.Route,
+      "status": e.Status,
+      "size": e.Size,
+      "duration": e.Duration,
+      "client_ip": e.ClientIP,
+    })
+  default:
+    gLogger.Info(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%s\t%s", e.Method, e.URI,
+                             e.Route, e.Status, e.Size, e.Duration,
+                             e.ClientIP), nil)
+  }
+}
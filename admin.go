@@ -0,0 +1,323 @@
+package ign
+
+import (
+  "net/http"
+)
+
+// Admin routes expose runtime controls to operators. They are appended to
+// the application routes by Init when IGN_ADMIN_ROUTES is "true", or
+// manually using Server.NewAdminRoutes, and require a token granted
+// Server.AdminScope (IGN_ADMIN_SCOPE, "admin" by default):
+// - GET/PUT /admin/log-level gets or sets the log level of the StdLogger.
+// - GET/PUT /admin/maintenance gets or sets the maintenance mode. While it is
+// enabled, the application routes reply with ErrorMaintenance.
+// - POST /admin/cache/flush clears the token cache and the caches
+// registered with Server.AddCacheFlusher.
+// - GET /admin/queues returns the status of the mail and Google Analytics
+// queues, and of the queues registered with Server.AddQueueStatus.
+// - GET /admin/config returns the server configuration, with the secrets
+// redacted.
+// The admin routes are served during maintenance, and while the database is
+// down.
+
+// AdminPathPrefix is the path prefix of the admin routes.
+const AdminPathPrefix = "/admin"
+
+// defaultAdminScope is the scope required by the admin routes if
+// Server.AdminScope is empty.
+const defaultAdminScope = "admin"
+
+// QueueStatus is the status of a worker queue returned by GET /admin/queues.
+type QueueStatus struct {
+  // Pending is the number of queued items.
+  Pending int `json:"pending"`
+  // Capacity is the max number of queued items. Zero means unknown or no
+  // limit.
+  Capacity int `json:"capacity,omitempty"`
+}
+
+// MaintenanceStatus is the maintenance mode of the server.
+type MaintenanceStatus struct {
+  Enabled bool `json:"enabled"`
+  // Message, if set, replaces the message of the ErrorMaintenance errors.
+  Message string `json:"message,omitempty"`
+}
+
+// logLevelBody is the body of the log level routes.
+type logLevelBody struct {
+  Level string `json:"level"`
+}
+
+// AddCacheFlusher registers a cache cleared by POST /admin/cache/flush.
+func (s *Server) AddCacheFlusher(name string, flush func() error) {
+  s.adminMutex.Lock()
+  defer s.adminMutex.Unlock()
+  if s.cacheFlushers == nil {
+    s.cacheFlushers = make(map[string]func() error)
+  }
+  s.cacheFlushers[name] = flush
+}
+
+// AddQueueStatus registers a worker queue reported by GET /admin/queues.
+func (s *Server) AddQueueStatus(name string, status func() QueueStatus) {
+  s.adminMutex.Lock()
+  defer s.adminMutex.Unlock()
+  if s.queueStatuses == nil {
+    s.queueStatuses = make(map[string]func() QueueStatus)
+  }
+  s.queueStatuses[name] = status
+}
+
+// SetMaintenance enables or disables the maintenance mode.
+func (s *Server) SetMaintenance(status MaintenanceStatus) {
+  s.adminMutex.Lock()
+  defer s.adminMutex.Unlock()
+  s.maintenance = status
+}
+
+// Maintenance returns the maintenance mode of the server.
+func (s *Server) Maintenance() MaintenanceStatus {
+  s.adminMutex.RLock()
+  defer s.adminMutex.RUnlock()
+  return s.maintenance
+}
+
+/////////////////////////////////////////////////
+// maintenanceMiddleware rejects the requests with ErrorMaintenance while
+// the maintenance mode is enabled.
+func maintenanceMiddleware(w http.ResponseWriter, r *http.Request,
+                           next http.HandlerFunc) {
+  if gServer != nil {
+    if status := gServer.Maintenance(); status.Enabled {
+      em := ErrorMessage(ErrorMaintenance)
+      if status.Message != "" {
+        em.Msg = status.Message
+      }
+      reportJSONError(w, r, em)
+      return
+    }
+  }
+  next(w, r)
+}
+
+// NewAdminRoutes returns the admin routes of the server, protected by
+// Server.AdminScope. They are not affected by the maintenance mode.
+func (s *Server) NewAdminRoutes() Routes {
+  scope := s.AdminScope
+  if scope == "" {
+    scope = defaultAdminScope
+  }
+  method := func(m string, handler http.Handler) Method {
+    return Method{
+      Type: m,
+      Handlers: FormatHandlers{FormatHandler{"", handler}},
+      RequiredScopes: []string{scope},
+    }
+  }
+  routes := Routes{
+    {
+      Name: "adminLogLevel",
+      Description: "Gets or sets the log level",
+      URI: AdminPathPrefix + "/log-level",
+      SecureMethods: SecureMethods{
+        method("GET", JSONResult(s.adminGetLogLevel)),
+        method("PUT", JSONResult(s.adminSetLogLevel)),
+      },
+    },
+    {
+      Name: "adminMaintenance",
+      Description: "Gets or sets the maintenance mode",
+      URI: AdminPathPrefix + "/maintenance",
+      SecureMethods: SecureMethods{
+        method("GET", JSONResult(s.adminGetMaintenance)),
+        method("PUT", JSONResult(s.adminSetMaintenance)),
+      },
+    },
+    {
+      Name: "adminCacheFlush",
+      Description: "Clears the caches of the server",
+      URI: AdminPathPrefix + "/cache/flush",
+      SecureMethods: SecureMethods{
+        method("POST", JSONResult(s.adminFlushCaches)),
+      },
+    },
+    {
+      Name: "adminQueues",
+      Description: "Returns the status of the worker queues",
+      URI: AdminPathPrefix + "/queues",
+      SecureMethods: SecureMethods{
+        method("GET", JSONResult(s.adminQueues)),
+      },
+    },
+    {
+      Name: "adminConfig",
+      Description: "Returns the server configuration, without secrets",
+      URI: AdminPathPrefix + "/config",
+      SecureMethods: SecureMethods{
+        method("GET", JSONResult(s.adminConfig)),
+      },
+    },
+  }
+  for i := range routes {
+    routes[i].admin = true
+  }
+  return routes
+}
+
+/////////////////////////////////////////////////
+// adminGetLogLevel returns the level of the StdLogger.
+func (s *Server) adminGetLogLevel(w http.ResponseWriter,
+                                  r *http.Request) (interface{}, *ErrMsg) {
+  std, ok := gLogger.(*StdLogger)
+  if !ok {
+    return nil, NewErrorMessageWithArgs(ErrorNotImplemented, nil,
+                                        []string{"the logger has no level"})
+  }
+  return logLevelBody{std.Level().String()}, nil
+}
+
+/////////////////////////////////////////////////
+// adminSetLogLevel sets the level of the StdLogger.
+func (s *Server) adminSetLogLevel(w http.ResponseWriter,
+                                  r *http.Request) (interface{}, *ErrMsg) {
+  var body logLevelBody
+  if em := BindJSON(r, &body); em != nil {
+    return nil, em
+  }
+  level, err := ParseLogLevel(body.Level)
+  if err != nil {
+    return nil, NewErrorMessageWithArgs(ErrorFormInvalidValue, err,
+                                        []string{"level"})
+  }
+  std, ok := gLogger.(*StdLogger)
+  if !ok {
+    return nil, NewErrorMessageWithArgs(ErrorNotImplemented, nil,
+                                        []string{"the logger has no level"})
+  }
+  std.SetLevel(level)
+  gLogger.Warn("Log level changed", Fields{"level": level.String()})
+  return logLevelBody{level.String()}, nil
+}
+
+/////////////////////////////////////////////////
+// adminGetMaintenance returns the maintenance mode.
+func (s *Server) adminGetMaintenance(w http.ResponseWriter,
+                                     r *http.Request) (interface{}, *ErrMsg) {
+  return s.Maintenance(), nil
+}
+
+/////////////////////////////////////////////////
+// adminSetMaintenance enables or disables the maintenance mode.
+func (s *Server) adminSetMaintenance(w http.ResponseWriter,
+                                     r *http.Request) (interface{}, *ErrMsg) {
+  var status MaintenanceStatus
+  if em := BindJSON(r, &status); em != nil {
+    return nil, em
+  }
+  s.SetMaintenance(status)
+  gLogger.Warn("Maintenance mode changed", Fields{"enabled": status.Enabled})
+  return status, nil
+}
+
+/////////////////////////////////////////////////
+// adminFlushCaches clears the token cache and the registered caches. It
+// returns the result of each flush ("ok" or the error message).
+func (s *Server) adminFlushCaches(w http.ResponseWriter,
+                                  r *http.Request) (interface{}, *ErrMsg) {
+  gTokenCache.clear()
+  result := map[string]string{"tokens": healthStatusOK}
+
+  s.adminMutex.RLock()
+  defer s.adminMutex.RUnlock()
+  for name, flush := range s.cacheFlushers {
+    result[name] = healthStatusOK
+    if err := flush(); err != nil {
+      result[name] = err.Error()
+    }
+  }
+  gLogger.Warn("Caches flushed", nil)
+  return result, nil
+}
+
+/////////////////////////////////////////////////
+// adminQueues returns the status of the worker queues.
+func (s *Server) adminQueues(w http.ResponseWriter,
+                             r *http.Request) (interface{}, *ErrMsg) {
+  result := map[string]QueueStatus{}
+  if s.Mailer != nil {
+    result["mail"] = QueueStatus{len(s.Mailer.queue), cap(s.Mailer.queue)}
+  }
+  if s.gaTracker != nil {
+    result["analytics"] = QueueStatus{len(s.gaTracker.queue),
+                                      cap(s.gaTracker.queue)}
+  }
+
+  s.adminMutex.RLock()
+  defer s.adminMutex.RUnlock()
+  for name, status := range s.queueStatuses {
+    result[name] = status()
+  }
+  return result, nil
+}
+
+/////////////////////////////////////////////////
+// adminConfig returns the server configuration, with the secrets
+// redacted.
+func (s *Server) adminConfig(w http.ResponseWriter,
+                             r *http.Request) (interface{}, *ErrMsg) {
+  return s.RedactedConfig(), nil
+}
+
+// RedactedConfig returns the configuration of the server, as loaded by
+// LoadConfig, with the passwords and secrets replaced by "[REDACTED]".
+func (s *Server) RedactedConfig() Config {
+  cfg := Config{
+    HTTPPort: s.HTTPPort,
+    SSLport: s.SSLport,
+    UnixSocket: s.UnixSocket,
+    DefaultAPIVersion: s.DefaultAPIVersion,
+    DeprecatedAPIVersions: s.DeprecatedAPIVersions,
+    RoutesIndexPath: s.RoutesIndexPath,
+    MaxRequestBodySize: s.MaxRequestBodySize,
+    MaxMultipartMemory: s.MaxMultipartMemory,
+    Pagination: s.Pagination,
+    TrustedProxies: s.TrustedProxies,
+    AccessLogFormat: s.AccessLogFormat,
+    ErrorFormat: s.ErrorFormat,
+    ProblemTypeBaseURL: s.ProblemTypeBaseURL,
+    DebugErrors: s.DebugErrors,
+    HealthRoutes: s.HealthRoutes,
+    TLS: TLSConfig{
+      Cert: s.SSLCert,
+      Key: s.SSLKey,
+      RedirectHTTP: s.RedirectHTTPToHTTPS,
+      AutocertHosts: s.AutocertHosts,
+      AutocertCacheDir: s.AutocertCacheDir,
+    },
+    Database: s.DbConfig,
+    Analytics: AnalyticsConfig{
+      TrackingID: s.GaTrackingID,
+      AppName: s.GaAppName,
+      CategoryPrefix: s.GaCategoryPrefix,
+    },
+    Auth: AuthConfig{
+      Auth0RsaPublicKey: s.auth0RsaPublickey,
+      Auth0JWKSURL: s.Auth0JWKSURL,
+      AnonymousIdentities: s.AnonymousIdentities,
+      AnonymousIDSalt: redact(s.AnonymousIDSalt),
+      JWTSharedSecret: redact(s.JWTSharedSecret),
+      JWTES256PublicKey: s.JWTES256PublicKey,
+      JWTTrustedIssuers: s.JWTTrustedIssuers,
+    },
+  }
+  cfg.Database.Password = redact(cfg.Database.Password)
+  return cfg
+}
+
+// redact returns redactedValue, or an empty string if the value is empty.
+func redact(value string) string {
+  if value == "" {
+    return ""
+  }
+  return redactedValue
+}
@@ -0,0 +1,91 @@
+package ign
+
+import (
+  "bytes"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+)
+
+// TestMaintenanceMiddleware tests rejecting requests in maintenance mode.
+func TestMaintenanceMiddleware(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+
+  send := func() *httptest.ResponseRecorder {
+    rec := httptest.NewRecorder()
+    req, _ := http.NewRequest("GET", "/models", nil)
+    maintenanceMiddleware(rec, req, func(w http.ResponseWriter, r *http.Request) {})
+    return rec
+  }
+  if rec := send(); rec.Code != http.StatusOK {
+    t.Fatal("Requests should be served without maintenance:", rec.Code)
+  }
+  gServer.SetMaintenance(MaintenanceStatus{Enabled: true, Message: "Back at 10"})
+  rec := send()
+  if rec.Code != http.StatusServiceUnavailable ||
+     !bytes.Contains(rec.Body.Bytes(), []byte("Back at 10")) {
+    t.Fatal("Unexpected maintenance response:", rec.Code, rec.Body.String())
+  }
+}
+
+// TestAdminRoutes tests the handlers of the admin routes.
+func TestAdminRoutes(t *testing.T) {
+  prevServer, prevLogger := gServer, gLogger
+  defer func() { gServer, gLogger = prevServer, prevLogger }()
+  s := &Server{DbConfig: DatabaseConfig{UserName: "fuel", Password: "1234"},
+               JWTSharedSecret: "secret"}
+  gServer = s
+  std := NewStdLogger(LogLevelInfo)
+  gLogger = std
+
+  for _, route := range s.NewAdminRoutes() {
+    if !route.admin || route.SecureMethods[0].RequiredScopes[0] != "admin" {
+      t.Fatal("Admin routes should require the admin scope:", route.Name)
+    }
+  }
+
+  req, _ := http.NewRequest("PUT", "/admin/log-level",
+                            bytes.NewBufferString(`{"level":"debug"}`))
+  req.Header.Set("Content-Type", "application/json")
+  if _, em := s.adminSetLogLevel(nil, req); em != nil || std.Level() != LogLevelDebug {
+    t.Fatal("Unable to set the log level:", em)
+  }
+  gLogger = struct{ Logger }{std}
+  if _, em := s.adminGetLogLevel(nil, req); em == nil ||
+     em.ErrCode != ErrorNotImplemented {
+    t.Fatal("Loggers without level should not be supported:", em)
+  }
+  gLogger = std
+
+  // The admin routes don't require the database, which is down
+  rec := httptest.NewRecorder()
+  NewRouter(s.NewAdminRoutes()).ServeHTTP(rec,
+    httptest.NewRequest("GET", AdminPathPrefix + "/maintenance", nil))
+  if rec.Code == http.StatusServiceUnavailable {
+    t.Fatal("The admin routes should not require the database")
+  }
+
+  flushed := false
+  s.AddCacheFlusher("models", func() error { flushed = true; return nil })
+  if _, em := s.adminFlushCaches(nil, nil); em != nil || !flushed {
+    t.Fatal("The registered caches should be flushed:", em)
+  }
+
+  s.AddQueueStatus("jobs", func() QueueStatus { return QueueStatus{Pending: 3} })
+  result, _ := s.adminQueues(nil, nil)
+  if result.(map[string]QueueStatus)["jobs"].Pending != 3 {
+    t.Fatal("Unexpected queues:", result)
+  }
+
+  cfg := s.RedactedConfig()
+  if cfg.Database.Password != redactedValue || cfg.Auth.JWTSharedSecret !=
+     redactedValue || cfg.Database.UserName != "fuel" ||
+     cfg.Auth.AnonymousIDSalt != "" {
+    t.Fatal("Unexpected redacted config:", cfg)
+  }
+  if s.DbConfig.Password != "1234" {
+    t.Fatal("The server config should not be changed")
+  }
+}
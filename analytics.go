@@ -0,0 +1,179 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+  "github.com/satori/go.uuid"
+)
+
+// Google Analytics events are delivered by a background worker, to avoid
+// adding the latency of the GA endpoint to API responses.
+// Events are queued in a bounded queue (events are dropped when the queue
+// is full) and sent in batches using the Measurement Protocol batch endpoint.
+// The queued events are flushed when the server shuts down.
+
+const (
+  // gaBatchURL is the Measurement Protocol endpoint used to send batches.
+  gaBatchURL = "https://www.google-analytics.com/batch"
+  // gaMaxBatchSize is the max number of hits accepted by the batch endpoint.
+  gaMaxBatchSize = 20
+  // gaQueueSize is the max number of events waiting to be sent.
+  gaQueueSize = 1000
+  // gaFlushInterval is the max time an event waits before being sent.
+  gaFlushInterval = 5 * time.Second
+)
+
+// gaEvent is a Google Analytics event.
+type gaEvent struct {
+  category string
+  action string
+  label string
+  // The GA client id. A random id is used if empty.
+  clientID string
+  // The IP address of the client, used by GA for geolocation.
+  clientIP string
+}
+
+// gaTracker sends events to Google Analytics in the background.
+type gaTracker struct {
+  trackingID string
+  appName string
+  endpoint string
+  client *HTTPClient
+  queue chan gaEvent
+  batchSize int
+  flushInterval time.Duration
+  // mu guards closed, so that track never sends to the closed queue.
+  mu sync.RWMutex
+  closed bool
+  // done is closed when the worker exits.
+  done chan struct{}
+}
+
+// newGaTracker creates a gaTracker and starts its background worker.
+func newGaTracker(trackingID, appName string) *gaTracker {
+  t := &gaTracker{
+    trackingID: trackingID,
+    appName: appName,
+    endpoint: gaBatchURL,
+    client: NewHTTPClient(),
+    queue: make(chan gaEvent, gaQueueSize),
+    batchSize: gaMaxBatchSize,
+    flushInterval: gaFlushInterval,
+    done: make(chan struct{}),
+  }
+  go t.run()
+  return t
+}
+
+// track queues an event to be sent. It never blocks; the event is dropped
+// if the queue is full or the tracker is closed. Returns false if the event
+// was dropped.
+func (t *gaTracker) track(e gaEvent) bool {
+  t.mu.RLock()
+  defer t.mu.RUnlock()
+  if t.closed {
+    return false
+  }
+  select {
+  case t.queue <- e:
+    return true
+  default:
+    return false
+  }
+}
+
+// Close stops accepting events and waits for the worker to send the queued
+// ones. It returns the context error if ctx is done first.
+func (t *gaTracker) Close(ctx context.Context) error {
+  t.mu.Lock()
+  if !t.closed {
+    t.closed = true
+    close(t.queue)
+  }
+  t.mu.Unlock()
+
+  select {
+  case <-t.done:
+    return nil
+  case <-ctx.Done():
+    return ctx.Err()
+  }
+}
+
+// run is the background worker loop. It sends a batch when it is full, or
+// when the flush interval expires. It sends the remaining events and exits
+// when the queue is closed.
+func (t *gaTracker) run() {
+  defer close(t.done)
+  ticker := time.NewTicker(t.flushInterval)
+  defer ticker.Stop()
+
+  batch := make([]gaEvent, 0, t.batchSize)
+  flush := func() {
+    if len(batch) == 0 {
+      return
+    }
+    if err := t.send(batch); err != nil {
+      gLogger.Warn("Error while sending events to GA", Fields{"error": err})
+    }
+    batch = batch[:0]
+  }
+
+  for {
+    select {
+    case e, ok := <-t.queue:
+      if !ok {
+        flush()
+        return
+      }
+      batch = append(batch, e)
+      if len(batch) >= t.batchSize {
+        flush()
+      }
+    case <-ticker.C:
+      flush()
+    }
+  }
+}
+
+// send posts the given events to the GA batch endpoint.
+func (t *gaTracker) send(events []gaEvent) error {
+  hits := make([]string, 0, len(events))
+  for _, e := range events {
+    cid := e.clientID
+    if cid == "" {
+      cid = uuid.Must(uuid.NewV4()).String()
+    }
+    v := url.Values{}
+    v.Set("v", "1")
+    v.Set("tid", t.trackingID)
+    v.Set("cid", cid)
+    v.Set("t", "event")
+    v.Set("ec", e.category)
+    v.Set("ea", e.action)
+    v.Set("el", e.label)
+    v.Set("ds", t.appName)
+    v.Set("an", t.appName)
+    if e.clientIP != "" {
+      v.Set("uip", e.clientIP)
+    }
+    hits = append(hits, v.Encode())
+  }
+
+  resp, err := t.client.Post(t.endpoint, "text/plain",
+                             strings.NewReader(strings.Join(hits, "\n")))
+  if err != nil {
+    return err
+  }
+  resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return errors.New("GA batch request failed: " + resp.Status)
+  }
+  return nil
+}
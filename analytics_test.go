@@ -0,0 +1,118 @@
+package ign
+
+import (
+  "context"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "strings"
+  "testing"
+  "time"
+)
+
+// Tests for the Google Analytics tracker
+
+// TestGaTrackerBatches tests that events are sent in batches.
+func TestGaTrackerBatches(t *testing.T) {
+  batches := make(chan []string, 10)
+  ga := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    body, _ := ioutil.ReadAll(r.Body)
+    batches <- strings.Split(string(body), "\n")
+  }))
+  defer ga.Close()
+
+  tracker := &gaTracker{
+    trackingID: "UA-1234-1",
+    appName: "test",
+    endpoint: ga.URL,
+    client: &HTTPClient{Client: http.DefaultClient},
+    queue: make(chan gaEvent, 10),
+    batchSize: 3,
+    flushInterval: 50 * time.Millisecond,
+    done: make(chan struct{}),
+  }
+  go tracker.run()
+
+  for i := 0; i < 4; i++ {
+    if !tracker.track(gaEvent{category: "models", action: "GET", label: "/1.0/models"}) {
+      t.Fatal("Event should have been queued")
+    }
+  }
+
+  // A full batch is sent first, and the remaining event after the flush
+  // interval.
+  for _, exp := range []int{3, 1} {
+    select {
+    case hits := <-batches:
+      if len(hits) != exp {
+        t.Fatal("Unexpected batch size", len(hits), exp)
+      }
+      v, err := url.ParseQuery(hits[0])
+      if err != nil || v.Get("tid") != "UA-1234-1" || v.Get("ec") != "models" ||
+         v.Get("ea") != "GET" || v.Get("t") != "event" || v.Get("cid") == "" {
+        t.Fatal("Unexpected GA hit", hits[0])
+      }
+    case <-time.After(time.Second):
+      t.Fatal("Timeout waiting for GA batch")
+    }
+  }
+}
+
+// TestGaTrackerQueueFull tests that events are dropped when the queue is full.
+func TestGaTrackerQueueFull(t *testing.T) {
+  // The worker is not started, so the queue is never consumed.
+  tracker := &gaTracker{queue: make(chan gaEvent, 1)}
+  if !tracker.track(gaEvent{}) {
+    t.Fatal("Event should have been queued")
+  }
+  if tracker.track(gaEvent{}) {
+    t.Fatal("Event should have been dropped")
+  }
+}
+
+// TestGaTrackerClose tests that the queued events are sent on Close.
+func TestGaTrackerClose(t *testing.T) {
+  batches := make(chan []string, 10)
+  ga := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    body, _ := ioutil.ReadAll(r.Body)
+    batches <- strings.Split(string(body), "\n")
+  }))
+  defer ga.Close()
+
+  tracker := &gaTracker{
+    trackingID: "UA-1234-1",
+    appName: "test",
+    endpoint: ga.URL,
+    client: &HTTPClient{Client: http.DefaultClient},
+    queue: make(chan gaEvent, 10),
+    batchSize: 20,
+    // Only Close sends the partial batch.
+    flushInterval: time.Hour,
+    done: make(chan struct{}),
+  }
+  go tracker.run()
+
+  for i := 0; i < 2; i++ {
+    tracker.track(gaEvent{category: "models", action: "GET"})
+  }
+  ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+  defer cancel()
+  if err := tracker.Close(ctx); err != nil {
+    t.Fatal("Unable to close the tracker:", err)
+  }
+  select {
+  case hits := <-batches:
+    if len(hits) != 2 {
+      t.Fatal("Unexpected batch size", len(hits))
+    }
+  default:
+    t.Fatal("The queued events should be sent on Close")
+  }
+  if tracker.track(gaEvent{}) {
+    t.Fatal("Events should be dropped after Close")
+  }
+  if err := tracker.Close(ctx); err != nil {
+    t.Fatal("Close should be idempotent:", err)
+  }
+}
@@ -0,0 +1,79 @@
+package ign
+
+import (
+  "context"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/hex"
+  "net/http"
+  "sync"
+)
+
+// When Server.AnonymousIdentities is enabled, requests without JWT to routes
+// with optional authentication get a stable anonymous ID, computed by
+// hashing the client IP and User-Agent. It lets analytics distinguish
+// anonymous users. Rate limits and quotas don't use it, as the User-Agent is
+// chosen by the client. The hash includes Server.AnonymousIDSalt, so
+// IP addresses can't be recovered from the IDs. If the salt is not set, a
+// random salt is generated by each process, so the IDs change on restart
+// and differ between server instances.
+
+// anonymousKey is the context key of the anonymous ID.
+type anonymousKey struct{}
+
+// processSalt is the random salt used when Server.AnonymousIDSalt is not
+// set.
+var processSalt struct {
+  once sync.Once
+  salt string
+  err error
+}
+
+// AnonymousID returns the anonymous ID of a request without JWT. It returns
+// false if the request is authenticated or anonymous IDs are disabled.
+func AnonymousID(r *http.Request) (string, bool) {
+  id, ok := r.Context().Value(anonymousKey{}).(string)
+  return id, ok
+}
+
+// anonymousID computes the anonymous ID of a request.
+func anonymousID(r *http.Request, salt string) string {
+  sum := sha256.Sum256([]byte(salt + "|" + ClientIP(r) + "|" + r.UserAgent()))
+  return "anon-" + hex.EncodeToString(sum[:16])
+}
+
+// withAnonymousID attaches an anonymous ID to unauthenticated requests, if
+// enabled.
+func withAnonymousID(r *http.Request) *http.Request {
+  if gServer == nil || !gServer.AnonymousIdentities {
+    return r
+  }
+  if _, ok := IdentityFromRequest(r); ok {
+    return r
+  }
+  salt, err := anonymousSalt()
+  if err != nil {
+    gLogger.Error("Unable to compute the anonymous ID", Fields{"error": err})
+    return r
+  }
+  id := anonymousID(r, salt)
+  return r.WithContext(context.WithValue(r.Context(), anonymousKey{}, id))
+}
+
+// anonymousSalt returns the salt of the anonymous IDs. An unsalted hash of
+// the IP could be brute-forced, so a random salt is used if none is set.
+func anonymousSalt() (string, error) {
+  if gServer.AnonymousIDSalt != "" {
+    return gServer.AnonymousIDSalt, nil
+  }
+  processSalt.once.Do(func() {
+    b := make([]byte, 32)
+    if _, processSalt.err = rand.Read(b); processSalt.err != nil {
+      return
+    }
+    processSalt.salt = hex.EncodeToString(b)
+    gLogger.Warn("IGN_ANONYMOUS_ID_SALT is not set. Using a random salt, " +
+                 "so anonymous IDs will change when the server restarts.", nil)
+  })
+  return processSalt.salt, processSalt.err
+}
@@ -0,0 +1,59 @@
+package ign
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "testing"
+)
+
+// TestAnonymousID tests assigning anonymous IDs on optional auth routes.
+func TestAnonymousID(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{AnonymousIdentities: true, AnonymousIDSalt: "salt"}
+
+  noJWT := func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    next(w, r)
+  }
+  idOf := func(optional bool, addr, ua string) string {
+    req, _ := http.NewRequest("GET", "/models", nil)
+    req.RemoteAddr = addr
+    req.Header.Set("User-Agent", ua)
+    var id string
+    newAuthMiddleware(noJWT, optional)(httptest.NewRecorder(), req,
+      func(w http.ResponseWriter, r *http.Request) {
+        id, _ = AnonymousID(r)
+        if key := rateLimitKey(r); key != "ip:10.0.0.1" && key != "ip:10.0.0.2" {
+          t.Fatal("Rate limiting should use the client IP:", key)
+        }
+      })
+    return id
+  }
+
+  id := idOf(true, "10.0.0.1:1000", "curl")
+  if id == "" || id != idOf(true, "10.0.0.1:2000", "curl") {
+    t.Fatal("Anonymous IDs should be stable for the same IP and UA:", id)
+  }
+  if id == idOf(true, "10.0.0.1:1000", "firefox") ||
+     id == idOf(true, "10.0.0.2:1000", "curl") {
+    t.Fatal("Anonymous IDs should differ for other IPs or UAs")
+  }
+  if idOf(false, "10.0.0.1:1000", "curl") != "" {
+    t.Fatal("Routes with required auth should not get anonymous IDs")
+  }
+
+  // Without salt, the IDs are still salted with a random value
+  gServer.AnonymousIDSalt = ""
+  req, _ := http.NewRequest("GET", "/models", nil)
+  req.RemoteAddr = "10.0.0.1:1000"
+  req.Header.Set("User-Agent", "curl")
+  unsalted := anonymousID(req, "")
+  if idOf(true, "10.0.0.1:1000", "curl") == unsalted {
+    t.Fatal("Anonymous IDs should not be an unsalted hash")
+  }
+
+  gServer.AnonymousIdentities = false
+  if idOf(true, "10.0.0.1:1000", "curl") != "" {
+    t.Fatal("Anonymous IDs should be disabled by default")
+  }
+}
@@ -0,0 +1,81 @@
+package ign
+
+import (
+  "crypto/ecdsa"
+  "errors"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// Secure routes accept tokens signed with:
+//  - RS256, using the Auth0 public key or JWKS (see SetAuth0JWKSURL).
+//  - HS256, using a shared secret (see SetJWTSharedSecret).
+//  - ES256, using an ECDSA public key (see SetJWTES256PublicKey).
+// HS256 and ES256 are disabled until their key is set. If trusted issuers are
+// configured (see SetJWTTrustedIssuers), the "iss" claim must be one of them.
+
+// Keys of the additional signing methods.
+var jwtHS256Secret []byte
+var jwtES256Key *ecdsa.PublicKey
+var jwtTrustedIssuers []string
+
+// SetJWTSharedSecret enables HS256 tokens signed with the given secret. An
+// empty secret disables HS256 tokens.
+func (s *Server) SetJWTSharedSecret(secret string) {
+  s.JWTSharedSecret = secret
+  jwtHS256Secret = []byte(secret)
+  gTokenCache.clear()
+}
+
+// SetJWTES256PublicKey enables ES256 tokens signed with the given ECDSA
+// public key, in PEM format. An empty key disables ES256 tokens.
+func (s *Server) SetJWTES256PublicKey(pem string) error {
+  var key *ecdsa.PublicKey
+  if pem != "" {
+    var err error
+    if key, err = jwt.ParseECPublicKeyFromPEM([]byte(pem)); err != nil {
+      return err
+    }
+  }
+  s.JWTES256PublicKey = pem
+  jwtES256Key = key
+  gTokenCache.clear()
+  return nil
+}
+
+// SetJWTTrustedIssuers sets the issuers accepted in the "iss" claim of
+// tokens. An empty list accepts any issuer.
+func (s *Server) SetJWTTrustedIssuers(issuers []string) {
+  s.JWTTrustedIssuers = issuers
+  jwtTrustedIssuers = issuers
+  gTokenCache.clear()
+}
+
+// jwtValidationKey returns the key used to validate the given token, based
+// on its signing method.
+func jwtValidationKey(token *jwt.Token) (interface{}, error) {
+  if len(jwtTrustedIssuers) > 0 {
+    claims, _ := token.Claims.(jwt.MapClaims)
+    iss, _ := claims["iss"].(string)
+    if !StrSliceContains(jwtTrustedIssuers, iss) {
+      return nil, errors.New("Untrusted JWT issuer: " + iss)
+    }
+  }
+
+  switch token.Method {
+  case jwt.SigningMethodRS256:
+    if jwksKeys != nil {
+      kid, _ := token.Header["kid"].(string)
+      return jwksKeys.key(kid)
+    }
+    return jwt.ParseRSAPublicKeyFromPEM([]byte(pemKeyString))
+  case jwt.SigningMethodHS256:
+    if len(jwtHS256Secret) > 0 {
+      return jwtHS256Secret, nil
+    }
+  case jwt.SigningMethodES256:
+    if jwtES256Key != nil {
+      return jwtES256Key, nil
+    }
+  }
+  return nil, errors.New("JWT signing method not accepted: " + token.Method.Alg())
+}
@@ -0,0 +1,61 @@
+package ign
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/x509"
+  "encoding/pem"
+  "testing"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// TestJWTSigningMethods tests validating HS256 and ES256 tokens, and
+// trusted issuers.
+func TestJWTSigningMethods(t *testing.T) {
+  s := &Server{}
+  defer func() {
+    s.SetJWTSharedSecret("")
+    s.SetJWTES256PublicKey("")
+    s.SetJWTTrustedIssuers(nil)
+  }()
+
+  ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+  ecPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+  validate := func(method jwt.SigningMethod, key interface{}, iss string) error {
+    token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "svc", "iss": iss})
+    signed, _ := token.SignedString(key)
+    _, err := jwt.Parse(signed, jwtValidationKey)
+    return err
+  }
+
+  // Disabled by default
+  if validate(jwt.SigningMethodHS256, []byte("secret"), "") == nil ||
+     validate(jwt.SigningMethodES256, ecKey, "") == nil {
+    t.Fatal("HS256 and ES256 tokens should be rejected by default")
+  }
+
+  s.SetJWTSharedSecret("secret")
+  if err := s.SetJWTES256PublicKey(ecPEM); err != nil {
+    t.Fatal("Unable to set the ES256 key:", err)
+  }
+  if err := validate(jwt.SigningMethodHS256, []byte("secret"), ""); err != nil {
+    t.Fatal("Valid HS256 token was rejected:", err)
+  }
+  if validate(jwt.SigningMethodHS256, []byte("other"), "") == nil {
+    t.Fatal("HS256 token with a wrong secret was accepted")
+  }
+  if err := validate(jwt.SigningMethodES256, ecKey, ""); err != nil {
+    t.Fatal("Valid ES256 token was rejected:", err)
+  }
+
+  s.SetJWTTrustedIssuers([]string{"https://internal"})
+  if err := validate(jwt.SigningMethodHS256, []byte("secret"), "https://internal"); err != nil {
+    t.Fatal("Token from a trusted issuer was rejected:", err)
+  }
+  if validate(jwt.SigningMethodHS256, []byte("secret"), "https://other") == nil {
+    t.Fatal("Token from an untrusted issuer was accepted")
+  }
+}
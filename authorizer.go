@@ -0,0 +1,38 @@
+package ign
+
+import (
+  "net/http"
+)
+
+// Authorizer decides if an authenticated request is allowed. It returns nil
+// to allow the request, or the error replied to the client (usually
+// ErrorUnauthorized). Applications use it to plug their own RBAC (eg.
+// Casbin).
+type Authorizer func(identity Identity, r *http.Request) *ErrMsg
+
+// SetAuthorizer sets the Authorizer invoked on secure routes, after the
+// JWT validation and required scopes. It can be called after the router
+// was created, but not while serving requests.
+func (s *Server) SetAuthorizer(authorizer Authorizer) {
+  s.authorizer = authorizer
+}
+
+/////////////////////////////////////////////////
+// authorizerMiddleware runs the server's Authorizer, if any.
+func authorizerMiddleware(w http.ResponseWriter, r *http.Request,
+                          next http.HandlerFunc) {
+  if gServer == nil || gServer.authorizer == nil {
+    next(w, r)
+    return
+  }
+  identity, ok := IdentityFromRequest(r)
+  if !ok {
+    reportJSONError(w, r, ErrorMessage(ErrorAuthJWTInvalid))
+    return
+  }
+  if em := gServer.authorizer(*identity, r); em != nil {
+    reportJSONError(w, r, *em)
+    return
+  }
+  next(w, r)
+}
@@ -0,0 +1,47 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// TestAuthorizer tests invoking the server's Authorizer.
+func TestAuthorizer(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+
+  send := func(sub string) int {
+    req, _ := http.NewRequest("DELETE", "/models/1", nil)
+    if sub != "" {
+      token := &jwt.Token{Claims: jwt.MapClaims{"sub": sub}}
+      req = req.WithContext(context.WithValue(req.Context(), "user", token))
+    }
+    rec := httptest.NewRecorder()
+    authorizerMiddleware(rec, req, func(w http.ResponseWriter, r *http.Request) {})
+    return rec.Code
+  }
+
+  if code := send(""); code != http.StatusOK {
+    t.Fatal("Requests should be allowed without Authorizer:", code)
+  }
+
+  gServer.SetAuthorizer(func(identity Identity, r *http.Request) *ErrMsg {
+    if identity.Subject != "admin" {
+      return NewErrorMessage(ErrorUnauthorized)
+    }
+    return nil
+  })
+  if code := send("admin"); code != http.StatusOK {
+    t.Fatal("Authorized request was rejected:", code)
+  }
+  if code := send("alice"); code != http.StatusUnauthorized {
+    t.Fatal("Unauthorized request was allowed:", code)
+  }
+  if code := send(""); code != http.StatusForbidden {
+    t.Fatal("Requests without identity should be rejected:", code)
+  }
+}
@@ -0,0 +1,36 @@
+package ign
+
+import (
+  "net/http"
+  "golang.org/x/crypto/acme/autocert"
+)
+
+// Let's Encrypt validates the hosts with HTTP-01 challenges, sent to port 80
+// of each host. They are answered by an HTTP listener always bound to TCP
+// port 80 (HTTPPort and UnixSocket are not used), so port 80 of the hosts
+// must reach the server.
+
+// defaultAutocertCacheDir is the directory used to cache Let's Encrypt
+// certificates when IGN_AUTOCERT_CACHE_DIR is not set.
+const defaultAutocertCacheDir = "autocert-cache"
+
+// autocertHTTPAddr is the address of the listener that answers the ACME
+// challenges.
+const autocertHTTPAddr = ":80"
+
+// autocertManager creates the ACME manager used to obtain and renew the
+// TLS certificates of the server's AutocertHosts.
+func (s *Server) autocertManager() *autocert.Manager {
+  return &autocert.Manager{
+    Prompt: autocert.AcceptTOS,
+    HostPolicy: autocert.HostWhitelist(s.AutocertHosts...),
+    Cache: autocert.DirCache(s.AutocertCacheDir),
+  }
+}
+
+// autocertHTTPHandler returns the handler of the HTTP listener when using
+// the given ACME manager. It answers the HTTP-01 challenges, and redirects
+// the other requests to HTTPS.
+func (s *Server) autocertHTTPHandler(m *autocert.Manager) http.Handler {
+  return m.HTTPHandler(s.httpsRedirectHandler())
+}
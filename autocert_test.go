@@ -0,0 +1,60 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "golang.org/x/crypto/acme/autocert"
+)
+
+// fakeAutocertCache is an autocert.Cache kept in memory.
+type fakeAutocertCache map[string][]byte
+
+func (c fakeAutocertCache) Get(ctx context.Context, key string) ([]byte, error) {
+  data, ok := c[key]
+  if !ok {
+    return nil, autocert.ErrCacheMiss
+  }
+  return data, nil
+}
+
+func (c fakeAutocertCache) Put(ctx context.Context, key string, data []byte) error {
+  c[key] = data
+  return nil
+}
+
+func (c fakeAutocertCache) Delete(ctx context.Context, key string) error {
+  delete(c, key)
+  return nil
+}
+
+// TestAutocertHTTPHandler tests answering ACME challenges and redirecting
+// the other requests of the HTTP listener.
+func TestAutocertHTTPHandler(t *testing.T) {
+  s := &Server{HTTPPort: ":80", SSLport: ":443",
+               AutocertHosts: []string{"example.org"}}
+  m := s.autocertManager()
+  m.Cache = fakeAutocertCache{"token+http-01": []byte("token.key")}
+  handler := s.autocertHTTPHandler(m)
+
+  get := func(url string) *httptest.ResponseRecorder {
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+    return rec
+  }
+  rec := get("http://example.org/.well-known/acme-challenge/token")
+  if rec.Code != http.StatusOK || rec.Body.String() != "token.key" {
+    t.Fatal("The challenge should be answered:", rec.Code, rec.Body.String())
+  }
+  if rec := get("http://other.org/.well-known/acme-challenge/token");
+     rec.Code != http.StatusForbidden {
+    t.Fatal("Challenges of other hosts should be rejected:", rec.Code)
+  }
+  rec = get("http://example.org/models?page=2")
+  if rec.Code != http.StatusMovedPermanently ||
+     rec.Header().Get("Location") != "https://example.org/models?page=2" {
+    t.Fatal("Other requests should be redirected to HTTPS:", rec.Code,
+            rec.Header().Get("Location"))
+  }
+}
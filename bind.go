@@ -0,0 +1,348 @@
+package ign
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "mime"
+  "net/http"
+  "reflect"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+// Request bodies can be decoded using BindJSON, and validated using
+// BindAndValidate and the "validate" tag of the destination struct fields.
+// Supported validations (comma separated):
+//
+//   required   the field must not be the zero value (eg. "" or 0)
+//   min=N      min length of strings and slices, or min value of numbers
+//   max=N      max length of strings and slices, or max value of numbers
+//   oneof=a b  the value must be one of the space separated values
+//
+// E.g.:
+//
+//   type newModel struct {
+//     Name string `json:"name" validate:"required,max=64"`
+//     License string `json:"license" validate:"oneof=CC0 MIT"`
+//   }
+//
+// The tags of each type are parsed once. Invalid tags make Validate fail
+// with ErrorInvalidValidationTag; use CheckValidationTags to find them at
+// startup.
+
+// defaultMaxJSONBodySize is the default max size of bodies read by
+// BindJSON.
+const defaultMaxJSONBodySize = 1 << 20
+
+// BindOptions are the options of BindJSONWithOptions.
+type BindOptions struct {
+  // Max size, in bytes, of the body. Defaults to 1MB.
+  MaxSize int64
+  // Reject bodies containing fields not found in the destination.
+  DisallowUnknownFields bool
+}
+
+// BindJSON decodes the JSON body of the request into dst, using the
+// default options. See BindJSONWithOptions.
+func BindJSON(r *http.Request, dst interface{}) *ErrMsg {
+  return BindJSONWithOptions(r, dst, BindOptions{})
+}
+
+// BindJSONWithOptions decodes the JSON body of the request into dst. It
+// returns ErrorUnsupportedMediaType if the request is not JSON,
+// ErrorPayloadEmpty if there is no body, ErrorPayloadTooLarge if the body
+// is too large, or ErrorUnmarshalJSON if the body is invalid. The Extra of
+// ErrorUnmarshalJSON contains the offending field, if known.
+func BindJSONWithOptions(r *http.Request, dst interface{},
+                         opts BindOptions) *ErrMsg {
+  mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+  if err != nil || (mediaType != "application/json" &&
+                    !strings.HasSuffix(mediaType, "+json")) {
+    return NewErrorMessageWithArgs(ErrorUnsupportedMediaType, err,
+                                   []string{r.Header.Get("Content-Type")})
+  }
+  if r.Body == nil {
+    return NewErrorMessage(ErrorPayloadEmpty)
+  }
+
+  maxSize := opts.MaxSize
+  if maxSize <= 0 {
+    maxSize = defaultMaxJSONBodySize
+  }
+  dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxSize))
+  if opts.DisallowUnknownFields {
+    dec.DisallowUnknownFields()
+  }
+  if err := dec.Decode(dst); err != nil {
+    return jsonBindError(err)
+  }
+  // Only one JSON value is accepted
+  if _, err := dec.Token(); err != io.EOF {
+    return NewErrorMessageWithBase(ErrorUnmarshalJSON,
+                                   errors.New("Unexpected data after the JSON body"))
+  }
+  return nil
+}
+
+// jsonBindError converts a JSON decoding error to an ErrMsg.
+func jsonBindError(err error) *ErrMsg {
+  if err == io.EOF {
+    return NewErrorMessageWithBase(ErrorPayloadEmpty, err)
+  }
+  if IsBodyTooLarge(err) {
+    return NewErrorMessageWithBase(ErrorPayloadTooLarge, err)
+  }
+  var field string
+  if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+    field = typeErr.Field
+  } else if strings.HasPrefix(err.Error(), "json: unknown field ") {
+    field, _ = strconv.Unquote(strings.TrimPrefix(err.Error(),
+                                                  "json: unknown field "))
+  }
+  if field != "" {
+    return NewErrorMessageWithArgs(ErrorUnmarshalJSON, err, []string{field})
+  }
+  return NewErrorMessageWithBase(ErrorUnmarshalJSON, err)
+}
+
+// BindAndValidate decodes the JSON body of the request into dst, and then
+// validates it using Validate.
+func BindAndValidate(r *http.Request, dst interface{}) *ErrMsg {
+  if em := BindJSON(r, dst); em != nil {
+    return em
+  }
+  return Validate(dst)
+}
+
+// Validate checks the "validate" tags of the given struct (or pointer to
+// struct) fields, including the fields of nested structs. It returns
+// ErrorMissingField for missing required fields, or ErrorFormInvalidValue
+// for other invalid values. The Extra contains the JSON name of the field.
+func Validate(value interface{}) *ErrMsg {
+  v := reflect.ValueOf(value)
+  for v.Kind() == reflect.Ptr {
+    if v.IsNil() {
+      return nil
+    }
+    v = v.Elem()
+  }
+  if v.Kind() != reflect.Struct {
+    return nil
+  }
+  return validateStruct(v, "")
+}
+
+// CheckValidationTags returns an error if the "validate" tags of the given
+// struct type, or of its nested structs, are invalid. E.g.:
+//
+//   if err := ign.CheckValidationTags(newModel{}); err != nil {
+//     log.Fatal(err)
+//   }
+func CheckValidationTags(value interface{}) error {
+  return checkValidationTags(reflect.TypeOf(value), map[reflect.Type]bool{})
+}
+
+// checkValidationTags checks the tags of a type and its nested structs,
+// skipping the visited ones.
+func checkValidationTags(t reflect.Type, visited map[reflect.Type]bool) error {
+  for t != nil && t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  if t == nil || t.Kind() != reflect.Struct || visited[t] {
+    return nil
+  }
+  visited[t] = true
+  plan := validationPlanOf(t)
+  if plan.err != nil {
+    return plan.err
+  }
+  for _, f := range plan.fields {
+    if err := checkValidationTags(t.Field(f.index).Type, visited); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// validationRule is a parsed rule of a "validate" tag.
+type validationRule struct {
+  key string
+  arg string
+  // limit of the min and max rules.
+  limit float64
+}
+
+// validatedField is a field checked by Validate.
+type validatedField struct {
+  index int
+  name string
+  rules []validationRule
+}
+
+// validationPlan are the parsed validation rules of a struct type.
+type validationPlan struct {
+  fields []validatedField
+  // err is set if the tags are invalid.
+  err error
+}
+
+// validationPlans caches the validationPlan of each struct type.
+var validationPlans sync.Map
+
+// validationPlanOf returns the validation rules of a struct type, parsing
+// its tags the first time.
+func validationPlanOf(t reflect.Type) *validationPlan {
+  if plan, ok := validationPlans.Load(t); ok {
+    return plan.(*validationPlan)
+  }
+  plan, _ := validationPlans.LoadOrStore(t, newValidationPlan(t))
+  return plan.(*validationPlan)
+}
+
+// newValidationPlan parses the "validate" tags of a struct type. Fields
+// without rules are kept if they can contain nested structs.
+func newValidationPlan(t reflect.Type) *validationPlan {
+  plan := &validationPlan{}
+  for i := 0; i < t.NumField(); i++ {
+    field := t.Field(i)
+    if field.PkgPath != "" {
+      continue
+    }
+    f := validatedField{index: i, name: jsonFieldName(field)}
+    if tag := field.Tag.Get("validate"); tag != "" && tag != "-" {
+      for _, rule := range strings.Split(tag, ",") {
+        parsed, err := parseValidationRule(strings.TrimSpace(rule))
+        if err != nil {
+          plan.err = fmt.Errorf("%v.%v: %v", t, field.Name, err)
+          return plan
+        }
+        f.rules = append(f.rules, parsed)
+      }
+    }
+    ft := field.Type
+    if ft.Kind() == reflect.Ptr {
+      ft = ft.Elem()
+    }
+    if len(f.rules) > 0 || ft.Kind() == reflect.Struct {
+      plan.fields = append(plan.fields, f)
+    }
+  }
+  return plan
+}
+
+// parseValidationRule parses a single rule of a "validate" tag.
+func parseValidationRule(rule string) (validationRule, error) {
+  r := validationRule{key: rule}
+  if i := strings.Index(rule, "="); i >= 0 {
+    r.key, r.arg = rule[:i], rule[i + 1:]
+  }
+  switch r.key {
+  case "required", "oneof":
+  case "min", "max":
+    limit, err := strconv.ParseFloat(r.arg, 64)
+    if err != nil {
+      return r, errors.New("Invalid validation rule [" + rule + "]")
+    }
+    r.limit = limit
+  default:
+    return r, errors.New("Unknown validation rule [" + rule + "]")
+  }
+  return r, nil
+}
+
+// validateStruct validates the fields of a struct. The prefix is prepended
+// to the field names (eg. "owner.").
+func validateStruct(v reflect.Value, prefix string) *ErrMsg {
+  plan := validationPlanOf(v.Type())
+  if plan.err != nil {
+    gLogger.Error("Invalid validation tags", Fields{"error": plan.err})
+    return NewErrorMessageWithBase(ErrorInvalidValidationTag, plan.err)
+  }
+  for _, f := range plan.fields {
+    name := prefix + f.name
+    fv := v.Field(f.index)
+
+    for _, rule := range f.rules {
+      if em := validateRule(fv, rule, name); em != nil {
+        return em
+      }
+    }
+
+    inner := fv
+    if inner.Kind() == reflect.Ptr && !inner.IsNil() {
+      inner = inner.Elem()
+    }
+    if inner.Kind() == reflect.Struct {
+      if em := validateStruct(inner, name + "."); em != nil {
+        return em
+      }
+    }
+  }
+  return nil
+}
+
+// validateRule checks a single validation rule of a field.
+func validateRule(v reflect.Value, rule validationRule, name string) *ErrMsg {
+  invalid := func(reason string) *ErrMsg {
+    return NewErrorMessageWithArgs(ErrorFormInvalidValue,
+                                   errors.New(name + ": " + reason),
+                                   []string{name})
+  }
+
+  switch rule.key {
+  case "required":
+    if v.IsZero() {
+      return NewErrorMessageWithArgs(ErrorMissingField, nil, []string{name})
+    }
+  case "min", "max":
+    size, ok := validationSize(v)
+    if !ok {
+      return nil
+    }
+    if rule.key == "min" && size < rule.limit {
+      return invalid(fmt.Sprintf("must be at least %v", rule.arg))
+    }
+    if rule.key == "max" && size > rule.limit {
+      return invalid(fmt.Sprintf("must be at most %v", rule.arg))
+    }
+  case "oneof":
+    if v.IsZero() {
+      return nil
+    }
+    value := fmt.Sprint(reflect.Indirect(v).Interface())
+    if !StrSliceContains(strings.Fields(rule.arg), value) {
+      return invalid("must be one of [" + rule.arg + "]")
+    }
+  }
+  return nil
+}
+
+// validationSize returns the length of strings, slices and maps, or the
+// value of numbers, used by the min and max rules.
+func validationSize(v reflect.Value) (float64, bool) {
+  v = reflect.Indirect(v)
+  switch v.Kind() {
+  case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+    return float64(v.Len()), true
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return float64(v.Int()), true
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+       reflect.Uint64:
+    return float64(v.Uint()), true
+  case reflect.Float32, reflect.Float64:
+    return v.Float(), true
+  }
+  return 0, false
+}
+
+// jsonFieldName returns the name of a struct field in JSON documents.
+func jsonFieldName(field reflect.StructField) string {
+  name := strings.Split(field.Tag.Get("json"), ",")[0]
+  if name == "" || name == "-" {
+    return field.Name
+  }
+  return name
+}
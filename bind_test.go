@@ -0,0 +1,108 @@
+package ign
+
+import (
+  "net/http"
+  "strings"
+  "testing"
+)
+
+type bindOwner struct {
+  Name string `json:"name" validate:"required"`
+}
+
+type bindModel struct {
+  Name string `json:"name" validate:"required,max=8"`
+  License string `json:"license" validate:"oneof=CC0 MIT"`
+  Tags []string `json:"tags" validate:"min=1"`
+  Scale float64 `json:"scale" validate:"min=0.5"`
+  Owner *bindOwner `json:"owner"`
+}
+
+// TestBindJSON tests decoding and validating JSON request bodies.
+func TestBindJSON(t *testing.T) {
+  bind := func(contentType, body string, strict bool) (*bindModel, *ErrMsg) {
+    r, _ := http.NewRequest("POST", "/models", strings.NewReader(body))
+    r.Header.Set("Content-Type", contentType)
+    var m bindModel
+    if em := BindJSONWithOptions(r, &m, BindOptions{MaxSize: 200,
+                                                    DisallowUnknownFields: strict}); em != nil {
+      return nil, em
+    }
+    return &m, Validate(&m)
+  }
+
+  valid := `{"name":"box","license":"MIT","tags":["a"],"scale":1,` +
+           `"owner":{"name":"osrf"}}`
+  if m, em := bind("application/json; charset=utf-8", valid, true); em != nil ||
+     m.Owner.Name != "osrf" {
+    t.Fatal("Unable to bind a valid body:", em)
+  }
+
+  tests := []struct {
+    contentType string
+    body string
+    errCode int
+    extra string
+  }{
+    {"text/plain", valid, ErrorUnsupportedMediaType, "text/plain"},
+    {"application/json", ``, ErrorPayloadEmpty, ""},
+    {"application/json", `{"name":` + strings.Repeat(" ", 300) + `"x"}`,
+     ErrorPayloadTooLarge, ""},
+    {"application/json", `{"name": 3}`, ErrorUnmarshalJSON, "name"},
+    {"application/json", `{"name":"box","color":"red"}`, ErrorUnmarshalJSON, "color"},
+    {"application/json", `{"name":"box"} {}`, ErrorUnmarshalJSON, ""},
+    {"application/json", `{"license":"MIT","tags":["a"],"scale":1}`,
+     ErrorMissingField, "name"},
+    {"application/json", `{"name":"a long name","tags":["a"],"scale":1}`,
+     ErrorFormInvalidValue, "name"},
+    {"application/json", `{"name":"box","license":"GPL","tags":["a"],"scale":1}`,
+     ErrorFormInvalidValue, "license"},
+    {"application/json", `{"name":"box","tags":[],"scale":1}`,
+     ErrorFormInvalidValue, "tags"},
+    {"application/json", `{"name":"box","tags":["a"],"scale":0.1}`,
+     ErrorFormInvalidValue, "scale"},
+    {"application/json", `{"name":"box","tags":["a"],"scale":1,"owner":{}}`,
+     ErrorMissingField, "owner.name"},
+  }
+  for _, test := range tests {
+    _, em := bind(test.contentType, test.body, true)
+    if em == nil || em.ErrCode != test.errCode ||
+       (test.extra != "" && (len(em.Extra) != 1 || em.Extra[0] != test.extra)) {
+      t.Fatal("Unexpected error for", test.body, em)
+    }
+  }
+
+  // Unknown fields are ignored by default
+  r, _ := http.NewRequest("POST", "/models",
+                          strings.NewReader(`{"name":"box","color":"red"}`))
+  r.Header.Set("Content-Type", "application/json")
+  var m bindModel
+  if em := BindJSON(r, &m); em != nil || m.Name != "box" {
+    t.Fatal("Unable to bind with unknown fields:", em)
+  }
+}
+
+type bindInvalidRules struct {
+  Name string `json:"name" validate:"required,maxlen=8"`
+}
+
+type bindNestedInvalidRules struct {
+  Model bindInvalidRules `json:"model"`
+}
+
+// TestValidationTags tests that invalid validation tags are reported as
+// errors instead of panics.
+func TestValidationTags(t *testing.T) {
+  if err := CheckValidationTags(&bindModel{}); err != nil {
+    t.Fatal("Valid tags should be accepted:", err)
+  }
+  if err := CheckValidationTags(bindNestedInvalidRules{}); err == nil ||
+     !strings.Contains(err.Error(), "maxlen=8") {
+    t.Fatal("Invalid nested tags should be found:", err)
+  }
+  em := Validate(&bindNestedInvalidRules{})
+  if em == nil || em.ErrCode != ErrorInvalidValidationTag ||
+     em.StatusCode != http.StatusInternalServerError {
+    t.Fatal("Invalid tags should fail the validation:", em)
+  }
+}
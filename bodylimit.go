@@ -0,0 +1,67 @@
+package ign
+
+import (
+  "errors"
+  "net/http"
+)
+
+// Request bodies can be limited globally using Server.MaxRequestBodySize,
+// or per route using Route.MaxBodySize. Requests with larger bodies are
+// rejected with ErrorPayloadTooLarge. Handlers reading multipart forms should
+// use ParseMultipartForm, which keeps at most Server.MaxMultipartMemory bytes
+// in memory (the rest is stored in temporary files).
+
+// defaultMaxMultipartMemory is the default max amount of memory used to
+// parse multipart forms.
+const defaultMaxMultipartMemory = 32 << 20
+
+// ParseMultipartForm parses a multipart form request using the server's
+// MaxMultipartMemory. It returns ErrorPayloadTooLarge if the body exceeds
+// the request body limit, or ErrorForm if the form is invalid.
+func ParseMultipartForm(r *http.Request) *ErrMsg {
+  maxMemory := int64(defaultMaxMultipartMemory)
+  if gServer != nil && gServer.MaxMultipartMemory > 0 {
+    maxMemory = gServer.MaxMultipartMemory
+  }
+  if err := r.ParseMultipartForm(maxMemory); err != nil {
+    if IsBodyTooLarge(err) {
+      return NewErrorMessageWithBase(ErrorPayloadTooLarge, err)
+    }
+    return NewErrorMessageWithBase(ErrorForm, err)
+  }
+  return nil
+}
+
+// IsBodyTooLarge returns true if the error was caused by reading a request
+// body larger than the allowed limit. The error can be wrapped by other
+// readers (eg. multipart).
+func IsBodyTooLarge(err error) bool {
+  var maxBytesErr *http.MaxBytesError
+  return errors.As(err, &maxBytesErr)
+}
+
+/////////////////////////////////////////////////
+// newBodyLimitMiddleware returns a middleware that limits the size of the
+// request body. If routeLimit is zero, the server's MaxRequestBodySize is
+// used.
+func newBodyLimitMiddleware(routeLimit int64) func(http.ResponseWriter,
+                                                   *http.Request, http.HandlerFunc) {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    limit := routeLimit
+    if limit == 0 && gServer != nil {
+      limit = gServer.MaxRequestBodySize
+    }
+    if limit <= 0 || r.Body == nil {
+      next(w, r)
+      return
+    }
+
+    // Reject early if the client declared a larger body
+    if r.ContentLength > limit {
+      reportJSONError(w, r, ErrorMessage(ErrorPayloadTooLarge))
+      return
+    }
+    r.Body = http.MaxBytesReader(w, r.Body, limit)
+    next(w, r)
+  }
+}
@@ -0,0 +1,70 @@
+package ign
+
+import (
+  "bytes"
+  "io/ioutil"
+  "mime/multipart"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// TestBodyLimitMiddleware tests rejecting large request bodies.
+func TestBodyLimitMiddleware(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{MaxRequestBodySize: 10}
+
+  read := func(w http.ResponseWriter, r *http.Request) {
+    if _, err := ioutil.ReadAll(r.Body); IsBodyTooLarge(err) {
+      reportJSONError(w, r, ErrorMessage(ErrorPayloadTooLarge))
+    }
+  }
+  send := func(routeLimit int64, body string, chunked bool) int {
+    req, _ := http.NewRequest("POST", "/models", strings.NewReader(body))
+    if chunked {
+      req.ContentLength = -1
+    }
+    rec := httptest.NewRecorder()
+    newBodyLimitMiddleware(routeLimit)(rec, req, read)
+    return rec.Code
+  }
+
+  if code := send(0, "0123456789", false); code != http.StatusOK {
+    t.Fatal("Body within the limit was rejected:", code)
+  }
+  if code := send(0, "0123456789A", false); code != http.StatusRequestEntityTooLarge {
+    t.Fatal("Large body should be rejected:", code)
+  }
+  if code := send(0, "0123456789A", true); code != http.StatusRequestEntityTooLarge {
+    t.Fatal("Large body without content length should be rejected:", code)
+  }
+  if code := send(20, "0123456789A", false); code != http.StatusOK {
+    t.Fatal("The route limit should override the server limit:", code)
+  }
+  if code := send(-1, "0123456789A", false); code != http.StatusOK {
+    t.Fatal("A negative route limit should disable the limit:", code)
+  }
+}
+
+// TestParseMultipartForm tests parsing forms larger than the body limit.
+func TestParseMultipartForm(t *testing.T) {
+  var body bytes.Buffer
+  mw := multipart.NewWriter(&body)
+  mw.WriteField("name", strings.Repeat("x", 100))
+  mw.Close()
+
+  for limit, exp := range map[int64]int64{0: 0, 50: ErrorPayloadTooLarge} {
+    req, _ := http.NewRequest("POST", "/models", bytes.NewReader(body.Bytes()))
+    req.Header.Set("Content-Type", mw.FormDataContentType())
+    req.ContentLength = -1
+    if limit > 0 {
+      req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, limit)
+    }
+    em := ParseMultipartForm(req)
+    if (em == nil && exp != 0) || (em != nil && int64(em.ErrCode) != exp) {
+      t.Fatal("Unexpected ParseMultipartForm result with limit", limit, em)
+    }
+  }
+}
@@ -0,0 +1,40 @@
+package ign
+
+import (
+  "errors"
+  "reflect"
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+)
+
+// maxQueryPlaceholders is the max number of placeholders of a MySQL
+// prepared statement.
+const maxQueryPlaceholders = 65535
+
+// BulkInsertOnDuplicate inserts the rows of the given slice using
+// multi-row INSERT ... ON DUPLICATE KEY UPDATE statements. Existing rows
+// (same primary or unique key) get the given columns updated. If
+// updateColumns is empty, existing rows are left unchanged.
+// Rows are split in as few statements as the placeholders limit allows.
+func BulkInsertOnDuplicate(db *gorm.DB, rows interface{},
+                           updateColumns []string) error {
+  v := reflect.Indirect(reflect.ValueOf(rows))
+  if v.Kind() != reflect.Slice {
+    return errors.New("BulkInsertOnDuplicate requires a slice")
+  }
+  if v.Len() == 0 {
+    return nil
+  }
+
+  stmt := &gorm.Statement{DB: db}
+  if err := stmt.Parse(rows); err != nil {
+    return err
+  }
+  batchSize := maxQueryPlaceholders / Max(int64(len(stmt.Schema.DBNames)), 1)
+
+  onConflict := clause.OnConflict{DoNothing: true}
+  if len(updateColumns) > 0 {
+    onConflict = clause.OnConflict{DoUpdates: clause.AssignmentColumns(updateColumns)}
+  }
+  return db.Clauses(onConflict).CreateInBatches(rows, int(batchSize)).Error
+}
@@ -0,0 +1,32 @@
+package ign
+
+import (
+  "testing"
+)
+
+// TestBulkInsertOnDuplicate tests inserting and updating rows at once.
+func TestBulkInsertOnDuplicate(t *testing.T) {
+  db := newListItemsDB(t, listItem{Name: "a", Status: "active"})
+  defer sqlDB(db).Close()
+
+  var existing listItem
+  db.Where("name = ?", "a").First(&existing)
+  rows := []listItem{
+    {ID: existing.ID, Name: "a", Status: "deleted", Owner: "ignored"},
+    {Name: "b", Status: "active"},
+  }
+  if err := BulkInsertOnDuplicate(db, &rows, []string{"status"}); err != nil {
+    t.Fatal("Bulk insert failed:", err)
+  }
+
+  var items []listItem
+  db.Where("name IN (?)", []string{"a", "b"}).Order("name").Find(&items)
+  if len(items) != 2 || items[0].Status != "deleted" || items[0].Owner != "" ||
+     items[1].Status != "active" {
+    t.Fatal("Unexpected rows:", items)
+  }
+
+  if err := BulkInsertOnDuplicate(db, listItem{}, nil); err == nil {
+    t.Fatal("Expected an error with a non slice")
+  }
+}
@@ -0,0 +1,188 @@
+package ign
+
+import (
+  "bytes"
+  "crypto/md5"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
+  "errors"
+  "hash"
+  "io"
+  "net/http"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// Uploads can be verified against the checksum sent by the client in the
+// Content-MD5 header (base64 MD5 of the body) or the Digest header (eg.
+// "SHA-256=<base64>", RFC 3230), so corrupted uploads are rejected with
+// ErrorChecksumMismatch instead of being stored. StoreRequestBody verifies
+// the request body, and BindMultipart the file parts with those headers.
+// Downloads can include the same headers using SetDigestHeaders.
+
+// ErrChecksumMismatch is returned by the readers of NewVerifyingReader when
+// the content does not match the expected checksum.
+var ErrChecksumMismatch = errors.New("Checksum mismatch")
+
+// Checksums are the hex encoded checksums of a file or directory.
+type Checksums struct {
+  SHA256 string `json:"sha256"`
+  MD5 string `json:"md5"`
+}
+
+// newChecksums returns the checksums of the given hashes.
+func newChecksums(sha, md hash.Hash) Checksums {
+  return Checksums{
+    SHA256: hex.EncodeToString(sha.Sum(nil)),
+    MD5: hex.EncodeToString(md.Sum(nil)),
+  }
+}
+
+// HashReader returns the checksums of the content read from r.
+func HashReader(r io.Reader) (Checksums, error) {
+  sha, md := sha256.New(), md5.New()
+  if _, err := io.Copy(io.MultiWriter(sha, md), r); err != nil {
+    return Checksums{}, err
+  }
+  return newChecksums(sha, md), nil
+}
+
+// HashFile returns the checksums of the file at the given path.
+func HashFile(path string) (Checksums, error) {
+  f, err := os.Open(path)
+  if err != nil {
+    return Checksums{}, err
+  }
+  defer f.Close()
+  return HashReader(f)
+}
+
+// HashDir returns the checksums of the files in a directory, including
+// subdirectories. The checksums only change if a file is added, removed,
+// renamed or modified. Each line of the hashed content is the SHA256 of a
+// file and its relative path, sorted by path.
+func HashDir(dir string) (Checksums, error) {
+  var lines []string
+  err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+    if err != nil || !info.Mode().IsRegular() {
+      return err
+    }
+    sums, err := HashFile(path)
+    if err != nil {
+      return err
+    }
+    rel, err := filepath.Rel(dir, path)
+    if err != nil {
+      return err
+    }
+    lines = append(lines, sums.SHA256 + "  " + filepath.ToSlash(rel) + "\n")
+    return nil
+  })
+  if err != nil {
+    return Checksums{}, err
+  }
+  sort.Strings(lines)
+  return HashReader(strings.NewReader(strings.Join(lines, "")))
+}
+
+// SetDigestHeaders sets the Content-MD5 and Digest headers of a response
+// with the given checksums. Invalid checksums are ignored.
+func SetDigestHeaders(w http.ResponseWriter, sums Checksums) {
+  var digests []string
+  if sum, err := hex.DecodeString(sums.SHA256); err == nil && len(sum) > 0 {
+    digests = append(digests, "SHA-256=" + base64.StdEncoding.EncodeToString(sum))
+  }
+  if sum, err := hex.DecodeString(sums.MD5); err == nil && len(sum) > 0 {
+    b64 := base64.StdEncoding.EncodeToString(sum)
+    w.Header().Set("Content-MD5", b64)
+    digests = append(digests, "MD5=" + b64)
+  }
+  if len(digests) > 0 {
+    w.Header().Set("Digest", strings.Join(digests, ","))
+  }
+}
+
+// expectedChecksums returns the checksums of the Content-MD5 and Digest
+// headers, by hash name ("sha-256" or "md5"). Unsupported algorithms are
+// ignored. It returns an error if a header is malformed.
+func expectedChecksums(h http.Header) (map[string][]byte, error) {
+  expected := map[string][]byte{}
+  if v := h.Get("Content-MD5"); v != "" {
+    sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+    if err != nil || len(sum) != md5.Size {
+      return nil, errors.New("Invalid Content-MD5 header")
+    }
+    expected["md5"] = sum
+  }
+  for _, d := range strings.Split(h.Get("Digest"), ",") {
+    i := strings.Index(d, "=")
+    if i < 0 {
+      continue
+    }
+    name := strings.ToLower(strings.TrimSpace(d[:i]))
+    size := map[string]int{"sha-256": sha256.Size, "md5": md5.Size}[name]
+    if size == 0 {
+      continue
+    }
+    sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(d[i + 1:]))
+    if err != nil || len(sum) != size {
+      return nil, errors.New("Invalid Digest header")
+    }
+    expected[name] = sum
+  }
+  return expected, nil
+}
+
+// verifyingReader checks the content of a reader once fully read.
+type verifyingReader struct {
+  r io.Reader
+  hashes map[string]hash.Hash
+  expected map[string][]byte
+}
+
+// NewVerifyingReader returns a reader that reads from r and returns
+// ErrChecksumMismatch at the end of the content if it doesn't match the
+// checksums of the Content-MD5 and Digest headers of h. It returns r as is
+// if h has no supported checksum, and an error if the headers are
+// malformed.
+func NewVerifyingReader(r io.Reader, h http.Header) (io.Reader, error) {
+  expected, err := expectedChecksums(h)
+  if err != nil || len(expected) == 0 {
+    return r, err
+  }
+  v := &verifyingReader{r: r, hashes: map[string]hash.Hash{},
+                        expected: expected}
+  for name := range expected {
+    if name == "md5" {
+      v.hashes[name] = md5.New()
+    } else {
+      v.hashes[name] = sha256.New()
+    }
+  }
+  return v, nil
+}
+
+// Read is part of the io.Reader interface.
+func (v *verifyingReader) Read(p []byte) (int, error) {
+  n, err := v.r.Read(p)
+  for _, h := range v.hashes {
+    h.Write(p[:n])
+  }
+  if err == io.EOF {
+    for name, h := range v.hashes {
+      if !bytes.Equal(h.Sum(nil), v.expected[name]) {
+        return n, ErrChecksumMismatch
+      }
+    }
+  }
+  return n, err
+}
+
+// IsChecksumMismatch returns true if err was caused by content not matching
+// its expected checksum.
+func IsChecksumMismatch(err error) bool {
+  return errors.Is(err, ErrChecksumMismatch)
+}
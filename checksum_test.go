@@ -0,0 +1,81 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+// TestHashDir tests the checksums of files and directories.
+func TestHashDir(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "checksum")
+  defer os.RemoveAll(dir)
+  os.MkdirAll(filepath.Join(dir, "meshes"), 0755)
+  ioutil.WriteFile(filepath.Join(dir, "model.sdf"), []byte("abc"), 0644)
+  ioutil.WriteFile(filepath.Join(dir, "meshes", "box.dae"), []byte("dae"), 0644)
+
+  sums, err := HashFile(filepath.Join(dir, "model.sdf"))
+  if err != nil || sums.MD5 != "900150983cd24fb0d6963f7d28e17f72" ||
+     sums.SHA256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+    t.Fatal("Unexpected file checksums:", sums, err)
+  }
+
+  before, err := HashDir(dir)
+  if err != nil {
+    t.Fatal("Unable to hash directory:", err)
+  }
+  if again, _ := HashDir(dir); again != before {
+    t.Fatal("Directory checksums should be stable")
+  }
+  os.Rename(filepath.Join(dir, "meshes", "box.dae"),
+            filepath.Join(dir, "meshes", "box2.dae"))
+  if after, _ := HashDir(dir); after == before {
+    t.Fatal("Renaming a file should change the checksums")
+  }
+}
+
+// TestStoreRequestBodyChecksum tests verifying uploads with Content-MD5 and
+// Digest headers.
+func TestStoreRequestBodyChecksum(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "checksum")
+  defer os.RemoveAll(dir)
+  storage, _ := NewLocalStorage(dir)
+
+  rec := httptest.NewRecorder()
+  SetDigestHeaders(rec, Checksums{
+    SHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+    MD5: "900150983cd24fb0d6963f7d28e17f72",
+  })
+  upload := func(body string, header http.Header) *ErrMsg {
+    r, _ := http.NewRequest("PUT", "/files/a", bytes.NewBufferString(body))
+    r.Header = header
+    return StoreRequestBody(r, storage, "a")
+  }
+
+  if em := upload("abc", rec.Header()); em != nil {
+    t.Fatal("Unexpected error:", em)
+  }
+  if em := upload("abd", rec.Header()); em == nil ||
+     em.ErrCode != ErrorChecksumMismatch {
+    t.Fatal("Corrupted uploads should be rejected:", em)
+  }
+  rc, err := storage.Get(context.Background(), "a")
+  if err != nil {
+    t.Fatal("Unable to read the stored file:", err)
+  }
+  data, _ := ioutil.ReadAll(rc)
+  rc.Close()
+  if string(data) != "abc" {
+    t.Fatal("The corrupted upload should not be stored:", string(data))
+  }
+
+  if em := upload("abc", http.Header{"Content-Md5": []string{"x"}}); em == nil ||
+     em.ErrCode != ErrorChecksumMismatch {
+    t.Fatal("Malformed headers should be rejected:", em)
+  }
+}
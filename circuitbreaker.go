@@ -0,0 +1,137 @@
+package ign
+
+import (
+  "math"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+  "github.com/codegangsta/negroni"
+)
+
+// Routes that depend on unreliable services (eg. S3 or git remotes) can set
+// the Route.CircuitBreaker field. After FailureThreshold consecutive failed
+// requests (5xx responses, or panics), the circuit opens and the requests
+// are rejected with ErrorCircuitOpen (HTTP 503) and a Retry-After header,
+// instead of waiting for the service to time out. After the cool-down, a
+// single request is let through: the circuit closes if it succeeds, and
+// opens again otherwise. The state is kept in memory, per server instance.
+
+// defaultCircuitCoolDown is the cool-down used if CircuitBreaker.CoolDown is
+// not set.
+const defaultCircuitCoolDown = 30 * time.Second
+
+// CircuitBreaker configures the circuit breaker of a route.
+type CircuitBreaker struct {
+  // Number of consecutive failures that open the circuit. Defaults to 5.
+  FailureThreshold int `json:"failure_threshold,omitempty"`
+  // Time the circuit stays open before a request is let through. Defaults
+  // to 30 seconds.
+  CoolDown time.Duration `json:"cool_down,omitempty"`
+}
+
+// circuitState is the state of a circuit breaker.
+type circuitState int
+
+const (
+  circuitClosed circuitState = iota
+  circuitOpen
+  // A trial request is running after the cool-down.
+  circuitHalfOpen
+)
+
+// circuitBreaker tracks the failures of a route.
+type circuitBreaker struct {
+  name string
+  threshold int
+  coolDown time.Duration
+  mutex sync.Mutex
+  state circuitState
+  failures int
+  openedAt time.Time
+}
+
+// newCircuitBreaker creates a circuitBreaker from a CircuitBreaker
+// configuration.
+func newCircuitBreaker(name string, cfg CircuitBreaker) *circuitBreaker {
+  cb := &circuitBreaker{
+    name: name,
+    threshold: cfg.FailureThreshold,
+    coolDown: cfg.CoolDown,
+  }
+  if cb.threshold <= 0 {
+    cb.threshold = 5
+  }
+  if cb.coolDown <= 0 {
+    cb.coolDown = defaultCircuitCoolDown
+  }
+  return cb
+}
+
+// allow returns true if a request can be sent. If not, it returns the time
+// until the next trial request.
+func (cb *circuitBreaker) allow(now time.Time) (bool, time.Duration) {
+  cb.mutex.Lock()
+  defer cb.mutex.Unlock()
+  switch cb.state {
+  case circuitOpen:
+    if wait := cb.openedAt.Add(cb.coolDown).Sub(now); wait > 0 {
+      return false, wait
+    }
+    cb.state = circuitHalfOpen
+    return true, 0
+  case circuitHalfOpen:
+    // Only the trial request is let through.
+    return false, cb.coolDown
+  }
+  return true, 0
+}
+
+// done records the result of a request let through by allow.
+func (cb *circuitBreaker) done(failed bool, now time.Time) {
+  cb.mutex.Lock()
+  defer cb.mutex.Unlock()
+  if !failed {
+    if cb.state != circuitClosed {
+      gLogger.Info("Circuit closed", Fields{"route": cb.name})
+    }
+    cb.state = circuitClosed
+    cb.failures = 0
+    return
+  }
+  cb.failures++
+  if cb.state == circuitHalfOpen || cb.failures >= cb.threshold {
+    if cb.state != circuitOpen {
+      gLogger.Warn("Circuit opened", Fields{"route": cb.name,
+                                            "failures": cb.failures})
+    }
+    cb.state = circuitOpen
+    cb.openedAt = now
+  }
+}
+
+/////////////////////////////////////////////////
+// newCircuitBreakerMiddleware returns a middleware that rejects requests
+// while the circuit of the route is open.
+func newCircuitBreakerMiddleware(cb *circuitBreaker) negroni.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    allowed, wait := cb.allow(clock().Now())
+    if !allowed {
+      seconds := int(math.Ceil(wait.Seconds()))
+      if seconds < 1 {
+        seconds = 1
+      }
+      w.Header().Set("Retry-After", strconv.Itoa(seconds))
+      reportJSONError(w, r, ErrorMessage(ErrorCircuitOpen))
+      return
+    }
+
+    rw := negroni.NewResponseWriter(w)
+    failed := true
+    defer func() {
+      cb.done(failed, clock().Now())
+    }()
+    next(rw, r)
+    failed = rw.Status() >= http.StatusInternalServerError
+  }
+}
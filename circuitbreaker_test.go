@@ -0,0 +1,54 @@
+package ign
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+)
+
+// TestCircuitBreaker tests opening and closing the circuit of a route.
+func TestCircuitBreaker(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  c := NewManualClock(time.Now())
+  gServer = &Server{Clock: c}
+
+  mw := newCircuitBreakerMiddleware(newCircuitBreaker("models",
+    CircuitBreaker{FailureThreshold: 2, CoolDown: time.Minute}))
+  status := http.StatusBadGateway
+  send := func() *httptest.ResponseRecorder {
+    rec := httptest.NewRecorder()
+    req, _ := http.NewRequest("GET", "/models", nil)
+    mw(rec, req, func(w http.ResponseWriter, r *http.Request) {
+      w.WriteHeader(status)
+    })
+    return rec
+  }
+
+  send()
+  if rec := send(); rec.Code != http.StatusBadGateway {
+    t.Fatal("Requests should be sent until the threshold:", rec.Code)
+  }
+  rec := send()
+  if em := ErrorMessage(ErrorCircuitOpen); rec.Code != em.StatusCode ||
+     rec.Header().Get("Retry-After") != "60" {
+    t.Fatal("The circuit should be open:", rec.Code, rec.Header())
+  }
+
+  // The trial request fails, so the circuit opens again
+  c.Add(time.Minute)
+  if rec := send(); rec.Code != http.StatusBadGateway {
+    t.Fatal("A trial request should be sent after the cool-down:", rec.Code)
+  }
+  if rec := send(); rec.Code != http.StatusServiceUnavailable {
+    t.Fatal("A failed trial should open the circuit:", rec.Code)
+  }
+
+  c.Add(time.Minute)
+  status = http.StatusOK
+  send()
+  if rec := send(); rec.Code != http.StatusOK {
+    t.Fatal("A successful trial should close the circuit:", rec.Code)
+  }
+}
@@ -0,0 +1,158 @@
+package ign
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "io"
+  "net/http"
+  "sync"
+  "time"
+)
+
+// clientTokenRefreshMargin is how long before its expiration a service
+// token is renewed.
+const clientTokenRefreshMargin = time.Minute
+
+// ClientCredentials are the settings of the OAuth2 client credentials flow
+// used by a Client to get its service token.
+type ClientCredentials struct {
+  // Token endpoint (eg. https://<tenant>.auth0.com/oauth/token).
+  TokenURL string
+  ClientID string
+  ClientSecret string
+  // API identifier the token is requested for.
+  Audience string
+}
+
+// Client is an HTTP client used to call the secure routes of other ign-go
+// services. It attaches a service JWT, obtained using the client credentials
+// flow, to every request, and renews it before it expires.
+type Client struct {
+  // HTTP client used to send the requests. Defaults to a client with a 30
+  // seconds timeout.
+  HTTPClient *http.Client
+
+  credentials ClientCredentials
+  mutex sync.Mutex
+  token string
+  expiry time.Time
+}
+
+// NewClient creates a Client that authenticates with the given credentials.
+func NewClient(credentials ClientCredentials) *Client {
+  return &Client{
+    HTTPClient: &http.Client{Timeout: 30 * time.Second},
+    credentials: credentials,
+  }
+}
+
+// Token returns the current service token, requesting a new one if there
+// is none or it is about to expire.
+func (c *Client) Token() (string, error) {
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+
+  if c.token != "" && time.Now().Add(clientTokenRefreshMargin).Before(c.expiry) {
+    return c.token, nil
+  }
+  if err := c.fetchToken(); err != nil {
+    return "", err
+  }
+  return c.token, nil
+}
+
+// fetchToken requests a token to the token endpoint. The mutex must be held.
+func (c *Client) fetchToken() error {
+  body, _ := json.Marshal(map[string]string{
+    "grant_type": "client_credentials",
+    "client_id": c.credentials.ClientID,
+    "client_secret": c.credentials.ClientSecret,
+    "audience": c.credentials.Audience,
+  })
+  resp, err := c.HTTPClient.Post(c.credentials.TokenURL, "application/json",
+                                 bytes.NewReader(body))
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return errors.New("Token request failed: " + resp.Status)
+  }
+
+  var result struct {
+    AccessToken string `json:"access_token"`
+    ExpiresIn int64 `json:"expires_in"`
+  }
+  if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+    return err
+  }
+  if result.AccessToken == "" {
+    return errors.New("Token response without access_token")
+  }
+  c.token = result.AccessToken
+  c.expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+  return nil
+}
+
+// invalidate discards the given token, so the next request gets a new one.
+func (c *Client) invalidate(token string) {
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  if c.token == token {
+    c.token = ""
+  }
+}
+
+// Do sends the request with the service token in its Authorization header.
+// If the response is 401 Unauthorized (eg. the token was revoked), the
+// token is renewed and the request is sent again, if its body can be
+// replayed.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+  token, err := c.Token()
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Authorization", "Bearer " + token)
+  resp, err := c.HTTPClient.Do(req)
+  if err != nil || resp.StatusCode != http.StatusUnauthorized {
+    return resp, err
+  }
+
+  c.invalidate(token)
+  if req.Body != nil && req.GetBody == nil {
+    return resp, nil
+  }
+  retry := req.Clone(req.Context())
+  if req.GetBody != nil {
+    if retry.Body, err = req.GetBody(); err != nil {
+      return resp, nil
+    }
+  }
+  if token, err = c.Token(); err != nil {
+    return resp, nil
+  }
+  resp.Body.Close()
+  retry.Header.Set("Authorization", "Bearer " + token)
+  return c.HTTPClient.Do(retry)
+}
+
+// Get sends an authenticated GET request to the given url.
+func (c *Client) Get(url string) (*http.Response, error) {
+  req, err := http.NewRequest("GET", url, nil)
+  if err != nil {
+    return nil, err
+  }
+  return c.Do(req)
+}
+
+// Post sends an authenticated POST request to the given url.
+func (c *Client) Post(url, contentType string,
+                      body io.Reader) (*http.Response, error) {
+  req, err := http.NewRequest("POST", url, body)
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Content-Type", contentType)
+  return c.Do(req)
+}
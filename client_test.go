@@ -0,0 +1,82 @@
+package ign
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "sync"
+  "testing"
+)
+
+// TestClient tests the service token handling of Client.
+func TestClient(t *testing.T) {
+  // mutex protects issued and received, which the servers update.
+  var mutex sync.Mutex
+  issued := 0
+  authServer := httptest.NewServer(http.HandlerFunc(
+    func(w http.ResponseWriter, r *http.Request) {
+      var body map[string]string
+      json.NewDecoder(r.Body).Decode(&body)
+      if body["grant_type"] != "client_credentials" ||
+         body["client_secret"] != "secret" {
+        w.WriteHeader(http.StatusUnauthorized)
+        return
+      }
+      mutex.Lock()
+      issued++
+      token := fmt.Sprintf("token%d", issued)
+      mutex.Unlock()
+      fmt.Fprintf(w, `{"access_token":"%s","expires_in":3600}`, token)
+    }))
+  defer authServer.Close()
+
+  // The API rejects token1, as if it was revoked.
+  var received []string
+  apiServer := httptest.NewServer(http.HandlerFunc(
+    func(w http.ResponseWriter, r *http.Request) {
+      auth := r.Header.Get("Authorization")
+      mutex.Lock()
+      received = append(received, auth)
+      mutex.Unlock()
+      if auth != "Bearer token2" {
+        w.WriteHeader(http.StatusUnauthorized)
+        return
+      }
+      w.Write([]byte("ok"))
+    }))
+  defer apiServer.Close()
+
+  client := NewClient(ClientCredentials{TokenURL: authServer.URL,
+                                        ClientID: "id",
+                                        ClientSecret: "secret",
+                                        Audience: "api"})
+  resp, err := client.Post(apiServer.URL, "text/plain", strings.NewReader("x"))
+  if err != nil {
+    t.Fatal("Request failed:", err)
+  }
+  resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    t.Fatal("Request was not retried with a new token:", resp.StatusCode)
+  }
+
+  // The token is reused
+  resp, err = client.Get(apiServer.URL)
+  if err != nil || resp.StatusCode != http.StatusOK {
+    t.Fatal("Second request failed:", err)
+  }
+  resp.Body.Close()
+  mutex.Lock()
+  tokens, requests := issued, len(received)
+  mutex.Unlock()
+  if tokens != 2 || requests != 3 {
+    t.Fatal("Unexpected number of tokens or requests:", tokens, requests)
+  }
+
+  bad := NewClient(ClientCredentials{TokenURL: authServer.URL,
+                                     ClientSecret: "wrong"})
+  if _, err := bad.Get(apiServer.URL); err == nil {
+    t.Fatal("Invalid credentials should fail")
+  }
+}
@@ -0,0 +1,113 @@
+package ign
+
+import (
+  "sort"
+  "sync"
+  "time"
+)
+
+// Clock is the source of time of the server subsystems that depend on it,
+// such as rate limits, the token cache and scheduled tasks. Tests can set a
+// ManualClock in Server.Clock to control time, instead of sleeping.
+type Clock interface {
+  // Now returns the current time.
+  Now() time.Time
+  // After returns a channel that receives the time once d has elapsed.
+  After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock of the system time.
+type realClock struct{}
+
+// Now is part of the Clock interface.
+func (realClock) Now() time.Time {
+  return time.Now()
+}
+
+// After is part of the Clock interface.
+func (realClock) After(d time.Duration) <-chan time.Time {
+  return time.After(d)
+}
+
+// clock returns the Clock of the server, or the system time if it is not
+// set.
+func clock() Clock {
+  if gServer != nil && gServer.Clock != nil {
+    return gServer.Clock
+  }
+  return realClock{}
+}
+
+// ManualClock is a Clock whose time only changes when Set or Add are
+// called. It is safe for concurrent use.
+type ManualClock struct {
+  mutex sync.Mutex
+  now time.Time
+  waiters []clockWaiter
+}
+
+// clockWaiter is a pending After channel of a ManualClock.
+type clockWaiter struct {
+  at time.Time
+  c chan time.Time
+}
+
+// NewManualClock creates a ManualClock with the given time.
+func NewManualClock(now time.Time) *ManualClock {
+  return &ManualClock{now: now}
+}
+
+// Now is part of the Clock interface.
+func (c *ManualClock) Now() time.Time {
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  return c.now
+}
+
+// After is part of the Clock interface. The channel receives the time when
+// the clock is moved to, or past, now + d.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  ch := make(chan time.Time, 1)
+  at := c.now.Add(d)
+  if d <= 0 {
+    ch <- c.now
+    return ch
+  }
+  c.waiters = append(c.waiters, clockWaiter{at: at, c: ch})
+  return ch
+}
+
+// Add moves the clock forward by d.
+func (c *ManualClock) Add(d time.Duration) {
+  c.Set(c.Now().Add(d))
+}
+
+// Set changes the time of the clock, firing the After channels that
+// expired, in order.
+func (c *ManualClock) Set(now time.Time) {
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  c.now = now
+  sort.SliceStable(c.waiters, func(i, j int) bool {
+    return c.waiters[i].at.Before(c.waiters[j].at)
+  })
+  pending := c.waiters[:0]
+  for _, w := range c.waiters {
+    if w.at.After(now) {
+      pending = append(pending, w)
+      continue
+    }
+    w.c <- now
+  }
+  c.waiters = pending
+}
+
+// Waiters returns the number of pending After channels. Tests use it to
+// wait until a goroutine is blocked on the clock before moving it.
+func (c *ManualClock) Waiters() int {
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  return len(c.waiters)
+}
@@ -0,0 +1,61 @@
+package ign
+
+import (
+  "testing"
+  "time"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// TestManualClock tests moving a ManualClock and its After channels.
+func TestManualClock(t *testing.T) {
+  start := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+  c := NewManualClock(start)
+  soon := c.After(time.Minute)
+  later := c.After(time.Hour)
+  if c.Waiters() != 2 {
+    t.Fatal("Unexpected waiters:", c.Waiters())
+  }
+
+  c.Add(time.Minute)
+  if !c.Now().Equal(start.Add(time.Minute)) {
+    t.Fatal("Unexpected time:", c.Now())
+  }
+  select {
+  case <-soon:
+  default:
+    t.Fatal("The expired channel should be fired")
+  }
+  select {
+  case <-later:
+    t.Fatal("The channel should not be fired before its time")
+  default:
+  }
+  if c.Waiters() != 1 {
+    t.Fatal("Unexpected waiters:", c.Waiters())
+  }
+  select {
+  case <-c.After(0):
+  default:
+    t.Fatal("A zero duration should fire immediately")
+  }
+}
+
+// TestTokenCacheClock tests the expiration of cached tokens using the
+// server clock.
+func TestTokenCacheClock(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  c := NewManualClock(time.Now())
+  gServer = &Server{Clock: c}
+
+  cache := newTokenCache(10)
+  cache.add("a", &jwt.Token{Claims: jwt.MapClaims{}})
+  c.Add(tokenCacheMaxAge - time.Second)
+  if _, ok := cache.get("a"); !ok {
+    t.Fatal("The token should still be cached")
+  }
+  c.Add(2 * time.Second)
+  if _, ok := cache.get("a"); ok {
+    t.Fatal("The token should expire with the server clock")
+  }
+}
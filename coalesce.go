@@ -0,0 +1,130 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "net/http"
+  "strings"
+  "golang.org/x/sync/singleflight"
+)
+
+// Request coalescing is used to protect expensive GET routes from bursts of
+// identical concurrent requests (eg. a popular resource requested by many
+// clients right after a cache expiry).
+// Concurrent requests with the same method, host, URL (route + params),
+// Accept header and user identity are collapsed into a single execution of
+// the wrapped handler, and the produced response is shared among all the
+// waiting requests. Conditional and range requests are not coalesced, as
+// their response depends on the client cache.
+// The typical usage is the following:
+// FormatHandler{".json", ign.Coalesce(ign.JSONResult(myHandler))}
+
+// coalescedResponse is the captured output of a handler execution that
+// will be replayed to all the coalesced requests.
+type coalescedResponse struct {
+  header http.Header
+  status int
+  body   []byte
+}
+
+// responseCapture is an http.ResponseWriter that keeps the written
+// header, status and body in memory.
+type responseCapture struct {
+  header http.Header
+  status int
+  body   bytes.Buffer
+}
+
+func newResponseCapture() *responseCapture {
+  return &responseCapture{header: http.Header{}}
+}
+
+// Header is part of the http.ResponseWriter interface.
+func (c *responseCapture) Header() http.Header {
+  return c.header
+}
+
+// WriteHeader is part of the http.ResponseWriter interface.
+func (c *responseCapture) WriteHeader(status int) {
+  if c.status == 0 {
+    c.status = status
+  }
+}
+
+// Write is part of the http.ResponseWriter interface.
+func (c *responseCapture) Write(b []byte) (int, error) {
+  if c.status == 0 {
+    c.status = http.StatusOK
+  }
+  return c.body.Write(b)
+}
+
+// Coalesce wraps the given handler so that concurrent identical GET and HEAD
+// requests are served by a single execution of the handler. See coalesceKey
+// for the requests considered identical.
+// Other HTTP methods, and conditional or range requests, are passed through
+// unmodified.
+func Coalesce(handler http.Handler) http.Handler {
+  var group singleflight.Group
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+       !coalescable(r) {
+      handler.ServeHTTP(w, r)
+      return
+    }
+
+    v, _, _ := group.Do(coalesceKey(r), func() (interface{}, error) {
+      // The response is shared, so it must not be cut short if the client
+      // of this request disconnects.
+      shared := r.WithContext(context.WithoutCancel(r.Context()))
+      capture := newResponseCapture()
+      handler.ServeHTTP(capture, shared)
+      if capture.status == 0 {
+        capture.status = http.StatusOK
+      }
+      return &coalescedResponse{capture.header, capture.status,
+                                capture.body.Bytes()}, nil
+    })
+
+    resp := v.(*coalescedResponse)
+    // Each waiter gets its own copy of the header values, as they may be
+    // modified by the middlewares of the waiter.
+    for k, values := range resp.header.Clone() {
+      w.Header()[k] = values
+    }
+    w.WriteHeader(resp.status)
+    w.Write(resp.body)
+  })
+}
+
+// uncoalescableHeaders are the request headers that make a response
+// specific to a client cache (eg. a 206 or 304 response).
+var uncoalescableHeaders = []string{"Range", "If-Range", "If-None-Match",
+                                    "If-Modified-Since", "If-Match",
+                                    "If-Unmodified-Since"}
+
+// coalescable returns false if the request is a conditional or range
+// request.
+func coalescable(r *http.Request) bool {
+  for _, h := range uncoalescableHeaders {
+    if r.Header.Get(h) != "" {
+      return false
+    }
+  }
+  return true
+}
+
+// coalesceKey returns the key used to identify identical requests. They
+// share the method, host, URL, Accept header and identity: the JWT subject
+// or session of authenticated requests, or the anonymous ID of the others.
+func coalesceKey(r *http.Request) string {
+  identity, _ := GetUserIdentity(r)
+  session := ""
+  if s, ok := SessionFromRequest(r); ok {
+    session = s.ID
+  }
+  anonymous, _ := AnonymousID(r)
+  return strings.Join([]string{r.Method, r.Host, r.URL.String(),
+                               r.Header.Get("Accept"), identity, session,
+                               anonymous}, "\x00")
+}
@@ -0,0 +1,131 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "sync"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+// Tests for request coalescing
+
+// TestCoalesceGET checks that concurrent identical GET requests are served
+// by a single handler execution.
+func TestCoalesceGET(t *testing.T) {
+  var calls int32
+  release := make(chan struct{})
+  handler := Coalesce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt32(&calls, 1)
+    <-release
+    w.Header().Set("Content-Type", "application/json")
+    w.Write([]byte(`{"ok":true}`))
+  }))
+
+  const count = 5
+  var wg sync.WaitGroup
+  recorders := make([]*httptest.ResponseRecorder, count)
+  for i := 0; i < count; i++ {
+    recorders[i] = httptest.NewRecorder()
+    wg.Add(1)
+    go func(rec *httptest.ResponseRecorder) {
+      defer wg.Done()
+      req, _ := http.NewRequest("GET", "/1.0/models?page=2", nil)
+      handler.ServeHTTP(rec, req)
+    }(recorders[i])
+  }
+  // Give all the requests time to join the in-flight call.
+  time.Sleep(100 * time.Millisecond)
+  close(release)
+  wg.Wait()
+
+  if got := atomic.LoadInt32(&calls); got != 1 {
+    t.Fatal("Expected a single handler execution, got:", got)
+  }
+  for _, rec := range recorders {
+    if rec.Code != http.StatusOK || rec.Body.String() != `{"ok":true}` {
+      t.Fatal("Unexpected coalesced response", rec.Code, rec.Body.String())
+    }
+    if rec.Header().Get("Content-Type") != "application/json" {
+      t.Fatal("Missing Content-Type in coalesced response")
+    }
+  }
+  // The waiters don't share the header values.
+  recorders[0].Header().Add("Content-Type", "text/plain")
+  recorders[0].Header()["Content-Type"][0] = "text/html"
+  if values := recorders[1].Header()["Content-Type"]; len(values) != 1 ||
+     values[0] != "application/json" {
+    t.Fatal("The coalesced headers should be copied:", values)
+  }
+}
+
+// TestCoalescePOST checks that non GET requests are not coalesced.
+func TestCoalescePOST(t *testing.T) {
+  var calls int32
+  handler := Coalesce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt32(&calls, 1)
+    w.WriteHeader(http.StatusCreated)
+  }))
+
+  for i := 0; i < 3; i++ {
+    rec := httptest.NewRecorder()
+    req, _ := http.NewRequest("POST", "/1.0/models", nil)
+    handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusCreated {
+      t.Fatal("Unexpected status code", rec.Code)
+    }
+  }
+  if got := atomic.LoadInt32(&calls); got != 3 {
+    t.Fatal("Expected 3 handler executions, got:", got)
+  }
+}
+
+// TestCoalesceKey checks the requests that are not coalesced together.
+func TestCoalesceKey(t *testing.T) {
+  newRequest := func(host, accept string) *http.Request {
+    req, _ := http.NewRequest("GET", "/1.0/models", nil)
+    req.Host = host
+    req.Header.Set("Accept", accept)
+    return req
+  }
+  key := coalesceKey(newRequest("a.example.com", "application/json"))
+  if key == coalesceKey(newRequest("b.example.com", "application/json")) ||
+     key == coalesceKey(newRequest("a.example.com", "application/x-protobuf")) {
+    t.Fatal("Requests with different hosts or formats should not be coalesced")
+  }
+  if key != coalesceKey(newRequest("a.example.com", "application/json")) {
+    t.Fatal("Identical requests should be coalesced")
+  }
+
+  req := newRequest("a.example.com", "application/json")
+  if !coalescable(req) {
+    t.Fatal("Plain GET requests should be coalesced")
+  }
+  req.Header.Set("If-None-Match", `"abc"`)
+  if coalescable(req) {
+    t.Fatal("Conditional requests should not be coalesced")
+  }
+}
+
+// TestCoalesceCancel checks that the shared execution is not cancelled
+// when the first client disconnects.
+func TestCoalesceCancel(t *testing.T) {
+  handler := Coalesce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.Context().Err() != nil {
+      w.WriteHeader(http.StatusServiceUnavailable)
+      return
+    }
+    w.Write([]byte("ok"))
+  }))
+
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  req, _ := http.NewRequest("GET", "/1.0/models", nil)
+  rec := httptest.NewRecorder()
+  handler.ServeHTTP(rec, req.WithContext(ctx))
+  if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+    t.Fatal("The shared execution should not be cancelled:", rec.Code)
+  }
+}
@@ -0,0 +1,246 @@
+package ign
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+  "gopkg.in/yaml.v2"
+)
+
+// Config contains the server properties that can be loaded from a YAML or
+// JSON file using Server.LoadConfig. Empty values are ignored.
+// Example (YAML):
+//
+//   http_port: ":8000"
+//   ssl_port: ":4430"
+//   tls:
+//     cert: /etc/ign/cert.pem
+//     key: /etc/ign/key.pem
+//   database:
+//     username: fuel
+//     address: localhost:3306
+//     name: fuel
+//   analytics:
+//     tracking_id: UA-XXXX-Y
+//     app_name: fuel
+type Config struct {
+  // Port used for non-secure requests
+  HTTPPort string `json:"http_port" yaml:"http_port"`
+  // Port used for secure requests
+  SSLport string `json:"ssl_port" yaml:"ssl_port"`
+  // Path of the Unix domain socket used for non-secure requests
+  UnixSocket string `json:"unix_socket" yaml:"unix_socket"`
+  // API version used to redirect unversioned requests
+  DefaultAPIVersion string `json:"default_api_version" yaml:"default_api_version"`
+  // API versions that are deprecated
+  DeprecatedAPIVersions []string `json:"deprecated_api_versions" yaml:"deprecated_api_versions"`
+  // Path of the route that lists all the routes
+  RoutesIndexPath string `json:"routes_index_path" yaml:"routes_index_path"`
+  // Max size, in bytes, of request bodies
+  MaxRequestBodySize int64 `json:"max_request_body_size" yaml:"max_request_body_size"`
+  // Max bytes kept in memory when parsing multipart forms
+  MaxMultipartMemory int64 `json:"max_multipart_memory" yaml:"max_multipart_memory"`
+  // Page sizes of paginated routes
+  Pagination PaginationConfig `json:"pagination" yaml:"pagination"`
+  // Proxies whose forwarding headers are trusted (IPs or CIDR ranges)
+  TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+  // Format of the requests log (text, json or fields)
+  AccessLogFormat string `json:"access_log_format" yaml:"access_log_format"`
+  // Format of the error responses (errmsg or problem)
+  ErrorFormat string `json:"error_format" yaml:"error_format"`
+  // Prefix of the "type" of problem+json errors
+  ProblemTypeBaseURL string `json:"problem_type_base_url" yaml:"problem_type_base_url"`
+  // Include the stack trace of panics in error responses
+  DebugErrors bool `json:"debug_errors" yaml:"debug_errors"`
+  // Enable the /healthz and /readyz routes
+  HealthRoutes bool `json:"health_routes" yaml:"health_routes"`
+  // TLS settings
+  TLS TLSConfig `json:"tls" yaml:"tls"`
+  // Database connection settings
+  Database DatabaseConfig `json:"database" yaml:"database"`
+  // Google Analytics settings
+  Analytics AnalyticsConfig `json:"analytics" yaml:"analytics"`
+  // Authentication settings
+  Auth AuthConfig `json:"auth" yaml:"auth"`
+}
+
+// TLSConfig contains the TLS settings of a Config.
+type TLSConfig struct {
+  // Path to the SSL certificate.
+  Cert string `json:"cert" yaml:"cert"`
+  // Path to the SSL private key.
+  Key string `json:"key" yaml:"key"`
+  // Serve HTTP and redirect it to HTTPS. See Server.RedirectHTTPToHTTPS.
+  RedirectHTTP bool `json:"redirect_http" yaml:"redirect_http"`
+  // Host names to obtain certificates for using Let's Encrypt.
+  AutocertHosts []string `json:"autocert_hosts" yaml:"autocert_hosts"`
+  // Directory where Let's Encrypt certificates are stored.
+  AutocertCacheDir string `json:"autocert_cache_dir" yaml:"autocert_cache_dir"`
+}
+
+// AnalyticsConfig contains the Google Analytics settings of a Config.
+type AnalyticsConfig struct {
+  // Google Analytics tracking ID. The format is UA-XXXX-Y
+  TrackingID string `json:"tracking_id" yaml:"tracking_id"`
+  // Google Analytics Application Name
+  AppName string `json:"app_name" yaml:"app_name"`
+  // (optional) A string to use as a prefix to GA Event Category.
+  CategoryPrefix string `json:"category_prefix" yaml:"category_prefix"`
+}
+
+// AuthConfig contains the authentication settings of a Config.
+type AuthConfig struct {
+  // Auth0 public key used for token validation
+  Auth0RsaPublicKey string `json:"auth0_rsa_public_key" yaml:"auth0_rsa_public_key"`
+  // Auth0 JWKS URL. If set, it is used instead of the public key
+  Auth0JWKSURL string `json:"auth0_jwks_url" yaml:"auth0_jwks_url"`
+  // Assign anonymous IDs to unauthenticated requests
+  AnonymousIdentities bool `json:"anonymous_identities" yaml:"anonymous_identities"`
+  // Secret included in the hash of anonymous IDs
+  AnonymousIDSalt string `json:"anonymous_id_salt" yaml:"anonymous_id_salt"`
+  // Shared secret used to validate HS256 tokens
+  JWTSharedSecret string `json:"jwt_hs256_secret" yaml:"jwt_hs256_secret"`
+  // ECDSA public key (PEM) used to validate ES256 tokens
+  JWTES256PublicKey string `json:"jwt_es256_public_key" yaml:"jwt_es256_public_key"`
+  // Accepted token issuers
+  JWTTrustedIssuers []string `json:"jwt_trusted_issuers" yaml:"jwt_trusted_issuers"`
+}
+
+// ReadConfigFile parses the given YAML or JSON config file. The format is
+// chosen based on the file extension (.json, .yaml or .yml). Unknown fields
+// are rejected.
+func ReadConfigFile(path string) (*Config, error) {
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  var cfg Config
+  switch strings.ToLower(filepath.Ext(path)) {
+  case ".json":
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.DisallowUnknownFields()
+    err = decoder.Decode(&cfg)
+  case ".yaml", ".yml":
+    err = yaml.UnmarshalStrict(data, &cfg)
+  default:
+    return nil, errors.New("Unknown config file format [" + path + "]")
+  }
+  if err != nil {
+    return nil, err
+  }
+  return &cfg, nil
+}
+
+// LoadConfig configures the server using the given YAML or JSON config file.
+// Env vars (eg. IGN_DB_USERNAME) take precedence over the file values.
+func (s *Server) LoadConfig(path string) error {
+  cfg, err := ReadConfigFile(path)
+  if err != nil {
+    return err
+  }
+  s.applyConfig(cfg)
+  return s.readPropertiesFromEnvVars()
+}
+
+// applyConfig sets the non empty values of the given config in the server.
+func (s *Server) applyConfig(cfg *Config) {
+  setIfNotEmpty(&s.HTTPPort, cfg.HTTPPort)
+  setIfNotEmpty(&s.SSLport, cfg.SSLport)
+  setIfNotEmpty(&s.UnixSocket, cfg.UnixSocket)
+
+  setIfNotEmpty(&s.DefaultAPIVersion, cfg.DefaultAPIVersion)
+  if len(cfg.DeprecatedAPIVersions) > 0 {
+    s.DeprecatedAPIVersions = cfg.DeprecatedAPIVersions
+  }
+  setIfNotEmpty(&s.RoutesIndexPath, cfg.RoutesIndexPath)
+  if cfg.MaxRequestBodySize > 0 {
+    s.MaxRequestBodySize = cfg.MaxRequestBodySize
+  }
+  if cfg.MaxMultipartMemory > 0 {
+    s.MaxMultipartMemory = cfg.MaxMultipartMemory
+  }
+  if cfg.Pagination.DefaultPageSize > 0 {
+    s.Pagination.DefaultPageSize = cfg.Pagination.DefaultPageSize
+  }
+  if cfg.Pagination.MaxPageSize > 0 {
+    s.Pagination.MaxPageSize = cfg.Pagination.MaxPageSize
+  }
+  setIfNotEmpty(&s.Pagination.OversizePolicy, cfg.Pagination.OversizePolicy)
+  if len(cfg.TrustedProxies) > 0 {
+    s.TrustedProxies = cfg.TrustedProxies
+  }
+  setIfNotEmpty(&s.AccessLogFormat, cfg.AccessLogFormat)
+  setIfNotEmpty(&s.ErrorFormat, cfg.ErrorFormat)
+  setIfNotEmpty(&s.ProblemTypeBaseURL, cfg.ProblemTypeBaseURL)
+  s.DebugErrors = s.DebugErrors || cfg.DebugErrors
+  s.HealthRoutes = s.HealthRoutes || cfg.HealthRoutes
+
+  setIfNotEmpty(&s.SSLCert, cfg.TLS.Cert)
+  setIfNotEmpty(&s.SSLKey, cfg.TLS.Key)
+  s.RedirectHTTPToHTTPS = s.RedirectHTTPToHTTPS || cfg.TLS.RedirectHTTP
+  if len(cfg.TLS.AutocertHosts) > 0 {
+    s.AutocertHosts = cfg.TLS.AutocertHosts
+  }
+  setIfNotEmpty(&s.AutocertCacheDir, cfg.TLS.AutocertCacheDir)
+
+  setIfNotEmpty(&s.DbConfig.UserName, cfg.Database.UserName)
+  setIfNotEmpty(&s.DbConfig.Password, cfg.Database.Password)
+  setIfNotEmpty(&s.DbConfig.Address, cfg.Database.Address)
+  setIfNotEmpty(&s.DbConfig.Name, cfg.Database.Name)
+  if cfg.Database.MaxOpenConns > 0 {
+    s.DbConfig.MaxOpenConns = cfg.Database.MaxOpenConns
+  }
+  if cfg.Database.MaxIdleConns != 0 {
+    s.DbConfig.MaxIdleConns = cfg.Database.MaxIdleConns
+  }
+  if cfg.Database.ConnMaxLifetime > 0 {
+    s.DbConfig.ConnMaxLifetime = cfg.Database.ConnMaxLifetime
+  }
+  setIfNotEmpty(&s.DbConfig.Dialect, cfg.Database.Dialect)
+  s.DbConfig.PrepareStmt = s.DbConfig.PrepareStmt || cfg.Database.PrepareStmt
+  if cfg.Database.SlowQueryThreshold != 0 {
+    s.DbConfig.SlowQueryThreshold = cfg.Database.SlowQueryThreshold
+  }
+  if cfg.Database.MaxAttempts > 0 {
+    s.DbConfig.MaxAttempts = cfg.Database.MaxAttempts
+  }
+  if cfg.Database.RetryDelay > 0 {
+    s.DbConfig.RetryDelay = cfg.Database.RetryDelay
+  }
+  if cfg.Database.RetryBackoff > 0 {
+    s.DbConfig.RetryBackoff = cfg.Database.RetryBackoff
+  }
+  if cfg.Database.MaxRetryDelay > 0 {
+    s.DbConfig.MaxRetryDelay = cfg.Database.MaxRetryDelay
+  }
+  if cfg.Database.RetryJitter > 0 {
+    s.DbConfig.RetryJitter = cfg.Database.RetryJitter
+  }
+
+  setIfNotEmpty(&s.GaTrackingID, cfg.Analytics.TrackingID)
+  setIfNotEmpty(&s.GaAppName, cfg.Analytics.AppName)
+  setIfNotEmpty(&s.GaCategoryPrefix, cfg.Analytics.CategoryPrefix)
+
+  if cfg.Auth.Auth0RsaPublicKey != "" {
+    s.SetAuth0RsaPublicKey(cfg.Auth.Auth0RsaPublicKey)
+  }
+  setIfNotEmpty(&s.Auth0JWKSURL, cfg.Auth.Auth0JWKSURL)
+  s.AnonymousIdentities = s.AnonymousIdentities || cfg.Auth.AnonymousIdentities
+  setIfNotEmpty(&s.AnonymousIDSalt, cfg.Auth.AnonymousIDSalt)
+  setIfNotEmpty(&s.JWTSharedSecret, cfg.Auth.JWTSharedSecret)
+  setIfNotEmpty(&s.JWTES256PublicKey, cfg.Auth.JWTES256PublicKey)
+  if len(cfg.Auth.JWTTrustedIssuers) > 0 {
+    s.JWTTrustedIssuers = cfg.Auth.JWTTrustedIssuers
+  }
+}
+
+// setIfNotEmpty sets dst to value, unless value is empty.
+func setIfNotEmpty(dst *string, value string) {
+  if value != "" {
+    *dst = value
+  }
+}
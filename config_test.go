@@ -0,0 +1,78 @@
+package ign
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+// Tests for config file loading
+
+// TestLoadConfig tests that config files are loaded and that env vars take
+// precedence over file values.
+func TestLoadConfig(t *testing.T) {
+  dir, err := ioutil.TempDir("", "ign-config")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  files := map[string]string{
+    "config.yaml": `
+http_port: ":9000"
+tls:
+  cert: /tmp/cert.pem
+database:
+  username: fuel
+  name: fueldb
+  max_open_conns: 5
+analytics:
+  app_name: fuel
+`,
+    "config.json": `{"http_port": ":9000", "tls": {"cert": "/tmp/cert.pem"},
+      "database": {"username": "fuel", "name": "fueldb", "max_open_conns": 5},
+      "analytics": {"app_name": "fuel"}}`,
+  }
+
+  os.Setenv("IGN_DB_NAME", "envdb")
+  defer os.Unsetenv("IGN_DB_NAME")
+
+  for name, contents := range files {
+    path := filepath.Join(dir, name)
+    if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+      t.Fatal(err)
+    }
+
+    s := Server{HTTPPort: ":8000"}
+    if err := s.LoadConfig(path); err != nil {
+      t.Fatal("Unable to load config", name, err)
+    }
+    if s.HTTPPort != ":9000" || s.SSLCert != "/tmp/cert.pem" ||
+       s.DbConfig.UserName != "fuel" || s.DbConfig.MaxOpenConns != 5 ||
+       s.GaAppName != "fuel" {
+      t.Fatal("Unexpected values loaded from", name, s.HTTPPort, s.SSLCert,
+              s.DbConfig, s.GaAppName)
+    }
+    if s.DbConfig.Name != "envdb" {
+      t.Fatal("Env var should override config file value. Got:", s.DbConfig.Name)
+    }
+  }
+
+  // Unknown formats and fields are rejected
+  bad := filepath.Join(dir, "config.txt")
+  ioutil.WriteFile(bad, []byte("http_port: 1"), 0644)
+  if _, err := ReadConfigFile(bad); err == nil {
+    t.Fatal("Expected an error with an unknown config file format")
+  }
+  bad = filepath.Join(dir, "bad.yaml")
+  ioutil.WriteFile(bad, []byte("unknown_field: 1"), 0644)
+  if _, err := ReadConfigFile(bad); err == nil {
+    t.Fatal("Expected an error with an unknown config field")
+  }
+  bad = filepath.Join(dir, "bad.json")
+  ioutil.WriteFile(bad, []byte(`{"http_prot": ":9000"}`), 0644)
+  if _, err := ReadConfigFile(bad); err == nil {
+    t.Fatal("Expected an error with an unknown JSON config field")
+  }
+}
@@ -1,14 +1,34 @@
 package ign
 
 import (
+  "context"
+  "database/sql"
+  "errors"
+  "net/http"
+  "os"
+  "sync"
+  "sync/atomic"
   "testing"
+  "time"
+  "gorm.io/gorm"
+  "gorm.io/gorm/logger"
 )
 
+// requireSQLite skips the test if the SQLite driver is not built in. The
+// SQLite tests need -tags sqlite, as the driver requires cgo.
+func requireSQLite(t *testing.T) {
+  if sqliteDialector == nil {
+    t.Skip("SQLite tests need -tags sqlite")
+  }
+}
+
 /////////////////////////////////////////////////
 // Test a bad connection to the database
 func TestBadDatabase(t *testing.T) {
   var server Server
   server.Db = nil
+  server.DbConfig.MaxAttempts = 2
+  server.DbConfig.RetryDelay = time.Millisecond
   err := server.dbInit()
 
   if err == nil {
@@ -20,5 +40,293 @@ func TestBadDatabase(t *testing.T) {
   }
 }
 
+/////////////////////////////////////////////////
+// Test that connection retries can be cancelled
+func TestDatabaseRetryCancel(t *testing.T) {
+  var server Server
+  server.DbConfig.RetryDelay = time.Hour
+  ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+  defer cancel()
+
+  if err := server.ConnectDatabase(ctx); err != context.DeadlineExceeded {
+    t.Fatal("Expected the connection retries to be cancelled. Got:", err)
+  }
+  if server.Db != nil {
+    t.Fatal("Database should be nil")
+  }
+}
+
+/////////////////////////////////////////////////
+// Test the database retry policy delays
+func TestDatabaseRetryDelay(t *testing.T) {
+  c := DatabaseConfig{RetryDelay: time.Second, RetryBackoff: 3,
+                      MaxRetryDelay: 20 * time.Second}
+  exp := []time.Duration{time.Second, 3 * time.Second, 9 * time.Second,
+                         20 * time.Second}
+  for i, e := range exp {
+    if got := c.retryDelay(i); got != e {
+      t.Fatal("Unexpected retry delay", i, got, e)
+    }
+  }
+
+  c.RetryJitter = 0.5
+  for i := 0; i < 20; i++ {
+    if got := c.retryDelay(0); got < 500 * time.Millisecond ||
+                                got > 1500 * time.Millisecond {
+      t.Fatal("Retry delay out of the jitter range", got)
+    }
+  }
+}
+
+/////////////////////////////////////////////////
+// Test reading the connection pool settings from env vars
+func TestDatabasePoolEnvVars(t *testing.T) {
+  os.Setenv("IGN_DB_MAX_IDLE_CONNS", "4")
+  os.Setenv("IGN_DB_CONN_MAX_LIFETIME", "5m")
+  defer os.Unsetenv("IGN_DB_MAX_IDLE_CONNS")
+  defer os.Unsetenv("IGN_DB_CONN_MAX_LIFETIME")
+
+  var server Server
+  server.readPropertiesFromEnvVars()
+  if server.DbConfig.MaxIdleConns != 4 {
+    t.Fatal("Unexpected max idle conns", server.DbConfig.MaxIdleConns)
+  }
+  if server.DbConfig.ConnMaxLifetime != 5 * time.Minute {
+    t.Fatal("Unexpected conn max lifetime", server.DbConfig.ConnMaxLifetime)
+  }
+}
+
+/////////////////////////////////////////////////
+// Test reading database settings with a custom env var prefix
+func TestReadDatabaseConfig(t *testing.T) {
+  os.Setenv("ARCHIVE_DB_NAME", "archive")
+  os.Setenv("ARCHIVE_DB_MAX_OPEN_CONNS", "8")
+  os.Setenv("ARCHIVE_DB_CONN_MAX_LIFETIME", "1m")
+  defer os.Unsetenv("ARCHIVE_DB_NAME")
+  defer os.Unsetenv("ARCHIVE_DB_MAX_OPEN_CONNS")
+  defer os.Unsetenv("ARCHIVE_DB_CONN_MAX_LIFETIME")
+
+  c, err := ReadDatabaseConfig("ARCHIVE")
+  if err != nil {
+    t.Fatal("Unexpected error:", err)
+  }
+  if c.Name != "archive" || c.MaxOpenConns != 8 ||
+     c.ConnMaxLifetime != time.Minute || c.UserName != "" {
+    t.Fatal("Unexpected config:", c)
+  }
+
+  os.Setenv("ARCHIVE_DB_MAX_OPEN_CONNS", "many")
+  if c, err = ReadDatabaseConfig("ARCHIVE"); err == nil {
+    t.Fatal("An invalid value should fail")
+  }
+  if c.Name != "archive" || c.MaxOpenConns != 0 {
+    t.Fatal("Valid settings should be read:", c)
+  }
+}
+
 /// \todo: Figure out how to test the database without including username
 /// and password information in the source code
+
+/////////////////////////////////////////////////
+// Test an in-memory SQLite database
+func TestSQLiteDatabase(t *testing.T) {
+  requireSQLite(t)
+  var server Server
+  server.DbConfig.Dialect = DialectSQLite
+  server.DbConfig.Name = sqliteInMemory
+  if err := server.dbInit(); err != nil {
+    t.Fatal("Unable to open the SQLite database", err)
+  }
+  defer sqlDB(server.Db).Close()
+
+  type item struct {
+    ID uint
+    Name string
+  }
+  if err := server.Db.AutoMigrate(&item{}); err != nil {
+    t.Fatal(err)
+  }
+  server.Db.Create(&item{Name: "test"})
+  var got item
+  if err := server.Db.First(&got).Error; err != nil || got.Name != "test" {
+    t.Fatal("Unable to read from the SQLite database", got, err)
+  }
+}
+
+/////////////////////////////////////////////////
+// Test the context-aware database helpers
+func TestTransaction(t *testing.T) {
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  server := Server{Db: db}
+  ctx := context.Background()
+
+  err := server.Transaction(ctx, func(tx *gorm.DB) error {
+    tx.Create(&listItem{Name: "rolled back"})
+    return errors.New("failure")
+  })
+  if err == nil {
+    t.Fatal("Expected the transaction error")
+  }
+  server.Transaction(ctx, func(tx *gorm.DB) error {
+    return tx.Create(&listItem{Name: "committed"}).Error
+  })
+  var count int64
+  server.DbWithContext(ctx).Model(&listItem{}).Where("name IN (?)",
+    []string{"rolled back", "committed"}).Count(&count)
+  if count != 1 {
+    t.Fatal("Expected only the committed item. Got:", count)
+  }
+
+  canceled, cancel := context.WithCancel(ctx)
+  cancel()
+  var items []listItem
+  if err := server.DbWithContext(canceled).Find(&items).Error; err == nil {
+    t.Fatal("Queries with a canceled context should fail")
+  }
+}
+
+/////////////////////////////////////////////////
+// Test the request-scoped database handle
+func TestDBFromRequest(t *testing.T) {
+  prevServer, prevLogger := gServer, gLogger
+  defer func() { gServer, gLogger = prevServer, prevLogger }()
+
+  req, _ := http.NewRequest("GET", "/models", nil)
+  gServer = &Server{}
+  if DBFromRequest(req) != nil {
+    t.Fatal("Expected a nil handle without database")
+  }
+
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  gServer.Db = db.Session(&gorm.Session{Logger: newDbLogger(logger.Info, 0)})
+  gLogger = NewStdLogger(LogLevelWarn)
+  handle := DBFromRequest(req)
+  if handle.Statement.Context != req.Context() {
+    t.Fatal("The handle should use the request context")
+  }
+  if l, ok := handle.Logger.(*dbLogger); !ok || l.level != logger.Warn {
+    t.Fatal("Queries should not be logged without debug logs:", handle.Logger)
+  }
+
+  // Requests can use other databases
+  other := newListItemsDB(t)
+  defer sqlDB(other).Close()
+  gServer = nil
+  if handle := DBFromRequest(WithDB(req, other)); handle == nil ||
+     handle.ConnPool != other.ConnPool {
+    t.Fatal("The request database should be used")
+  }
+}
+
+/////////////////////////////////////////////////
+// Test the database health check
+func TestDbHealthCheck(t *testing.T) {
+  requireSQLite(t)
+  var server Server
+  server.DbConfig.MaxAttempts = 1
+  if server.DbHealthy() {
+    t.Fatal("Database should not be healthy without a connection")
+  }
+
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  server.StartDbHealthCheck(ctx, time.Hour)
+  if server.DbHealthy() || server.Db != nil {
+    t.Fatal("Database should not be healthy with a failed connection")
+  }
+
+  // A later check reconnects to the database
+  server.DbConfig.Dialect = DialectSQLite
+  server.DbConfig.Name = sqliteInMemory
+  server.checkDbHealth(ctx)
+  if !server.DbHealthy() {
+    t.Fatal("Database should be healthy after reconnecting")
+  }
+
+  sqlDB(server.Db).Close()
+  server.checkDbHealth(ctx)
+  if server.DbHealthy() {
+    t.Fatal("Database should not be healthy after being closed")
+  }
+}
+
+/////////////////////////////////////////////////
+// Test reconnecting to the database while requests use it. It detects data
+// races when run with -race.
+func TestDbReconnectWithRequests(t *testing.T) {
+  requireSQLite(t)
+  prev := gServer
+  defer func() { gServer = prev }()
+  server := &Server{}
+  server.DbConfig.Dialect = DialectSQLite
+  server.DbConfig.Name = sqliteInMemory
+  atomic.StoreInt32(&server.dbHealthChecking, 1)
+  gServer = server
+
+  done := make(chan struct{})
+  var wg sync.WaitGroup
+  for i := 0; i < 4; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      req, _ := http.NewRequest("GET", "/models", nil)
+      for {
+        select {
+        case <-done:
+          return
+        default:
+        }
+        if db := DBFromRequest(req); db != nil {
+          db.Exec("SELECT 1")
+        }
+        server.DbHealthy()
+      }
+    }()
+  }
+
+  ctx := context.Background()
+  for i := 0; i < 20; i++ {
+    server.checkDbHealth(ctx)
+    db := server.Database()
+    if db == nil || !server.DbHealthy() {
+      t.Fatal("The health check should reconnect to the database")
+    }
+    // Drop the connection, so the next check reconnects
+    server.setDatabase(nil)
+    sqlDB(db).Close()
+  }
+  close(done)
+  wg.Wait()
+}
+
+// listItem is the model used by the query helpers tests.
+type listItem struct {
+  ID uint
+  Name string
+  Status string
+  Owner string
+}
+
+// newListItemsDB returns an in-memory SQLite database with the given items.
+func newListItemsDB(t *testing.T, items ...listItem) *gorm.DB {
+  requireSQLite(t)
+  db, err := gorm.Open(sqliteDialector(sqliteInMemory), &gorm.Config{})
+  if err != nil {
+    t.Fatal("Unable to open the SQLite database", err)
+  }
+  if err := db.AutoMigrate(&listItem{}); err != nil {
+    t.Fatal(err)
+  }
+  for i := range items {
+    db.Create(&items[i])
+  }
+  return db
+}
+
+// sqlDB returns the connection pool of a database.
+func sqlDB(db *gorm.DB) *sql.DB {
+  pool, _ := db.DB()
+  return pool
+}
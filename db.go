@@ -0,0 +1,207 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+  "gorm.io/driver/mysql"
+  "gorm.io/gorm"
+  "gorm.io/gorm/logger"
+)
+
+// Handlers should get the database using DBFromRequest, instead of using
+// Server.Db directly, so queries are canceled when the client goes away
+// instead of keeping a MySQL connection busy, and their logs include the
+// route and request ID.
+
+// ErrNoDatabase is returned by the database helpers when the server has no
+// database connection.
+var ErrNoDatabase = errors.New("No database connection")
+
+// sqliteDialector opens SQLite databases. It is set when building with
+// -tags sqlite, as the SQLite driver requires cgo.
+var sqliteDialector func(dsn string) gorm.Dialector
+
+// ReadDatabaseConfig reads the database settings from the env vars with the
+// given prefix, so applications can connect to other databases using their
+// own env vars. E.g., the prefix "ARCHIVE" reads ARCHIVE_DB_USERNAME,
+// ARCHIVE_DB_PASSWORD, ARCHIVE_DB_ADDRESS, ARCHIVE_DB_NAME,
+// ARCHIVE_DB_DIALECT, ARCHIVE_DB_MAX_OPEN_CONNS, ARCHIVE_DB_MAX_IDLE_CONNS,
+// ARCHIVE_DB_CONN_MAX_LIFETIME, ARCHIVE_DB_SLOW_QUERY_THRESHOLD and
+// ARCHIVE_DB_PREPARE_STMT. The server settings use the "IGN" prefix.
+// An error is returned if a setting has an invalid value.
+func ReadDatabaseConfig(prefix string) (DatabaseConfig, error) {
+  var c DatabaseConfig
+  err := c.overrideFromEnvVars(prefix)
+  return c, err
+}
+
+// overrideFromEnvVars sets the settings found in the env vars with the
+// given prefix (see ReadDatabaseConfig), leaving the others untouched.
+// Invalid values are skipped and reported in the returned error.
+func (c *DatabaseConfig) overrideFromEnvVars(prefix string) error {
+  p := prefix + "_DB_"
+  overrideFromEnvVar(p + "USERNAME", &c.UserName)
+  overrideFromEnvVar(p + "PASSWORD", &c.Password)
+  overrideFromEnvVar(p + "ADDRESS", &c.Address)
+  overrideFromEnvVar(p + "NAME", &c.Name)
+  overrideFromEnvVar(p + "DIALECT", &c.Dialect)
+
+  var invalid []string
+  readInt := func(name string, dst *int) {
+    if v, err := ReadEnvVar(p + name); err == nil {
+      if i, err := strconv.Atoi(v); err != nil {
+        invalid = append(invalid, p + name)
+      } else {
+        *dst = i
+      }
+    }
+  }
+  readDuration := func(name string, dst *time.Duration) {
+    if v, err := ReadEnvVar(p + name); err == nil {
+      if d, err := time.ParseDuration(v); err != nil {
+        invalid = append(invalid, p + name)
+      } else {
+        *dst = d
+      }
+    }
+  }
+  readInt("MAX_OPEN_CONNS", &c.MaxOpenConns)
+  readInt("MAX_IDLE_CONNS", &c.MaxIdleConns)
+  readDuration("CONN_MAX_LIFETIME", &c.ConnMaxLifetime)
+  readDuration("SLOW_QUERY_THRESHOLD", &c.SlowQueryThreshold)
+  if v, err := ReadEnvVar(p + "PREPARE_STMT"); err == nil {
+    c.PrepareStmt = v == "true"
+  }
+
+  if len(invalid) > 0 {
+    return errors.New("Invalid env vars [" + strings.Join(invalid, ", ") + "]")
+  }
+  return nil
+}
+
+// newDialector returns the gorm dialector of the given dialect.
+func newDialector(dialect, dsn string) (gorm.Dialector, error) {
+  switch dialect {
+  case DialectMySQL:
+    return mysql.Open(dsn), nil
+  case DialectSQLite:
+    if sqliteDialector == nil {
+      return nil, errors.New("SQLite support requires building with -tags sqlite")
+    }
+    return sqliteDialector(dsn), nil
+  }
+  return nil, errors.New("Unknown database dialect [" + dialect + "]")
+}
+
+// DbWithContext returns the server database bound to the given context
+// (eg. the request context). Queries fail once the context is canceled.
+// It returns nil if there is no database connection.
+func (s *Server) DbWithContext(ctx context.Context) *gorm.DB {
+  db := s.Database()
+  if db == nil {
+    return nil
+  }
+  return db.WithContext(ctx)
+}
+
+// DBFromRequest returns the server database bound to the request context.
+// The handle is a new gorm session, so conditions added by the handler don't
+// leak to other requests. Queries are only logged one by one when debug logs
+// are enabled (see IGN_LOG_LEVEL), while slow and failed queries are always
+// logged. It returns nil if there is no database connection.
+func DBFromRequest(r *http.Request) *gorm.DB {
+  base := dbFromContext(r.Context())
+  if base == nil {
+    return nil
+  }
+  db := base.WithContext(r.Context())
+  if l, ok := db.Logger.(*dbLogger); ok && l.level >= logger.Info &&
+     !debugLogsEnabled() {
+    db = db.Session(&gorm.Session{Logger: l.LogMode(logger.Warn)})
+  }
+  return db
+}
+
+// dbKey is the context key of the database set with WithDB.
+type dbKey struct{}
+
+// WithDB returns a shallow copy of the request that uses the given database
+// in DBFromRequest, instead of the server database. It allows serving
+// requests with other databases, eg. a database per test (see
+// igntest.NewTestServer).
+func WithDB(r *http.Request, db *gorm.DB) *http.Request {
+  return r.WithContext(context.WithValue(r.Context(), dbKey{}, db))
+}
+
+// requestDB returns the database set with WithDB in the given request
+// context, or nil.
+func requestDB(ctx context.Context) *gorm.DB {
+  db, _ := ctx.Value(dbKey{}).(*gorm.DB)
+  return db
+}
+
+// dbFromContext returns the database set with WithDB in the given request
+// context, or the server database. It returns nil if there is none.
+func dbFromContext(ctx context.Context) *gorm.DB {
+  if db := requestDB(ctx); db != nil {
+    return db
+  }
+  if gServer == nil {
+    return nil
+  }
+  return gServer.Database()
+}
+
+// debugLogsEnabled returns false if the package Logger discards debug logs.
+func debugLogsEnabled() bool {
+  std, ok := gLogger.(*StdLogger)
+  return !ok || std.Level() <= LogLevelDebug
+}
+
+// RequestTransaction runs fn in a database transaction bound to the request
+// context (see DBFromRequest). The transaction is committed if fn returns
+// nil, and rolled back if it returns an ErrMsg, which is returned as is.
+// Database errors, such as a failed commit, are returned using
+// ErrMsgFromDB.
+//
+//   return RequestTransaction(r, func(tx *gorm.DB) *ErrMsg {
+//     if err := tx.Create(&robot).Error; err != nil {
+//       return ErrMsgFromDB(err)
+//     }
+//     ...
+//   })
+func RequestTransaction(r *http.Request, fn func(tx *gorm.DB) *ErrMsg) *ErrMsg {
+  db := DBFromRequest(r)
+  if db == nil {
+    return NewErrorMessageWithBase(ErrorNoDatabase, ErrNoDatabase)
+  }
+  var em *ErrMsg
+  err := db.Transaction(func(tx *gorm.DB) error {
+    if em = fn(tx); em != nil {
+      return errRollback
+    }
+    return nil
+  })
+  if em != nil {
+    return em
+  }
+  return ErrMsgFromDB(err)
+}
+
+// errRollback makes RequestTransaction roll back the transaction.
+var errRollback = errors.New("Transaction rolled back")
+
+// Transaction runs fn in a database transaction bound to the given
+// context. The transaction is committed if fn returns nil, and rolled back
+// if it returns an error or panics.
+func (s *Server) Transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+  db := s.Database()
+  if db == nil {
+    return ErrNoDatabase
+  }
+  return db.WithContext(ctx).Transaction(fn)
+}
@@ -0,0 +1,123 @@
+package ign
+
+import (
+  "database/sql"
+  "database/sql/driver"
+  "errors"
+  "net"
+  "strings"
+  "github.com/go-sql-driver/mysql"
+  "gorm.io/gorm"
+)
+
+// Database errors are translated to ErrMsg codes here, so handlers don't
+// need to know the error codes (or messages) of each database driver.
+// E.g., saving a row that violates a unique index with
+//
+//   NewErrorMessageWithBase(ErrorDbSave, err)
+//
+// results in ErrorResourceExists (HTTP 409) instead of ErrorDbSave. Handlers
+// can also use ErrMsgFromDB, which picks the code of any database error.
+
+// MySQL error numbers.
+const (
+  mysqlErrDuplicateEntry = 1062
+  mysqlErrLockWaitTimeout = 1205
+  mysqlErrDeadlock = 1213
+)
+
+// mysqlConstraintErrors are the MySQL error numbers of constraint violations
+// other than duplicate keys: NULL column, foreign keys and checks.
+var mysqlConstraintErrors = map[uint16]bool{
+  1048: true, 1216: true, 1217: true, 1451: true, 1452: true, 3819: true,
+}
+
+// ErrMsgFromDB returns the ErrMsg of a database error: ErrorIDNotFound if
+// no record was found, ErrorResourceExists for duplicate keys,
+// ErrorDbDeadlock for deadlocks and lock timeouts, ErrorDbConstraint for
+// other constraint violations, ErrorNoDatabase for connection errors and
+// ErrorDbQuery otherwise. It returns nil if err is nil.
+func ErrMsgFromDB(err error) *ErrMsg {
+  if err == nil {
+    return nil
+  }
+  var code int64
+  switch {
+  case errors.Is(err, gorm.ErrRecordNotFound):
+    code = ErrorIDNotFound
+  case IsDuplicateKeyError(err):
+    code = ErrorResourceExists
+  case isDeadlockError(err):
+    code = ErrorDbDeadlock
+  case isConstraintError(err):
+    code = ErrorDbConstraint
+  case isConnectionError(err):
+    code = ErrorNoDatabase
+  default:
+    code = ErrorDbQuery
+  }
+  return NewErrorMessageWithBase(code, err)
+}
+
+// IsDuplicateKeyError returns true if err was caused by inserting or
+// updating a row with the same primary or unique key of an existing row.
+func IsDuplicateKeyError(err error) bool {
+  if err == nil {
+    return false
+  }
+  if errors.Is(err, gorm.ErrDuplicatedKey) {
+    return true
+  }
+  var myErr *mysql.MySQLError
+  if errors.As(err, &myErr) {
+    return myErr.Number == mysqlErrDuplicateEntry
+  }
+  // The SQLite driver requires cgo, so its errors are matched by message
+  return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// isDeadlockError returns true if err was caused by a deadlock or a lock
+// wait timeout.
+func isDeadlockError(err error) bool {
+  var myErr *mysql.MySQLError
+  if errors.As(err, &myErr) {
+    return myErr.Number == mysqlErrDeadlock ||
+           myErr.Number == mysqlErrLockWaitTimeout
+  }
+  return strings.Contains(err.Error(), "database is locked")
+}
+
+// isConstraintError returns true if err was caused by a constraint
+// violation other than a duplicate key.
+func isConstraintError(err error) bool {
+  if errors.Is(err, gorm.ErrForeignKeyViolated) ||
+     errors.Is(err, gorm.ErrCheckConstraintViolated) {
+    return true
+  }
+  var myErr *mysql.MySQLError
+  if errors.As(err, &myErr) {
+    return mysqlConstraintErrors[myErr.Number]
+  }
+  return strings.Contains(err.Error(), "constraint failed")
+}
+
+// isConnectionError returns true if err was caused by a broken or missing
+// database connection.
+func isConnectionError(err error) bool {
+  if errors.Is(err, ErrNoDatabase) || errors.Is(err, driver.ErrBadConn) ||
+     errors.Is(err, sql.ErrConnDone) || errors.Is(err, mysql.ErrInvalidConn) {
+    return true
+  }
+  var netErr net.Error
+  return errors.As(err, &netErr)
+}
+
+// translateDBError returns the error code of a database error, if it is
+// more specific than the given generic code. It returns the given code
+// otherwise.
+func translateDBError(code int64, err error) int64 {
+  if code == ErrorDbSave && IsDuplicateKeyError(err) {
+    return ErrorResourceExists
+  }
+  return code
+}
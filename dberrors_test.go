@@ -0,0 +1,98 @@
+package ign
+
+import (
+  "database/sql/driver"
+  "errors"
+  "fmt"
+  "net/http"
+  "testing"
+  "github.com/go-sql-driver/mysql"
+  "gorm.io/gorm"
+)
+
+// TestIsDuplicateKeyError tests detecting unique key violations.
+func TestIsDuplicateKeyError(t *testing.T) {
+  db := newListItemsDB(t, listItem{Name: "a"})
+  defer sqlDB(db).Close()
+
+  var existing listItem
+  db.Where("name = ?", "a").First(&existing)
+  err := db.Create(&listItem{ID: existing.ID, Name: "b"}).Error
+  if !IsDuplicateKeyError(err) {
+    t.Fatal("Expected a duplicate key error. Got:", err)
+  }
+
+  myErr := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1'"}
+  if !IsDuplicateKeyError(fmt.Errorf("save: %w", myErr)) {
+    t.Fatal("MySQL 1062 should be a duplicate key error")
+  }
+  if IsDuplicateKeyError(&mysql.MySQLError{Number: 1452}) ||
+     IsDuplicateKeyError(errors.New("other")) || IsDuplicateKeyError(nil) {
+    t.Fatal("Unexpected duplicate key error")
+  }
+
+  em := NewErrorMessageWithBase(ErrorDbSave, myErr)
+  if em.ErrCode != ErrorResourceExists || em.StatusCode != 409 {
+    t.Fatal("Duplicate keys should be reported as ErrorResourceExists:", em)
+  }
+  if em := NewErrorMessageWithBase(ErrorDbSave, errors.New("other"));
+     em.ErrCode != ErrorDbSave {
+    t.Fatal("Other errors should keep their code:", em)
+  }
+}
+
+// TestErrMsgFromDB tests the codes of database errors.
+func TestErrMsgFromDB(t *testing.T) {
+  tests := []struct {
+    err error
+    code int64
+  }{
+    {gorm.ErrRecordNotFound, ErrorIDNotFound},
+    {&mysql.MySQLError{Number: 1062}, ErrorResourceExists},
+    {&mysql.MySQLError{Number: 1213}, ErrorDbDeadlock},
+    {&mysql.MySQLError{Number: 1452}, ErrorDbConstraint},
+    {errors.New("FOREIGN KEY constraint failed"), ErrorDbConstraint},
+    {driver.ErrBadConn, ErrorNoDatabase},
+    {errors.New("syntax error"), ErrorDbQuery},
+  }
+  for _, test := range tests {
+    if em := ErrMsgFromDB(test.err); em.ErrCode != int(test.code) {
+      t.Error("Unexpected code for", test.err, ":", em.ErrCode)
+    }
+  }
+  if ErrMsgFromDB(nil) != nil {
+    t.Fatal("No error expected")
+  }
+}
+
+// TestRequestTransaction tests that transactions are rolled back when the
+// handler fails.
+func TestRequestTransaction(t *testing.T) {
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{Db: db}
+
+  r, _ := http.NewRequest("POST", "/items", nil)
+  em := RequestTransaction(r, func(tx *gorm.DB) *ErrMsg {
+    tx.Create(&listItem{Name: "rolled back"})
+    return NewErrorMessage(ErrorForm)
+  })
+  if em == nil || em.ErrCode != ErrorForm {
+    t.Fatal("The handler error should be returned. Got:", em)
+  }
+  em = RequestTransaction(r, func(tx *gorm.DB) *ErrMsg {
+    tx.Create(&listItem{Name: "committed"})
+    return nil
+  })
+  if em != nil {
+    t.Fatal("Unexpected error:", em)
+  }
+
+  var names []string
+  db.Model(&listItem{}).Pluck("name", &names)
+  if len(names) != 1 || names[0] != "committed" {
+    t.Fatal("Unexpected rows:", names)
+  }
+}
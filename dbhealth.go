@@ -0,0 +1,101 @@
+package ign
+
+import (
+  "context"
+  "sync/atomic"
+  "time"
+  "gorm.io/gorm"
+)
+
+// The database health checker periodically pings the database, and
+// reconnects if the database was unavailable. This way a transient database
+// outage does not require a server restart.
+// The health status is available through Server.DbHealthy(), and it is used
+// by the router to reject requests with ErrorNoDatabase while the database
+// is down. Reconnecting replaces Server.Db, so it must be read with
+// Server.Database() while the health check runs.
+
+// StartDbHealthCheck starts a background goroutine that pings the database
+// every interval, reconnecting to the database if needed. A single
+// connection attempt is made per interval. The health check stops when the
+// given context is done.
+func (s *Server) StartDbHealthCheck(ctx context.Context, interval time.Duration) {
+  atomic.StoreInt32(&s.dbHealthChecking, 1)
+  s.checkDbHealth(ctx)
+
+  go func() {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+      select {
+      case <-ctx.Done():
+        atomic.StoreInt32(&s.dbHealthChecking, 0)
+        return
+      case <-ticker.C:
+        s.checkDbHealth(ctx)
+      }
+    }
+  }()
+}
+
+// DbHealthy returns true if the database is available. If the health check
+// is not running, the database is considered healthy once connected.
+func (s *Server) DbHealthy() bool {
+  if atomic.LoadInt32(&s.dbHealthChecking) == 0 {
+    return s.Database() != nil
+  }
+  return atomic.LoadInt32(&s.dbHealthy) == 1
+}
+
+// Database returns the server database, or nil if there is no connection.
+// Unlike reading Server.Db, it is safe while the health check reconnects.
+func (s *Server) Database() *gorm.DB {
+  s.dbMutex.RLock()
+  defer s.dbMutex.RUnlock()
+  return s.Db
+}
+
+// setDatabase publishes a new database connection.
+func (s *Server) setDatabase(db *gorm.DB) {
+  s.dbMutex.Lock()
+  defer s.dbMutex.Unlock()
+  s.Db = db
+}
+
+// checkDbHealth pings the database and updates the health status. If there
+// is no database connection, a single connection attempt is made, so the
+// next tick is not delayed by the retries.
+func (s *Server) checkDbHealth(ctx context.Context) {
+  healthy := false
+  db := s.Database()
+  if db == nil {
+    if err := s.connectDatabase(ctx, 1); err != nil {
+      gLogger.Error("Database health check: unable to reconnect.",
+                    Fields{"error": err})
+    } else {
+      gLogger.Info("Database health check: reconnected to the database.", nil)
+      healthy = true
+    }
+  } else if err := pingDb(ctx, db); err != nil {
+    gLogger.Warn("Database health check: ping failed.", Fields{"error": err})
+  } else {
+    healthy = true
+  }
+
+  var value int32
+  if healthy {
+    value = 1
+  }
+  if old := atomic.SwapInt32(&s.dbHealthy, value); old != value && !healthy {
+    gLogger.Error("Database health check: database is unavailable.", nil)
+  }
+}
+
+// pingDb checks the connection of a database.
+func pingDb(ctx context.Context, db *gorm.DB) error {
+  sqlDB, err := db.DB()
+  if err != nil {
+    return err
+  }
+  return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,174 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "sync"
+  "time"
+  "gorm.io/gorm"
+  "gorm.io/gorm/logger"
+)
+
+// Database queries are logged through the package Logger, tagged with the
+// route and request ID of the query context (see DBFromRequest).
+// Queries slower than DatabaseConfig.SlowQueryThreshold are logged as
+// warnings. The number and duration of the queries of each route are
+// available using Server.DbQueryStats, to find the routes causing database
+// load.
+
+// defaultSlowQueryThreshold is used when DatabaseConfig.SlowQueryThreshold
+// is not set.
+const defaultSlowQueryThreshold = 200 * time.Millisecond
+
+// DbQueryStats are the database query counters of a route.
+type DbQueryStats struct {
+  Queries int64 `json:"queries"`
+  SlowQueries int64 `json:"slow_queries"`
+  Errors int64 `json:"errors"`
+  Duration time.Duration `json:"duration"`
+}
+
+// dbQueryStats keeps the DbQueryStats of each route.
+type dbQueryStats struct {
+  mutex sync.Mutex
+  routes map[string]*DbQueryStats
+}
+
+// gDbQueryStats are the query counters of the server.
+var gDbQueryStats = &dbQueryStats{routes: map[string]*DbQueryStats{}}
+
+// add counts a query of the given route.
+func (s *dbQueryStats) add(route string, elapsed time.Duration, slow,
+                           failed bool) {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  stats, ok := s.routes[route]
+  if !ok {
+    stats = &DbQueryStats{}
+    s.routes[route] = stats
+  }
+  stats.Queries++
+  stats.Duration += elapsed
+  if slow {
+    stats.SlowQueries++
+  }
+  if failed {
+    stats.Errors++
+  }
+}
+
+// DbQueryStats returns the query counters of each route since the server
+// started. Queries made outside requests are counted under an empty route
+// name.
+func (s *Server) DbQueryStats() map[string]DbQueryStats {
+  gDbQueryStats.mutex.Lock()
+  defer gDbQueryStats.mutex.Unlock()
+  result := make(map[string]DbQueryStats, len(gDbQueryStats.routes))
+  for route, stats := range gDbQueryStats.routes {
+    result[route] = *stats
+  }
+  return result
+}
+
+/////////////////////////////////////////////////
+
+// dbLogger is the gorm logger of the server database.
+type dbLogger struct {
+  level logger.LogLevel
+  slowThreshold time.Duration
+  stats *dbQueryStats
+}
+
+// newDbLogger creates a dbLogger. A zero slowThreshold uses the default
+// threshold, and a negative one disables slow queries.
+func newDbLogger(level logger.LogLevel, slowThreshold time.Duration) *dbLogger {
+  if slowThreshold == 0 {
+    slowThreshold = defaultSlowQueryThreshold
+  }
+  return &dbLogger{level: level, slowThreshold: slowThreshold,
+                   stats: gDbQueryStats}
+}
+
+// LogMode is part of the gorm logger.Interface.
+func (l *dbLogger) LogMode(level logger.LogLevel) logger.Interface {
+  c := *l
+  c.level = level
+  return &c
+}
+
+// Info is part of the gorm logger.Interface.
+func (l *dbLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+  if l.level >= logger.Info {
+    gLogger.Info(fmt.Sprintf(msg, data...), l.fields(ctx))
+  }
+}
+
+// Warn is part of the gorm logger.Interface.
+func (l *dbLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+  if l.level >= logger.Warn {
+    gLogger.Warn(fmt.Sprintf(msg, data...), l.fields(ctx))
+  }
+}
+
+// Error is part of the gorm logger.Interface.
+func (l *dbLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+  if l.level >= logger.Error {
+    gLogger.Error(fmt.Sprintf(msg, data...), l.fields(ctx))
+  }
+}
+
+// Trace is part of the gorm logger.Interface. It is called after each
+// query. Missing records are not considered errors.
+func (l *dbLogger) Trace(ctx context.Context, begin time.Time,
+                         fc func() (string, int64), err error) {
+  elapsed := time.Since(begin)
+  slow := l.slowThreshold > 0 && elapsed > l.slowThreshold
+  failed := err != nil && !errors.Is(err, gorm.ErrRecordNotFound)
+  l.stats.add(routeNameFromContext(ctx), elapsed, slow, failed)
+
+  if l.level == logger.Silent {
+    return
+  }
+  fields := func() Fields {
+    sql, rows := fc()
+    f := l.fields(ctx)
+    f["sql"] = sql
+    f["rows"] = rows
+    f["duration"] = elapsed
+    return f
+  }
+  switch {
+  case failed && l.level >= logger.Error:
+    f := fields()
+    f["error"] = err
+    gLogger.Error("Database query failed", f)
+  case slow && l.level >= logger.Warn:
+    gLogger.Warn("Slow database query", fields())
+  case l.level >= logger.Info:
+    gLogger.Debug("Database query", fields())
+  }
+}
+
+// fields returns the log fields of the request that made a query.
+func (l *dbLogger) fields(ctx context.Context) Fields {
+  f := Fields{}
+  if route := routeNameFromContext(ctx); route != "" {
+    f["route"] = route
+  }
+  if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+    f["request_id"] = id
+  }
+  return f
+}
+
+/////////////////////////////////////////////////
+
+// routeNameKey is the context key of the route name.
+type routeNameKey struct{}
+
+// routeNameFromContext returns the name of the route of a request context.
+func routeNameFromContext(ctx context.Context) string {
+  name, _ := ctx.Value(routeNameKey{}).(string)
+  return name
+}
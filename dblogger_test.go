@@ -0,0 +1,34 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "testing"
+  "time"
+  "gorm.io/gorm"
+  "gorm.io/gorm/logger"
+)
+
+// TestDbLogger tests counting and flagging slow queries per route.
+func TestDbLogger(t *testing.T) {
+  stats := &dbQueryStats{routes: map[string]*DbQueryStats{}}
+  l := newDbLogger(logger.Silent, 50 * time.Millisecond)
+  l.stats = stats
+
+  ctx := context.WithValue(context.Background(), routeNameKey{}, "modelList")
+  sql := func() (string, int64) { return "SELECT 1", 1 }
+  l.Trace(ctx, time.Now(), sql, nil)
+  l.Trace(ctx, time.Now().Add(-time.Second), sql, nil)
+  l.Trace(ctx, time.Now(), sql, errors.New("failure"))
+  // Missing records are not errors
+  l.Trace(context.Background(), time.Now(), sql, gorm.ErrRecordNotFound)
+
+  s := stats.routes["modelList"]
+  if s == nil || s.Queries != 3 || s.SlowQueries != 1 || s.Errors != 1 ||
+     s.Duration < time.Second {
+    t.Fatal("Unexpected route stats:", s)
+  }
+  if s := stats.routes[""]; s == nil || s.Queries != 1 || s.Errors != 0 {
+    t.Fatal("Unexpected stats of queries outside requests:", s)
+  }
+}
@@ -0,0 +1,214 @@
+package ign
+
+import (
+  "bytes"
+  "encoding/json"
+  "io"
+  "mime"
+  "net/http"
+  "net/url"
+  "regexp"
+  "strconv"
+  "strings"
+  "unicode/utf8"
+  "github.com/codegangsta/negroni"
+)
+
+// The bodies of the requests and responses of a route can be logged to
+// diagnose client integrations (eg. in staging), by setting
+// Route.DebugBodies, or by sending the X-Debug header with a token granted
+// the Server.DebugScope scope. Bodies are truncated to debugBodyMaxSize
+// bytes, and the values of fields that look like secrets (eg. "password" or
+// "token") are redacted.
+
+// debugBodyMaxSize is the max number of bytes logged of each body.
+const debugBodyMaxSize = 4096
+
+// debugHeader is the request header that enables the capture of bodies.
+const debugHeader = "X-Debug"
+
+// redactedValue replaces the values of secret fields.
+const redactedValue = "[REDACTED]"
+
+// secretFieldRegexp matches the names of the fields that are redacted.
+var secretFieldRegexp = regexp.MustCompile(
+  `(?i)pass(word)?|secret|token|authorization|api_?key|credential|private`)
+
+// cappedBuffer keeps the first bytes written to it, up to a max size.
+type cappedBuffer struct {
+  bytes.Buffer
+  max int
+  // total is the number of bytes written, including the discarded ones.
+  total int64
+}
+
+// Write is part of the io.Writer interface. It never fails.
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+  b.total += int64(len(p))
+  if room := b.max - b.Len(); room > 0 {
+    if len(p) > room {
+      b.Buffer.Write(p[:room])
+    } else {
+      b.Buffer.Write(p)
+    }
+  }
+  return len(p), nil
+}
+
+// debugReadCloser copies the request body read by the handlers to a
+// cappedBuffer.
+type debugReadCloser struct {
+  io.Reader
+  io.Closer
+}
+
+// debugResponseWriter copies the response body to a cappedBuffer.
+type debugResponseWriter struct {
+  negroni.ResponseWriter
+  body *cappedBuffer
+}
+
+// Write is part of the http.ResponseWriter interface.
+func (w *debugResponseWriter) Write(p []byte) (int, error) {
+  n, err := w.ResponseWriter.Write(p)
+  w.body.Write(p[:n])
+  return n, err
+}
+
+/////////////////////////////////////////////////
+// newDebugBodiesMiddleware returns a middleware that logs the request and
+// response bodies if always is true, or if the request has the X-Debug
+// header and its token grants the Server.DebugScope. It must run after the
+// auth middleware. Bodies are logged as they are streamed, so only the
+// part of the request body read by the handler is logged.
+func newDebugBodiesMiddleware(always bool, routeName string) negroni.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    if !always && !debugRequested(r) {
+      next(w, r)
+      return
+    }
+
+    reqBody := &cappedBuffer{max: debugBodyMaxSize}
+    if r.Body != nil && r.Body != http.NoBody {
+      r.Body = debugReadCloser{io.TeeReader(r.Body, reqBody), r.Body}
+    }
+    rw := &debugResponseWriter{negroni.NewResponseWriter(w),
+                               &cappedBuffer{max: debugBodyMaxSize}}
+    next(rw, r)
+
+    status := rw.Status()
+    if status == 0 {
+      status = http.StatusOK
+    }
+    gLogger.Info("Request and response bodies", Fields{
+      "route": routeName,
+      "method": r.Method,
+      "uri": r.RequestURI,
+      "request_id": RequestID(r),
+      "status": status,
+      "request_body": sanitizeBody(reqBody, r.Header.Get("Content-Type")),
+      "response_body": sanitizeBody(rw.body, rw.Header().Get("Content-Type")),
+    })
+  }
+}
+
+// debugRequested returns true if the request has the X-Debug header and
+// its token grants the debug scope.
+func debugRequested(r *http.Request) bool {
+  if gServer == nil || gServer.DebugScope == "" {
+    return false
+  }
+  if v := r.Header.Get(debugHeader); v == "" || v == "false" || v == "0" {
+    return false
+  }
+  identity, ok := IdentityFromRequest(r)
+  return ok && identity.HasScope(gServer.DebugScope)
+}
+
+// sanitizeBody returns the loggable version of a captured body, with the
+// secret fields redacted. Only the size of binary bodies is logged.
+func sanitizeBody(b *cappedBuffer, contentType string) string {
+  if b.total == 0 {
+    return ""
+  }
+  mediaType, _, _ := mime.ParseMediaType(contentType)
+  body := b.String()
+  truncated := b.total > int64(b.Len())
+
+  switch {
+  case mediaType == "application/x-www-form-urlencoded":
+    body = redactForm(body)
+  case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+    body = redactJSON(body, truncated)
+  case strings.HasPrefix(mediaType, "text/") || mediaType == "" &&
+       !truncated && isPrintable(body):
+  default:
+    return "<" + strconv.FormatInt(b.total, 10) + " bytes of " + mediaType + ">"
+  }
+  if truncated {
+    body += "...<" + strconv.FormatInt(b.total, 10) + " bytes>"
+  }
+  return body
+}
+
+// redactJSON redacts the secret fields of a JSON body. Truncated or
+// invalid bodies are redacted with a regexp.
+func redactJSON(body string, truncated bool) string {
+  var value interface{}
+  if !truncated && json.Unmarshal([]byte(body), &value) == nil {
+    if redacted, err := json.Marshal(redactValue(value)); err == nil {
+      return string(redacted)
+    }
+  }
+  return secretJSONFieldRegexp.ReplaceAllString(body, `$1"` + redactedValue + `"`)
+}
+
+// secretJSONFieldRegexp matches the string values of secret fields in
+// JSON bodies that can't be decoded.
+var secretJSONFieldRegexp = regexp.MustCompile(
+  `(?i)("[^"]*(?:pass(?:word)?|secret|token|authorization|api_?key|` +
+  `credential|private)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+
+// redactValue redacts the secret fields of a decoded JSON value.
+func redactValue(value interface{}) interface{} {
+  switch v := value.(type) {
+  case map[string]interface{}:
+    for key, item := range v {
+      if secretFieldRegexp.MatchString(key) {
+        v[key] = redactedValue
+      } else {
+        v[key] = redactValue(item)
+      }
+    }
+  case []interface{}:
+    for i, item := range v {
+      v[i] = redactValue(item)
+    }
+  }
+  return value
+}
+
+// redactForm redacts the secret fields of a form body.
+func redactForm(body string) string {
+  values, err := url.ParseQuery(body)
+  if err != nil {
+    return body
+  }
+  for key := range values {
+    if secretFieldRegexp.MatchString(key) {
+      values[key] = []string{redactedValue}
+    }
+  }
+  return values.Encode()
+}
+
+// isPrintable returns true if s is text without control characters, other
+// than whitespace.
+func isPrintable(s string) bool {
+  for _, r := range s {
+    if r == utf8.RuneError || r < ' ' && r != '\n' && r != '\r' && r != '\t' {
+      return false
+    }
+  }
+  return true
+}
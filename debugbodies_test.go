@@ -0,0 +1,72 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "strings"
+  "testing"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// TestSanitizeBody tests the redaction and truncation of logged bodies.
+func TestSanitizeBody(t *testing.T) {
+  capture := func(body string) *cappedBuffer {
+    b := &cappedBuffer{max: 64}
+    b.Write([]byte(body))
+    return b
+  }
+
+  got := sanitizeBody(capture(`{"user":"bob","auth":{"access_token":"x"}}`),
+                      "application/json; charset=utf-8")
+  if got != `{"auth":{"access_token":"[REDACTED]"},"user":"bob"}` {
+    t.Error("Unexpected JSON body:", got)
+  }
+  got = sanitizeBody(capture("name=bob&password=1234"),
+                     "application/x-www-form-urlencoded")
+  if got != "name=bob&password=%5BREDACTED%5D" {
+    t.Error("Unexpected form body:", got)
+  }
+  long := `{"client_secret":"` + strings.Repeat("s", 100) + `"}`
+  got = sanitizeBody(capture(long), "application/json")
+  if strings.Contains(got, "sss") || !strings.HasSuffix(got, "...<120 bytes>") {
+    t.Error("Unexpected truncated body:", got)
+  }
+  if got = sanitizeBody(capture("\x00\x01\x02"), "application/zip"); got !=
+     "<3 bytes of application/zip>" {
+    t.Error("Binary bodies should not be logged:", got)
+  }
+  if got = sanitizeBody(capture(""), "text/plain"); got != "" {
+    t.Error("Unexpected empty body:", got)
+  }
+}
+
+// TestDebugRequested tests enabling the capture with the X-Debug header.
+func TestDebugRequested(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{DebugScope: "admin"}
+
+  request := func(header, scope string) *http.Request {
+    req, _ := http.NewRequest("GET", "/models", nil)
+    if header != "" {
+      req.Header.Set(debugHeader, header)
+    }
+    claims := jwt.MapClaims{"sub": "alice", "scope": scope}
+    return req.WithContext(context.WithValue(req.Context(), "user",
+                                             &jwt.Token{Claims: claims}))
+  }
+
+  if !debugRequested(request("true", "openid admin")) {
+    t.Fatal("Admins should be able to capture bodies")
+  }
+  if debugRequested(request("true", "openid")) {
+    t.Fatal("The debug scope should be required")
+  }
+  if debugRequested(request("", "admin")) || debugRequested(request("0", "admin")) {
+    t.Fatal("The X-Debug header should be required")
+  }
+  gServer.DebugScope = ""
+  if debugRequested(request("true", "admin")) {
+    t.Fatal("An empty debug scope should disable the header")
+  }
+}
@@ -0,0 +1,31 @@
+package ign
+
+import (
+  "net/http"
+)
+
+// ErrorReporter receives the errors replied to clients, along with their
+// request, so they can be sent to an error tracking service (eg. Sentry).
+// It is called synchronously, so it should not block. See the errreporter
+// package for ready-made reporters.
+type ErrorReporter func(errMsg ErrMsg, r *http.Request)
+
+// SetErrorReporter sets the ErrorReporter called for every error reply,
+// including panics in handlers. A nil reporter disables the reporting.
+func (s *Server) SetErrorReporter(reporter ErrorReporter) {
+  s.errorReporter = reporter
+}
+
+// reportToErrorReporter sends an error to the server's ErrorReporter, if
+// any. Panics of the reporter are logged and ignored.
+func reportToErrorReporter(errMsg ErrMsg, r *http.Request) {
+  if gServer == nil || gServer.errorReporter == nil {
+    return
+  }
+  defer func() {
+    if p := recover(); p != nil {
+      gLogger.Error("ErrorReporter panicked", Fields{"panic": p})
+    }
+  }()
+  gServer.errorReporter(errMsg, r)
+}
@@ -0,0 +1,50 @@
+package ign
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "testing"
+)
+
+// TestErrorReporter tests sending errors and panics to the ErrorReporter.
+func TestErrorReporter(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+
+  var reported []ErrMsg
+  var paths []string
+  gServer.SetErrorReporter(func(errMsg ErrMsg, r *http.Request) {
+    reported = append(reported, errMsg)
+    paths = append(paths, r.URL.Path)
+  })
+
+  req, _ := http.NewRequest("GET", "/models/1", nil)
+  rec := httptest.NewRecorder()
+  reportJSONError(rec, req, ErrorMessage(ErrorIDNotFound))
+
+  rec = httptest.NewRecorder()
+  panicRecoveryMiddleware(rec, req,
+    func(w http.ResponseWriter, r *http.Request) {
+      panic("boom")
+    })
+  if rec.Code != http.StatusInternalServerError {
+    t.Fatal("Panics should reply 500:", rec.Code)
+  }
+
+  if len(reported) != 2 || reported[0].ErrCode != ErrorIDNotFound ||
+     reported[1].StatusCode != http.StatusInternalServerError ||
+     reported[1].BaseError == nil || paths[1] != "/models/1" {
+    t.Fatal("Unexpected reported errors:", reported, paths)
+  }
+
+  // Panics of the reporter are ignored
+  gServer.SetErrorReporter(func(errMsg ErrMsg, r *http.Request) {
+    panic("reporter")
+  })
+  rec = httptest.NewRecorder()
+  reportJSONError(rec, req, ErrorMessage(ErrorIDNotFound))
+  if rec.Code != http.StatusNotFound {
+    t.Fatal("Unexpected status:", rec.Code)
+  }
+}
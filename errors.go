@@ -1,8 +1,11 @@
 package ign
 
 import (
+  "errors"
   "fmt"
   "net/http"
+  "strings"
+  "sync"
   "github.com/satori/go.uuid"
 )
 
@@ -26,6 +29,14 @@ const ErrorNameNotFound    = 1004
 // ErrorFileNotFound is triggered when a model's file with the specified name is not
 // found
 const ErrorFileNotFound    = 1005
+// ErrorDbDeadlock is triggered when a query was aborted by a deadlock or a
+// lock wait timeout. The request can be retried.
+const ErrorDbDeadlock      = 1006
+// ErrorDbConstraint is triggered when a query violates a database constraint
+// (eg. a foreign key).
+const ErrorDbConstraint    = 1007
+// ErrorDbQuery is triggered when a database query fails for other reasons.
+const ErrorDbQuery         = 1008
 
 ///////////////////
 // JSON error codes
@@ -43,6 +54,28 @@ const ErrorUnmarshalJSON   = 2001
 // ErrorMarshalProto is triggered if there is an error marshalling data into protobuf
 const ErrorMarshalProto     = 2500
 
+///////////////////
+// XML error codes
+///////////////////
+
+// ErrorMarshalXML is triggered if there is an error marshalling data into XML
+const ErrorMarshalXML       = 2600
+
+///////////////////
+// CSV error codes
+///////////////////
+
+// ErrorMarshalCSV is triggered if there is an error marshalling data into CSV
+const ErrorMarshalCSV       = 2700
+
+///////////////////////////
+// MessagePack error codes
+///////////////////////////
+
+// ErrorMarshalMsgPack is triggered if there is an error marshalling data into
+// MessagePack
+const ErrorMarshalMsgPack   = 2800
+
 //////////////////////
 // Request error codes
 //////////////////////
@@ -96,6 +129,39 @@ const ErrorFormDuplicateModelName  = 3015
 const ErrorInvalidPaginationRequest = 3016
 // ErrorPaginationPageNotFound is triggered when the requested page is empty / not found.
 const ErrorPaginationPageNotFound = 3017
+// ErrorRateLimited is triggered when a client exceeded the rate limit of a
+// route.
+const ErrorRateLimited = 3018
+// ErrorRouteNotFound is triggered when the requested URL does not match any
+// route.
+const ErrorRouteNotFound = 3019
+// ErrorMethodNotAllowed is triggered when the requested URL matches a route,
+// but the route does not support the request method.
+const ErrorMethodNotAllowed = 3020
+// ErrorPayloadTooLarge is triggered when the request body is larger than the
+// allowed limit.
+const ErrorPayloadTooLarge = 3021
+// ErrorInvalidFilter is triggered when the request has an unknown or empty
+// filter argument.
+const ErrorInvalidFilter = 3022
+// ErrorUnsupportedMediaType is triggered when the request body has an
+// unsupported content type.
+const ErrorUnsupportedMediaType = 3023
+// ErrorQuotaExceeded is triggered when a client exceeded the requests quota
+// of a route.
+const ErrorQuotaExceeded = 3024
+// ErrorUploadQuotaExceeded is triggered when a client exceeded the uploaded
+// bytes quota of a route.
+const ErrorUploadQuotaExceeded = 3025
+// ErrorChecksumMismatch is triggered when an uploaded file does not match
+// the checksum sent by the client (Content-MD5 or Digest header).
+const ErrorChecksumMismatch = 3026
+// ErrorUnsafeContent is triggered when an uploaded file is rejected by the
+// upload scanner (eg. it contains malware).
+const ErrorUnsafeContent = 3027
+// ErrorInvalidImage is triggered when an uploaded image can't be decoded or
+// its dimensions exceed the limits.
+const ErrorInvalidImage = 3028
 
 ////////////////////////////
 // Authorization error codes
@@ -110,6 +176,39 @@ const ErrorAuthJWTInvalid  = 4001
 // ErrorUnauthorized is triggered when a user is not authorized to perform a
 // given action.
 const ErrorUnauthorized    = 4002
+// ErrorTenantRequired is triggered when the tenant of a request can't be
+// resolved.
+const ErrorTenantRequired  = 4003
+// ErrorTenantMismatch is triggered when the tenant of the JWT is not the
+// tenant of the requested subdomain.
+const ErrorTenantMismatch  = 4004
+
+//////////////////////
+// Server error codes
+//////////////////////
+
+// ErrorInternalPanic is triggered when a handler panics.
+const ErrorInternalPanic = 5000
+
+// ErrorMaintenance is triggered when the server is in maintenance mode. See
+// Server.SetMaintenance.
+const ErrorMaintenance = 5001
+
+// ErrorCircuitOpen is triggered when a route is rejecting requests because
+// a service it depends on is failing. See Route.CircuitBreaker.
+const ErrorCircuitOpen = 5002
+
+// ErrorNotImplemented is triggered when a feature is not supported by the
+// server configuration (eg. changing the level of a custom logger).
+const ErrorNotImplemented = 5003
+
+// ErrorInvalidResult is triggered when a handler returns a result of a type
+// its route does not support (eg. an SSEResult that is not a channel).
+const ErrorInvalidResult = 5004
+
+// ErrorInvalidValidationTag is triggered when a bound type has invalid
+// "validate" tags. See CheckValidationTags.
+const ErrorInvalidValidationTag = 5005
 
 ////////////////////
 // Other error codes
@@ -160,12 +259,46 @@ type ErrMsg struct {
   BaseError   error `json:"-"`
   // Generated ID for easy tracking in server logs
   ErrID  string  `json:"errid"`
+  // Callers of the function that created the error, for the server logs
+  Stack  string  `json:"-"`
 }
 
+// errStackDepth is the number of callers kept in ErrMsg.Stack.
+const errStackDepth = 3
+
 // LogString creates a verbose error string
 func (e *ErrMsg) LogString() string {
-  return fmt.Sprintf("[ErrID:%s][ErrCode:%d] %s. Extra: %v", e.ErrID, e.ErrCode, e.Msg, e.Extra)
- }
+  s := fmt.Sprintf("[ErrID:%s][ErrCode:%d] %s. Extra: %v", e.ErrID, e.ErrCode, e.Msg, e.Extra)
+  if e.Stack != "" {
+    s += ". Stack: " + e.Stack
+  }
+  return s
+}
+
+// errorStack returns the callers of the function that created an ErrMsg,
+// skipping the ErrMsg constructors, as in "file.go:10 pkg.F < file.go:20 pkg.G".
+func errorStack() string {
+  var frames []string
+  // Skip errorStack and ErrorMessage
+  for _, f := range callerFrames(2, maxStackDepth) {
+    if isErrorConstructor(f.Function) {
+      continue
+    }
+    frames = append(frames, f.String())
+    if len(frames) == errStackDepth {
+      break
+    }
+  }
+  return strings.Join(frames, " < ")
+}
+
+// isErrorConstructor returns true if the given function name is one of the
+// NewErrorMessage functions.
+func isErrorConstructor(name string) bool {
+  name = name[strings.LastIndex(name, "/") + 1:]
+  name = name[strings.Index(name, ".") + 1:]
+  return strings.HasPrefix(name, "NewErrorMessage")
+}
 
 // NewErrorMessage is a convenience function that receives an error code
 // and returns a pointer to an ErrMsg.
@@ -176,31 +309,95 @@ func NewErrorMessage(err int64) (*ErrMsg) {
 
 // NewErrorMessageWithBase receives an error code and a root error
 // and returns a pointer to an ErrMsg.
+// Generic database codes are replaced by the code matching the root error
+// (eg. ErrorResourceExists for duplicate keys). See dberrors.go.
 func NewErrorMessageWithBase(err int64, base error) (*ErrMsg) {
-  em := NewErrorMessage(err)
+  em := NewErrorMessage(translateDBError(err, base))
   em.BaseError = base
   return em
 }
 
 // NewErrorMessageWithArgs receives an error code, a root error, and a slice
 // of extra arguments, and returns a pointer to an ErrMsg.
+// Messages of registered errors containing fmt verbs are formatted using
+// the extra arguments. Without extra arguments the message is kept as is.
 func NewErrorMessageWithArgs(err int64, base error, extra []string) (*ErrMsg) {
   em := NewErrorMessageWithBase(err, base)
   em.Extra = extra
+  if re, ok := registeredError(err); ok && len(extra) > 0 &&
+     strings.Contains(re.msg, "%") {
+    args := make([]interface{}, len(extra))
+    for i, e := range extra {
+      args[i] = e
+    }
+    em.Msg = fmt.Sprintf(re.msg, args...)
+  }
   return em
 }
 
+// errorDefinition is an error code registered using RegisterError.
+type errorDefinition struct {
+  statusCode int
+  msg string
+}
+
+// registeredErrors contains the error codes added by the application.
+var registeredErrors = map[int64]errorDefinition{}
+var registeredErrorsMutex sync.RWMutex
+
+// RegisterError adds an application specific error code, so it can be used
+// with ErrorMessage and NewErrorMessage* like the built-in codes. The message
+// can contain fmt verbs (eg. "Model %s is locked"), formatted using the
+// extra arguments given to NewErrorMessageWithArgs. It returns an error if
+// the code is already in use. Applications should use codes from 200000.
+func RegisterError(code int64, statusCode int, msgTemplate string) error {
+  if builtinErrorMessage(code).ErrCode != 0 {
+    return fmt.Errorf("Error code %d is a built-in code", code)
+  }
+  if statusCode < 400 || statusCode > 599 {
+    return errors.New("Invalid HTTP status code for an error")
+  }
+  registeredErrorsMutex.Lock()
+  defer registeredErrorsMutex.Unlock()
+  if _, ok := registeredErrors[code]; ok {
+    return fmt.Errorf("Error code %d is already registered", code)
+  }
+  registeredErrors[code] = errorDefinition{statusCode, msgTemplate}
+  return nil
+}
+
+// registeredError returns the definition of a registered error code.
+func registeredError(code int64) (errorDefinition, bool) {
+  registeredErrorsMutex.RLock()
+  defer registeredErrorsMutex.RUnlock()
+  re, ok := registeredErrors[code]
+  return re, ok
+}
+
 // ErrorMessageOK creates an ErrMsg initialized with OK (default) values.
 func ErrorMessageOK() (ErrMsg) {
   return ErrMsg{ErrCode: 0, StatusCode: http.StatusOK, Msg: ""}
 }
 
-// ErrorMessage receives an error code and generate an error message response
+// ErrorMessage receives an error code and generate an error message response.
+// The code can be a built-in code or one added using RegisterError.
 func ErrorMessage(err int64) (ErrMsg) {
+  em := builtinErrorMessage(err)
+  if re, ok := registeredError(err); ok {
+    em.Msg = re.msg
+    em.ErrCode = int(err)
+    em.StatusCode = re.statusCode
+  }
+  em.ErrID = uuid.Must(uuid.NewV4()).String()
+  em.Stack = errorStack()
+  return em
+}
 
-  em := ErrorMessageOK()
+// builtinErrorMessage returns the message of a built-in error code. The
+// ErrCode of the result is 0 if the code is unknown.
+func builtinErrorMessage(err int64) (ErrMsg) {
 
-  em.ErrID = uuid.Must(uuid.NewV4()).String()
+  em := ErrorMessageOK()
 
   switch (err) {
     case ErrorNoDatabase:
@@ -227,6 +424,18 @@ func ErrorMessage(err int64) (ErrMsg) {
       em.Msg = "Requested file not found on server"
       em.ErrCode = ErrorFileNotFound
       em.StatusCode = http.StatusNotFound
+    case ErrorDbDeadlock:
+      em.Msg = "The database is busy. Try again later"
+      em.ErrCode = ErrorDbDeadlock
+      em.StatusCode = http.StatusServiceUnavailable
+    case ErrorDbConstraint:
+      em.Msg = "The request conflicts with other resources"
+      em.ErrCode = ErrorDbConstraint
+      em.StatusCode = http.StatusConflict
+    case ErrorDbQuery:
+      em.Msg = "Unable to query the database"
+      em.ErrCode = ErrorDbQuery
+      em.StatusCode = http.StatusInternalServerError
     case ErrorMarshalJSON:
       em.Msg = "Unable to marshal the response into a JSON"
       em.ErrCode = ErrorMarshalJSON
@@ -239,6 +448,18 @@ func ErrorMessage(err int64) (ErrMsg) {
       em.Msg = "Unable to marshal the response into a protobuf"
       em.ErrCode = ErrorMarshalProto
       em.StatusCode = http.StatusInternalServerError
+    case ErrorMarshalXML:
+      em.Msg = "Unable to marshal the response into XML"
+      em.ErrCode = ErrorMarshalXML
+      em.StatusCode = http.StatusInternalServerError
+    case ErrorMarshalCSV:
+      em.Msg = "Unable to marshal the response into CSV"
+      em.ErrCode = ErrorMarshalCSV
+      em.StatusCode = http.StatusInternalServerError
+    case ErrorMarshalMsgPack:
+      em.Msg = "Unable to marshal the response into MessagePack"
+      em.ErrCode = ErrorMarshalMsgPack
+      em.StatusCode = http.StatusInternalServerError
     case ErrorIDNotInRequest:
       em.Msg = "ID not present in request"
       em.ErrCode = ErrorIDNotInRequest
@@ -311,6 +532,50 @@ func ErrorMessage(err int64) (ErrMsg) {
       em.Msg = "One or more required fields are missing"
       em.ErrCode = ErrorMissingField
       em.StatusCode = http.StatusBadRequest
+    case ErrorRateLimited:
+      em.Msg = "Too many requests"
+      em.ErrCode = ErrorRateLimited
+      em.StatusCode = http.StatusTooManyRequests
+    case ErrorRouteNotFound:
+      em.Msg = "Route not found"
+      em.ErrCode = ErrorRouteNotFound
+      em.StatusCode = http.StatusNotFound
+    case ErrorMethodNotAllowed:
+      em.Msg = "Method not allowed"
+      em.ErrCode = ErrorMethodNotAllowed
+      em.StatusCode = http.StatusMethodNotAllowed
+    case ErrorPayloadTooLarge:
+      em.Msg = "Request payload is too large"
+      em.ErrCode = ErrorPayloadTooLarge
+      em.StatusCode = http.StatusRequestEntityTooLarge
+    case ErrorInvalidFilter:
+      em.Msg = "Invalid filter in request"
+      em.ErrCode = ErrorInvalidFilter
+      em.StatusCode = http.StatusBadRequest
+    case ErrorUnsupportedMediaType:
+      em.Msg = "Unsupported request content type"
+      em.ErrCode = ErrorUnsupportedMediaType
+      em.StatusCode = http.StatusUnsupportedMediaType
+    case ErrorQuotaExceeded:
+      em.Msg = "Requests quota exceeded"
+      em.ErrCode = ErrorQuotaExceeded
+      em.StatusCode = http.StatusTooManyRequests
+    case ErrorUploadQuotaExceeded:
+      em.Msg = "Upload quota exceeded"
+      em.ErrCode = ErrorUploadQuotaExceeded
+      em.StatusCode = http.StatusRequestEntityTooLarge
+    case ErrorChecksumMismatch:
+      em.Msg = "The uploaded content does not match its checksum"
+      em.ErrCode = ErrorChecksumMismatch
+      em.StatusCode = http.StatusBadRequest
+    case ErrorUnsafeContent:
+      em.Msg = "The uploaded content was rejected as unsafe"
+      em.ErrCode = ErrorUnsafeContent
+      em.StatusCode = http.StatusUnprocessableEntity
+    case ErrorInvalidImage:
+      em.Msg = "Invalid image"
+      em.ErrCode = ErrorInvalidImage
+      em.StatusCode = http.StatusUnprocessableEntity
     case ErrorAuthNoUser:
       em.Msg = "No user in server with the claimed identity"
       em.ErrCode = ErrorAuthNoUser
@@ -323,6 +588,38 @@ func ErrorMessage(err int64) (ErrMsg) {
       em.Msg = "Unauthorized request"
       em.ErrCode = ErrorAuthJWTInvalid
       em.StatusCode = http.StatusUnauthorized
+    case ErrorTenantRequired:
+      em.Msg = "Unable to resolve the tenant of the request"
+      em.ErrCode = ErrorTenantRequired
+      em.StatusCode = http.StatusBadRequest
+    case ErrorTenantMismatch:
+      em.Msg = "The user does not belong to the requested tenant"
+      em.ErrCode = ErrorTenantMismatch
+      em.StatusCode = http.StatusForbidden
+    case ErrorInternalPanic:
+      em.Msg = "Internal server error"
+      em.ErrCode = ErrorInternalPanic
+      em.StatusCode = http.StatusInternalServerError
+    case ErrorMaintenance:
+      em.Msg = "The server is under maintenance"
+      em.ErrCode = ErrorMaintenance
+      em.StatusCode = http.StatusServiceUnavailable
+    case ErrorCircuitOpen:
+      em.Msg = "The service is temporarily unavailable"
+      em.ErrCode = ErrorCircuitOpen
+      em.StatusCode = http.StatusServiceUnavailable
+    case ErrorNotImplemented:
+      em.Msg = "Not supported by the server"
+      em.ErrCode = ErrorNotImplemented
+      em.StatusCode = http.StatusNotImplemented
+    case ErrorInvalidResult:
+      em.Msg = "The handler returned an invalid result"
+      em.ErrCode = ErrorInvalidResult
+      em.StatusCode = http.StatusInternalServerError
+    case ErrorInvalidValidationTag:
+      em.Msg = "Invalid validation tag"
+      em.ErrCode = ErrorInvalidValidationTag
+      em.StatusCode = http.StatusInternalServerError
     case ErrorZipNotAvailable:
       em.Msg = "Zip file not available for this resource"
       em.ErrCode = ErrorZipNotAvailable
@@ -0,0 +1,63 @@
+package ign
+
+import (
+  "net/http"
+  "strings"
+  "testing"
+)
+
+// TestRegisterError tests adding application specific error codes.
+func TestRegisterError(t *testing.T) {
+  const errorModelLocked = 200000
+  if err := RegisterError(errorModelLocked, http.StatusConflict,
+                          "Model %s is locked"); err != nil {
+    t.Fatal("Unable to register error:", err)
+  }
+  defer func() {
+    registeredErrorsMutex.Lock()
+    delete(registeredErrors, errorModelLocked)
+    registeredErrorsMutex.Unlock()
+  }()
+
+  if RegisterError(errorModelLocked, http.StatusConflict, "x") == nil {
+    t.Fatal("Codes should not be registered twice")
+  }
+  if RegisterError(ErrorIDNotFound, http.StatusConflict, "x") == nil {
+    t.Fatal("Built-in codes should not be registered")
+  }
+  if RegisterError(200001, http.StatusOK, "x") == nil {
+    t.Fatal("Non error status codes should be rejected")
+  }
+
+  em := NewErrorMessageWithArgs(errorModelLocked, nil, []string{"box"})
+  if em.ErrCode != errorModelLocked || em.StatusCode != http.StatusConflict ||
+     em.Msg != "Model box is locked" || em.ErrID == "" {
+    t.Fatal("Unexpected error message:", em)
+  }
+  // Messages are not formatted without arguments
+  for _, em := range []*ErrMsg{NewErrorMessage(errorModelLocked),
+                               NewErrorMessageWithArgs(errorModelLocked, nil, nil)} {
+    if em.Msg != "Model %s is locked" {
+      t.Fatal("Unexpected error message:", em.Msg)
+    }
+  }
+
+  if em := ErrorMessage(ErrorIDNotFound); em.StatusCode != http.StatusNotFound {
+    t.Fatal("Built-in codes should not change:", em)
+  }
+}
+
+// TestErrorStack tests capturing the callers of the error constructors.
+func TestErrorStack(t *testing.T) {
+  em := NewErrorMessageWithArgs(ErrorIDNotFound, nil, []string{"id"})
+  if !strings.HasPrefix(em.Stack, "errors_test.go:") ||
+     !strings.Contains(em.Stack, "TestErrorStack") {
+    t.Fatal("Unexpected stack:", em.Stack)
+  }
+  if len(strings.Split(em.Stack, " < ")) > errStackDepth {
+    t.Fatal("Too many frames:", em.Stack)
+  }
+  if !strings.Contains(em.LogString(), "Stack: errors_test.go:") {
+    t.Fatal("The stack should be logged:", em.LogString())
+  }
+}
@@ -0,0 +1,39 @@
+// Package errreporter provides ign.ErrorReporter implementations that send
+// server errors to error tracking services. Use them with
+// Server.SetErrorReporter.
+package errreporter
+
+import (
+  "net/http"
+  "strconv"
+  "bitbucket.org/ignitionrobotics/ign-go"
+  "github.com/getsentry/sentry-go"
+)
+
+/////////////////////////////////////////////////
+
+// Sentry returns an ign.ErrorReporter that sends server errors (status code
+// >= 500) to Sentry, including the request information. The hub must be
+// configured (eg. using sentry.Init and sentry.CurrentHub()).
+func Sentry(hub *sentry.Hub) ign.ErrorReporter {
+  return func(errMsg ign.ErrMsg, r *http.Request) {
+    if errMsg.StatusCode < http.StatusInternalServerError {
+      return
+    }
+    hub := hub.Clone()
+    hub.WithScope(func(scope *sentry.Scope) {
+      if r != nil {
+        scope.SetRequest(r)
+      }
+      scope.SetTag("errcode", strconv.Itoa(errMsg.ErrCode))
+      scope.SetTag("errid", errMsg.ErrID)
+      scope.SetExtra("extra", errMsg.Extra)
+      if errMsg.BaseError != nil {
+        scope.SetExtra("msg", errMsg.Msg)
+        hub.CaptureException(errMsg.BaseError)
+      } else {
+        hub.CaptureMessage(errMsg.Msg)
+      }
+    })
+  }
+}
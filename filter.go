@@ -0,0 +1,96 @@
+package ign
+
+import (
+  "net/http"
+  "sort"
+  "strings"
+  "gorm.io/gorm"
+)
+
+// Filters are sent in the URL query as filter[name]=value (eg.
+// ?filter[status]=active&filter[owner]=osrf). A filter matches any of its
+// comma separated or repeated values (eg. ?filter[owner]=osrf,nasa).
+// Only the declared filters are accepted, and their values are always
+// passed as query arguments, so the query can't be injected.
+// The typical usage is the following:
+//
+//   filters := map[string]string{"status": "status", "owner": "owner_name"}
+//   fr, em := NewFilterRequest(r, filters)
+//   q := fr.Apply(db.Model(&Model{}))
+
+// filterArgPrefix is the prefix of the filter arguments.
+const filterArgPrefix = "filter["
+
+// Filter is a filter found in the request.
+type Filter struct {
+  // Name of the filter, as found in the URL query.
+  Name string
+  // Column of the filter, as declared by the application.
+  Column string
+  // Accepted values.
+  Values []string
+}
+
+// FilterRequest contains the filters requested in the URL query.
+type FilterRequest struct {
+  // Filters sorted by name.
+  Filters []Filter
+}
+
+// NewFilterRequest parses the filters of the given request. The columns map
+// contains the accepted filter names, and the column each one applies to.
+// It returns ErrorInvalidFilter if the request has an unknown filter.
+func NewFilterRequest(r *http.Request,
+                      columns map[string]string) (*FilterRequest, *ErrMsg) {
+  var fr FilterRequest
+  for arg, values := range r.URL.Query() {
+    if !strings.HasPrefix(arg, filterArgPrefix) || !strings.HasSuffix(arg, "]") {
+      continue
+    }
+    name := arg[len(filterArgPrefix):len(arg) - 1]
+    column, ok := columns[name]
+    if !ok {
+      return nil, NewErrorMessageWithArgs(ErrorInvalidFilter, nil, []string{name})
+    }
+
+    filter := Filter{Name: name, Column: column}
+    for _, v := range values {
+      for _, value := range strings.Split(v, ",") {
+        if value = strings.TrimSpace(value); value != "" {
+          filter.Values = append(filter.Values, value)
+        }
+      }
+    }
+    if len(filter.Values) == 0 {
+      return nil, NewErrorMessageWithArgs(ErrorInvalidFilter, nil, []string{name})
+    }
+    fr.Filters = append(fr.Filters, filter)
+  }
+  sort.Slice(fr.Filters, func(i, j int) bool {
+    return fr.Filters[i].Name < fr.Filters[j].Name
+  })
+  return &fr, nil
+}
+
+// Get returns the values of the given filter, or nil if the filter was not
+// requested.
+func (fr *FilterRequest) Get(name string) []string {
+  for _, f := range fr.Filters {
+    if f.Name == name {
+      return f.Values
+    }
+  }
+  return nil
+}
+
+// Apply adds a where clause to the query for each filter.
+func (fr *FilterRequest) Apply(q *gorm.DB) *gorm.DB {
+  for _, f := range fr.Filters {
+    if len(f.Values) == 1 {
+      q = q.Where(f.Column + " = ?", f.Values[0])
+    } else {
+      q = q.Where(f.Column + " IN (?)", f.Values)
+    }
+  }
+  return q
+}
@@ -0,0 +1,55 @@
+package ign
+
+import (
+  "net/http"
+  "testing"
+)
+
+// TestFilterRequest tests parsing and applying filter arguments.
+func TestFilterRequest(t *testing.T) {
+  db := newListItemsDB(t,
+    listItem{Name: "a", Status: "active", Owner: "osrf"},
+    listItem{Name: "b", Status: "active", Owner: "nasa"},
+    listItem{Name: "c", Status: "deleted", Owner: "osrf"},
+    listItem{Name: "d", Status: "active", Owner: "other"},
+  )
+  defer sqlDB(db).Close()
+  columns := map[string]string{"status": "status", "owner": "owner"}
+
+  find := func(query string) ([]string, *ErrMsg) {
+    r, _ := http.NewRequest("GET", "/items?" + query, nil)
+    fr, em := NewFilterRequest(r, columns)
+    if em != nil {
+      return nil, em
+    }
+    var items []listItem
+    if err := fr.Apply(db.Model(&listItem{})).Order("name").Find(&items).Error; err != nil {
+      t.Fatal("Query failed:", err)
+    }
+    var names []string
+    for _, i := range items {
+      names = append(names, i.Name)
+    }
+    return names, nil
+  }
+
+  tests := map[string][]string{
+    "": {"a", "b", "c", "d"},
+    "filter[status]=active&filter[owner]=osrf": {"a"},
+    "filter[owner]=osrf,nasa": {"a", "b", "c"},
+    "filter[owner]=osrf&filter[owner]=other&page=2": {"a", "c", "d"},
+  }
+  for query, expected := range tests {
+    names, em := find(query)
+    if em != nil || !SameElements(names, expected) {
+      t.Fatal("Unexpected result for", query, names, em)
+    }
+  }
+
+  for _, query := range []string{"filter[name]=a", "filter[status]=",
+                                 "filter[status]=x&filter[id]=1"} {
+    if _, em := find(query); em == nil || em.ErrCode != ErrorInvalidFilter {
+      t.Fatal("Expected ErrorInvalidFilter for", query, em)
+    }
+  }
+}
@@ -0,0 +1,152 @@
+package ign
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "io/ioutil"
+  "mime"
+  "net/http"
+  "github.com/graphql-go/graphql"
+  "github.com/graphql-go/graphql/language/ast"
+  "github.com/graphql-go/graphql/language/parser"
+)
+
+// GraphQL queries can be served by a route using GraphQLHandler as its
+// handler, eg.
+//
+//   Route{
+//     Name: "GraphQL",
+//     URI: "/graphql",
+//     Methods: Methods{
+//       Method{Type: "GET", Handlers: FormatHandlers{
+//         FormatHandler{"", GraphQLHandler(schema)}}},
+//       Method{Type: "POST", Handlers: FormatHandlers{
+//         FormatHandler{"", GraphQLHandler(schema)}}},
+//     },
+//   }
+//
+// The queries go through the route middlewares, so resolvers can get the
+// request with GraphQLRequest, and use IdentityFromRequest and
+// DBFromRequest. Transport failures (eg. an invalid body) are replied with
+// an ErrMsg, while query errors are returned in the "errors" field of the
+// response, as required by GraphQL. Mutations and subscriptions must be sent
+// with POST, so they can't be triggered by links or cross-site GETs; GET
+// requests with other operations are rejected with ErrorMethodNotAllowed.
+
+// graphQLRequestKey is the context key of the request of a GraphQL query.
+type graphQLRequestKey struct{}
+
+// graphQLBody is the body of the GraphQL POST requests, also used for the
+// query parameters of GET requests.
+type graphQLBody struct {
+  Query string `json:"query"`
+  OperationName string `json:"operationName"`
+  Variables map[string]interface{} `json:"variables"`
+}
+
+// GraphQLHandler returns a handler that runs the GraphQL queries of the
+// requests with the given schema. Queries are read from the "query",
+// "operationName" and "variables" parameters of GET requests, or from the
+// body of POST requests (application/json or application/graphql). GET
+// requests can only run queries.
+func GraphQLHandler(schema graphql.Schema) http.Handler {
+  return Handler(func(w http.ResponseWriter, r *http.Request) *ErrMsg {
+    body, em := readGraphQLBody(r)
+    if em != nil {
+      return em
+    }
+    if op := graphQLOperation(body); r.Method == "GET" && op != "" &&
+       op != ast.OperationTypeQuery {
+      w.Header().Set("Allow", "POST")
+      return NewErrorMessageWithBase(ErrorMethodNotAllowed,
+                                     errors.New("GraphQL " + op +
+                                                " operations require POST"))
+    }
+    ctx := context.WithValue(r.Context(), graphQLRequestKey{}, r)
+    result := graphql.Do(graphql.Params{
+      Schema: schema,
+      RequestString: body.Query,
+      VariableValues: body.Variables,
+      OperationName: body.OperationName,
+      Context: ctx,
+    })
+
+    data, err := json.Marshal(result)
+    if err != nil {
+      return NewErrorMessageWithBase(ErrorMarshalJSON, err)
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(data)
+    return nil
+  })
+}
+
+// GraphQLRequest returns the HTTP request of a GraphQL query, given the
+// context of a resolver (graphql.ResolveParams.Context). It returns nil if
+// the query was not run by GraphQLHandler.
+func GraphQLRequest(ctx context.Context) *http.Request {
+  r, _ := ctx.Value(graphQLRequestKey{}).(*http.Request)
+  return r
+}
+
+// graphQLOperation returns the type (eg. "query" or "mutation") of the
+// operation run by a GraphQL request. It returns an empty string if the
+// document is invalid or the operation is not found, as graphql.Do rejects
+// those requests without running them.
+func graphQLOperation(body *graphQLBody) string {
+  doc, err := parser.Parse(parser.ParseParams{Source: body.Query})
+  if err != nil {
+    return ""
+  }
+  for _, def := range doc.Definitions {
+    op, ok := def.(*ast.OperationDefinition)
+    if !ok {
+      continue
+    }
+    if body.OperationName == "" ||
+       (op.Name != nil && op.Name.Value == body.OperationName) {
+      return op.Operation
+    }
+  }
+  return ""
+}
+
+// readGraphQLBody reads the query of a GraphQL request.
+func readGraphQLBody(r *http.Request) (*graphQLBody, *ErrMsg) {
+  var body graphQLBody
+  switch r.Method {
+  case "GET":
+    q := r.URL.Query()
+    body.Query = q.Get("query")
+    body.OperationName = q.Get("operationName")
+    if v := q.Get("variables"); v != "" {
+      if err := json.Unmarshal([]byte(v), &body.Variables); err != nil {
+        return nil, NewErrorMessageWithArgs(ErrorUnmarshalJSON, err,
+                                            []string{"variables"})
+      }
+    }
+  case "POST":
+    mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if mediaType == "application/graphql" {
+      data, err := ioutil.ReadAll(r.Body)
+      if err != nil {
+        if IsBodyTooLarge(err) {
+          return nil, NewErrorMessageWithBase(ErrorPayloadTooLarge, err)
+        }
+        return nil, NewErrorMessageWithBase(ErrorForm, err)
+      }
+      body.Query = string(data)
+    } else if em := BindJSON(r, &body); em != nil {
+      return nil, em
+    }
+  default:
+    return nil, NewErrorMessage(ErrorMethodNotAllowed)
+  }
+
+  if body.Query == "" {
+    return nil, NewErrorMessageWithArgs(ErrorMissingField, nil,
+                                        []string{"query"})
+  }
+  return &body, nil
+}
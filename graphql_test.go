@@ -0,0 +1,92 @@
+package ign
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "testing"
+  "github.com/graphql-go/graphql"
+)
+
+// TestGraphQLHandler tests running GraphQL queries.
+func TestGraphQLHandler(t *testing.T) {
+  touched := 0
+  schema, err := graphql.NewSchema(graphql.SchemaConfig{
+    Mutation: graphql.NewObject(graphql.ObjectConfig{
+      Name: "Mutation",
+      Fields: graphql.Fields{
+        "touch": &graphql.Field{
+          Type: graphql.Int,
+          Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+            touched++
+            return touched, nil
+          },
+        },
+      },
+    }),
+    Query: graphql.NewObject(graphql.ObjectConfig{
+      Name: "Query",
+      Fields: graphql.Fields{
+        "path": &graphql.Field{
+          Type: graphql.String,
+          Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+            return GraphQLRequest(p.Context).URL.Path, nil
+          },
+        },
+      },
+    }),
+  })
+  if err != nil {
+    t.Fatal("Unable to create the schema:", err)
+  }
+  handler := GraphQLHandler(schema)
+  send := func(req *http.Request) (int, map[string]interface{}) {
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    var result map[string]interface{}
+    json.Unmarshal(rec.Body.Bytes(), &result)
+    return rec.Code, result
+  }
+
+  req := httptest.NewRequest("GET", "/graphql?query=" +
+                             url.QueryEscape("{ path }"), nil)
+  code, result := send(req)
+  data, _ := result["data"].(map[string]interface{})
+  if code != http.StatusOK || data["path"] != "/graphql" {
+    t.Fatal("Unexpected GET result:", code, result)
+  }
+
+  req = httptest.NewRequest("POST", "/graphql",
+                            bytes.NewBufferString(`{"query": "{ unknown }"}`))
+  req.Header.Set("Content-Type", "application/json")
+  code, result = send(req)
+  if code != http.StatusOK || result["errors"] == nil {
+    t.Fatal("Query errors should be returned in the response:", code, result)
+  }
+
+  // Mutations can't be sent with GET, even in a document with queries
+  for _, rawQuery := range []string{
+    "query=" + url.QueryEscape("mutation { touch }"),
+    "query=" + url.QueryEscape("query Q { path } mutation M { touch }") +
+    "&operationName=M",
+  } {
+    req = httptest.NewRequest("GET", "/graphql?" + rawQuery, nil)
+    if code, _ = send(req); code != http.StatusMethodNotAllowed || touched != 0 {
+      t.Fatal("GET mutations should be rejected:", rawQuery, code)
+    }
+  }
+  req = httptest.NewRequest("POST", "/graphql",
+                            bytes.NewBufferString(`{"query": "mutation { touch }"}`))
+  req.Header.Set("Content-Type", "application/json")
+  if code, _ = send(req); code != http.StatusOK || touched != 1 {
+    t.Fatal("POST mutations should be run:", code, touched)
+  }
+
+  req = httptest.NewRequest("POST", "/graphql", bytes.NewBufferString(`{}`))
+  req.Header.Set("Content-Type", "application/json")
+  if code, _ = send(req); code != http.StatusBadRequest {
+    t.Fatal("Requests without query should be rejected:", code)
+  }
+}
@@ -0,0 +1,106 @@
+package ign
+
+import (
+  "encoding/json"
+  "net/http"
+  "sort"
+)
+
+// Health routes can be used as liveness and readiness probes (eg. by
+// Kubernetes):
+// - GET /healthz (liveness) reports that the server is up and running.
+// - GET /readyz (readiness) reports the database connectivity and the
+// result of the custom checks registered with Server.AddHealthCheck.
+// Both routes return 200 when healthy, and 503 otherwise.
+// The routes are registered by Init when IGN_HEALTH_ROUTES is "true", or
+// manually using Server.AddHealthRoutes. They are registered without the
+// middlewares used by the application routes (eg. JWT validation).
+
+const (
+  // HealthzPath is the path of the liveness route.
+  HealthzPath = "/healthz"
+  // ReadyzPath is the path of the readiness route.
+  ReadyzPath = "/readyz"
+
+  healthStatusOK = "ok"
+  healthStatusError = "error"
+)
+
+// HealthStatus is the JSON response of the health routes.
+type HealthStatus struct {
+  // Status is "ok" if all the checks passed, or "error" otherwise.
+  Status string `json:"status"`
+  // Checks contains the result of each check ("ok" or the error message).
+  Checks map[string]string `json:"checks,omitempty"`
+}
+
+// AddHealthCheck registers a custom readiness check. The check is considered
+// failed if it returns an error.
+func (s *Server) AddHealthCheck(name string, check func() error) {
+  s.healthMutex.Lock()
+  defer s.healthMutex.Unlock()
+  if s.healthChecks == nil {
+    s.healthChecks = make(map[string]func() error)
+  }
+  s.healthChecks[name] = check
+}
+
+// AddHealthRoutes registers the /healthz and /readyz routes in the server
+// router.
+func (s *Server) AddHealthRoutes() {
+  s.Router.Methods("GET").Path(HealthzPath).Name("healthz").
+    HandlerFunc(s.livenessHandler)
+  s.Router.Methods("GET").Path(ReadyzPath).Name("readyz").
+    HandlerFunc(s.readinessHandler)
+}
+
+// CheckHealth runs the database check and the custom checks, and returns the
+// resulting status.
+func (s *Server) CheckHealth() HealthStatus {
+  status := HealthStatus{Status: healthStatusOK, Checks: map[string]string{}}
+
+  status.Checks["database"] = healthStatusOK
+  if !s.DbHealthy() {
+    status.Checks["database"] = ErrorMessage(ErrorNoDatabase).Msg
+    status.Status = healthStatusError
+  }
+
+  s.healthMutex.RLock()
+  names := make([]string, 0, len(s.healthChecks))
+  for name := range s.healthChecks {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    status.Checks[name] = healthStatusOK
+    if err := s.healthChecks[name](); err != nil {
+      status.Checks[name] = err.Error()
+      status.Status = healthStatusError
+    }
+  }
+  s.healthMutex.RUnlock()
+
+  return status
+}
+
+/////////////////////////////////////////////////
+// livenessHandler reports that the server is running.
+func (s *Server) livenessHandler(w http.ResponseWriter, r *http.Request) {
+  writeHealthStatus(w, HealthStatus{Status: healthStatusOK})
+}
+
+/////////////////////////////////////////////////
+// readinessHandler reports whether the server is ready to serve requests.
+func (s *Server) readinessHandler(w http.ResponseWriter, r *http.Request) {
+  writeHealthStatus(w, s.CheckHealth())
+}
+
+// writeHealthStatus writes the given status as JSON.
+func writeHealthStatus(w http.ResponseWriter, status HealthStatus) {
+  w.Header().Set("Content-Type", "application/json")
+  w.Header().Set("Cache-Control", "no-cache")
+  if status.Status != healthStatusOK {
+    w.WriteHeader(http.StatusServiceUnavailable)
+  }
+  json.NewEncoder(w).Encode(status)
+}
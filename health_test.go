@@ -0,0 +1,50 @@
+package ign
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "github.com/gorilla/mux"
+)
+
+// Tests for the health routes
+
+// TestHealthRoutes tests the liveness and readiness routes.
+func TestHealthRoutes(t *testing.T) {
+  var s Server
+  s.Router = mux.NewRouter()
+  s.AddHealthRoutes()
+
+  cacheErr := errors.New("cache unavailable")
+  var cacheStatus error
+  s.AddHealthCheck("cache", func() error { return cacheStatus })
+
+  get := func(path string) (int, HealthStatus) {
+    req, _ := http.NewRequest("GET", path, nil)
+    rec := httptest.NewRecorder()
+    s.Router.ServeHTTP(rec, req)
+    var status HealthStatus
+    if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+      t.Fatal("Unable to parse health status", rec.Body.String())
+    }
+    return rec.Code, status
+  }
+
+  // The server is alive, but it is not ready without database.
+  if code, status := get(HealthzPath); code != http.StatusOK || status.Status != "ok" {
+    t.Fatal("Unexpected liveness response", code, status)
+  }
+  code, status := get(ReadyzPath)
+  if code != http.StatusServiceUnavailable || status.Status != "error" ||
+     status.Checks["cache"] != "ok" || status.Checks["database"] == "ok" {
+    t.Fatal("Unexpected readiness response", code, status)
+  }
+
+  // Custom checks are reported
+  cacheStatus = cacheErr
+  if _, status := get(ReadyzPath); status.Checks["cache"] != cacheErr.Error() {
+    t.Fatal("Custom check error not reported", status)
+  }
+}
@@ -0,0 +1,212 @@
+package ign
+
+import (
+  "errors"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "strconv"
+  "sync/atomic"
+  "time"
+  "go.opentelemetry.io/otel/attribute"
+  "go.opentelemetry.io/otel/codes"
+  "go.opentelemetry.io/otel/propagation"
+  "go.opentelemetry.io/otel/trace"
+)
+
+// defaultHTTPClientTimeout is the timeout of each attempt of the requests
+// sent by an HTTPClient without Client.
+const defaultHTTPClientTimeout = 10 * time.Second
+
+// defaultHTTPClient is used by HTTPClients without Client.
+var defaultHTTPClient = &http.Client{Timeout: defaultHTTPClientTimeout}
+
+// HTTPClient sends requests to other services. Failed attempts (network
+// errors, 5xx and 429 responses) of idempotent requests are retried with
+// exponential backoff, the
+// W3C trace context of the request context is propagated, and a client
+// span is created if tracing is enabled (see Server.SetTracerProvider).
+// The zero value sends each request once, with a 10 seconds timeout.
+type HTTPClient struct {
+  // Client used to send the requests. Defaults to a client with a 10
+  // seconds timeout.
+  Client *http.Client
+  // MaxAttempts is the number of attempts of each request. Only requests
+  // whose body can be read again (see http.Request.GetBody) are retried.
+  MaxAttempts int
+  // RetryNonIdempotent enables retrying requests with non-idempotent
+  // methods (eg. POST) that have no Idempotency-Key header. Only enable it
+  // if the receiver handles duplicated requests, as a failed attempt may
+  // have been processed.
+  RetryNonIdempotent bool
+  // RetryDelay is the delay before the first retry. It doubles after each
+  // attempt, unless the response has a Retry-After header.
+  RetryDelay time.Duration
+  // MaxRetryDelay is the max delay between two attempts.
+  MaxRetryDelay time.Duration
+
+  requests int64
+  retries int64
+  failures int64
+}
+
+// HTTPClientStats are the counters of an HTTPClient.
+type HTTPClientStats struct {
+  // Requests is the number of requests sent, without retries.
+  Requests int64 `json:"requests"`
+  // Retries is the number of retried attempts.
+  Retries int64 `json:"retries"`
+  // Failures is the number of requests that failed after all the attempts.
+  Failures int64 `json:"failures"`
+}
+
+// NewHTTPClient creates an HTTPClient with 3 attempts per request.
+func NewHTTPClient() *HTTPClient {
+  return &HTTPClient{
+    Client: &http.Client{Timeout: defaultHTTPClientTimeout},
+    MaxAttempts: 3,
+    RetryDelay: 500 * time.Millisecond,
+    MaxRetryDelay: 10 * time.Second,
+  }
+}
+
+// Stats returns the counters of the client.
+func (c *HTTPClient) Stats() HTTPClientStats {
+  return HTTPClientStats{
+    Requests: atomic.LoadInt64(&c.requests),
+    Retries: atomic.LoadInt64(&c.retries),
+    Failures: atomic.LoadInt64(&c.failures),
+  }
+}
+
+// Get sends a GET request.
+func (c *HTTPClient) Get(url string) (*http.Response, error) {
+  req, err := http.NewRequest("GET", url, nil)
+  if err != nil {
+    return nil, err
+  }
+  return c.Do(req)
+}
+
+// Post sends a POST request with the given body. Like http.Client.Post, the
+// body can only be sent again if it is a *bytes.Buffer, *bytes.Reader or
+// *strings.Reader.
+func (c *HTTPClient) Post(url, contentType string,
+                          body io.Reader) (*http.Response, error) {
+  req, err := http.NewRequest("POST", url, body)
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Content-Type", contentType)
+  return c.Do(req)
+}
+
+// Do sends the request, retrying the failed attempts. It returns the
+// response of the last attempt, which can be a 5xx response. Waiting for
+// a retry stops if the request context is canceled.
+func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+  atomic.AddInt64(&c.requests, 1)
+  ctx := req.Context()
+  if gServer != nil && gServer.tracer != nil {
+    var span trace.Span
+    ctx, span = gServer.tracer.Start(ctx, "http: " + req.Method + " " + req.URL.Host,
+      trace.WithSpanKind(trace.SpanKindClient),
+      trace.WithAttributes(
+        attribute.String("http.method", req.Method),
+        attribute.String("http.url", req.URL.String()),
+      ))
+    defer span.End()
+    req = req.WithContext(ctx)
+  }
+  // It is a no-op span if tracing is disabled.
+  span := trace.SpanFromContext(ctx)
+  tracePropagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+  attempts := c.MaxAttempts
+  if attempts < 1 || req.Body != nil && req.GetBody == nil ||
+     !c.RetryNonIdempotent && !idempotent(req) {
+    attempts = 1
+  }
+  delay := c.RetryDelay
+  client := c.Client
+  if client == nil {
+    client = defaultHTTPClient
+  }
+
+  for attempt := 1; ; attempt++ {
+    resp, err := client.Do(req)
+    if resp != nil {
+      span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+    }
+    if !retryable(resp, err) {
+      return resp, err
+    }
+    if attempt >= attempts {
+      atomic.AddInt64(&c.failures, 1)
+      if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+      } else {
+        span.SetStatus(codes.Error, resp.Status)
+      }
+      return resp, err
+    }
+
+    wait := delay
+    if resp != nil {
+      if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+        wait = time.Duration(seconds) * time.Second
+      }
+      // Read the body, so the connection can be reused.
+      io.Copy(ioutil.Discard, resp.Body)
+      resp.Body.Close()
+    }
+    if c.MaxRetryDelay > 0 && wait > c.MaxRetryDelay {
+      wait = c.MaxRetryDelay
+    }
+    gLogger.Debug("Retrying HTTP request", Fields{"url": req.URL.String(),
+                                                  "attempt": attempt,
+                                                  "error": err})
+    select {
+    case <-ctx.Done():
+      atomic.AddInt64(&c.failures, 1)
+      return nil, ctx.Err()
+    case <-clock().After(wait):
+    }
+    delay *= 2
+
+    if req.GetBody != nil {
+      body, err := req.GetBody()
+      if err != nil {
+        atomic.AddInt64(&c.failures, 1)
+        return nil, errors.New("Unable to retry the request: " + err.Error())
+      }
+      req.Body = body
+    }
+    atomic.AddInt64(&c.retries, 1)
+  }
+}
+
+// idempotent returns true if sending the request several times has the same
+// effect as sending it once. Like net/http, the requests with an
+// Idempotency-Key header are considered idempotent.
+func idempotent(req *http.Request) bool {
+  switch req.Method {
+  case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+    return true
+  }
+  _, ok := req.Header["Idempotency-Key"]
+  if !ok {
+    _, ok = req.Header["X-Idempotency-Key"]
+  }
+  return ok
+}
+
+// retryable returns true if the result of an attempt should be retried.
+func retryable(resp *http.Response, err error) bool {
+  if err != nil {
+    return true
+  }
+  return resp.StatusCode >= http.StatusInternalServerError ||
+         resp.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,88 @@
+package ign
+
+import (
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+// TestHTTPClientRetries tests retrying failed requests.
+func TestHTTPClientRetries(t *testing.T) {
+  var attempts int64
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    attempt := atomic.AddInt64(&attempts, 1)
+    body, _ := ioutil.ReadAll(r.Body)
+    if string(body) != "hits" {
+      t.Error("The body should be sent in every attempt:", string(body))
+    }
+    if attempt < 3 {
+      w.WriteHeader(http.StatusBadGateway)
+      return
+    }
+    if r.URL.Path == "/bad" {
+      w.WriteHeader(http.StatusBadRequest)
+    }
+  }))
+  defer srv.Close()
+
+  c := NewHTTPClient()
+  c.RetryDelay = time.Millisecond
+  put := func(url string) (*http.Response, error) {
+    req, _ := http.NewRequest("PUT", url, strings.NewReader("hits"))
+    return c.Do(req)
+  }
+  resp, err := put(srv.URL)
+  if err != nil || resp.StatusCode != http.StatusOK ||
+     atomic.LoadInt64(&attempts) != 3 {
+    t.Fatal("The request should succeed after the retries:", err,
+            atomic.LoadInt64(&attempts))
+  }
+  resp.Body.Close()
+
+  // Client errors are not retried
+  atomic.StoreInt64(&attempts, 2)
+  resp, err = put(srv.URL + "/bad")
+  if err != nil || resp.StatusCode != http.StatusBadRequest ||
+     atomic.LoadInt64(&attempts) != 3 {
+    t.Fatal("Client errors should not be retried:", err,
+            atomic.LoadInt64(&attempts))
+  }
+  resp.Body.Close()
+
+  // The last response is returned when all the attempts fail
+  atomic.StoreInt64(&attempts, -10)
+  resp, err = put(srv.URL)
+  if err != nil || resp.StatusCode != http.StatusBadGateway ||
+     atomic.LoadInt64(&attempts) != -7 {
+    t.Fatal("Unexpected result of failed attempts:", err,
+            atomic.LoadInt64(&attempts))
+  }
+  resp.Body.Close()
+
+  stats := c.Stats()
+  if stats.Requests != 3 || stats.Retries != 4 || stats.Failures != 1 {
+    t.Fatal("Unexpected stats:", stats)
+  }
+
+  // POST requests are only retried if enabled
+  atomic.StoreInt64(&attempts, 0)
+  resp, err = c.Post(srv.URL, "text/plain", strings.NewReader("hits"))
+  if err != nil || resp.StatusCode != http.StatusBadGateway ||
+     atomic.LoadInt64(&attempts) != 1 {
+    t.Fatal("POST requests should not be retried:", err,
+            atomic.LoadInt64(&attempts))
+  }
+  resp.Body.Close()
+  c.RetryNonIdempotent = true
+  resp, err = c.Post(srv.URL, "text/plain", strings.NewReader("hits"))
+  if err != nil || resp.StatusCode != http.StatusOK ||
+     atomic.LoadInt64(&attempts) != 3 {
+    t.Fatal("POST requests should be retried when enabled:", err,
+            atomic.LoadInt64(&attempts))
+  }
+  resp.Body.Close()
+}
@@ -0,0 +1,116 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "strings"
+  "github.com/codegangsta/negroni"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// Identity is the authenticated user of a request, built from the claims
+// of its JWT. The auth middleware attaches it to the request context, and
+// handlers get it using IdentityFromRequest.
+type Identity struct {
+  // The token subject ("sub" claim). It is the user ID.
+  Subject string
+  // The "email" claim, if present.
+  Email string
+  // Scopes granted by the "scope" (space separated) and "permissions"
+  // claims.
+  Scopes []string
+  // All the token claims.
+  Claims map[string]interface{}
+}
+
+// HasScope returns true if the identity was granted the given scope.
+func (i *Identity) HasScope(scope string) bool {
+  return StrSliceContains(i.Scopes, scope)
+}
+
+// identityKey is the context key of the request Identity.
+type identityKey struct{}
+
+// IdentityFromRequest returns the identity of an authenticated request. It
+// returns false if the request has no valid JWT.
+func IdentityFromRequest(r *http.Request) (*Identity, bool) {
+  if identity, ok := r.Context().Value(identityKey{}).(*Identity); ok {
+    return identity, true
+  }
+  // The request did not go through the auth middleware (eg. tests)
+  return identityFromToken(r)
+}
+
+// identityFromToken builds the identity from the JWT parsed by the JWT
+// middleware.
+func identityFromToken(r *http.Request) (*Identity, bool) {
+  token, ok := r.Context().Value("user").(*jwt.Token)
+  if !ok || token == nil {
+    return nil, false
+  }
+  identity := identityFromJWT(token)
+  return identity, identity != nil
+}
+
+// identityFromJWT builds the identity from the claims of a token. It
+// returns nil if the claims are not a jwt.MapClaims.
+func identityFromJWT(token *jwt.Token) *Identity {
+  claims, ok := token.Claims.(jwt.MapClaims)
+  if !ok {
+    return nil
+  }
+
+  identity := &Identity{Claims: claims}
+  identity.Subject, _ = claims["sub"].(string)
+  identity.Email, _ = claims["email"].(string)
+  if scope, ok := claims["scope"].(string); ok {
+    identity.Scopes = strings.Fields(scope)
+  }
+  if permissions, ok := claims["permissions"].([]interface{}); ok {
+    for _, p := range permissions {
+      if s, ok := p.(string); ok {
+        identity.Scopes = append(identity.Scopes, s)
+      }
+    }
+  }
+  return identity
+}
+
+/////////////////////////////////////////////////
+// newAuthMiddleware wraps a JWT middleware, attaching the Identity of
+// authenticated requests to the request context. Requests without JWT are
+// authenticated using their session, if any (see Sessions). If optional is
+// true, unauthenticated requests get an anonymous ID (see AnonymousID).
+func newAuthMiddleware(jwtMiddleware negroni.HandlerFunc,
+                       optional bool) negroni.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    // Skip the validation of cached tokens
+    raw, _ := jwtTokenExtractor(r)
+    // Requests without JWT can be authenticated with a session cookie
+    if raw == "" {
+      if r, ok := sessionAuth(r); ok {
+        next(w, r)
+        return
+      }
+    }
+    if token, ok := gTokenCache.get(raw); raw != "" && ok {
+      r = r.WithContext(context.WithValue(r.Context(), "user", token))
+      r = r.WithContext(context.WithValue(r.Context(), identityKey{},
+                                          identityFromJWT(token)))
+      next(w, r)
+      return
+    }
+
+    jwtMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
+      if token, ok := r.Context().Value("user").(*jwt.Token); ok && raw != "" {
+        gTokenCache.add(raw, token)
+      }
+      if identity, ok := identityFromToken(r); ok {
+        r = r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+      } else if optional {
+        r = withAnonymousID(r)
+      }
+      next(w, r)
+    })
+  }
+}
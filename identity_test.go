@@ -0,0 +1,45 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// TestIdentityFromRequest tests attaching the identity in the auth
+// middleware.
+func TestIdentityFromRequest(t *testing.T) {
+  // Simulate a JWT middleware that validated a token
+  jwtMiddleware := func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    claims := jwt.MapClaims{
+      "sub": "auth0|alice",
+      "email": "alice@example.com",
+      "scope": "openid read:models",
+      "permissions": []interface{}{"write:models"},
+    }
+    next(w, r.WithContext(context.WithValue(r.Context(), "user",
+                                            &jwt.Token{Claims: claims})))
+  }
+
+  var identity *Identity
+  req, _ := http.NewRequest("GET", "/models", nil)
+  newAuthMiddleware(jwtMiddleware, true)(httptest.NewRecorder(), req,
+    func(w http.ResponseWriter, r *http.Request) {
+      identity, _ = r.Context().Value(identityKey{}).(*Identity)
+      if sub, ok := GetUserIdentity(r); !ok || sub != "auth0|alice" {
+        t.Fatal("Unexpected user identity:", sub)
+      }
+    })
+
+  if identity == nil || identity.Subject != "auth0|alice" ||
+     identity.Email != "alice@example.com" || len(identity.Scopes) != 3 ||
+     !identity.HasScope("write:models") || identity.Claims["sub"] != "auth0|alice" {
+    t.Fatal("Unexpected identity:", identity)
+  }
+
+  if _, ok := IdentityFromRequest(req); ok {
+    t.Fatal("Requests without JWT should not have an identity")
+  }
+}
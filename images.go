@@ -0,0 +1,484 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "encoding/binary"
+  "errors"
+  "image"
+  "image/gif"
+  "image/jpeg"
+  "image/png"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "path"
+  "strconv"
+  "strings"
+  "golang.org/x/image/draw"
+)
+
+// Uploaded images (eg. model thumbnails) can be handled with an Images,
+// eg.
+//
+//   img, em := images.Validate(content)
+//   if em != nil {
+//     return em
+//   }
+//   images.Storage.Put(ctx, key, bytes.NewReader(img.Data))
+//   op, err := images.StartThumbnails(r, key, img)
+//
+// Validate checks the type, dimensions and size of the image, and encodes
+// it again, which drops its metadata (EXIF, comments, ICC profiles). The
+// EXIF orientation of JPEG images is applied to the pixels first, so they
+// are not displayed rotated. The number of pixels of all the frames of
+// animated GIFs is checked before decoding them. The
+// thumbnails are resized to fit in squares of the ThumbnailSizes, and are
+// stored next to the image (see ThumbnailKey). StartThumbnails generates
+// them as an async operation (see Operations).
+
+// Default limits of an Images.
+const (
+  // DefaultImageMaxSize is the default max size of an image: 20MB.
+  DefaultImageMaxSize = 20 << 20
+  // DefaultImageMaxDimension is the default max width and height.
+  DefaultImageMaxDimension = 8192
+  // DefaultImageMaxPixels is the default max number of decoded pixels.
+  DefaultImageMaxPixels = DefaultImageMaxDimension * DefaultImageMaxDimension
+)
+
+// jpegQuality is the quality of the encoded JPEG images.
+const jpegQuality = 90
+
+// imageFormats are the supported image formats.
+var imageFormats = []string{"jpeg", "png", "gif"}
+
+// ErrOperationsDisabled is returned when an async operation is started
+// without Operations.
+var ErrOperationsDisabled = errors.New("Async operations are not enabled")
+
+// Images validates uploaded images and generates their thumbnails.
+type Images struct {
+  // Storage where the thumbnails are stored.
+  Storage Storage
+  // Operations used by StartThumbnails. Defaults to Server.Operations.
+  Operations *Operations
+  // MaxSize is the max size of an image, in bytes. Defaults to
+  // DefaultImageMaxSize.
+  MaxSize int64
+  // MaxWidth and MaxHeight are the max dimensions of an image, in pixels.
+  // Default to DefaultImageMaxDimension.
+  MaxWidth int
+  MaxHeight int
+  // MaxPixels is the max number of decoded pixels, counting all the frames
+  // of animated GIFs. Defaults to DefaultImageMaxPixels.
+  MaxPixels int64
+  // Formats are the accepted formats. Defaults to "jpeg", "png" and "gif".
+  Formats []string
+  // ThumbnailSizes are the sizes, in pixels, of the squares the thumbnails
+  // fit in. Defaults to 256.
+  ThumbnailSizes []int
+}
+
+// Image is a validated image, without metadata.
+type Image struct {
+  // Format of the image: "jpeg", "png" or "gif".
+  Format string
+  Width int
+  Height int
+  // Data is the encoded image.
+  Data []byte
+
+  decoded image.Image
+}
+
+// Thumbnail is a stored thumbnail.
+type Thumbnail struct {
+  Key string `json:"key"`
+  Width int `json:"width"`
+  Height int `json:"height"`
+}
+
+// NewImages creates an Images that stores the thumbnails in the given
+// storage, with the default limits.
+func NewImages(storage Storage) *Images {
+  return &Images{Storage: storage}
+}
+
+// Validate reads an image and checks its size, format and dimensions,
+// before decoding it. It returns ErrorPayloadTooLarge if the image is too
+// large, ErrorUnsupportedMediaType if its format is not accepted, and
+// ErrorInvalidImage if it can't be decoded or its dimensions or number of
+// pixels exceed the limits.
+func (i *Images) Validate(r io.Reader) (*Image, *ErrMsg) {
+  maxSize := i.MaxSize
+  if maxSize <= 0 {
+    maxSize = DefaultImageMaxSize
+  }
+  data, err := ioutil.ReadAll(io.LimitReader(r, maxSize + 1))
+  if err != nil {
+    if IsBodyTooLarge(err) {
+      return nil, NewErrorMessageWithBase(ErrorPayloadTooLarge, err)
+    }
+    return nil, NewErrorMessageWithBase(ErrorInvalidImage, err)
+  }
+  if int64(len(data)) > maxSize {
+    return nil, NewErrorMessage(ErrorPayloadTooLarge)
+  }
+
+  // The header is checked first, so large images are not decoded
+  cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+  if err != nil {
+    return nil, NewErrorMessageWithBase(ErrorInvalidImage, err)
+  }
+  formats := i.Formats
+  if len(formats) == 0 {
+    formats = imageFormats
+  }
+  if !StrSliceContains(formats, format) ||
+     !StrSliceContains(imageFormats, format) {
+    return nil, NewErrorMessageWithArgs(ErrorUnsupportedMediaType, nil,
+                                        []string{"image/" + format})
+  }
+  orientation := 1
+  if format == "jpeg" {
+    orientation = jpegOrientation(data)
+  }
+  width, height := cfg.Width, cfg.Height
+  if orientation >= 5 {
+    width, height = height, width
+  }
+  if width > i.maxWidth() || height > i.maxHeight() {
+    return nil, NewErrorMessageWithArgs(ErrorInvalidImage, nil,
+      []string{strconv.Itoa(width) + "x" + strconv.Itoa(height)})
+  }
+  frames := 1
+  if format == "gif" {
+    if frames, err = gifFrameCount(data); err != nil {
+      return nil, NewErrorMessageWithBase(ErrorInvalidImage, err)
+    }
+  }
+  if int64(frames) * int64(width) * int64(height) > i.maxPixels() {
+    return nil, NewErrorMessageWithArgs(ErrorInvalidImage, nil,
+      []string{strconv.Itoa(frames) + " frames of " + strconv.Itoa(width) +
+               "x" + strconv.Itoa(height)})
+  }
+
+  img := &Image{Format: format, Width: width, Height: height}
+  img.Data, img.decoded, err = reencodeImage(data, format, orientation)
+  if err != nil {
+    return nil, NewErrorMessageWithBase(ErrorInvalidImage, err)
+  }
+  return img, nil
+}
+
+// maxWidth returns the max width of an image.
+func (i *Images) maxWidth() int {
+  if i.MaxWidth > 0 {
+    return i.MaxWidth
+  }
+  return DefaultImageMaxDimension
+}
+
+// maxHeight returns the max height of an image.
+func (i *Images) maxHeight() int {
+  if i.MaxHeight > 0 {
+    return i.MaxHeight
+  }
+  return DefaultImageMaxDimension
+}
+
+// maxPixels returns the max number of decoded pixels of an image.
+func (i *Images) maxPixels() int64 {
+  if i.MaxPixels > 0 {
+    return i.MaxPixels
+  }
+  return DefaultImageMaxPixels
+}
+
+// reencodeImage decodes an image and encodes it again, without metadata,
+// applying the given EXIF orientation. Animated GIFs keep their frames.
+func reencodeImage(data []byte, format string,
+                   orientation int) ([]byte, image.Image, error) {
+  var buf bytes.Buffer
+  if format == "gif" {
+    g, err := gif.DecodeAll(bytes.NewReader(data))
+    if err != nil {
+      return nil, nil, err
+    }
+    if err := gif.EncodeAll(&buf, g); err != nil {
+      return nil, nil, err
+    }
+    return buf.Bytes(), g.Image[0], nil
+  }
+
+  decoded, _, err := image.Decode(bytes.NewReader(data))
+  if err != nil {
+    return nil, nil, err
+  }
+  decoded = orientImage(decoded, orientation)
+  if err := encodeImage(&buf, decoded, format); err != nil {
+    return nil, nil, err
+  }
+  return buf.Bytes(), decoded, nil
+}
+
+// gifFrameCount counts the frames of a GIF image, without decoding them.
+func gifFrameCount(data []byte) (int, error) {
+  errInvalid := errors.New("Invalid GIF image")
+  // Header and logical screen descriptor
+  if len(data) < 13 {
+    return 0, errInvalid
+  }
+  pos := 13
+  if flags := data[10]; flags & 0x80 != 0 {
+    pos += 3 << (flags & 0x07 + 1)
+  }
+  // skipSubBlocks skips data sub-blocks, up to the block terminator.
+  skipSubBlocks := func() bool {
+    for pos < len(data) {
+      size := int(data[pos])
+      pos += size + 1
+      if size == 0 {
+        return true
+      }
+    }
+    return false
+  }
+
+  frames := 0
+  for pos < len(data) {
+    switch data[pos] {
+    case 0x21:
+      // Extension: introducer, label and sub-blocks
+      pos += 2
+      if !skipSubBlocks() {
+        return 0, errInvalid
+      }
+    case 0x2C:
+      // Image descriptor, local color table, LZW code size and sub-blocks
+      if pos + 10 > len(data) {
+        return 0, errInvalid
+      }
+      if flags := data[pos + 9]; flags & 0x80 != 0 {
+        pos += 3 << (flags & 0x07 + 1)
+      }
+      pos += 11
+      if !skipSubBlocks() {
+        return 0, errInvalid
+      }
+      frames++
+    case 0x3B:
+      return frames, nil
+    default:
+      return 0, errInvalid
+    }
+  }
+  // Truncated GIFs without trailer are decoded anyway
+  return frames, nil
+}
+
+// jpegOrientation returns the EXIF orientation (1 to 8) of a JPEG image.
+// It returns 1 if the image doesn't have a valid orientation.
+func jpegOrientation(data []byte) int {
+  pos := 2
+  for pos + 4 <= len(data) && data[pos] == 0xFF {
+    marker := data[pos + 1]
+    size := int(binary.BigEndian.Uint16(data[pos + 2:]))
+    // The metadata segments are before the start of scan
+    if marker == 0xDA || size < 2 || pos + 2 + size > len(data) {
+      break
+    }
+    segment := data[pos + 4:pos + 2 + size]
+    if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+      return exifOrientation(segment[6:])
+    }
+    pos += 2 + size
+  }
+  return 1
+}
+
+// exifOrientation returns the orientation tag of the first IFD of EXIF
+// data, or 1 if not found.
+func exifOrientation(tiff []byte) int {
+  var order binary.ByteOrder
+  switch {
+  case bytes.HasPrefix(tiff, []byte("II*\x00")):
+    order = binary.LittleEndian
+  case bytes.HasPrefix(tiff, []byte("MM\x00*")):
+    order = binary.BigEndian
+  default:
+    return 1
+  }
+  if len(tiff) < 8 {
+    return 1
+  }
+  ifd := int64(order.Uint32(tiff[4:]))
+  if ifd + 2 > int64(len(tiff)) {
+    return 1
+  }
+  entries := int(order.Uint16(tiff[ifd:]))
+  for n := 0; n < entries; n++ {
+    entry := ifd + 2 + int64(n) * 12
+    if entry + 12 > int64(len(tiff)) {
+      return 1
+    }
+    // Orientation is a SHORT, stored at the start of the value
+    if order.Uint16(tiff[entry:]) == 0x0112 &&
+       order.Uint16(tiff[entry + 2:]) == 3 {
+      if o := int(order.Uint16(tiff[entry + 8:])); o >= 1 && o <= 8 {
+        return o
+      }
+      return 1
+    }
+  }
+  return 1
+}
+
+// orientImage transforms an image according to an EXIF orientation, so it
+// is displayed upright.
+func orientImage(img image.Image, orientation int) image.Image {
+  if orientation <= 1 || orientation > 8 {
+    return img
+  }
+  b := img.Bounds()
+  w, h := b.Dx(), b.Dy()
+  dw, dh := w, h
+  if orientation >= 5 {
+    dw, dh = h, w
+  }
+  dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+  for y := 0; y < h; y++ {
+    for x := 0; x < w; x++ {
+      var dx, dy int
+      switch orientation {
+      case 2:
+        dx, dy = w - 1 - x, y
+      case 3:
+        dx, dy = w - 1 - x, h - 1 - y
+      case 4:
+        dx, dy = x, h - 1 - y
+      case 5:
+        dx, dy = y, x
+      case 6:
+        dx, dy = h - 1 - y, x
+      case 7:
+        dx, dy = h - 1 - y, w - 1 - x
+      case 8:
+        dx, dy = y, w - 1 - x
+      }
+      dst.Set(dx, dy, img.At(b.Min.X + x, b.Min.Y + y))
+    }
+  }
+  return dst
+}
+
+// encodeImage encodes an image with the given format. GIFs are encoded as
+// PNGs, as thumbnails are not animated.
+func encodeImage(w io.Writer, img image.Image, format string) error {
+  if format == "jpeg" {
+    return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+  }
+  return png.Encode(w, img)
+}
+
+// ThumbnailKey returns the storage key of the thumbnail of the given size
+// of an image (eg. "models/box/thumb.png" and 256 give
+// "models/box/thumb_256.png").
+func ThumbnailKey(key string, size int, format string) string {
+  ext := ".png"
+  if format == "jpeg" {
+    ext = ".jpg"
+  }
+  base := strings.TrimSuffix(key, path.Ext(key))
+  return base + "_" + strconv.Itoa(size) + ext
+}
+
+// Thumbnails resizes the image to the ThumbnailSizes and stores the
+// thumbnails. Images are not enlarged. The image key is the key of the
+// stored image.
+func (i *Images) Thumbnails(ctx context.Context, key string,
+                            img *Image) ([]Thumbnail, error) {
+  return i.thumbnails(ctx, key, img, func(int) {})
+}
+
+// thumbnails generates the thumbnails, reporting the progress.
+func (i *Images) thumbnails(ctx context.Context, key string, img *Image,
+                            progress func(int)) ([]Thumbnail, error) {
+  if i.Storage == nil {
+    return nil, errors.New("Images without Storage")
+  }
+  if img.decoded == nil {
+    return nil, errors.New("Image not validated")
+  }
+  sizes := i.ThumbnailSizes
+  if len(sizes) == 0 {
+    sizes = []int{256}
+  }
+
+  thumbs := make([]Thumbnail, 0, len(sizes))
+  for n, size := range sizes {
+    if err := ctx.Err(); err != nil {
+      return nil, err
+    }
+    resized := resizeImage(img.decoded, size)
+    var buf bytes.Buffer
+    if err := encodeImage(&buf, resized, img.Format); err != nil {
+      return nil, err
+    }
+    thumb := Thumbnail{
+      Key: ThumbnailKey(key, size, img.Format),
+      Width: resized.Bounds().Dx(),
+      Height: resized.Bounds().Dy(),
+    }
+    if err := i.Storage.Put(ctx, thumb.Key, &buf); err != nil {
+      return nil, err
+    }
+    thumbs = append(thumbs, thumb)
+    progress((n + 1) * 100 / len(sizes))
+  }
+  return thumbs, nil
+}
+
+// StartThumbnails generates the thumbnails of an image as an async
+// operation of kind "thumbnails", whose result is the list of Thumbnails.
+func (i *Images) StartThumbnails(r *http.Request, key string,
+                                 img *Image) (*Operation, error) {
+  ops := i.Operations
+  if ops == nil && gServer != nil {
+    ops = gServer.Operations
+  }
+  if ops == nil {
+    return nil, ErrOperationsDisabled
+  }
+  return ops.Start(r, "thumbnails", func(ctx context.Context,
+                                         progress func(int)) (interface{}, error) {
+    return i.thumbnails(ctx, key, img, progress)
+  })
+}
+
+// resizeImage scales an image to fit in a square of the given size, keeping
+// its aspect ratio. Smaller images are returned as they are.
+func resizeImage(img image.Image, size int) image.Image {
+  b := img.Bounds()
+  w, h := b.Dx(), b.Dy()
+  if w <= size && h <= size {
+    return img
+  }
+  if w >= h {
+    h = h * size / w
+    w = size
+  } else {
+    w = w * size / h
+    h = size
+  }
+  if w < 1 {
+    w = 1
+  }
+  if h < 1 {
+    h = 1
+  }
+  dst := image.NewRGBA(image.Rect(0, 0, w, h))
+  draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+  return dst
+}
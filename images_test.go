@@ -0,0 +1,199 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "image"
+  "image/color"
+  "image/gif"
+  "image/jpeg"
+  "image/png"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "testing"
+)
+
+// newJPEGWithEXIF encodes a JPEG image with an EXIF segment.
+func newJPEGWithEXIF(w, h int) []byte {
+  return newJPEGWithSegment(w, h, []byte("Exif\x00\x00GPS 51.5N 0.1W"))
+}
+
+// newJPEGWithOrientation encodes a JPEG image with an EXIF orientation.
+func newJPEGWithOrientation(w, h int, orientation byte) []byte {
+  exif := []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08" +
+                 // One entry: orientation (0x0112), SHORT, count 1
+                 "\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01")
+  exif = append(exif, 0, orientation, 0, 0, 0, 0, 0, 0)
+  return newJPEGWithSegment(w, h, exif)
+}
+
+// newJPEGWithSegment encodes a JPEG image with the given APP1 segment. The
+// top left pixel is white.
+func newJPEGWithSegment(w, h int, exif []byte) []byte {
+  img := image.NewRGBA(image.Rect(0, 0, w, h))
+  for x := 0; x < w; x++ {
+    img.Set(x, x % h, color.RGBA{255, 0, 0, 255})
+  }
+  img.Set(0, 0, color.White)
+  var buf bytes.Buffer
+  jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+  data := buf.Bytes()
+  segment := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+  // The APP1 segment goes right after the SOI marker
+  return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+// TestImagesValidate tests validating images and stripping their metadata.
+func TestImagesValidate(t *testing.T) {
+  images := NewImages(nil)
+  data := newJPEGWithEXIF(40, 20)
+  img, em := images.Validate(bytes.NewReader(data))
+  if em != nil {
+    t.Fatal("Unable to validate the image:", em)
+  }
+  if img.Format != "jpeg" || img.Width != 40 || img.Height != 20 ||
+     bytes.Contains(img.Data, []byte("Exif")) {
+    t.Fatal("The EXIF data should be stripped:", img.Format, img.Width,
+            img.Height)
+  }
+
+  images.MaxWidth = 32
+  if _, em := images.Validate(bytes.NewReader(data)); em == nil ||
+     em.ErrCode != ErrorInvalidImage {
+    t.Fatal("Large dimensions should be rejected:", em)
+  }
+  images.MaxWidth = 0
+  images.MaxSize = 64
+  if _, em := images.Validate(bytes.NewReader(data)); em == nil ||
+     em.ErrCode != ErrorPayloadTooLarge {
+    t.Fatal("Large files should be rejected:", em)
+  }
+  images.MaxSize = 0
+  images.Formats = []string{"png"}
+  if _, em := images.Validate(bytes.NewReader(data)); em == nil ||
+     em.ErrCode != ErrorUnsupportedMediaType {
+    t.Fatal("Unaccepted formats should be rejected:", em)
+  }
+  if _, em := images.Validate(bytes.NewReader([]byte("<svg/>"))); em == nil ||
+     em.ErrCode != ErrorInvalidImage {
+    t.Fatal("Invalid images should be rejected:", em)
+  }
+}
+
+// TestImagesOrientation tests applying the EXIF orientation of JPEG images.
+func TestImagesOrientation(t *testing.T) {
+  images := NewImages(nil)
+  // Orientation 6 is displayed rotated 90 degrees clockwise
+  img, em := images.Validate(bytes.NewReader(newJPEGWithOrientation(40, 20, 6)))
+  if em != nil {
+    t.Fatal("Unable to validate the image:", em)
+  }
+  if img.Width != 20 || img.Height != 40 {
+    t.Fatal("The orientation should be applied:", img.Width, img.Height)
+  }
+  decoded, err := jpeg.Decode(bytes.NewReader(img.Data))
+  if err != nil || decoded.Bounds().Dx() != 20 {
+    t.Fatal("Unexpected encoded image:", err)
+  }
+  // The top left pixel ends at the top right
+  if r, _, _, _ := decoded.At(19, 0).RGBA(); r < 0xE000 {
+    t.Fatal("The pixels should be rotated")
+  }
+  if orientation := jpegOrientation(newJPEGWithEXIF(4, 4)); orientation != 1 {
+    t.Fatal("Images without orientation should be upright:", orientation)
+  }
+}
+
+// TestImagesGIFFrames tests limiting the pixels of animated GIFs.
+func TestImagesGIFFrames(t *testing.T) {
+  newGIF := func(frames int) []byte {
+    g := &gif.GIF{}
+    for n := 0; n < frames; n++ {
+      g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 40, 20),
+                                                  color.Palette{color.Black}))
+      g.Delay = append(g.Delay, 10)
+    }
+    var buf bytes.Buffer
+    gif.EncodeAll(&buf, g)
+    return buf.Bytes()
+  }
+  if frames, err := gifFrameCount(newGIF(3)); err != nil || frames != 3 {
+    t.Fatal("Unexpected number of frames:", frames, err)
+  }
+
+  images := NewImages(nil)
+  images.MaxPixels = 2 * 40 * 20
+  if _, em := images.Validate(bytes.NewReader(newGIF(2))); em != nil {
+    t.Fatal("Unable to validate the animated image:", em)
+  }
+  if _, em := images.Validate(bytes.NewReader(newGIF(3))); em == nil ||
+     em.ErrCode != ErrorInvalidImage {
+    t.Fatal("Too many frames should be rejected:", em)
+  }
+}
+
+// TestImagesThumbnails tests generating thumbnails, synchronously and as an
+// async operation.
+func TestImagesThumbnails(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "images")
+  defer os.RemoveAll(dir)
+  storage, _ := NewLocalStorage(dir)
+  images := NewImages(storage)
+  images.ThumbnailSizes = []int{16, 64}
+
+  var buf bytes.Buffer
+  png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+  img, em := images.Validate(&buf)
+  if em != nil {
+    t.Fatal("Unable to validate the image:", em)
+  }
+  thumbs, err := images.Thumbnails(context.Background(), "models/box/thumb.png",
+                                   img)
+  if err != nil || len(thumbs) != 2 ||
+     thumbs[0] != (Thumbnail{"models/box/thumb_16.png", 16, 8}) ||
+     thumbs[1] != (Thumbnail{"models/box/thumb_64.png", 40, 20}) {
+    t.Fatal("Unexpected thumbnails:", thumbs, err)
+  }
+  r, err := storage.Get(context.Background(), thumbs[0].Key)
+  if err != nil {
+    t.Fatal("The thumbnail should be stored:", err)
+  }
+  cfg, format, err := image.DecodeConfig(r)
+  r.Close()
+  if err != nil || format != "png" || cfg.Width != 16 {
+    t.Fatal("Unexpected thumbnail:", format, cfg, err)
+  }
+
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+  req, _ := http.NewRequest("POST", "/models", nil)
+  if _, err := images.StartThumbnails(req, "x.png", img); err !=
+     ErrOperationsDisabled {
+    t.Fatal("Thumbnails can't be async without operations:", err)
+  }
+
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  sqlDB(db).SetMaxOpenConns(1)
+  ops, err := NewOperations(db)
+  if err != nil {
+    t.Fatal("Unable to create the operations:", err)
+  }
+  images.Operations = ops
+  op, err := images.StartThumbnails(req, "models/sphere/thumb.png", img)
+  if err != nil {
+    t.Fatal("Unable to start the thumbnails:", err)
+  }
+  ops.Close()
+  op, err = ops.Get(context.Background(), op.ID)
+  if err != nil || op.Status != OperationDone || op.Kind != "thumbnails" {
+    t.Fatal("Unexpected operation:", op, err)
+  }
+  r, err = storage.Get(context.Background(), "models/sphere/thumb_16.png")
+  if err != nil {
+    t.Fatal("The async thumbnails should be stored:", err)
+  }
+  r.Close()
+}
@@ -0,0 +1,24 @@
+package ign
+
+import (
+  "encoding/json"
+  "net/http"
+)
+
+// routesIndexHandler returns a handler that lists the given routes as JSON,
+// including their descriptions, methods and whether they require
+// authentication (SecureMethods). It is the aggregated version of the
+// OPTIONS response of each route.
+func routesIndexHandler(routes Routes) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    output, err := json.Marshal(routes)
+    if err != nil {
+      em := NewErrorMessageWithBase(ErrorMarshalJSON, err)
+      reportJSONError(w, r, *em)
+      return
+    }
+    addCORSheaders(w)
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(output)
+  })
+}
@@ -0,0 +1,193 @@
+package ign
+
+import (
+  "crypto/rsa"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "math/big"
+  "net/http"
+  "sync"
+  "time"
+  "golang.org/x/sync/singleflight"
+)
+
+// Tokens can be validated using the keys published by Auth0 in a JSON Web
+// Key Set (JWKS) endpoint, instead of a static public key. Keys are selected
+// using the "kid" header of the token, and cached. The key set is fetched
+// again when a token uses an unknown key, so key rotations don't require a
+// redeploy.
+
+// jwksMinRefreshInterval is the min time between two fetches of the key set.
+// It prevents tokens with unknown keys from flooding the JWKS endpoint.
+// Within the interval, each unknown key id can still force one fetch, up to
+// jwksMaxForcedRefreshes, so a rotation right after a fetch is not missed.
+const jwksMinRefreshInterval = time.Minute
+
+// jwksMaxForcedRefreshes is the max number of fetches forced by unknown key
+// ids within a jwksMinRefreshInterval.
+const jwksMaxForcedRefreshes = 10
+
+// jwksKeys is the key set used to validate tokens. If nil, pemKeyString is
+// used.
+var jwksKeys *jwksCache
+
+// jwksCache caches the keys of a JWKS endpoint.
+type jwksCache struct {
+  url string
+  client *HTTPClient
+  // group makes concurrent requests share a single fetch.
+  group singleflight.Group
+  mutex sync.Mutex
+  keys map[string]*rsa.PublicKey
+  lastFetch time.Time
+  // forced are the key ids that requested a fetch since lastFetch.
+  forced map[string]bool
+  // fetching is the number of requests waiting for a fetch.
+  fetching int
+  // fetches is the number of completed fetches.
+  fetches int
+}
+
+// jsonWebKey is a key of a JWKS document. Only RSA keys are supported.
+type jsonWebKey struct {
+  Kid string `json:"kid"`
+  Kty string `json:"kty"`
+  N string `json:"n"`
+  E string `json:"e"`
+}
+
+// SetAuth0JWKSURL configures the server to validate tokens using the keys
+// found at the given JWKS URL (eg.
+// https://<tenant>.auth0.com/.well-known/jwks.json). An empty url restores
+// the use of the RSA public key.
+func (s *Server) SetAuth0JWKSURL(url string) {
+  s.Auth0JWKSURL = url
+  gTokenCache.clear()
+  if url == "" {
+    jwksKeys = nil
+    return
+  }
+  jwksKeys = &jwksCache{
+    url: url,
+    client: NewHTTPClient(),
+    keys: map[string]*rsa.PublicKey{},
+  }
+}
+
+// key returns the key with the given id, fetching the key set if the key is
+// unknown. The key set is fetched without holding the lock, so requests with
+// known keys are not blocked by a slow JWKS endpoint.
+func (c *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+  c.mutex.Lock()
+  if key, ok := c.keys[kid]; ok {
+    c.mutex.Unlock()
+    return key, nil
+  }
+  // Requests join the ongoing fetch, if any
+  refresh := c.fetching > 0 || c.canRefresh(kid)
+  if refresh {
+    c.fetching++
+  }
+  fetches := c.fetches
+  c.mutex.Unlock()
+
+  if refresh {
+    _, err, _ := c.group.Do(c.url, func() (interface{}, error) {
+      // Skip the fetch if another one ended meanwhile
+      c.mutex.Lock()
+      done := c.fetches != fetches
+      c.mutex.Unlock()
+      if done {
+        return nil, nil
+      }
+      keys, err := c.fetch()
+      if err != nil {
+        gLogger.Error("Unable to fetch the JWKS keys", Fields{"error": err,
+                                                             "url": c.url})
+        return nil, err
+      }
+      c.mutex.Lock()
+      c.keys = keys
+      c.fetches++
+      c.mutex.Unlock()
+      return nil, nil
+    })
+    c.mutex.Lock()
+    c.fetching--
+    c.mutex.Unlock()
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  if key, ok := c.keys[kid]; ok {
+    return key, nil
+  }
+  return nil, errors.New("Unknown JWT key id: " + kid)
+}
+
+// canRefresh returns true if the given unknown key id can fetch the key set.
+// It must be called with the lock held.
+func (c *jwksCache) canRefresh(kid string) bool {
+  if clock().Now().Sub(c.lastFetch) >= jwksMinRefreshInterval {
+    c.lastFetch = clock().Now()
+    c.forced = map[string]bool{kid: true}
+    return true
+  }
+  if c.forced[kid] || len(c.forced) >= jwksMaxForcedRefreshes {
+    return false
+  }
+  c.forced[kid] = true
+  return true
+}
+
+// fetch downloads the key set.
+func (c *jwksCache) fetch() (map[string]*rsa.PublicKey, error) {
+  resp, err := c.client.Get(c.url)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return nil, errors.New("JWKS request failed: " + resp.Status)
+  }
+
+  var doc struct {
+    Keys []jsonWebKey `json:"keys"`
+  }
+  if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+    return nil, err
+  }
+
+  keys := map[string]*rsa.PublicKey{}
+  for _, k := range doc.Keys {
+    if k.Kty != "RSA" {
+      continue
+    }
+    key, err := k.rsaPublicKey()
+    if err != nil {
+      return nil, err
+    }
+    keys[k.Kid] = key
+  }
+  return keys, nil
+}
+
+// rsaPublicKey decodes the modulus and exponent of an RSA key.
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+  n, err := base64.RawURLEncoding.DecodeString(k.N)
+  if err != nil {
+    return nil, err
+  }
+  e, err := base64.RawURLEncoding.DecodeString(k.E)
+  if err != nil {
+    return nil, err
+  }
+  return &rsa.PublicKey{
+    N: new(big.Int).SetBytes(n),
+    E: int(new(big.Int).SetBytes(e).Int64()),
+  }, nil
+}
@@ -0,0 +1,105 @@
+package ign
+
+import (
+  "crypto/rand"
+  "crypto/rsa"
+  "encoding/base64"
+  "encoding/json"
+  "math/big"
+  "net/http"
+  "net/http/httptest"
+  "sync"
+  "sync/atomic"
+  "testing"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// TestJWKS tests validating tokens with keys from a JWKS endpoint, including
+// key rotation.
+func TestJWKS(t *testing.T) {
+  prev := jwksKeys
+  defer func() { jwksKeys = prev }()
+
+  key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+  key2, _ := rsa.GenerateKey(rand.Reader, 2048)
+  var mutex sync.Mutex
+  published := map[string]*rsa.PrivateKey{"k1": key1}
+  publish := func(keys map[string]*rsa.PrivateKey) {
+    mutex.Lock()
+    published = keys
+    mutex.Unlock()
+  }
+  var fetches int32
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt32(&fetches, 1)
+    mutex.Lock()
+    defer mutex.Unlock()
+    var keys []jsonWebKey
+    for kid, k := range published {
+      keys = append(keys, jsonWebKey{
+        Kid: kid,
+        Kty: "RSA",
+        N: base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+        E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+      })
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+  }))
+  defer server.Close()
+
+  s := &Server{}
+  s.SetAuth0JWKSURL(server.URL)
+
+  validate := func(kid string, key *rsa.PrivateKey) error {
+    token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "alice"})
+    token.Header["kid"] = kid
+    signed, _ := token.SignedString(key)
+    _, err := jwt.Parse(signed, jwtValidationKey)
+    return err
+  }
+
+  if err := validate("k1", key1); err != nil {
+    t.Fatal("Valid token was rejected:", err)
+  }
+  if err := validate("k1", key2); err == nil {
+    t.Fatal("Token signed with a different key was accepted")
+  }
+
+  // Rotate keys. Unknown keys force a new fetch, even right after the last
+  // one.
+  publish(map[string]*rsa.PrivateKey{"k2": key2})
+  if err := validate("k2", key2); err != nil {
+    t.Fatal("Token signed with the rotated key was rejected:", err)
+  }
+  if err := validate("k3", key2); err == nil ||
+     atomic.LoadInt32(&fetches) != 3 {
+    t.Fatal("Unknown keys should force one fetch:", fetches)
+  }
+  if err := validate("k3", key2); err == nil ||
+     atomic.LoadInt32(&fetches) != 3 {
+    t.Fatal("Unknown keys should not fetch the key set again so soon:",
+            fetches)
+  }
+
+  // Concurrent requests with a new key share a single fetch
+  publish(map[string]*rsa.PrivateKey{"k2": key2, "k4": key1})
+  var wg sync.WaitGroup
+  errs := make(chan error, 10)
+  for i := 0; i < 10; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      errs <- validate("k4", key1)
+    }()
+  }
+  wg.Wait()
+  close(errs)
+  for err := range errs {
+    if err != nil {
+      t.Fatal("Token signed with the new key was rejected:", err)
+    }
+  }
+  if n := atomic.LoadInt32(&fetches); n != 4 {
+    t.Fatal("Concurrent requests should share a fetch:", n)
+  }
+}
@@ -0,0 +1,73 @@
+// Package logadapter provides ign.Logger implementations backed by popular
+// logging libraries. Use them with Server.SetLogger.
+package logadapter
+
+import (
+  "bitbucket.org/ignitionrobotics/ign-go"
+  "github.com/rs/zerolog"
+  "github.com/sirupsen/logrus"
+)
+
+/////////////////////////////////////////////////
+
+// Logrus is an ign.Logger that writes entries using logrus.
+type Logrus struct {
+  logger logrus.FieldLogger
+}
+
+// NewLogrus creates an ign.Logger backed by the given logrus logger.
+func NewLogrus(l logrus.FieldLogger) *Logrus {
+  return &Logrus{logger: l}
+}
+
+// Debug is part of the ign.Logger interface.
+func (l *Logrus) Debug(msg string, fields ign.Fields) {
+  l.logger.WithFields(logrus.Fields(fields)).Debug(msg)
+}
+
+// Info is part of the ign.Logger interface.
+func (l *Logrus) Info(msg string, fields ign.Fields) {
+  l.logger.WithFields(logrus.Fields(fields)).Info(msg)
+}
+
+// Warn is part of the ign.Logger interface.
+func (l *Logrus) Warn(msg string, fields ign.Fields) {
+  l.logger.WithFields(logrus.Fields(fields)).Warn(msg)
+}
+
+// Error is part of the ign.Logger interface.
+func (l *Logrus) Error(msg string, fields ign.Fields) {
+  l.logger.WithFields(logrus.Fields(fields)).Error(msg)
+}
+
+/////////////////////////////////////////////////
+
+// Zerolog is an ign.Logger that writes entries using zerolog.
+type Zerolog struct {
+  logger zerolog.Logger
+}
+
+// NewZerolog creates an ign.Logger backed by the given zerolog logger.
+func NewZerolog(l zerolog.Logger) *Zerolog {
+  return &Zerolog{logger: l}
+}
+
+// Debug is part of the ign.Logger interface.
+func (l *Zerolog) Debug(msg string, fields ign.Fields) {
+  l.logger.Debug().Fields(map[string]interface{}(fields)).Msg(msg)
+}
+
+// Info is part of the ign.Logger interface.
+func (l *Zerolog) Info(msg string, fields ign.Fields) {
+  l.logger.Info().Fields(map[string]interface{}(fields)).Msg(msg)
+}
+
+// Warn is part of the ign.Logger interface.
+func (l *Zerolog) Warn(msg string, fields ign.Fields) {
+  l.logger.Warn().Fields(map[string]interface{}(fields)).Msg(msg)
+}
+
+// Error is part of the ign.Logger interface.
+func (l *Zerolog) Error(msg string, fields ign.Fields) {
+  l.logger.Error().Fields(map[string]interface{}(fields)).Msg(msg)
+}
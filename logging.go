@@ -0,0 +1,141 @@
+package ign
+
+import (
+  "fmt"
+  "log"
+  "sort"
+  "strings"
+  "sync/atomic"
+)
+
+// Logging in this package is done through the Logger interface. By default
+// logs are written using the standard "log" package. Applications can
+// inject their own logger using Server.SetLogger (see the logadapter
+// package for logrus and zerolog adapters).
+
+// Fields are structured key/value pairs attached to a log entry
+// (eg. route, method, status, duration).
+type Fields map[string]interface{}
+
+// Logger is the interface used by this package to output logs.
+type Logger interface {
+  Debug(msg string, fields Fields)
+  Info(msg string, fields Fields)
+  Warn(msg string, fields Fields)
+  Error(msg string, fields Fields)
+}
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+// Log levels, in increasing severity.
+const (
+  LogLevelDebug LogLevel = iota
+  LogLevelInfo
+  LogLevelWarn
+  LogLevelError
+)
+
+// String returns the name of the log level.
+func (l LogLevel) String() string {
+  switch l {
+  case LogLevelDebug:
+    return "DEBUG"
+  case LogLevelInfo:
+    return "INFO"
+  case LogLevelWarn:
+    return "WARN"
+  case LogLevelError:
+    return "ERROR"
+  }
+  return "UNKNOWN"
+}
+
+// ParseLogLevel parses a log level name (debug, info, warn or error).
+func ParseLogLevel(name string) (LogLevel, error) {
+  for l := LogLevelDebug; l <= LogLevelError; l++ {
+    if strings.EqualFold(name, l.String()) {
+      return l, nil
+    }
+  }
+  return LogLevelInfo, fmt.Errorf("Unknown log level [%s]", name)
+}
+
+// gLogger is the logger used by this package.
+var gLogger Logger = NewStdLogger(LogLevelDebug)
+
+// SetLogger sets the logger used by this package.
+func (s *Server) SetLogger(l Logger) {
+  gLogger = l
+}
+
+// Logger returns the logger used by this package. Applications can use it
+// to log in the same format as the server.
+func (s *Server) Logger() Logger {
+  return gLogger
+}
+
+/////////////////////////////////////////////////
+
+// StdLogger is a Logger that writes entries using the standard "log"
+// package, in the form: [LEVEL] message key1=value1 key2=value2
+type StdLogger struct {
+  // Entries below this level are discarded. It is accessed atomically, as
+  // it can be changed while logging (see the admin routes).
+  level int32
+}
+
+// NewStdLogger creates a StdLogger with the given minimum level.
+func NewStdLogger(level LogLevel) *StdLogger {
+  return &StdLogger{level: int32(level)}
+}
+
+// Level returns the minimum level of the logged entries.
+func (l *StdLogger) Level() LogLevel {
+  return LogLevel(atomic.LoadInt32(&l.level))
+}
+
+// SetLevel changes the minimum level of the logged entries. It can be
+// called while logging.
+func (l *StdLogger) SetLevel(level LogLevel) {
+  atomic.StoreInt32(&l.level, int32(level))
+}
+
+// Debug is part of the Logger interface.
+func (l *StdLogger) Debug(msg string, fields Fields) {
+  l.output(LogLevelDebug, msg, fields)
+}
+
+// Info is part of the Logger interface.
+func (l *StdLogger) Info(msg string, fields Fields) {
+  l.output(LogLevelInfo, msg, fields)
+}
+
+// Warn is part of the Logger interface.
+func (l *StdLogger) Warn(msg string, fields Fields) {
+  l.output(LogLevelWarn, msg, fields)
+}
+
+// Error is part of the Logger interface.
+func (l *StdLogger) Error(msg string, fields Fields) {
+  l.output(LogLevelError, msg, fields)
+}
+
+// output writes a log entry. Fields are sorted by key to produce stable
+// output.
+func (l *StdLogger) output(level LogLevel, msg string, fields Fields) {
+  if level < l.Level() {
+    return
+  }
+  keys := make([]string, 0, len(fields))
+  for k := range fields {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+
+  entry := "[" + level.String() + "] " + msg
+  for _, k := range keys {
+    entry += fmt.Sprintf(" %s=%v", k, fields[k])
+  }
+  log.Println(entry)
+}
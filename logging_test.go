@@ -0,0 +1,73 @@
+package ign
+
+import (
+  "bytes"
+  "log"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "strings"
+  "testing"
+)
+
+// Tests for the logging subsystem
+
+// TestStdLogger tests the StdLogger format and level filtering.
+func TestStdLogger(t *testing.T) {
+  var buf bytes.Buffer
+  log.SetOutput(&buf)
+  defer log.SetOutput(os.Stderr)
+
+  l := NewStdLogger(LogLevelInfo)
+  l.Debug("hidden", nil)
+  l.Warn("Request", Fields{"route": "models", "method": "GET", "status": 200})
+
+  got := strings.TrimSpace(buf.String())
+  exp := "[WARN] Request method=GET route=models status=200"
+  if !strings.HasSuffix(got, exp) || strings.Contains(got, "hidden") {
+    t.Fatal("Unexpected log output. Exp:", exp, "Got:", got)
+  }
+}
+
+// TestParseLogLevel tests parsing log level names.
+func TestParseLogLevel(t *testing.T) {
+  if l, err := ParseLogLevel("warn"); err != nil || l != LogLevelWarn {
+    t.Fatal("Unexpected log level", l, err)
+  }
+  if _, err := ParseLogLevel("verbose"); err == nil {
+    t.Fatal("Expected an error with an unknown log level")
+  }
+}
+
+// TestAccessLog tests the access log decorator formats.
+func TestAccessLog(t *testing.T) {
+  var buf bytes.Buffer
+  log.SetOutput(&buf)
+  defer log.SetOutput(os.Stderr)
+
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+
+  handler := logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.WriteHeader(http.StatusCreated)
+    w.Write([]byte("12345"))
+  }), "modelCreate")
+
+  formats := map[string]string{
+    AccessLogText: "POST\t/1.0/models\tmodelCreate\t201\t5\t",
+    AccessLogJSON: `{"method":"POST","uri":"/1.0/models","route":"modelCreate","status":201,"size":5,`,
+    AccessLogFields: "method=POST route=modelCreate size=5 status=201 uri=/1.0/models",
+  }
+  for format, exp := range formats {
+    buf.Reset()
+    gServer.AccessLogFormat = format
+    req, _ := http.NewRequest("POST", "/1.0/models", nil)
+    req.RequestURI = "/1.0/models"
+    handler.ServeHTTP(httptest.NewRecorder(), req)
+    if !strings.Contains(buf.String(), exp) {
+      t.Fatal("Unexpected access log. Format:", format, "Exp:", exp,
+              "Got:", buf.String())
+    }
+  }
+}
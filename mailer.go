@@ -0,0 +1,399 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "crypto/tls"
+  "errors"
+  "fmt"
+  "html/template"
+  "mime"
+  "mime/multipart"
+  "mime/quotedprintable"
+  "net"
+  "net/smtp"
+  "net/textproto"
+  "strings"
+  "sync"
+  "time"
+  "github.com/aws/aws-sdk-go-v2/aws"
+  "github.com/aws/aws-sdk-go-v2/config"
+  "github.com/aws/aws-sdk-go-v2/service/sesv2"
+  "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// Email is a message sent by a Mailer.
+type Email struct {
+  // From address. Defaults to the Mailer From.
+  From string
+  To []string
+  Subject string
+  // HTML body.
+  HTML string
+  // Optional plain text body.
+  Text string
+}
+
+// MailBackend is the interface of the services used to deliver emails.
+type MailBackend interface {
+  Send(ctx context.Context, email Email) error
+}
+
+// ErrMailQueueFull is returned by Mailer.Enqueue when the send queue is
+// full.
+var ErrMailQueueFull = errors.New("Mail queue is full")
+
+// ErrMailerClosed is returned by Mailer.Enqueue after Close.
+var ErrMailerClosed = errors.New("Mailer is closed")
+
+// mailMaxAttempts is the number of attempts of queued emails.
+const mailMaxAttempts = 3
+
+// mailSendTimeout is the max duration of each attempt of queued emails.
+const mailSendTimeout = time.Minute
+
+// Mailer sends emails, rendered from HTML templates, using a MailBackend.
+// Emails can be sent synchronously with Send, or queued with Enqueue and
+// SendTemplate to be sent by a background worker.
+type Mailer struct {
+  // Backend used to deliver the emails.
+  Backend MailBackend
+  // From is the default sender address.
+  From string
+  // Templates used by SendTemplate. See LoadTemplates.
+  Templates *template.Template
+  // RetryDelay is the delay between attempts of queued emails.
+  RetryDelay time.Duration
+
+  queue chan Email
+  done sync.WaitGroup
+  // mu guards closed, so that Enqueue never sends to the closed queue.
+  mu sync.RWMutex
+  closed bool
+}
+
+// NewMailer creates a Mailer, and starts its worker. queueSize is the max
+// number of queued emails.
+func NewMailer(backend MailBackend, from string, queueSize int) *Mailer {
+  m := &Mailer{
+    Backend: backend,
+    From: from,
+    RetryDelay: 5 * time.Second,
+    queue: make(chan Email, queueSize),
+  }
+  m.done.Add(1)
+  go m.worker()
+  return m
+}
+
+// NewMailerFromEnvVars creates the Mailer selected with the
+// IGN_MAILER_PROVIDER env var. Supported values are "smtp" and "ses".
+// It returns nil if IGN_MAILER_PROVIDER is not set.
+func NewMailerFromEnvVars() (*Mailer, error) {
+  kind, err := ReadEnvVar("IGN_MAILER_PROVIDER")
+  if err != nil {
+    return nil, nil
+  }
+  from, err := ReadEnvVar("IGN_MAIL_FROM")
+  if err != nil {
+    return nil, err
+  }
+
+  var backend MailBackend
+  switch kind {
+  case "smtp":
+    var b SMTPBackend
+    if b.Addr, err = ReadEnvVar("IGN_SMTP_ADDR"); err != nil {
+      return nil, err
+    }
+    b.Username, _ = ReadEnvVar("IGN_SMTP_USERNAME")
+    b.Password, _ = ReadEnvVar("IGN_SMTP_PASSWORD")
+    backend = &b
+  case "ses":
+    if backend, err = NewSESBackend(); err != nil {
+      return nil, err
+    }
+  default:
+    return nil, errors.New("Unknown IGN_MAILER_PROVIDER [" + kind + "]")
+  }
+
+  // Load the templates before starting the worker of the mailer
+  var templates *template.Template
+  if pattern, err := ReadEnvVar("IGN_MAIL_TEMPLATES"); err == nil {
+    if templates, err = template.ParseGlob(pattern); err != nil {
+      return nil, err
+    }
+  }
+  m := NewMailer(backend, from, 100)
+  m.Templates = templates
+  return m, nil
+}
+
+// LoadTemplates parses the HTML templates matching the glob pattern (eg.
+// "templates/*.html"). Templates are named after their file name.
+func (m *Mailer) LoadTemplates(pattern string) error {
+  t, err := template.ParseGlob(pattern)
+  if err != nil {
+    return err
+  }
+  m.Templates = t
+  return nil
+}
+
+// Render executes the named template with the given data.
+func (m *Mailer) Render(name string, data interface{}) (string, error) {
+  if m.Templates == nil {
+    return "", errors.New("No mail templates loaded")
+  }
+  var buf bytes.Buffer
+  if err := m.Templates.ExecuteTemplate(&buf, name, data); err != nil {
+    return "", err
+  }
+  return buf.String(), nil
+}
+
+// Send delivers the email synchronously.
+func (m *Mailer) Send(ctx context.Context, email Email) error {
+  if email.From == "" {
+    email.From = m.From
+  }
+  return m.Backend.Send(ctx, email)
+}
+
+// Enqueue queues the email to be sent by the background worker. Failed
+// sends are retried, and logged. It returns ErrMailerClosed after Close.
+func (m *Mailer) Enqueue(email Email) error {
+  m.mu.RLock()
+  defer m.mu.RUnlock()
+  if m.closed {
+    return ErrMailerClosed
+  }
+  select {
+  case m.queue <- email:
+    return nil
+  default:
+    return ErrMailQueueFull
+  }
+}
+
+// SendTemplate renders the named template and queues the email.
+func (m *Mailer) SendTemplate(to []string, subject, name string,
+                              data interface{}) error {
+  html, err := m.Render(name, data)
+  if err != nil {
+    return err
+  }
+  return m.Enqueue(Email{To: to, Subject: subject, HTML: html})
+}
+
+// Close stops the worker after sending the queued emails.
+func (m *Mailer) Close() {
+  m.mu.Lock()
+  if !m.closed {
+    m.closed = true
+    close(m.queue)
+  }
+  m.mu.Unlock()
+  m.done.Wait()
+}
+
+// worker sends the queued emails.
+func (m *Mailer) worker() {
+  defer m.done.Done()
+  for email := range m.queue {
+    var err error
+    for attempt := 1; attempt <= mailMaxAttempts; attempt++ {
+      ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+      err = m.Send(ctx, email)
+      cancel()
+      if err == nil {
+        break
+      }
+      if attempt < mailMaxAttempts {
+        time.Sleep(m.RetryDelay)
+      }
+    }
+    if err != nil {
+      gLogger.Error("Unable to send email", Fields{"to": email.To,
+        "subject": email.Subject, "error": err})
+    }
+  }
+}
+
+/////////////////////////////////////////////////
+
+// SMTPBackend is a MailBackend that sends emails to an SMTP server.
+type SMTPBackend struct {
+  // Addr is the server address, in the form "host:port".
+  Addr string
+  // Username and Password enable PLAIN authentication, if set.
+  Username string
+  Password string
+}
+
+// Send sends the email as a MIME message, using STARTTLS if the server
+// supports it. The connection is closed if ctx is done.
+func (b *SMTPBackend) Send(ctx context.Context, email Email) error {
+  msg, err := mimeMessage(email)
+  if err != nil {
+    return err
+  }
+  var dialer net.Dialer
+  conn, err := dialer.DialContext(ctx, "tcp", b.Addr)
+  if err != nil {
+    return err
+  }
+  stop := make(chan struct{})
+  defer close(stop)
+  go func() {
+    select {
+    case <-ctx.Done():
+      conn.Close()
+    case <-stop:
+    }
+  }()
+
+  if err := b.send(conn, email, msg); err != nil {
+    if ctx.Err() != nil {
+      return ctx.Err()
+    }
+    return err
+  }
+  return nil
+}
+
+// send runs the SMTP commands that deliver msg through conn, and closes it.
+func (b *SMTPBackend) send(conn net.Conn, email Email, msg []byte) error {
+  host, _, _ := net.SplitHostPort(b.Addr)
+  c, err := smtp.NewClient(conn, host)
+  if err != nil {
+    conn.Close()
+    return err
+  }
+  defer c.Close()
+  if ok, _ := c.Extension("STARTTLS"); ok {
+    if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+      return err
+    }
+  }
+  if b.Username != "" {
+    if err := c.Auth(smtp.PlainAuth("", b.Username, b.Password, host)); err != nil {
+      return err
+    }
+  }
+  if err := c.Mail(email.From); err != nil {
+    return err
+  }
+  for _, to := range email.To {
+    if err := c.Rcpt(to); err != nil {
+      return err
+    }
+  }
+  w, err := c.Data()
+  if err != nil {
+    return err
+  }
+  if _, err := w.Write(msg); err != nil {
+    return err
+  }
+  if err := w.Close(); err != nil {
+    return err
+  }
+  return c.Quit()
+}
+
+// mimeMessage returns the email as a multipart/alternative MIME message. The
+// parts are quoted-printable encoded, so long lines are wrapped.
+func mimeMessage(email Email) ([]byte, error) {
+  // Reject header injections
+  if strings.ContainsAny(email.From + strings.Join(email.To, ""), "\r\n") {
+    return nil, errors.New("Invalid email address")
+  }
+  var body bytes.Buffer
+  mw := multipart.NewWriter(&body)
+  parts := []struct {
+    contentType string
+    content string
+  }{
+    {"text/plain", email.Text},
+    {"text/html", email.HTML},
+  }
+  for _, p := range parts {
+    if p.content == "" {
+      continue
+    }
+    w, err := mw.CreatePart(textproto.MIMEHeader{
+      "Content-Type": {p.contentType + "; charset=UTF-8"},
+      "Content-Transfer-Encoding": {"quoted-printable"},
+    })
+    if err != nil {
+      return nil, err
+    }
+    qw := quotedprintable.NewWriter(w)
+    if _, err := qw.Write([]byte(p.content)); err != nil {
+      return nil, err
+    }
+    if err := qw.Close(); err != nil {
+      return nil, err
+    }
+  }
+  if err := mw.Close(); err != nil {
+    return nil, err
+  }
+
+  var msg bytes.Buffer
+  fmt.Fprintf(&msg, "From: %s\r\n", email.From)
+  fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(email.To, ", "))
+  fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8",
+                                                            email.Subject))
+  fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+  msg.WriteString("MIME-Version: 1.0\r\n")
+  fmt.Fprintf(&msg, "Content-Type: %s\r\n\r\n",
+              mime.FormatMediaType("multipart/alternative",
+                                   map[string]string{"boundary": mw.Boundary()}))
+  msg.Write(body.Bytes())
+  return msg.Bytes(), nil
+}
+
+/////////////////////////////////////////////////
+
+// SESBackend is a MailBackend that sends emails using AWS SES. AWS
+// credentials and region are read using the default AWS configuration
+// chain.
+type SESBackend struct {
+  client *sesv2.Client
+}
+
+// NewSESBackend creates an SESBackend.
+func NewSESBackend() (*SESBackend, error) {
+  cfg, err := config.LoadDefaultConfig(context.Background())
+  if err != nil {
+    return nil, err
+  }
+  return &SESBackend{client: sesv2.NewFromConfig(cfg)}, nil
+}
+
+// Send sends the email using the SES SendEmail API.
+func (b *SESBackend) Send(ctx context.Context, email Email) error {
+  body := &types.Body{}
+  if email.HTML != "" {
+    body.Html = &types.Content{Data: aws.String(email.HTML),
+                               Charset: aws.String("UTF-8")}
+  }
+  if email.Text != "" {
+    body.Text = &types.Content{Data: aws.String(email.Text),
+                               Charset: aws.String("UTF-8")}
+  }
+  _, err := b.client.SendEmail(ctx, &sesv2.SendEmailInput{
+    FromEmailAddress: aws.String(email.From),
+    Destination: &types.Destination{ToAddresses: email.To},
+    Content: &types.EmailContent{
+      Simple: &types.Message{
+        Subject: &types.Content{Data: aws.String(email.Subject),
+                                Charset: aws.String("UTF-8")},
+        Body: body,
+      },
+    },
+  })
+  return err
+}
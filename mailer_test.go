@@ -0,0 +1,147 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "io/ioutil"
+  "mime"
+  "mime/multipart"
+  "net"
+  "net/mail"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+)
+
+// fakeMailBackend records the sent emails. The first sends fail, as many
+// times as failures.
+type fakeMailBackend struct {
+  mutex sync.Mutex
+  sent []Email
+  failures int
+}
+
+func (b *fakeMailBackend) Send(ctx context.Context, email Email) error {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if b.failures > 0 {
+    b.failures--
+    return errors.New("unavailable")
+  }
+  b.sent = append(b.sent, email)
+  return nil
+}
+
+// TestMailer tests rendering templates and sending queued emails.
+func TestMailer(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "mail")
+  defer os.RemoveAll(dir)
+  ioutil.WriteFile(filepath.Join(dir, "verify.html"),
+                   []byte(`<p>Hi {{.Name}}, <a href="{{.URL}}">verify</a></p>`), 0644)
+
+  backend := &fakeMailBackend{failures: 1}
+  m := NewMailer(backend, "fuel@example.org", 1)
+  m.RetryDelay = time.Millisecond
+  if err := m.SendTemplate([]string{"a@example.org"}, "Verify", "verify.html",
+                           nil); err == nil {
+    t.Fatal("Sending templates without templates should fail")
+  }
+  if err := m.LoadTemplates(filepath.Join(dir, "*.html")); err != nil {
+    t.Fatal("Unable to load templates:", err)
+  }
+  err := m.SendTemplate([]string{"a@example.org"}, "Verify", "verify.html",
+    map[string]string{"Name": "<alice>", "URL": "https://example.org/v?t=1"})
+  if err != nil {
+    t.Fatal("Unable to send the template:", err)
+  }
+  m.Close()
+  if err := m.Enqueue(Email{To: []string{"b@example.org"}}); err != ErrMailerClosed {
+    t.Fatal("Emails should be rejected after Close:", err)
+  }
+  m.Close()
+
+  if len(backend.sent) != 1 {
+    t.Fatal("Failed sends should be retried:", backend.sent)
+  }
+  email := backend.sent[0]
+  if email.From != "fuel@example.org" || email.Subject != "Verify" ||
+     email.HTML != `<p>Hi &lt;alice&gt;, <a href="https://example.org/v?t=1">verify</a></p>` {
+    t.Fatal("Unexpected email:", email)
+  }
+}
+
+// TestMimeMessage tests the messages sent to SMTP servers.
+func TestMimeMessage(t *testing.T) {
+  html := "<p>" + strings.Repeat("añadido ", 200) + "</p>"
+  data, err := mimeMessage(Email{From: "fuel@example.org",
+    To: []string{"a@example.org", "b@example.org"}, Subject: "Añadido",
+    HTML: html, Text: "text"})
+  if err != nil {
+    t.Fatal("Unable to create the message:", err)
+  }
+  for _, line := range strings.Split(string(data), "\r\n") {
+    if len(line) > 998 {
+      t.Fatal("Long lines should be wrapped:", line)
+    }
+  }
+  msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+  if err != nil {
+    t.Fatal("Invalid message:", err)
+  }
+  subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+  if subject != "Añadido" || msg.Header.Get("To") != "a@example.org, b@example.org" {
+    t.Fatal("Unexpected headers:", msg.Header)
+  }
+  mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+  if mediaType != "multipart/alternative" {
+    t.Fatal("Unexpected content type:", mediaType)
+  }
+  mr := multipart.NewReader(msg.Body, params["boundary"])
+  var bodies []string
+  for {
+    part, err := mr.NextPart()
+    if err != nil {
+      break
+    }
+    body, _ := ioutil.ReadAll(part)
+    bodies = append(bodies, string(body))
+  }
+  if len(bodies) != 2 || bodies[0] != "text" || bodies[1] != html {
+    t.Fatal("Unexpected parts:", bodies)
+  }
+
+  if _, err := mimeMessage(Email{From: "a@example.org\r\nBcc: x@example.org",
+                                 To: []string{"b@example.org"}}); err == nil {
+    t.Fatal("Header injections should be rejected")
+  }
+}
+
+// TestSMTPBackendContext tests that SMTP sends stop when their context is
+// done.
+func TestSMTPBackendContext(t *testing.T) {
+  // The server never replies
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal("Unable to listen:", err)
+  }
+  defer l.Close()
+  go func() {
+    conn, err := l.Accept()
+    if err == nil {
+      defer conn.Close()
+      ioutil.ReadAll(conn)
+    }
+  }()
+
+  ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+  defer cancel()
+  b := &SMTPBackend{Addr: l.Addr().String()}
+  err = b.Send(ctx, Email{From: "fuel@example.org", To: []string{"a@example.org"},
+                          Text: "text"})
+  if err != context.DeadlineExceeded {
+    t.Fatal("The send should stop when the context is done:", err)
+  }
+}
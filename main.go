@@ -2,48 +2,245 @@ package ign
 
 // Import this file's dependencies
 import (
+  "context"
   "errors"
   "flag"
   "fmt"
   "io/ioutil"
   "log"
+  "math"
+  "math/rand"
+  "net"
   "net/http"
+  "os"
   "strconv"
+  "sync"
   "time"
+  "github.com/codegangsta/negroni"
   "github.com/gorilla/mux"
-  "github.com/jinzhu/gorm"
-  // Needed by dbInit
-  _ "github.com/go-sql-driver/mysql"
+  "gorm.io/gorm"
+  "gorm.io/gorm/logger"
+  "go.opentelemetry.io/otel/trace"
 )
 
 // Server encapsulates information needed by a downstream application
 type Server struct {
-  /// Global database interface
+  /// Global database interface. It is replaced when the database health
+  /// check reconnects, so code running concurrently with the health check
+  /// must use Database() instead.
   Db *gorm.DB
 
   Router *mux.Router
 
-  // Port used for non-secure requests
+  // Port used for non-secure requests. It can also include the address
+  // of the interface to bind to (eg. "127.0.0.1:8000").
   HTTPPort string
 
-  // SSLport used for secure requests
+  // SSLport used for secure requests. It can also include the address
+  // of the interface to bind to (eg. "127.0.0.1:4430").
   SSLport string
 
+  // UnixSocket is the path of a Unix domain socket. If set, non-secure
+  // requests are served on this socket instead of HTTPPort.
+  UnixSocket string
+
   // SSLCert is the path to the SSL certificate.
   SSLCert string
 
   // SSLKey is the path to the SSL private key.
   SSLKey string
 
+  // RedirectHTTPToHTTPS makes Run serve both the HTTP and HTTPS listeners
+  // when SSL is enabled. The HTTP listener redirects (301) requests to
+  // HTTPS, except for the paths listed in RedirectExemptPaths.
+  RedirectHTTPToHTTPS bool
+
+  // AutocertHosts is the list of host names for which TLS certificates are
+  // automatically obtained and renewed using Let's Encrypt (ACME). If not
+  // empty, SSLCert and SSLKey are ignored. The ACME challenges are answered
+  // by an HTTP listener on TCP port 80, instead of HTTPPort or UnixSocket.
+  AutocertHosts []string
+
+  // AutocertCacheDir is the directory used to store the certificates
+  // obtained through ACME.
+  AutocertCacheDir string
+
+  // RedirectExemptPaths is a list of URL paths (eg. health checks) that are
+  // served over plain HTTP instead of being redirected to HTTPS.
+  RedirectExemptPaths []string
+
   // DbConfig contains information about the database
   DbConfig DatabaseConfig
 
+  // SecretsProvider, if set, is used to read the database credentials
+  // every time a database connection is established.
+  SecretsProvider SecretsProvider
+
+  // DbSecretNames contains the names of the database credentials in the
+  // SecretsProvider. If empty, DefaultDatabaseSecretNames is used.
+  DbSecretNames DatabaseSecretNames
+
+  // Storage, if set, is where the service stores blobs such as model files.
+  // See NewStorageFromEnvVars.
+  Storage Storage
+
+  // UploadScanner, if set, scans the files read by BindMultipart and the
+  // Unzip functions. See NewUploadScannerFromEnvVars.
+  UploadScanner UploadScanner
+
+  // Mailer, if set, sends the emails of the service. See
+  // NewMailerFromEnvVars.
+  Mailer *Mailer
+
+  // Redis, if set, is the Redis client shared by the server subsystems and
+  // the application. See NewRedisFromEnvVars.
+  Redis *Redis
+
+  // Sessions, if set, authenticates requests with session cookies. See
+  // NewSessionsFromEnvVars.
+  Sessions *Sessions
+
+  // Operations, if set, runs the async operations served by
+  // OperationRoutes. See NewOperations.
+  Operations *Operations
+
+  // Tenancy, if set, resolves the tenant of each request. See
+  // TenantScoped.
+  Tenancy *Tenancy
+
+  // QuotaStore keeps the usage counters of routes with a Quota. Defaults to
+  // a MemoryQuotaStore.
+  QuotaStore QuotaStore
+
+  // Clock is the source of time of rate limits, the token cache and
+  // scheduled tasks. Defaults to the system time. Tests can set a
+  // ManualClock.
+  Clock Clock
+
   // IsTest is true when tests are running.
   IsTest bool
 
+  // Authorizer of secure routes. See SetAuthorizer.
+  authorizer Authorizer
+
+  // Receives the errors replied to clients. See SetErrorReporter.
+  errorReporter ErrorReporter
+
+  // Middleware added using Use.
+  middleware []negroni.Handler
+
+  // DefaultAPIVersion is the version used to redirect unversioned requests
+  // of routes that declare Versions.
+  DefaultAPIVersion string
+
+  // DeprecatedAPIVersions are API versions whose responses include the
+  // Deprecation header.
+  DeprecatedAPIVersions []string
+
+  // RoutesIndexPath, if set, is the path of a route that lists all the
+  // routes (eg. "/routes").
+  RoutesIndexPath string
+
+  // MaxRequestBodySize is the max size, in bytes, of request bodies. Zero
+  // means no limit. Routes can override it with Route.MaxBodySize.
+  MaxRequestBodySize int64
+
+  // DebugScope is the scope that allows clients to get the bodies of their
+  // requests logged, using the X-Debug header. Empty disables the header.
+  // See Route.DebugBodies.
+  DebugScope string
+
+  // MaxMultipartMemory is the max number of bytes kept in memory by
+  // ParseMultipartForm. Defaults to 32MB.
+  MaxMultipartMemory int64
+
+  // Pagination contains the page sizes used by NewPaginationRequest.
+  // Routes can override them with Route.Pagination.
+  Pagination PaginationConfig
+
+  // AccessLogFormat is the format of the requests log. One of AccessLogText
+  // (default), AccessLogJSON or AccessLogFields.
+  AccessLogFormat string
+
+  // ErrorFormat is the format of the error responses. One of
+  // ErrorFormatDefault (default) or ErrorFormatProblem.
+  ErrorFormat string
+
+  // ProblemTypeBaseURL is the prefix of the "type" of problem+json errors,
+  // followed by the error code (eg. https://example.com/errors/).
+  ProblemTypeBaseURL string
+
+  // DbHealthCheckInterval is the interval between database health checks.
+  // A value <= 0 disables the health check. See StartDbHealthCheck.
+  DbHealthCheckInterval time.Duration
+
+  // Database health status, updated by the health check.
+  dbHealthChecking int32
+  dbHealthy int32
+  // dbMutex guards Db while the health check reconnects.
+  dbMutex sync.RWMutex
+
+  // DebugErrors includes the stack trace of panics in the error responses.
+  // It must not be enabled in production.
+  DebugErrors bool
+
+  // HealthRoutes enables the /healthz and /readyz routes. See health.go.
+  HealthRoutes bool
+
+  // Custom readiness checks. See AddHealthCheck.
+  healthChecks map[string]func() error
+  healthMutex sync.RWMutex
+
+  // AdminRoutes enables the /admin routes. See admin.go.
+  AdminRoutes bool
+
+  // AdminScope is the scope required by the admin routes. Defaults to
+  // "admin".
+  AdminScope string
+
+  // State of the admin routes. See admin.go.
+  maintenance MaintenanceStatus
+  cacheFlushers map[string]func() error
+  queueStatuses map[string]func() QueueStatus
+  adminMutex sync.RWMutex
+
+  // Runs the tasks registered with Schedule.
+  scheduler *scheduler
+  schedulerMutex sync.Mutex
+
   /// Auth0 public key used for token validation
   auth0RsaPublickey string
 
+  // Auth0JWKSURL is the URL of the Auth0 JSON Web Key Set. If set, tokens are
+  // validated using its keys instead of the RSA public key. See
+  // SetAuth0JWKSURL.
+  Auth0JWKSURL string
+
+  // AnonymousIdentities assigns an anonymous ID to unauthenticated requests
+  // of routes with optional authentication. See AnonymousID.
+  AnonymousIdentities bool
+
+  // AnonymousIDSalt is included in the hash of anonymous IDs. If empty, a
+  // random salt is generated by each process.
+  AnonymousIDSalt string
+
+  // JWTSharedSecret enables HS256 tokens. See SetJWTSharedSecret.
+  JWTSharedSecret string
+
+  // JWTES256PublicKey enables ES256 tokens. See SetJWTES256PublicKey.
+  JWTES256PublicKey string
+
+  // JWTTrustedIssuers are the accepted token issuers. See
+  // SetJWTTrustedIssuers.
+  JWTTrustedIssuers []string
+
+  // TrustedProxies are the IP addresses or CIDR ranges of the proxies whose
+  // forwarding headers are trusted. See SetTrustedProxies.
+  TrustedProxies []string
+
+  // Parsed TrustedProxies.
+  trustedProxyNets []*net.IPNet
+
   // Google Analytics tracking ID. The format is UA-XXXX-Y
   GaTrackingID  string
 
@@ -52,22 +249,115 @@ type Server struct {
 
   // (optional) A string to use as a prefix to GA Event Category.
   GaCategoryPrefix  string
+
+  // Background worker that sends events to Google Analytics.
+  gaTracker *gaTracker
+
+  // OpenTelemetry tracer. Nil if tracing is disabled.
+  tracer trace.Tracer
 }
 
 // DatabaseConfig contains information about a database connection
 type DatabaseConfig struct {
   // Username to login to a database.
-  UserName string
+  UserName string `json:"username" yaml:"username"`
   // Password to login to a database.
-  Password string
+  Password string `json:"password" yaml:"password"`
   // Address of the database.
-  Address string
+  Address string `json:"address" yaml:"address"`
   // Name of the database.
-  Name string
+  Name string `json:"name" yaml:"name"`
   // Allowed Max Open Connections.
   // A value <= 0 means unlimited connections.
   // See 'https://golang.org/src/database/sql/sql.go'
-  MaxOpenConns int
+  MaxOpenConns int `json:"max_open_conns" yaml:"max_open_conns"`
+  // Allowed Max Idle Connections in the pool.
+  // A value of 0 means the go/sql default (2). A negative value means no
+  // idle connections are kept.
+  MaxIdleConns int `json:"max_idle_conns" yaml:"max_idle_conns"`
+  // Max amount of time a connection may be reused.
+  // A value <= 0 means connections are reused forever.
+  ConnMaxLifetime time.Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime"`
+  // Dialect is the gorm dialect of the database. One of DialectMySQL
+  // (default) or DialectSQLite. When using SQLite, Name is the path of the
+  // database file and the server must be built with -tags sqlite.
+  Dialect string `json:"dialect" yaml:"dialect"`
+  // PrepareStmt caches the prepared statements of the queries.
+  PrepareStmt bool `json:"prepare_stmt" yaml:"prepare_stmt"`
+  // Queries slower than SlowQueryThreshold are logged as warnings.
+  // A value of 0 means 200 milliseconds. A negative value disables it.
+  SlowQueryThreshold time.Duration `json:"slow_query_threshold" yaml:"slow_query_threshold"`
+  // Max number of attempts to connect to the database.
+  // A value <= 0 means 10 attempts.
+  MaxAttempts int `json:"max_attempts" yaml:"max_attempts"`
+  // Delay after the first failed connection attempt.
+  // A value <= 0 means 500 milliseconds.
+  RetryDelay time.Duration `json:"retry_delay" yaml:"retry_delay"`
+  // Factor applied to the delay after each failed attempt (exponential
+  // backoff). A value < 1 means 2.
+  RetryBackoff float64 `json:"retry_backoff" yaml:"retry_backoff"`
+  // Max delay between connection attempts.
+  // A value <= 0 means 5 seconds.
+  MaxRetryDelay time.Duration `json:"max_retry_delay" yaml:"max_retry_delay"`
+  // Fraction of the delay, between 0 and 1, that is randomly added or
+  // subtracted to avoid many servers retrying at the same time.
+  RetryJitter float64 `json:"retry_jitter" yaml:"retry_jitter"`
+}
+
+const (
+  // DialectMySQL is the gorm dialect used for MySQL databases.
+  DialectMySQL = "mysql"
+  // DialectSQLite is the gorm dialect used for SQLite databases.
+  DialectSQLite = "sqlite3"
+
+  // sqliteInMemory is the SQLite database used in tests when IGN_TEST_SQLITE
+  // is set. The cache is shared to let all pool connections see the same
+  // database.
+  sqliteInMemory = "file::memory:?cache=shared"
+)
+
+// Default database retry policy
+const (
+  defaultDbMaxAttempts = 10
+  defaultDbRetryDelay = 500 * time.Millisecond
+  defaultDbRetryBackoff = 2
+  defaultDbMaxRetryDelay = 5 * time.Second
+)
+
+// serverShutdownTimeout is the time given to the remaining listeners to
+// finish their requests when a listener fails.
+const serverShutdownTimeout = 5 * time.Second
+
+// retryDelay returns the time to wait after the given failed connection
+// attempt (starting at 0).
+func (c DatabaseConfig) retryDelay(attempt int) time.Duration {
+  delay := float64(c.RetryDelay)
+  if delay <= 0 {
+    delay = float64(defaultDbRetryDelay)
+  }
+  maxDelay := float64(c.MaxRetryDelay)
+  if maxDelay <= 0 {
+    maxDelay = float64(defaultDbMaxRetryDelay)
+  }
+  backoff := c.RetryBackoff
+  if backoff < 1 {
+    backoff = defaultDbRetryBackoff
+  }
+
+  delay = math.Min(delay * math.Pow(backoff, float64(attempt)), maxDelay)
+  if c.RetryJitter > 0 {
+    jitter := math.Min(c.RetryJitter, 1)
+    delay += delay * jitter * (2 * rand.Float64() - 1)
+  }
+  return time.Duration(delay)
+}
+
+// dialect returns the gorm dialect of the database.
+func (c DatabaseConfig) dialect() string {
+  if c.Dialect == "" {
+    return DialectMySQL
+  }
+  return c.Dialect
 }
 
 // gServer is an internal pointer to the Server.
@@ -75,19 +365,40 @@ var gServer *Server
 
 // Init initialize this package
 func Init(routes Routes, auth0RSAPublicKey string) (server *Server, err error) {
+  return InitWithConfig(routes, auth0RSAPublicKey, "")
+}
+
+// InitWithConfig initializes this package, loading the server configuration
+// from the given YAML or JSON file. Env vars override the file values.
+// An empty configPath means that only env vars are used.
+// If auth0RSAPublicKey is empty, the key found in the config file is used.
+func InitWithConfig(routes Routes, auth0RSAPublicKey string,
+                    configPath string) (server *Server, err error) {
 
   server = &Server{
     HTTPPort: ":8000",
     SSLport: ":4430",
   }
-  server.readPropertiesFromEnvVars()
+  if configPath != "" {
+    if err = server.LoadConfig(configPath); err != nil {
+      return nil, err
+    }
+  } else {
+    server.readPropertiesFromEnvVars()
+  }
   gServer = server
 
   server.IsTest = flag.Lookup("test.v") != nil
 
   if server.IsTest {
-    // Let's use a separate DB name if under test mode.
-    server.DbConfig.Name = server.DbConfig.Name + "_test"
+    if useSQLite, _ := ReadEnvVar("IGN_TEST_SQLITE"); useSQLite == "true" {
+      // Use an in-memory SQLite database instead of a MySQL server.
+      server.DbConfig.Dialect = DialectSQLite
+      server.DbConfig.Name = sqliteInMemory
+    } else {
+      // Let's use a separate DB name if under test mode.
+      server.DbConfig.Name = server.DbConfig.Name + "_test"
+    }
 
     // Parse verbose setting, and adjust logging accordingly
     if !flag.Parsed() {
@@ -108,94 +419,333 @@ func Init(routes Routes, auth0RSAPublicKey string) (server *Server, err error) {
   err = server.dbInit()
 
   if err != nil {
-    log.Println(err)
+    gLogger.Error(err.Error(), nil)
+  }
+
+  // Start sending events to Google Analytics, if enabled
+  if server.GaTrackingID != "" && server.GaAppName != "" {
+    server.gaTracker = newGaTracker(server.GaTrackingID, server.GaAppName)
+  }
+
+  // Keep checking the database, and reconnect if needed
+  if server.DbHealthCheckInterval > 0 {
+    server.StartDbHealthCheck(context.Background(),
+                              server.DbHealthCheckInterval)
   }
 
   if server.IsTest {
     server.initTests()
-  } else {
+  } else if auth0RSAPublicKey != "" || server.auth0RsaPublickey == "" {
     server.SetAuth0RsaPublicKey(auth0RSAPublicKey)
   }
+  if !server.IsTest && server.Auth0JWKSURL != "" {
+    server.SetAuth0JWKSURL(server.Auth0JWKSURL)
+  }
+  server.SetJWTSharedSecret(server.JWTSharedSecret)
+  server.SetJWTTrustedIssuers(server.JWTTrustedIssuers)
+  if err = server.SetTrustedProxies(server.TrustedProxies); err != nil {
+    return nil, err
+  }
+  if err = server.SetJWTES256PublicKey(server.JWTES256PublicKey); err != nil {
+    return nil, err
+  }
+
+  if server.AdminRoutes {
+    routes = append(routes, server.NewAdminRoutes()...)
+  }
 
   // Create the router
-  server.Router = NewRouter(routes)
+  server.Router = NewRouterWithOptions(routes, RouterOptions{
+    DefaultVersion: server.DefaultAPIVersion,
+    DeprecatedVersions: server.DeprecatedAPIVersions,
+    IndexPath: server.RoutesIndexPath,
+  })
+
+  if server.HealthRoutes {
+    if server.Redis != nil {
+      server.AddHealthCheck("redis", server.Redis.HealthCheck)
+    }
+    server.AddHealthRoutes()
+    // Probes usually use plain HTTP
+    server.RedirectExemptPaths = append(server.RedirectExemptPaths,
+                                        HealthzPath, ReadyzPath)
+  }
 
   return
 }
 
 // readPropertiesFromEnvVars configures the server based on env vars.
+// Env vars override the values already set in the server (eg. the values
+// loaded from a config file).
 func (s *Server) readPropertiesFromEnvVars() error {
   var err error
 
+  // Get the log level, if specified.
+  if levelStr, err := ReadEnvVar("IGN_LOG_LEVEL"); err == nil {
+    if level, err := ParseLogLevel(levelStr); err != nil {
+      gLogger.Warn("Error parsing IGN_LOG_LEVEL env variable.", nil)
+    } else if std, ok := gLogger.(*StdLogger); ok {
+      std.SetLevel(level)
+    }
+  }
+
+  // Get the API version settings, if specified.
+  overrideFromEnvVar("IGN_DEFAULT_API_VERSION", &s.DefaultAPIVersion)
+  var deprecatedVersions string
+  if overrideFromEnvVar("IGN_DEPRECATED_API_VERSIONS", &deprecatedVersions) {
+    s.DeprecatedAPIVersions = StrToSlice(deprecatedVersions)
+  }
+
+  // Get the Auth0 JWKS URL, if specified.
+  overrideFromEnvVar("IGN_AUTH0_JWKS_URL", &s.Auth0JWKSURL)
+
+  // Get the additional JWT signing keys and trusted issuers, if specified.
+  overrideFromEnvVar("IGN_JWT_HS256_SECRET", &s.JWTSharedSecret)
+  overrideFromEnvVar("IGN_JWT_ES256_PUBLIC_KEY", &s.JWTES256PublicKey)
+  var issuers string
+  if overrideFromEnvVar("IGN_JWT_TRUSTED_ISSUERS", &issuers) {
+    s.JWTTrustedIssuers = StrToSlice(issuers)
+  }
+
+  // Get the size of the validated tokens cache, if specified.
+  if sizeStr, err := ReadEnvVar("IGN_JWT_CACHE_SIZE"); err == nil {
+    if size, err := strconv.Atoi(sizeStr); err != nil {
+      gLogger.Warn("Error parsing IGN_JWT_CACHE_SIZE env variable. " +
+                   "Default cache size will be used.", nil)
+    } else {
+      s.SetTokenCacheSize(size)
+    }
+  }
+
+  // Get the trusted proxies, if specified.
+  var proxies string
+  if overrideFromEnvVar("IGN_TRUSTED_PROXIES", &proxies) {
+    s.TrustedProxies = StrToSlice(proxies)
+  }
+
+  // Get the anonymous identities settings, if specified.
+  if v, err := ReadEnvVar("IGN_ANONYMOUS_IDENTITIES"); err == nil {
+    s.AnonymousIdentities = v == "true"
+  }
+  overrideFromEnvVar("IGN_ANONYMOUS_ID_SALT", &s.AnonymousIDSalt)
+
+  // Get the routes index path, if specified.
+  overrideFromEnvVar("IGN_ROUTES_INDEX_PATH", &s.RoutesIndexPath)
+
+  // Get the scope that allows logging request bodies, if specified.
+  overrideFromEnvVar("IGN_DEBUG_SCOPE", &s.DebugScope)
+
+  // Get the request body limits, if specified.
+  if sizeStr, err := ReadEnvVar("IGN_MAX_REQUEST_BODY_SIZE"); err == nil {
+    if size, err := strconv.ParseInt(sizeStr, 10, 64); err != nil {
+      gLogger.Warn("Error parsing IGN_MAX_REQUEST_BODY_SIZE env variable. " +
+                   "Request bodies will not be limited.", nil)
+    } else {
+      s.MaxRequestBodySize = size
+    }
+  }
+  if sizeStr, err := ReadEnvVar("IGN_MAX_MULTIPART_MEMORY"); err == nil {
+    if size, err := strconv.ParseInt(sizeStr, 10, 64); err != nil {
+      gLogger.Warn("Error parsing IGN_MAX_MULTIPART_MEMORY env variable. " +
+                   "Default multipart memory limit will be used.", nil)
+    } else {
+      s.MaxMultipartMemory = size
+    }
+  }
+
+  if sizeStr, err := ReadEnvVar("IGN_DEFAULT_PAGE_SIZE"); err == nil {
+    if size, err := strconv.ParseInt(sizeStr, 10, 64); err != nil {
+      gLogger.Warn("Error parsing IGN_DEFAULT_PAGE_SIZE env variable. " +
+                   "Default page size will be used.", nil)
+    } else {
+      s.Pagination.DefaultPageSize = size
+    }
+  }
+  if sizeStr, err := ReadEnvVar("IGN_MAX_PAGE_SIZE"); err == nil {
+    if size, err := strconv.ParseInt(sizeStr, 10, 64); err != nil {
+      gLogger.Warn("Error parsing IGN_MAX_PAGE_SIZE env variable. " +
+                   "Default max page size will be used.", nil)
+    } else {
+      s.Pagination.MaxPageSize = size
+    }
+  }
+
+  overrideFromEnvVar("IGN_PAGE_SIZE_POLICY", &s.Pagination.OversizePolicy)
+
+  // Get the access log format, if specified.
+  overrideFromEnvVar("IGN_ACCESS_LOG_FORMAT", &s.AccessLogFormat)
+
+  // Get the error format, if specified.
+  overrideFromEnvVar("IGN_ERROR_FORMAT", &s.ErrorFormat)
+  overrideFromEnvVar("IGN_PROBLEM_TYPE_BASE_URL", &s.ProblemTypeBaseURL)
+
+  // Get the bind addresses, if specified.
+  overrideFromEnvVar("IGN_HTTP_ADDR", &s.HTTPPort)
+  overrideFromEnvVar("IGN_SSL_ADDR", &s.SSLport)
+  overrideFromEnvVar("IGN_UNIX_SOCKET", &s.UnixSocket)
+
   // Get the SSL certificate, if specified.
-  if s.SSLCert, err = ReadEnvVar("IGN_SSL_CERT"); err != nil {
-    log.Printf("Missing IGN_SSL_CERT env variable. " +
-               "Server will not be secure (no https).")
+  if !overrideFromEnvVar("IGN_SSL_CERT", &s.SSLCert) && s.SSLCert == "" {
+    gLogger.Warn("Missing IGN_SSL_CERT env variable. " +
+               "Server will not be secure (no https).", nil)
   }
   // Get the SSL private key, if specified.
-  if s.SSLKey, err = ReadEnvVar("IGN_SSL_KEY"); err != nil {
-    log.Printf("Missing IGN_SSL_KEY env variable. " +
-               "Server will not be secure (no https).")
+  if !overrideFromEnvVar("IGN_SSL_KEY", &s.SSLKey) && s.SSLKey == "" {
+    gLogger.Warn("Missing IGN_SSL_KEY env variable. " +
+               "Server will not be secure (no https).", nil)
+  }
+
+  // Get the hosts to use with Let's Encrypt, if specified.
+  var autocertHosts string
+  if autocertHosts, err = ReadEnvVar("IGN_AUTOCERT_HOSTS"); err == nil {
+    s.AutocertHosts = StrToSlice(autocertHosts)
+  }
+  if !overrideFromEnvVar("IGN_AUTOCERT_CACHE_DIR", &s.AutocertCacheDir) &&
+     s.AutocertCacheDir == "" {
+    s.AutocertCacheDir = defaultAutocertCacheDir
   }
 
   // Read Google Analytics parameters
-  if s.GaTrackingID, err = ReadEnvVar("IGN_GA_TRACKING_ID"); err != nil {
-    log.Printf("Missing IGN_GA_TRACKING_ID env variable. GA will not be enabled")
+  if !overrideFromEnvVar("IGN_GA_TRACKING_ID", &s.GaTrackingID) &&
+     s.GaTrackingID == "" {
+    gLogger.Warn("Missing IGN_GA_TRACKING_ID env variable. GA will not be enabled", nil)
+  }
+  if !overrideFromEnvVar("IGN_GA_APP_NAME", &s.GaAppName) && s.GaAppName == "" {
+    gLogger.Warn("Missing IGN_GA_APP_NAME env variable. GA will not be enabled", nil)
+  }
+  if !overrideFromEnvVar("IGN_GA_CAT_PREFIX", &s.GaCategoryPrefix) &&
+     s.GaCategoryPrefix == "" {
+    gLogger.Warn("Missing optional IGN_GA_CAT_PREFIX env variable.", nil)
+  }
+
+  // Get the secrets provider, if specified.
+  if p, err := NewSecretsProviderFromEnvVars(); err != nil {
+    gLogger.Error("Unable to create secrets provider", Fields{"error": err})
+  } else if p != nil {
+    s.SecretsProvider = p
   }
-  if s.GaAppName, err = ReadEnvVar("IGN_GA_APP_NAME"); err != nil {
-    log.Printf("Missing IGN_GA_APP_NAME env variable. GA will not be enabled")
+
+  // Get the blob storage, if specified.
+  if st, err := NewStorageFromEnvVars(); err != nil {
+    gLogger.Error("Unable to create storage", Fields{"error": err})
+  } else if st != nil {
+    s.Storage = st
+  }
+
+  // Get the upload scanner, if specified.
+  if sc, err := NewUploadScannerFromEnvVars(); err != nil {
+    gLogger.Error("Unable to create upload scanner", Fields{"error": err})
+  } else if sc != nil {
+    s.UploadScanner = sc
   }
-  if s.GaCategoryPrefix, err = ReadEnvVar("IGN_GA_CAT_PREFIX"); err != nil {
-    log.Printf("Missing optional IGN_GA_CAT_PREFIX env variable.")
+
+  // Get the mailer, if specified.
+  if m, err := NewMailerFromEnvVars(); err != nil {
+    gLogger.Error("Unable to create mailer", Fields{"error": err})
+  } else if m != nil {
+    s.Mailer = m
   }
 
-  // Get the database username
-  if s.DbConfig.UserName, err = ReadEnvVar("IGN_DB_USERNAME"); err != nil {
-    log.Printf("Missing IGN_DB_USERNAME env variable. " +
-      "Database connection will not work")
+  // Get the Redis client, if specified.
+  if r, err := NewRedisFromEnvVars(); err != nil {
+    gLogger.Error("Unable to create Redis client", Fields{"error": err})
+  } else if r != nil {
+    s.Redis = r
   }
 
-  // Get the database password
-  if s.DbConfig.Password, err = ReadEnvVar("IGN_DB_PASSWORD"); err != nil {
-    log.Printf("Missing IGN_DB_PASSWORD env variable." +
-               "Database connection will not work")
+  // Get the sessions, if enabled.
+  if sessions, err := NewSessionsFromEnvVars(s.Redis); err != nil {
+    gLogger.Error("Unable to create sessions", Fields{"error": err})
+  } else if sessions != nil {
+    s.Sessions = sessions
+  }
+
+  // Get the tenancy settings, if specified.
+  var claim, baseDomain string
+  overrideFromEnvVar("IGN_TENANT_CLAIM", &claim)
+  overrideFromEnvVar("IGN_TENANT_BASE_DOMAIN", &baseDomain)
+  if claim != "" || baseDomain != "" {
+    s.Tenancy = &Tenancy{Claim: claim, BaseDomain: baseDomain}
+    overrideFromEnvVar("IGN_TENANT_COLUMN", &s.Tenancy.Column)
+    if v, err := ReadEnvVar("IGN_TENANT_REQUIRED"); err == nil {
+      s.Tenancy.Required = v == "true"
+    }
   }
 
-  // Get the database address
-  if s.DbConfig.Address, err = ReadEnvVar("IGN_DB_ADDRESS"); err != nil {
-    log.Printf("Missing IGN_DB_ADDRESS env variable." +
-               "Database connection will not work")
+  // Get the quota store, if specified.
+  if q, err := NewQuotaStoreFromEnvVars(s.Redis); err != nil {
+    gLogger.Error("Unable to create quota store", Fields{"error": err})
+  } else if q != nil {
+    s.QuotaStore = q
   }
 
-  // Get the database name
-  if s.DbConfig.Name, err = ReadEnvVar("IGN_DB_NAME"); err != nil {
-    log.Printf("Missing IGN_DB_NAME env variable." +
-               "Database connection will not work")
+  // Check if the stack traces of panics should be included in the errors.
+  if v, err := ReadEnvVar("IGN_DEBUG_ERRORS"); err == nil {
+    s.DebugErrors = v == "true"
   }
 
-  // Get the database max open conns
-  var maxStr string
-  if maxStr, err = ReadEnvVar("IGN_DB_MAX_OPEN_CONNS"); err != nil {
-    log.Printf("Missing IGN_DB_MAX_OPEN_CONNS env variable." +
-               "Database max open connections will be set to unlimited," +
-              "with the risk of getting 'too many connections' error.")
+  // Check if the health routes should be enabled.
+  if v, err := ReadEnvVar("IGN_HEALTH_ROUTES"); err == nil {
+    s.HealthRoutes = v == "true"
+  }
+
+  // Check if the admin routes should be enabled.
+  if v, err := ReadEnvVar("IGN_ADMIN_ROUTES"); err == nil {
+    s.AdminRoutes = v == "true"
+  }
+  overrideFromEnvVar("IGN_ADMIN_SCOPE", &s.AdminScope)
+
+  // Get the database settings
+  if err := s.DbConfig.overrideFromEnvVars("IGN"); err != nil {
+    gLogger.Warn("Invalid database env variable. Default values will be " +
+                 "used", Fields{"error": err})
+  }
+  for _, missing := range []struct {
+    name, value string
+  }{
+    {"IGN_DB_USERNAME", s.DbConfig.UserName},
+    {"IGN_DB_PASSWORD", s.DbConfig.Password},
+    {"IGN_DB_ADDRESS", s.DbConfig.Address},
+    {"IGN_DB_NAME", s.DbConfig.Name},
+  } {
+    if missing.value == "" {
+      gLogger.Warn("Missing " + missing.name + " env variable. " +
+                   "Database connection will not work", nil)
+    }
+  }
+  if s.DbConfig.MaxOpenConns <= 0 {
+    gLogger.Warn("Missing IGN_DB_MAX_OPEN_CONNS env variable. " +
+               "Database max open connections will be set to unlimited, " +
+               "with the risk of getting 'too many connections' error.", nil)
     s.DbConfig.MaxOpenConns = 0
-  } else {
-    var i int64
-    i, err = strconv.ParseInt(maxStr, 10, 32)
-    if err != nil || i <= 0 {
-      log.Printf("Error parsing IGN_DB_MAX_OPEN_CONNS env variable." +
-          "Database max open connections will be set to unlimited," +
-          "with the risk of getting 'too many connections' error.")
-        s.DbConfig.MaxOpenConns = 0
+  }
+
+  // Get the database health check interval
+  var intervalStr string
+  if intervalStr, err = ReadEnvVar("IGN_DB_HEALTH_CHECK_INTERVAL"); err == nil {
+    var d time.Duration
+    if d, err = time.ParseDuration(intervalStr); err != nil {
+      gLogger.Warn("Error parsing IGN_DB_HEALTH_CHECK_INTERVAL env variable. " +
+                 "Database health check will be disabled.", nil)
     } else {
-      s.DbConfig.MaxOpenConns = int(i)
+      s.DbHealthCheckInterval = d
     }
   }
 
   return nil
 }
 
+// overrideFromEnvVar sets dst to the value of the given env var. It returns
+// false, leaving dst untouched, if the env var is not set.
+func overrideFromEnvVar(name string, dst *string) bool {
+  value, err := ReadEnvVar(name)
+  if err != nil {
+    return false
+  }
+  *dst = value
+  return true
+}
+
 // Auth0RsaPublicKey return the Auth0 public key
 func (s *Server) Auth0RsaPublicKey() string {
   return s.auth0RsaPublickey
@@ -204,30 +754,131 @@ func (s *Server) Auth0RsaPublicKey() string {
 // SetAuth0RsaPublicKey sets the server's Auth0 RSA public key
 func (s *Server) SetAuth0RsaPublicKey(key string) {
   s.auth0RsaPublickey = key
+  gTokenCache.clear()
   pemKeyString = "-----BEGIN CERTIFICATE-----\n" + s.auth0RsaPublickey +
          "\n-----END CERTIFICATE-----"
 }
 
-// Run the router and server
+// Run the router and server. It exits the application if the server fails.
+// Use RunWithError to handle listener failures.
 func (s *Server) Run() {
+  if err := s.RunWithError(); err != nil {
+    log.Fatal(err)
+  }
+}
+
+// RunWithError runs the router and server, and returns the error that made
+// any of the listeners stop (eg. the port is already in use). The other
+// listeners are shut down before returning.
+func (s *Server) RunWithError() error {
+  var servers []*http.Server
+  // Buffered to let the other listeners exit even if nobody is waiting.
+  errs := make(chan error, 2)
+  serve := func(srv *http.Server, run func(*http.Server) error) {
+    servers = append(servers, srv)
+    go func() {
+      errs <- run(srv)
+    }()
+  }
+  serveTLS := func(certFile, keyFile string) func(*http.Server) error {
+    return func(srv *http.Server) error {
+      return srv.ListenAndServeTLS(certFile, keyFile)
+    }
+  }
 
-  if (s.SSLCert != "" && s.SSLKey != "") {
+  if len(s.AutocertHosts) > 0 {
+    // Start the webserver with TLS certificates obtained from Let's Encrypt.
+    // The http listener is needed to answer the ACME challenges, which are
+    // always sent to port 80.
+    m := s.autocertManager()
+    serve(&http.Server{Addr: autocertHTTPAddr,
+                       Handler: s.autocertHTTPHandler(m)},
+          (*http.Server).ListenAndServe)
+    serve(&http.Server{
+      Addr: s.SSLport,
+      Handler: s.Router,
+      TLSConfig: m.TLSConfig(),
+    }, serveTLS("", ""))
+  } else if (s.SSLCert != "" && s.SSLKey != "") {
+    if s.RedirectHTTPToHTTPS {
+      // Start the http webserver that redirects to https.
+      serve(&http.Server{Handler: s.httpsRedirectHandler()}, s.listenAndServe)
+    }
     // Start the webserver with TLS support.
-    log.Fatal(http.ListenAndServeTLS(s.SSLport, s.SSLCert, s.SSLKey, s.Router))
+    serve(&http.Server{Addr: s.SSLport, Handler: s.Router},
+          serveTLS(s.SSLCert, s.SSLKey))
   } else {
     // Start the http webserver
-    log.Fatal(http.ListenAndServe(s.HTTPPort, s.Router))
+    serve(&http.Server{Handler: s.Router}, s.listenAndServe)
+  }
+
+  err := <-errs
+  ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+  defer cancel()
+  for _, srv := range servers {
+    srv.Shutdown(ctx)
+  }
+  // Send the analytics events queued by the last requests.
+  if s.gaTracker != nil {
+    if err := s.gaTracker.Close(ctx); err != nil {
+      gLogger.Warn("Unable to send the queued GA events", Fields{"error": err})
+    }
   }
+  return err
 }
 
 /////////////////////////////////////////////////
 // Private functions
 
+// listenAndServe serves non-secure requests using the given server. It
+// listens on the UnixSocket, if set, or on HTTPPort otherwise.
+func (s *Server) listenAndServe(srv *http.Server) error {
+  if s.UnixSocket == "" {
+    srv.Addr = s.HTTPPort
+    return srv.ListenAndServe()
+  }
+
+  // Remove stale sockets left by a previous run.
+  if err := os.Remove(s.UnixSocket); err != nil && !os.IsNotExist(err) {
+    return err
+  }
+  l, err := net.Listen("unix", s.UnixSocket)
+  if err != nil {
+    return err
+  }
+  defer l.Close()
+  return srv.Serve(l)
+}
+
+// httpsRedirectHandler returns the handler used by the HTTP listener when
+// RedirectHTTPToHTTPS is enabled. It redirects requests to the HTTPS port,
+// and serves the RedirectExemptPaths using the router.
+func (s *Server) httpsRedirectHandler() http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    for _, p := range s.RedirectExemptPaths {
+      if r.URL.Path == p {
+        s.Router.ServeHTTP(w, r)
+        return
+      }
+    }
+
+    host, _, err := net.SplitHostPort(r.Host)
+    if err != nil {
+      host = r.Host
+    }
+    if _, port, err := net.SplitHostPort(s.SSLport); err == nil && port != "443" {
+      host = net.JoinHostPort(host, port)
+    }
+    target := "https://" + host + r.URL.RequestURI()
+    http.Redirect(w, r, target, http.StatusMovedPermanently)
+  })
+}
+
 // initTests is run as the last step of init() and only when `go test` was run.
 func (s *Server) initTests() {
   // Override Auth0 public RSA key with test key, if present
   if testKey, err := ReadEnvVar("TEST_RSA256_PUBLIC_KEY"); err != nil {
-    log.Printf("Missing TEST_RSA256_PUBLIC_KEY. Test with authentication may not work.")
+    gLogger.Warn("Missing TEST_RSA256_PUBLIC_KEY. Test with authentication may not work.", nil)
   } else {
     s.SetAuth0RsaPublicKey(testKey)
   }
@@ -235,52 +886,134 @@ func (s *Server) initTests() {
 
 // dbInit Initialize the database connection
 func (s *Server) dbInit() (error) {
+  return s.ConnectDatabase(context.Background())
+}
+
+// ConnectDatabase connects to the database, retrying according to the
+// DbConfig retry policy. The given context can be used to cancel the
+// retries.
+func (s *Server) ConnectDatabase(ctx context.Context) error {
+  attempts := s.DbConfig.MaxAttempts
+  if attempts <= 0 {
+    attempts = defaultDbMaxAttempts
+  }
+  return s.connectDatabase(ctx, attempts)
+}
+
+// connectDatabase connects to the database with the given number of
+// attempts. The connection is only published in Db once it is ready, and Db
+// is set to nil if it fails.
+func (s *Server) connectDatabase(ctx context.Context, attempts int) error {
+  db, err := s.openDatabase(ctx, attempts)
+  s.setDatabase(db)
+  return err
+}
+
+// openDatabase opens and configures a new database connection.
+func (s *Server) openDatabase(ctx context.Context, attempts int) (*gorm.DB, error) {
+
+  // Refresh the database credentials, as they may have been rotated.
+  if s.SecretsProvider != nil {
+    names := s.DbSecretNames
+    if names == (DatabaseSecretNames{}) {
+      names = DefaultDatabaseSecretNames
+    }
+    if err := s.DbConfig.LoadFromSecrets(s.SecretsProvider, names); err != nil {
+      return nil, err
+    }
+  }
 
   // Connect to the database
+  dialect := s.DbConfig.dialect()
   url := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=UTC",
     s.DbConfig.UserName, s.DbConfig.Password, s.DbConfig.Address,
     s.DbConfig.Name)
+  if dialect == DialectSQLite {
+    url = s.DbConfig.Name
+  }
 
-  var err error
+  dialector, err := newDialector(dialect, url)
+  if err != nil {
+    return nil, err
+  }
+
+  // Enable logging
+  logLevel := logger.Info
+  if flag.Lookup("test.v") != nil &&
+     flag.Lookup("test.v").Value.String() == "false" {
+    logLevel = logger.Silent
+  }
+  config := &gorm.Config{
+    Logger: newDbLogger(logLevel, s.DbConfig.SlowQueryThreshold),
+    PrepareStmt: s.DbConfig.PrepareStmt,
+  }
 
   // Try to connect to the database. This is in for loop due to timing
   // issues. In particular, bitbucket pipelines uses a parallel database
   // container that may not be ready by the time this code executes.
   //
   // I have also seen this needed on amazon ec2 machines.
-  for i := 0; i < 10; i++ {
-    s.Db, err = gorm.Open("mysql", url)
+  var db *gorm.DB
+  for i := 0; i < attempts; i++ {
+    db, err = gorm.Open(dialector, config)
 
     // Check for errors
     if err != nil {
-      log.Printf("Attempt[%d] to connect to the database failed.\n", i)
-      log.Println(url)
-      log.Println(err)
-      time.Sleep(5)
+      gLogger.Warn("Attempt to connect to the database failed",
+                   Fields{"attempt": i, "address": s.DbConfig.Address,
+                          "database": s.DbConfig.Name, "error": err})
+      if i+1 == attempts {
+        break
+      }
+      select {
+      case <-ctx.Done():
+        return nil, ctx.Err()
+      case <-time.After(s.DbConfig.retryDelay(i)):
+      }
     } else {
       break
     }
   }
 
   if err != nil {
-    s.Db = nil
-    return errors.New("Unable to connect to the database")
+    return nil, errors.New("Unable to connect to the database")
   }
-  log.Printf("Connected to the database.\n")
+  gLogger.Info("Connected to the database.", nil)
 
-  // Enable logging
-  if flag.Lookup("test.v") != nil {
-    s.Db.LogMode(flag.Lookup("test.v").Value.String() != "false")
-  } else {
-    s.Db.LogMode(true)
+  sqlDB, err := db.DB()
+  if err != nil {
+    return nil, err
+  }
+  if err := RegisterAuditCallbacks(db); err != nil {
+    return nil, err
+  }
+  if err := RegisterTenantCallbacks(db); err != nil {
+    return nil, err
   }
 
   // Set max open connections in pool. Other requests will be automatically queued
   // by go/sql. See https://golang.org/src/database/sql/sql.go
   if s.DbConfig.MaxOpenConns != 0 {
-    log.Println("Setting DB Max Open Conns", s.DbConfig.MaxOpenConns)
-    s.Db.DB().SetMaxOpenConns(s.DbConfig.MaxOpenConns)
+    gLogger.Info("Setting DB Max Open Conns",
+                 Fields{"max_open_conns": s.DbConfig.MaxOpenConns})
+    sqlDB.SetMaxOpenConns(s.DbConfig.MaxOpenConns)
   }
 
-  return nil
+  // Set max idle connections in pool. By default go/sql keeps 2 idle
+  // connections.
+  if s.DbConfig.MaxIdleConns != 0 {
+    gLogger.Info("Setting DB Max Idle Conns",
+                 Fields{"max_idle_conns": s.DbConfig.MaxIdleConns})
+    sqlDB.SetMaxIdleConns(s.DbConfig.MaxIdleConns)
+  }
+
+  // Set the max lifetime of pool connections, to avoid reusing connections
+  // that were already closed by the database server (eg. AWS RDS).
+  if s.DbConfig.ConnMaxLifetime > 0 {
+    gLogger.Info("Setting DB Conn Max Lifetime",
+                 Fields{"conn_max_lifetime": s.DbConfig.ConnMaxLifetime})
+    sqlDB.SetConnMaxLifetime(s.DbConfig.ConnMaxLifetime)
+  }
+
+  return db, nil
 }
@@ -0,0 +1,49 @@
+package ign
+
+import (
+  "net/http"
+  "github.com/codegangsta/negroni"
+)
+
+// Applications can add their own middleware to the chain of each route.
+// The middleware chain of a route is:
+//
+//   request ID, recovery, tracing, database check, CORS headers,
+//   body size limit, URI parameters validation, pagination settings,
+//   JWT validation, tenant, required scopes, Authorizer (secure routes),
+//   rate limit, quota, Server.Use middleware, Route.Middleware, analytics,
+//   handler
+//
+// so custom middleware can use the identity of the request
+// (see GetUserIdentity).
+
+// Use adds middleware that runs on every route, after the JWT validation
+// and before the middleware of each route. It can be called after the
+// router was created, but not while serving requests.
+func (s *Server) Use(handlers ...negroni.Handler) {
+  s.middleware = append(s.middleware, handlers...)
+}
+
+/////////////////////////////////////////////////
+// serverMiddleware runs the middleware added using Server.Use.
+func serverMiddleware(w http.ResponseWriter, r *http.Request,
+                      next http.HandlerFunc) {
+  if gServer == nil {
+    next(w, r)
+    return
+  }
+  runMiddleware(gServer.middleware, w, r, next)
+}
+
+// runMiddleware runs the given middleware in order, and then next. Any
+// middleware can stop the chain by not calling its next function.
+func runMiddleware(handlers []negroni.Handler, w http.ResponseWriter,
+                   r *http.Request, next http.HandlerFunc) {
+  if len(handlers) == 0 {
+    next(w, r)
+    return
+  }
+  handlers[0].ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) {
+    runMiddleware(handlers[1:], w, r, next)
+  })
+}
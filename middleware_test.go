@@ -0,0 +1,48 @@
+package ign
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "reflect"
+  "testing"
+  "github.com/codegangsta/negroni"
+)
+
+// TestServerUse tests running the server and route middleware in order.
+func TestServerUse(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+
+  var calls []string
+  named := func(name string, stop bool) negroni.Handler {
+    return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request,
+                                    next http.HandlerFunc) {
+      calls = append(calls, name)
+      if stop {
+        w.WriteHeader(http.StatusForbidden)
+        return
+      }
+      next(w, r)
+    })
+  }
+  gServer.Use(named("audit", false), named("quota", false))
+
+  n := negroni.New(negroni.HandlerFunc(serverMiddleware), named("route", false))
+  n.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    calls = append(calls, "handler")
+  }))
+  n.ServeHTTP(httptest.NewRecorder(), &http.Request{})
+  if !reflect.DeepEqual(calls, []string{"audit", "quota", "route", "handler"}) {
+    t.Fatal("Unexpected middleware calls:", calls)
+  }
+
+  // Middleware can stop the chain
+  calls = nil
+  gServer.Use(named("deny", true))
+  rec := httptest.NewRecorder()
+  n.ServeHTTP(rec, &http.Request{})
+  if len(calls) != 3 || rec.Code != http.StatusForbidden {
+    t.Fatal("The chain should stop at the deny middleware:", calls, rec.Code)
+  }
+}
@@ -0,0 +1,74 @@
+package ign
+
+import (
+  "time"
+  "gorm.io/gorm"
+)
+
+// Model and Audited are mixins that downstream models can embed to get
+// consistent columns:
+//
+//   type Robot struct {
+//     ign.Model
+//     ign.Audited
+//     Name string
+//   }
+//
+// Rows of models with Model are soft deleted: Delete sets DeletedAt, and
+// deleted rows are skipped by queries unless Unscoped is used.
+// The Audited columns are set with the subject of the identity found in
+// the query context (see DBFromRequest), by callbacks registered in the
+// server database. Use RegisterAuditCallbacks with other databases.
+
+// Model contains the ID, timestamps and soft delete columns.
+type Model struct {
+  ID uint `gorm:"primaryKey" json:"id"`
+  CreatedAt time.Time `json:"created_at"`
+  UpdatedAt time.Time `json:"updated_at"`
+  DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+}
+
+// Audited contains the users that created and last updated a row.
+type Audited struct {
+  CreatedBy string `gorm:"size:255" json:"created_by,omitempty"`
+  UpdatedBy string `gorm:"size:255" json:"updated_by,omitempty"`
+}
+
+// RegisterAuditCallbacks registers the callbacks that set the Audited
+// columns in the given database.
+func RegisterAuditCallbacks(db *gorm.DB) error {
+  err := db.Callback().Create().Before("gorm:create").
+    Register("ign:audit_create", auditCreate)
+  if err != nil {
+    return err
+  }
+  return db.Callback().Update().Before("gorm:update").
+    Register("ign:audit_update", auditUpdate)
+}
+
+// auditCreate sets the CreatedBy and UpdatedBy columns of new rows.
+func auditCreate(db *gorm.DB) {
+  setAuditColumns(db, "CreatedBy", "UpdatedBy")
+}
+
+// auditUpdate sets the UpdatedBy column of updated rows.
+func auditUpdate(db *gorm.DB) {
+  setAuditColumns(db, "UpdatedBy")
+}
+
+// setAuditColumns sets the given fields to the subject of the identity of
+// the query context, if any.
+func setAuditColumns(db *gorm.DB, fields ...string) {
+  if db.Error != nil || db.Statement.Schema == nil {
+    return
+  }
+  identity, ok := db.Statement.Context.Value(identityKey{}).(*Identity)
+  if !ok || identity.Subject == "" {
+    return
+  }
+  for _, name := range fields {
+    if db.Statement.Schema.LookUpField(name) != nil {
+      db.Statement.SetColumn(name, identity.Subject, true)
+    }
+  }
+}
@@ -0,0 +1,54 @@
+package ign
+
+import (
+  "context"
+  "testing"
+)
+
+// auditedItem is the model used by the audit callbacks test.
+type auditedItem struct {
+  Model
+  Audited
+  Name string
+}
+
+// TestAuditCallbacks tests setting the audit columns and soft deletes.
+func TestAuditCallbacks(t *testing.T) {
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  if err := RegisterAuditCallbacks(db); err != nil {
+    t.Fatal("Unable to register the callbacks:", err)
+  }
+  if err := db.AutoMigrate(&auditedItem{}); err != nil {
+    t.Fatal(err)
+  }
+
+  alice := context.WithValue(context.Background(), identityKey{},
+                             &Identity{Subject: "alice"})
+  bob := context.WithValue(context.Background(), identityKey{},
+                           &Identity{Subject: "bob"})
+  item := auditedItem{Name: "box"}
+  if err := db.WithContext(alice).Create(&item).Error; err != nil {
+    t.Fatal("Unable to create:", err)
+  }
+  if item.CreatedBy != "alice" || item.UpdatedBy != "alice" {
+    t.Fatal("Unexpected audit columns after create:", item.Audited)
+  }
+  db.WithContext(bob).Model(&item).Update("name", "sphere")
+
+  var got auditedItem
+  db.First(&got, item.ID)
+  if got.CreatedBy != "alice" || got.UpdatedBy != "bob" || got.Name != "sphere" {
+    t.Fatal("Unexpected audit columns after update:", got.Audited)
+  }
+
+  // Deleted rows are only found with Unscoped
+  db.Delete(&got)
+  if err := db.First(&auditedItem{}, item.ID).Error; err == nil {
+    t.Fatal("Soft deleted rows should not be found")
+  }
+  if err := db.Unscoped().First(&got, item.ID).Error; err != nil ||
+     !got.DeletedAt.Valid {
+    t.Fatal("Soft deleted row should keep DeletedAt:", got, err)
+  }
+}
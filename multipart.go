@@ -0,0 +1,257 @@
+package ign
+
+import (
+  "errors"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strconv"
+  "strings"
+)
+
+// maxFormValueSize is the max size of a text field read by BindMultipart.
+const maxFormValueSize = 1 << 20
+
+// FileSink receives the files of a multipart form read by BindMultipart.
+// The content must be consumed (eg. copied to disk or S3) before returning.
+type FileSink func(field, filename string, content io.Reader) error
+
+// DirSink returns a FileSink, to be used with BindMultipart, that writes the
+// uploaded files to the given directory. Files are written to a temporary
+// file in the same directory, which replaces the existing file only once
+// the whole content was written, so failed uploads leave no partial files.
+func DirSink(dir string) FileSink {
+  return func(field, filename string, content io.Reader) error {
+    p, err := SafeJoin(dir, filename)
+    if err != nil {
+      return err
+    }
+    f, err := ioutil.TempFile(filepath.Dir(p), "." + filepath.Base(p) + ".tmp")
+    if err != nil {
+      return err
+    }
+    fail := func(err error) error {
+      f.Close()
+      os.Remove(f.Name())
+      return err
+    }
+    // TempFile creates the file only readable by its owner
+    if err := f.Chmod(0644); err != nil {
+      return fail(err)
+    }
+    if _, err := io.Copy(f, content); err != nil {
+      return fail(err)
+    }
+    if err := f.Close(); err != nil {
+      os.Remove(f.Name())
+      return err
+    }
+    if err := os.Rename(f.Name(), p); err != nil {
+      os.Remove(f.Name())
+      return err
+    }
+    return nil
+  }
+}
+
+// BindMultipart reads a multipart form request, streaming the file parts
+// to the sink and setting the text fields in dst, without keeping the
+// whole form in memory. The fields of dst are matched using their "form"
+// tag (eg. `form:"name,required"`). Supported field types are strings,
+// integers, floats, bools and slices of strings (repeated fields).
+// File names are validated with SanitizeFileName before calling the sink,
+// and file parts with a Content-MD5 or Digest header are verified.
+// It returns ErrorForm if the form is invalid or a required field is
+// missing (the Extra contains its name), ErrorFormInvalidValue if a value
+// can't be converted or a file name is unsafe, ErrorChecksumMismatch if a
+// file doesn't match its checksum, ErrorPayloadTooLarge if the body is too
+// large, ErrorUnsafeContent if the Server.UploadScanner rejects a file, and
+// ErrorCreatingFile if the sink (or the scanner) fails.
+func BindMultipart(r *http.Request, dst interface{}, sink FileSink) *ErrMsg {
+  reader, err := r.MultipartReader()
+  if err != nil {
+    return NewErrorMessageWithBase(ErrorForm, err)
+  }
+  fields := formFields(dst)
+  found := map[string]bool{}
+
+  for {
+    part, err := reader.NextPart()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return formReadError(err)
+    }
+    name := part.FormName()
+
+    if part.FileName() != "" {
+      filename, err := SanitizeFileName(part.FileName())
+      if err != nil {
+        part.Close()
+        return NewErrorMessageWithArgs(ErrorFormInvalidValue, err, []string{name})
+      }
+      if sink != nil {
+        content, err := NewVerifyingReader(part, http.Header(part.Header))
+        if err != nil {
+          part.Close()
+          return NewErrorMessageWithArgs(ErrorChecksumMismatch, err, []string{name})
+        }
+        if err := scanAndSink(r, sink, name, filename, content); err != nil {
+          if IsUnsafeContent(err) {
+            return NewErrorMessageWithArgs(ErrorUnsafeContent, err, []string{name})
+          }
+          if IsBodyTooLarge(err) {
+            return NewErrorMessageWithBase(ErrorPayloadTooLarge, err)
+          }
+          if IsChecksumMismatch(err) {
+            return NewErrorMessageWithArgs(ErrorChecksumMismatch, err, []string{name})
+          }
+          return NewErrorMessageWithArgs(ErrorCreatingFile, err, []string{name})
+        }
+      }
+      found[name] = true
+      part.Close()
+      continue
+    }
+
+    value, err := ioutil.ReadAll(io.LimitReader(part, maxFormValueSize + 1))
+    part.Close()
+    if err != nil {
+      return formReadError(err)
+    }
+    if len(value) > maxFormValueSize {
+      return NewErrorMessageWithArgs(ErrorPayloadTooLarge, nil, []string{name})
+    }
+    if field, ok := fields[name]; ok {
+      if err := setFormValue(field, string(value)); err != nil {
+        return NewErrorMessageWithArgs(ErrorFormInvalidValue, err, []string{name})
+      }
+    }
+    found[name] = true
+  }
+
+  for name := range requiredFormFields(dst) {
+    if !found[name] {
+      return NewErrorMessageWithArgs(ErrorForm, nil, []string{name})
+    }
+  }
+  return nil
+}
+
+// scanAndSink passes a file to the sink. If the server has an
+// UploadScanner, the file is first copied to a temporary file and scanned.
+func scanAndSink(r *http.Request, sink FileSink, field, filename string,
+                 content io.Reader) error {
+  scanner := uploadScanner()
+  if scanner == nil {
+    return sink(field, filename, content)
+  }
+  f, err := spoolAndScan(r.Context(), scanner, filename, content)
+  if err != nil {
+    return err
+  }
+  defer os.Remove(f.Name())
+  defer f.Close()
+  return sink(field, filename, f)
+}
+
+// formReadError converts an error reading a multipart form to an ErrMsg.
+func formReadError(err error) *ErrMsg {
+  if IsBodyTooLarge(err) {
+    return NewErrorMessageWithBase(ErrorPayloadTooLarge, err)
+  }
+  return NewErrorMessageWithBase(ErrorForm, err)
+}
+
+// formFields returns the fields of the struct pointed by dst, by form name.
+func formFields(dst interface{}) map[string]reflect.Value {
+  fields := map[string]reflect.Value{}
+  v := reflect.ValueOf(dst)
+  if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+    return fields
+  }
+  v = v.Elem()
+  for i := 0; i < v.NumField(); i++ {
+    if name := formFieldName(v.Type().Field(i)); name != "" {
+      fields[name] = v.Field(i)
+    }
+  }
+  return fields
+}
+
+// requiredFormFields returns the names of the required fields of the
+// struct pointed by dst.
+func requiredFormFields(dst interface{}) map[string]bool {
+  required := map[string]bool{}
+  t := reflect.TypeOf(dst)
+  if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+    return required
+  }
+  t = t.Elem()
+  for i := 0; i < t.NumField(); i++ {
+    tag := strings.Split(t.Field(i).Tag.Get("form"), ",")
+    if len(tag) > 1 && StrSliceContains(tag[1:], "required") {
+      required[tag[0]] = true
+    }
+  }
+  return required
+}
+
+// formFieldName returns the form name of an exported struct field, or an
+// empty string if the field has no form tag.
+func formFieldName(field reflect.StructField) string {
+  if field.PkgPath != "" {
+    return ""
+  }
+  name := strings.Split(field.Tag.Get("form"), ",")[0]
+  if name == "-" {
+    return ""
+  }
+  return name
+}
+
+// setFormValue converts a form value and sets it in the given field.
+// Values of slice fields are appended.
+func setFormValue(field reflect.Value, value string) error {
+  switch field.Kind() {
+  case reflect.String:
+    field.SetString(value)
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    n, err := strconv.ParseInt(value, 10, 64)
+    if err != nil {
+      return err
+    }
+    field.SetInt(n)
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+       reflect.Uint64:
+    n, err := strconv.ParseUint(value, 10, 64)
+    if err != nil {
+      return err
+    }
+    field.SetUint(n)
+  case reflect.Float32, reflect.Float64:
+    f, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+      return err
+    }
+    field.SetFloat(f)
+  case reflect.Bool:
+    b, err := strconv.ParseBool(value)
+    if err != nil {
+      return err
+    }
+    field.SetBool(b)
+  case reflect.Slice:
+    if field.Type().Elem().Kind() != reflect.String {
+      return errors.New("Unsupported form field type")
+    }
+    field.Set(reflect.Append(field, reflect.ValueOf(value)))
+  default:
+    return errors.New("Unsupported form field type")
+  }
+  return nil
+}
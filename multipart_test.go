@@ -0,0 +1,109 @@
+package ign
+
+import (
+  "bytes"
+  "errors"
+  "io"
+  "io/ioutil"
+  "mime/multipart"
+  "net/http"
+  "os"
+  "path/filepath"
+  "testing"
+  "testing/iotest"
+)
+
+type uploadForm struct {
+  Name string `form:"name,required"`
+  Private bool `form:"private"`
+  Tags []string `form:"tags"`
+  Count int `form:"count"`
+}
+
+// TestBindMultipart tests binding multipart forms and streaming files.
+func TestBindMultipart(t *testing.T) {
+  newRequest := func(fields [][2]string, files map[string]string) *http.Request {
+    var body bytes.Buffer
+    mw := multipart.NewWriter(&body)
+    for _, f := range fields {
+      mw.WriteField(f[0], f[1])
+    }
+    for name, content := range files {
+      fw, _ := mw.CreateFormFile("file", name)
+      fw.Write([]byte(content))
+    }
+    mw.Close()
+    r, _ := http.NewRequest("POST", "/models", &body)
+    r.Header.Set("Content-Type", mw.FormDataContentType())
+    return r
+  }
+
+  received := map[string]string{}
+  sink := func(field, filename string, content io.Reader) error {
+    data, err := ioutil.ReadAll(content)
+    received[field + "/" + filename] = string(data)
+    return err
+  }
+
+  r := newRequest([][2]string{{"name", "box"}, {"private", "true"},
+                              {"tags", "a"}, {"tags", "b"}, {"other", "x"}},
+                  map[string]string{"model.sdf": "<sdf/>"})
+  var form uploadForm
+  if em := BindMultipart(r, &form, sink); em != nil {
+    t.Fatal("Unable to bind the form:", em)
+  }
+  if form.Name != "box" || !form.Private || len(form.Tags) != 2 ||
+     received["file/model.sdf"] != "<sdf/>" {
+    t.Fatal("Unexpected form:", form, received)
+  }
+
+  r = newRequest([][2]string{{"private", "true"}}, nil)
+  if em := BindMultipart(r, &uploadForm{}, sink); em == nil ||
+     em.ErrCode != ErrorForm || em.Extra[0] != "name" {
+    t.Fatal("Missing required fields should be rejected:", em)
+  }
+
+  r = newRequest([][2]string{{"name", "box"}, {"count", "x"}}, nil)
+  if em := BindMultipart(r, &uploadForm{}, sink); em == nil ||
+     em.ErrCode != ErrorFormInvalidValue {
+    t.Fatal("Invalid values should be rejected:", em)
+  }
+
+  r = newRequest([][2]string{{"name", "box"}}, map[string]string{"nul.sdf": "x"})
+  if em := BindMultipart(r, &uploadForm{}, sink); em == nil ||
+     em.ErrCode != ErrorFormInvalidValue {
+    t.Fatal("Unsafe file names should be rejected:", em)
+  }
+
+  r, _ = http.NewRequest("POST", "/models", bytes.NewBufferString("{}"))
+  r.Header.Set("Content-Type", "application/json")
+  if em := BindMultipart(r, &uploadForm{}, sink); em == nil ||
+     em.ErrCode != ErrorForm {
+    t.Fatal("Non multipart requests should be rejected:", em)
+  }
+}
+
+// TestDirSink tests that DirSink replaces files only after a complete
+// upload.
+func TestDirSink(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "dirsink")
+  defer os.RemoveAll(dir)
+  sink := DirSink(dir)
+  p := filepath.Join(dir, "model.sdf")
+
+  if err := sink("file", "model.sdf", bytes.NewBufferString("<sdf/>")); err != nil {
+    t.Fatal("Unable to write the file:", err)
+  }
+  failing := io.MultiReader(bytes.NewBufferString("<sdf"),
+                            iotest.ErrReader(errors.New("connection reset")))
+  if err := sink("file", "model.sdf", failing); err == nil {
+    t.Fatal("Read errors should be returned")
+  }
+  data, err := ioutil.ReadFile(p)
+  if err != nil || string(data) != "<sdf/>" {
+    t.Fatal("Failed uploads should not replace the file:", string(data), err)
+  }
+  if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+    t.Fatal("Temporary files should be removed:", len(entries))
+  }
+}
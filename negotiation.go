@@ -0,0 +1,111 @@
+package ign
+
+import (
+  "net/http"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+// Content negotiation: the URI without extension of a route (ie. the
+// FormatHandler with an empty Extension) also serves the formats of the
+// other FormatHandlers of the same method, based on the request's Accept
+// header. Eg. a route with "" and ".proto" handlers serves protobuf at
+// "/models" when the request has "Accept: application/x-protobuf".
+// The handler with an empty Extension is used when no other format is
+// acceptable. URIs with an extension are not affected.
+
+// ExtensionMediaTypes maps a FormatHandler Extension to the media type used
+// to select it from the Accept header. Applications can add their own.
+var ExtensionMediaTypes = map[string]string{
+  ".json": "application/json",
+  ".proto": "application/x-protobuf",
+  ".csv": "text/csv",
+  ".xml": "application/xml",
+  ".msgpack": "application/x-msgpack",
+}
+
+// negotiatedHandler selects a handler based on the Accept header.
+type negotiatedHandler struct {
+  defaultHandler http.Handler
+  // Handlers by media type
+  handlers map[string]http.Handler
+}
+
+/////////////////////////////////////////////////
+func (n negotiatedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  w.Header().Add("Vary", "Accept")
+  for _, mediaType := range parseAccept(r.Header.Get("Accept")) {
+    if h, ok := n.handlers[mediaType]; ok {
+      h.ServeHTTP(w, r)
+      return
+    }
+    if mediaType == "*/*" {
+      break
+    }
+  }
+  n.defaultHandler.ServeHTTP(w, r)
+}
+
+// negotiateHandlers returns a copy of the given handlers, where the handler
+// without extension is replaced by a negotiatedHandler able to serve the
+// formats of the other handlers.
+func negotiateHandlers(handlers FormatHandlers) FormatHandlers {
+  result := make(FormatHandlers, len(handlers))
+  copy(result, handlers)
+
+  defaultIndex := -1
+  byType := map[string]http.Handler{}
+  for i, fh := range handlers {
+    if fh.Extension == "" {
+      defaultIndex = i
+    } else if mediaType, ok := ExtensionMediaTypes[fh.Extension]; ok {
+      byType[mediaType] = fh.Handler
+    }
+  }
+  if defaultIndex < 0 || len(byType) == 0 {
+    return result
+  }
+
+  result[defaultIndex].Handler = negotiatedHandler{
+    defaultHandler: handlers[defaultIndex].Handler,
+    handlers: byType,
+  }
+  return result
+}
+
+// parseAccept returns the media types of an Accept header, sorted by
+// preference (quality). Media types with zero quality are discarded.
+func parseAccept(header string) []string {
+  type accepted struct {
+    mediaType string
+    q float64
+  }
+  var list []accepted
+  for _, part := range strings.Split(header, ",") {
+    params := strings.Split(part, ";")
+    mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+    if mediaType == "" {
+      continue
+    }
+    q := 1.0
+    for _, param := range params[1:] {
+      kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+      if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+        if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+          q = v
+        }
+      }
+    }
+    if q > 0 {
+      list = append(list, accepted{mediaType, q})
+    }
+  }
+  sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+
+  types := make([]string, len(list))
+  for i, a := range list {
+    types[i] = a.mediaType
+  }
+  return types
+}
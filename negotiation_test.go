@@ -0,0 +1,56 @@
+package ign
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "reflect"
+  "testing"
+)
+
+// TestParseAccept tests parsing Accept headers.
+func TestParseAccept(t *testing.T) {
+  got := parseAccept("text/csv;q=0.5, application/x-protobuf, */*;q=0.1, text/html;q=0")
+  exp := []string{"application/x-protobuf", "text/csv", "*/*"}
+  if !reflect.DeepEqual(got, exp) {
+    t.Fatal("Unexpected media types. Exp:", exp, "Got:", got)
+  }
+}
+
+// TestNegotiateHandlers tests selecting a format handler by Accept header.
+func TestNegotiateHandlers(t *testing.T) {
+  named := func(name string) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      w.Write([]byte(name))
+    })
+  }
+  handlers := negotiateHandlers(FormatHandlers{
+    {"", named("default")},
+    {".json", named("json")},
+    {".proto", named("proto")},
+  })
+
+  tests := map[string]string{
+    "": "default",
+    "*/*": "default",
+    "text/html": "default",
+    "application/x-protobuf": "proto",
+    "application/json;q=0.9, application/x-protobuf": "proto",
+    "text/html, application/json;q=0.5": "json",
+  }
+  for accept, exp := range tests {
+    req, _ := http.NewRequest("GET", "/1.0/models", nil)
+    req.Header.Set("Accept", accept)
+    rec := httptest.NewRecorder()
+    handlers[0].Handler.ServeHTTP(rec, req)
+    if rec.Body.String() != exp {
+      t.Fatal("Accept:", accept, "Exp:", exp, "Got:", rec.Body.String())
+    }
+  }
+
+  // Handlers with extension are not changed
+  rec := httptest.NewRecorder()
+  handlers[2].Handler.ServeHTTP(rec, &http.Request{Header: http.Header{}})
+  if rec.Body.String() != "proto" {
+    t.Fatal("Handler with extension should not negotiate")
+  }
+}
@@ -0,0 +1,347 @@
+package ign
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+  "github.com/gorilla/mux"
+  "github.com/satori/go.uuid"
+  "gorm.io/gorm"
+)
+
+// Long running tasks (eg. building a large zip file) can be run as async
+// operations. The handler starts the operation with Operations.Start, and
+// replies with WriteOperationAccepted (HTTP 202 and a Location header).
+// Clients then poll GET /operations/{id} (see OperationRoutes) to get its
+// status, progress and result. The "wait" query parameter (in seconds, up
+// to 60) makes the request wait until the operation ends (long polling).
+//
+// Operations are stored in the database, so any server instance can report
+// them, and run in the background of the instance that started them. The
+// instance records a heartbeat of its unfinished operations. If it stops
+// (eg. it crashes), its operations are marked as failed once their heartbeat
+// is stale.
+
+// Status values of an Operation.
+const (
+  OperationPending = "pending"
+  OperationRunning = "running"
+  OperationDone = "done"
+  OperationFailed = "failed"
+)
+
+// OperationsPath is the path prefix of the operation routes.
+const OperationsPath = "/operations"
+
+// operationMaxWait is the max long polling time of GET /operations/{id}.
+const operationMaxWait = 60 * time.Second
+
+// operationPollInterval is how often a long polling request checks the
+// operation.
+const operationPollInterval = 500 * time.Millisecond
+
+// operationHeartbeatInterval is how often the heartbeat of the unfinished
+// operations is recorded.
+const operationHeartbeatInterval = 30 * time.Second
+
+// operationStaleTimeout is the time after which an unfinished operation
+// without heartbeat is marked as failed.
+const operationStaleTimeout = 4 * operationHeartbeatInterval
+
+// operationInterrupted is the error of the operations whose instance
+// stopped.
+const operationInterrupted = "The operation was interrupted"
+
+// Operation is the status of an async operation.
+type Operation struct {
+  ID string `gorm:"primaryKey;size:36" json:"id"`
+  CreatedAt time.Time `json:"created_at"`
+  UpdatedAt time.Time `json:"updated_at"`
+  // Kind describes the operation (eg. "zip").
+  Kind string `gorm:"size:255" json:"kind"`
+  // Owner is the subject of the user that started the operation. Only the
+  // owner can get it. Empty for anonymous operations.
+  Owner string `gorm:"size:255;index" json:"-"`
+  Status string `gorm:"size:16" json:"status"`
+  // Progress of the operation, from 0 to 100.
+  Progress int `json:"progress"`
+  // Result is the JSON result of a done operation.
+  Result json.RawMessage `gorm:"type:text" json:"result,omitempty"`
+  // Error is the error message of a failed operation.
+  Error string `gorm:"size:1024" json:"error,omitempty"`
+  // HeartbeatAt is the last time the instance running the operation
+  // reported that it was alive.
+  HeartbeatAt time.Time `gorm:"index" json:"-"`
+}
+
+// Finished returns true if the operation is done or failed.
+func (op *Operation) Finished() bool {
+  return op.Status == OperationDone || op.Status == OperationFailed
+}
+
+// OperationFunc is the function of an operation. It reports its progress
+// (0 to 100) with the given function, and returns a result that is
+// marshaled to JSON. The context is canceled when Operations.Close is
+// called.
+type OperationFunc func(ctx context.Context,
+                        progress func(percent int)) (interface{}, error)
+
+// Operations runs the async operations and stores their status.
+type Operations struct {
+  db *gorm.DB
+  ctx context.Context
+  cancel context.CancelFunc
+  running sync.WaitGroup
+}
+
+// NewOperations creates an Operations that stores the operations in the
+// given database, creating the table if needed. Stale operations are
+// marked as failed.
+func NewOperations(db *gorm.DB) (*Operations, error) {
+  if err := db.AutoMigrate(&Operation{}); err != nil {
+    return nil, err
+  }
+  ctx, cancel := context.WithCancel(context.Background())
+  o := &Operations{db: db, ctx: ctx, cancel: cancel}
+  if err := o.failStale(ctx, ""); err != nil {
+    cancel()
+    return nil, err
+  }
+  return o, nil
+}
+
+// Start creates an operation of the given kind, owned by the user of the
+// request, and runs fn in the background.
+func (o *Operations) Start(r *http.Request, kind string,
+                           fn OperationFunc) (*Operation, error) {
+  op := &Operation{
+    ID: uuid.Must(uuid.NewV4()).String(),
+    Kind: kind,
+    Status: OperationPending,
+    HeartbeatAt: clock().Now().UTC(),
+  }
+  if identity, ok := IdentityFromRequest(r); ok {
+    op.Owner = identity.Subject
+  }
+  if err := o.db.Create(op).Error; err != nil {
+    return nil, err
+  }
+
+  o.running.Add(1)
+  go func() {
+    defer o.running.Done()
+    o.run(op.ID, fn)
+  }()
+  return op, nil
+}
+
+// run runs the function of an operation, storing its progress and result.
+func (o *Operations) run(id string, fn OperationFunc) {
+  o.update(id, map[string]interface{}{"status": OperationRunning})
+  stop := make(chan struct{})
+  defer close(stop)
+  go o.heartbeat(id, stop)
+
+  var mutex sync.Mutex
+  last := 0
+  progress := func(percent int) {
+    if percent < 0 {
+      percent = 0
+    } else if percent > 100 {
+      percent = 100
+    }
+    mutex.Lock()
+    defer mutex.Unlock()
+    if percent != last {
+      last = percent
+      o.update(id, map[string]interface{}{"progress": percent})
+    }
+  }
+
+  result, err := runOperationFunc(o.ctx, fn, progress)
+  var data []byte
+  if err == nil {
+    data, err = json.Marshal(result)
+  }
+  if err != nil {
+    gLogger.Error("Operation failed", Fields{"operation": id, "error": err})
+    o.update(id, map[string]interface{}{"status": OperationFailed,
+                                        "error": truncate(err.Error(), 1024)})
+    return
+  }
+  o.update(id, map[string]interface{}{"status": OperationDone,
+                                      "progress": 100, "result": data})
+}
+
+// heartbeat records the heartbeat of an operation until stop is closed.
+func (o *Operations) heartbeat(id string, stop chan struct{}) {
+  c := clock()
+  for {
+    select {
+    case <-stop:
+      return
+    case <-c.After(operationHeartbeatInterval):
+      o.update(id, map[string]interface{}{"heartbeat_at": c.Now().UTC()})
+    }
+  }
+}
+
+// runOperationFunc calls fn, returning panics as errors.
+func runOperationFunc(ctx context.Context, fn OperationFunc,
+                      progress func(int)) (result interface{}, err error) {
+  defer func() {
+    if p := recover(); p != nil {
+      err = errors.New("Operation panicked")
+      gLogger.Error("Operation panicked", Fields{"panic": p})
+    }
+  }()
+  return fn(ctx, progress)
+}
+
+// update changes the given columns of an operation.
+func (o *Operations) update(id string, values map[string]interface{}) {
+  err := o.db.Model(&Operation{}).Where("id = ?", id).Updates(values).Error
+  if err != nil {
+    gLogger.Error("Unable to update operation", Fields{"operation": id,
+                                                       "error": err})
+  }
+}
+
+// Get returns an operation. It returns gorm.ErrRecordNotFound if it doesn't
+// exist. Stale operations are marked as failed.
+func (o *Operations) Get(ctx context.Context, id string) (*Operation, error) {
+  var op Operation
+  if err := o.db.WithContext(ctx).Where("id = ?", id).First(&op).Error; err != nil {
+    return nil, err
+  }
+  if op.Finished() ||
+     clock().Now().Sub(op.HeartbeatAt) <= operationStaleTimeout {
+    return &op, nil
+  }
+  if err := o.failStale(ctx, id); err != nil {
+    return nil, err
+  }
+  if err := o.db.WithContext(ctx).Where("id = ?", id).First(&op).Error; err != nil {
+    return nil, err
+  }
+  return &op, nil
+}
+
+// failStale marks the unfinished operations whose heartbeat is older than
+// operationStaleTimeout as failed. If id is not empty, only that operation
+// is checked.
+func (o *Operations) failStale(ctx context.Context, id string) error {
+  q := o.db.WithContext(ctx).Model(&Operation{}).
+       Where("status IN ?", []string{OperationPending, OperationRunning}).
+       Where("(heartbeat_at < ? OR heartbeat_at IS NULL)",
+             clock().Now().UTC().Add(-operationStaleTimeout))
+  if id != "" {
+    q = q.Where("id = ?", id)
+  }
+  return q.Updates(map[string]interface{}{"status": OperationFailed,
+                                          "error": operationInterrupted}).Error
+}
+
+// Close cancels the context of the running operations, and waits until
+// they end.
+func (o *Operations) Close() {
+  o.cancel()
+  o.running.Wait()
+}
+
+// WriteOperationAccepted replies with HTTP 202, the operation as JSON, and
+// a Location header with its URL.
+func WriteOperationAccepted(w http.ResponseWriter, op *Operation) *ErrMsg {
+  data, err := json.Marshal(op)
+  if err != nil {
+    return NewErrorMessageWithBase(ErrorMarshalJSON, err)
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.Header().Set("Location", OperationsPath + "/" + op.ID)
+  w.WriteHeader(http.StatusAccepted)
+  w.Write(data)
+  return nil
+}
+
+// OperationRoutes returns the GET /operations/{id} route, which uses the
+// Server.Operations.
+func OperationRoutes() Routes {
+  return Routes{
+    {
+      Name: "operation",
+      Description: "Returns the status of an async operation",
+      URI: OperationsPath + "/{id}",
+      Methods: Methods{
+        Method{
+          Type: "GET",
+          Description: "Get the status, progress and result of an " +
+                       "operation. The wait parameter (in seconds) waits " +
+                       "until the operation ends",
+          Handlers: FormatHandlers{
+            FormatHandler{"", JSONResult(operationHandler)},
+          },
+        },
+      },
+    },
+  }
+}
+
+/////////////////////////////////////////////////
+// operationHandler returns an operation of the user, waiting until it ends
+// if the request has the wait parameter.
+func operationHandler(w http.ResponseWriter,
+                      r *http.Request) (interface{}, *ErrMsg) {
+  if gServer == nil || gServer.Operations == nil {
+    return nil, NewErrorMessage(ErrorNonExistentResource)
+  }
+  var wait time.Duration
+  if v := r.URL.Query().Get("wait"); v != "" {
+    seconds, err := strconv.Atoi(v)
+    if err != nil || seconds < 0 {
+      return nil, NewErrorMessageWithArgs(ErrorFormInvalidValue, err,
+                                          []string{"wait"})
+    }
+    wait = time.Duration(seconds) * time.Second
+    if wait > operationMaxWait {
+      wait = operationMaxWait
+    }
+  }
+
+  id := mux.Vars(r)["id"]
+  c := clock()
+  deadline := c.Now().Add(wait)
+  for {
+    op, err := gServer.Operations.Get(r.Context(), id)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+      return nil, NewErrorMessage(ErrorIDNotFound)
+    } else if err != nil {
+      return nil, ErrMsgFromDB(err)
+    }
+    if op.Owner != "" {
+      identity, ok := IdentityFromRequest(r)
+      if !ok || identity.Subject != op.Owner {
+        return nil, NewErrorMessage(ErrorIDNotFound)
+      }
+    }
+    if op.Finished() || !c.Now().Before(deadline) {
+      return op, nil
+    }
+    select {
+    case <-r.Context().Done():
+      return op, nil
+    case <-c.After(operationPollInterval):
+    }
+  }
+}
+
+// truncate returns the first n bytes of s.
+func truncate(s string, n int) string {
+  if len(s) > n {
+    return s[:n]
+  }
+  return s
+}
@@ -0,0 +1,114 @@
+package ign
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+  "github.com/gorilla/mux"
+)
+
+// TestOperations tests running async operations and polling their status.
+func TestOperations(t *testing.T) {
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  sqlDB(db).SetMaxOpenConns(1)
+
+  ops, err := NewOperations(db)
+  if err != nil {
+    t.Fatal("Unable to create the operations:", err)
+  }
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{Operations: ops}
+
+  req, _ := http.NewRequest("POST", "/zips", nil)
+  release := make(chan struct{})
+  op, err := ops.Start(req, "zip", func(ctx context.Context,
+                                        progress func(int)) (interface{}, error) {
+    progress(40)
+    <-release
+    return map[string]string{"url": "/zips/1.zip"}, nil
+  })
+  if err != nil || op.Status != OperationPending {
+    t.Fatal("Unable to start the operation:", err)
+  }
+  failed, _ := ops.Start(req, "zip", func(ctx context.Context,
+                                          progress func(int)) (interface{}, error) {
+    return nil, errors.New("disk full")
+  })
+
+  router := mux.NewRouter()
+  router.Handle(OperationsPath + "/{id}", JSONResult(operationHandler))
+  get := func(uri string) (int, Operation) {
+    rec := httptest.NewRecorder()
+    router.ServeHTTP(rec, httptest.NewRequest("GET", uri, nil))
+    var result Operation
+    json.Unmarshal(rec.Body.Bytes(), &result)
+    return rec.Code, result
+  }
+
+  close(release)
+  code, result := get(OperationsPath + "/" + op.ID + "?wait=5")
+  if code != http.StatusOK || result.Status != OperationDone ||
+     result.Progress != 100 || string(result.Result) != `{"url":"/zips/1.zip"}` {
+    t.Fatal("Unexpected operation:", code, result)
+  }
+  ops.Close()
+  if _, result = get(OperationsPath + "/" + failed.ID); result.Status !=
+     OperationFailed || result.Error != "disk full" {
+    t.Fatal("Unexpected failed operation:", result)
+  }
+  if code, _ = get(OperationsPath + "/unknown"); code != http.StatusNotFound {
+    t.Fatal("Unknown operations should not be found:", code)
+  }
+
+  rec := httptest.NewRecorder()
+  WriteOperationAccepted(rec, op)
+  if rec.Code != http.StatusAccepted ||
+     rec.Header().Get("Location") != OperationsPath + "/" + op.ID {
+    t.Fatal("Unexpected accepted response:", rec.Code, rec.Header())
+  }
+}
+
+// TestOperationsStale tests failing the operations of stopped instances.
+func TestOperationsStale(t *testing.T) {
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  sqlDB(db).SetMaxOpenConns(1)
+
+  prev := gServer
+  defer func() { gServer = prev }()
+  clk := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+  gServer = &Server{Clock: clk}
+
+  // An operation left running by a crashed instance
+  db.AutoMigrate(&Operation{})
+  db.Create(&Operation{ID: "crashed", Status: OperationRunning,
+                       HeartbeatAt: clk.Now().Add(-time.Hour)})
+  db.Create(&Operation{ID: "alive", Status: OperationRunning,
+                       HeartbeatAt: clk.Now()})
+  ops, err := NewOperations(db)
+  if err != nil {
+    t.Fatal("Unable to create the operations:", err)
+  }
+  defer ops.Close()
+  ctx := context.Background()
+  if op, err := ops.Get(ctx, "crashed"); err != nil ||
+     op.Status != OperationFailed || op.Error != operationInterrupted {
+    t.Fatal("Stale operations should fail on startup:", op, err)
+  }
+  if op, err := ops.Get(ctx, "alive"); err != nil ||
+     op.Status != OperationRunning {
+    t.Fatal("Operations with a heartbeat should keep running:", op, err)
+  }
+
+  clk.Add(operationStaleTimeout + time.Second)
+  if op, err := ops.Get(ctx, "alive"); err != nil ||
+     op.Status != OperationFailed || op.Error != operationInterrupted {
+    t.Fatal("Stale operations should fail when read:", op, err)
+  }
+}
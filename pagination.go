@@ -1,11 +1,17 @@
 package ign
 
 import (
+  "context"
+  "encoding/json"
+  "errors"
   "fmt"
   "net/http"
   "net/url"
+  "reflect"
   "strconv"
-  "github.com/jinzhu/gorm"
+  "strings"
+  "github.com/codegangsta/negroni"
+  "gorm.io/gorm"
 )
 
 const (
@@ -30,6 +36,78 @@ const (
 // pagResult := PaginateQuery(q, result, pagRequest)
 // 3) Write the prev and next headers in the output response
 // WritePaginationHeaders(pagResult, w, r)
+// Other sources of items can be paginated using PaginateSlice (in-memory
+// slices) or Paginate with a Paginator (eg. raw database/sql queries).
+
+//////////////////////////////////////
+
+// Policies applied when the "per_page" argument exceeds the max page size.
+// See PaginationConfig.OversizePolicy.
+const (
+  // PageSizeReset uses the default page size.
+  PageSizeReset = "reset"
+  // PageSizeClamp uses the max page size.
+  PageSizeClamp = "clamp"
+  // PageSizeReject rejects the request with ErrorInvalidPaginationRequest.
+  // The error Extra contains "per_page" and the max page size
+  // (eg. "max=100").
+  PageSizeReject = "reject"
+)
+
+// PaginationConfig contains the page sizes used by NewPaginationRequest.
+// Zero values use the defaults (20 and 100).
+type PaginationConfig struct {
+  // Page size used if the request has no "per_page" argument.
+  DefaultPageSize int64 `json:"default_page_size,omitempty" yaml:"default_page_size"`
+  // Max accepted "per_page" argument.
+  MaxPageSize int64 `json:"max_page_size,omitempty" yaml:"max_page_size"`
+  // Policy applied when "per_page" exceeds MaxPageSize. One of
+  // PageSizeReset (default), PageSizeClamp or PageSizeReject.
+  OversizePolicy string `json:"oversize_policy,omitempty" yaml:"oversize_policy"`
+}
+
+// paginationKey is the context key of the route PaginationConfig.
+type paginationKey struct{}
+
+// paginationConfig returns the pagination settings of the given request.
+// Values not set by the route (Route.Pagination) are taken from the
+// server, and then from the defaults.
+func paginationConfig(r *http.Request) PaginationConfig {
+  cfg := PaginationConfig{}
+  if route, ok := r.Context().Value(paginationKey{}).(PaginationConfig); ok {
+    cfg = route
+  }
+  if gServer != nil {
+    if cfg.DefaultPageSize <= 0 {
+      cfg.DefaultPageSize = gServer.Pagination.DefaultPageSize
+    }
+    if cfg.MaxPageSize <= 0 {
+      cfg.MaxPageSize = gServer.Pagination.MaxPageSize
+    }
+    if cfg.OversizePolicy == "" {
+      cfg.OversizePolicy = gServer.Pagination.OversizePolicy
+    }
+  }
+  if cfg.DefaultPageSize <= 0 {
+    cfg.DefaultPageSize = defaultPageSize
+  }
+  if cfg.MaxPageSize <= 0 {
+    cfg.MaxPageSize = maxPageSize
+  }
+  if cfg.DefaultPageSize > cfg.MaxPageSize {
+    cfg.DefaultPageSize = cfg.MaxPageSize
+  }
+  return cfg
+}
+
+/////////////////////////////////////////////////
+// newPaginationMiddleware returns a middleware that sets the pagination
+// settings of a route.
+func newPaginationMiddleware(cfg PaginationConfig) negroni.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    next(w, r.WithContext(context.WithValue(r.Context(), paginationKey{}, cfg)))
+  }
+}
 
 //////////////////////////////////////
 
@@ -44,14 +122,21 @@ type PaginationRequest struct {
   PerPage int64
   // The original request URL
   URL string
+  // SkipCount avoids counting the query results, which is slow on huge
+  // tables. Set by the application. The X-Total-Count header and the "last"
+  // link are then omitted, and the "next" link is written if there are more
+  // results.
+  SkipCount bool
 }
 
 // NewPaginationRequest creates a new PaginationRequest from the given http request.
+// The page sizes are set by Server.Pagination and Route.Pagination.
 func NewPaginationRequest(r *http.Request) (*PaginationRequest, *ErrMsg) {
+  cfg := paginationConfig(r)
   pageRequest := PaginationRequest{
     PageRequested: false,
     Page: defaultPageNumber,
-    PerPage: defaultPageSize,
+    PerPage: cfg.DefaultPageSize,
     URL: r.URL.String(),
   }
   var err error
@@ -81,8 +166,16 @@ func NewPaginationRequest(r *http.Request) (*PaginationRequest, *ErrMsg) {
     if pageRequest.PerPage <= 0 {
       return nil, NewErrorMessageWithArgs(ErrorInvalidPaginationRequest, err, []string{perPageArgName})
     }
-    if pageRequest.PerPage > maxPageSize {
-      pageRequest.PerPage = defaultPageSize
+    if pageRequest.PerPage > cfg.MaxPageSize {
+      switch cfg.OversizePolicy {
+      case PageSizeClamp:
+        pageRequest.PerPage = cfg.MaxPageSize
+      case PageSizeReject:
+        return nil, NewErrorMessageWithArgs(ErrorInvalidPaginationRequest, nil,
+          []string{perPageArgName, "max=" + strconv.FormatInt(cfg.MaxPageSize, 10)})
+      default:
+        pageRequest.PerPage = cfg.DefaultPageSize
+      }
     }
   }
   return &pageRequest, nil
@@ -104,6 +197,12 @@ type PaginationResult struct {
   // OR if it is the first page and the DB query is empty. In this empty scenario,
   // we want to return status OK with zero elements, rather than a 404 status.
   PageFound bool
+  // True if the count was skipped (see PaginationRequest.SkipCount). Then
+  // QueryCount is not set.
+  CountSkipped bool
+  // True if there are more results after this page. Only set if the count
+  // was skipped.
+  HasNext bool
 }
 
 func newPaginationResult() PaginationResult {
@@ -126,17 +225,33 @@ func computeLastPage(page *PaginationResult) int64 {
 // Param[out] result [interface{}] The paginated list of items
 // Param[in] p The pagination request
 // Returns a PaginationResult describing the returned page.
+// Use a query bound to the request context (see DBFromRequest) to
+// cancel it when the client goes away.
 func PaginateQuery(q *gorm.DB, result interface{}, p PaginationRequest) (*PaginationResult, error) {
-  q = q.Limit(int(p.PerPage))
-  q = q.Offset((Max(p.Page, 1) - 1) * p.PerPage)
-  q = q.Find(result)
-  if err := q.Error; err != nil {
+  if p.SkipCount {
+    return paginateWithoutCount(q, result, p)
+  }
+  return Paginate(&gormPaginator{q, result}, p)
+}
+
+// Paginator is a source of items that can be paginated using Paginate, for
+// sources other than GORM queries (eg. raw database/sql queries).
+type Paginator interface {
+  // Fetch loads the items of the given range (eg. using LIMIT and OFFSET).
+  Fetch(offset, limit int64) error
+  // Count returns the total number of items.
+  Count() (int64, error)
+}
+
+// Paginate fetches the requested page of the given Paginator.
+// The PaginationRequest.SkipCount option is ignored.
+// Returns a PaginationResult describing the returned page.
+func Paginate(src Paginator, p PaginationRequest) (*PaginationResult, error) {
+  if err := src.Fetch((Max(p.Page, 1) - 1) * p.PerPage, p.PerPage); err != nil {
     return nil, err
   }
-  q = q.Limit(-1)
-  q = q.Offset(-1)
-  count := 0
-  if err := q.Count(&count).Error; err != nil {
+  count, err := src.Count()
+  if err != nil {
     return nil, err
   }
 
@@ -144,7 +259,7 @@ func PaginateQuery(q *gorm.DB, result interface{}, p PaginationRequest) (*Pagina
   r.Page = p.Page
   r.PerPage = p.PerPage
   r.URL = p.URL
-  r.QueryCount = int64(count)
+  r.QueryCount = count
 
   lastPage := computeLastPage(&r)
   // A page is considered "found" if it is within the range of valid pages,
@@ -155,53 +270,188 @@ func PaginateQuery(q *gorm.DB, result interface{}, p PaginationRequest) (*Pagina
   return &r, nil
 }
 
+// gormPaginator is the Paginator of a GORM query.
+type gormPaginator struct {
+  q *gorm.DB
+  result interface{}
+}
+
+// Fetch is part of the Paginator interface.
+// A new session is used, so the query can be reused by Count.
+func (g *gormPaginator) Fetch(offset, limit int64) error {
+  return g.q.Session(&gorm.Session{}).Limit(int(limit)).Offset(int(offset)).
+    Find(g.result).Error
+}
+
+// Count is part of the Paginator interface.
+func (g *gormPaginator) Count() (int64, error) {
+  var count int64
+  err := g.q.Session(&gorm.Session{}).Count(&count).Error
+  return count, err
+}
+
+// slicePaginator is the Paginator of an in-memory slice.
+type slicePaginator struct {
+  items reflect.Value
+  page reflect.Value
+}
+
+// Fetch is part of the Paginator interface.
+func (s *slicePaginator) Fetch(offset, limit int64) error {
+  length := int64(s.items.Len())
+  start := Min(offset, length)
+  s.page = s.items.Slice(int(start), int(Min(start + limit, length)))
+  return nil
+}
+
+// Count is part of the Paginator interface.
+func (s *slicePaginator) Count() (int64, error) {
+  return int64(s.items.Len()), nil
+}
+
+// PaginateSlice returns the requested page of an in-memory slice, and the
+// PaginationResult describing it. The page shares the slice storage. It
+// returns an error if items is not a slice.
+func PaginateSlice(items interface{},
+                   p PaginationRequest) (interface{}, *PaginationResult, error) {
+  v := reflect.ValueOf(items)
+  if v.Kind() != reflect.Slice {
+    return nil, nil, errors.New("PaginateSlice requires a slice")
+  }
+  src := &slicePaginator{items: v}
+  r, err := Paginate(src, p)
+  if err != nil {
+    return nil, nil, err
+  }
+  return src.page.Interface(), r, nil
+}
+
+// paginateWithoutCount executes a paginated query without counting its
+// results. It fetches one more item than requested to know if there is a
+// next page. The result must be a pointer to a slice.
+func paginateWithoutCount(q *gorm.DB, result interface{},
+                          p PaginationRequest) (*PaginationResult, error) {
+  q = q.Limit(int(p.PerPage + 1))
+  q = q.Offset(int((Max(p.Page, 1) - 1) * p.PerPage))
+  if err := q.Find(result).Error; err != nil {
+    return nil, err
+  }
+
+  r := newPaginationResult()
+  r.Page = p.Page
+  r.PerPage = p.PerPage
+  r.URL = p.URL
+  r.CountSkipped = true
+
+  items := reflect.ValueOf(result).Elem()
+  if int64(items.Len()) > p.PerPage {
+    r.HasNext = true
+    items.Set(items.Slice(0, int(p.PerPage)))
+  }
+  r.PageFound = items.Len() > 0 || r.Page == 1
+  return &r, nil
+}
+
 //////////////////////////////////////
 
-// newLinkStr is a helper function to create a page link header string.
-func newLinkStr(u *url.URL, page int64, name string) string {
+// newLinkURL is a helper function to create the URL of a page.
+func newLinkURL(u *url.URL, page int64) string {
   params := u.Query()
   params.Set(pageArgName, fmt.Sprint(page))
   u.RawQuery = params.Encode()
-  return fmt.Sprintf("<%s>; rel=\"%s\"", u, name)
+  return u.String()
 }
 
-// WritePaginationHeaders writes the 'next', 'last', 'first', and 'prev' Link headers to the given
-// ResponseWriter.
-func WritePaginationHeaders(page PaginationResult, w http.ResponseWriter, r *http.Request) error {
-  u , _ := url.Parse(page.URL)
-  params := u.Query()
-  params.Set(perPageArgName, fmt.Sprint(page.PerPage))
+// pageLink is a link to a page, with its relation (eg. "next").
+type pageLink struct {
+  rel string
+  url string
+}
 
-  lastPage := computeLastPage(&page)
+// paginationLinks returns the 'next', 'last', 'first', and 'prev' links of
+// the given page, if they exist.
+func paginationLinks(page PaginationResult) []pageLink {
+  u , _ := url.Parse(page.URL)
 
-  var links []string
+  var links []pageLink
 
-  // Next and Last
-  if page.Page < lastPage {
-    links = append(links, newLinkStr(u, page.Page + 1, "next"))
-    links = append(links, newLinkStr(u, lastPage, "last"))
+  // Next and Last. The last page is unknown if the count was skipped.
+  lastPage := page.Page
+  if page.CountSkipped {
+    if page.HasNext {
+      links = append(links, pageLink{"next", newLinkURL(u, page.Page + 1)})
+    }
+  } else {
+    lastPage = computeLastPage(&page)
+    if page.Page < lastPage {
+      links = append(links, pageLink{"next", newLinkURL(u, page.Page + 1)})
+      links = append(links, pageLink{"last", newLinkURL(u, lastPage)})
+    }
   }
 
   // First and Prev
   if page.Page > 1 {
-    links = append(links, newLinkStr(u, 1, "first"))
+    links = append(links, pageLink{"first", newLinkURL(u, 1)})
     prev := page.Page - 1
     if page.Page > lastPage {
       prev = lastPage
     }
-    links = append(links, newLinkStr(u, prev, "prev"))
+    links = append(links, pageLink{"prev", newLinkURL(u, prev)})
   }
+  return links
+}
 
+// WritePaginationHeaders writes the 'next', 'last', 'first', and 'prev' Link headers to the given
+// ResponseWriter.
+func WritePaginationHeaders(page PaginationResult, w http.ResponseWriter, r *http.Request) error {
   // Build the output Links header
-  c := len(links)
-  headerStr := ""
-  for i, l := range links {
-    headerStr += l
-    if i+1 < c {
-      headerStr += ", "
-    }
+  var headers []string
+  for _, l := range paginationLinks(page) {
+    headers = append(headers, fmt.Sprintf("<%s>; rel=\"%s\"", l.url, l.rel))
+  }
+  w.Header().Set("Link", strings.Join(headers, ", "))
+  if !page.CountSkipped {
+    w.Header().Set("X-Total-Count", fmt.Sprint(page.QueryCount))
   }
-  w.Header().Set("Link", headerStr)
-  w.Header().Set("X-Total-Count", fmt.Sprint(page.QueryCount))
   return nil
 }
+
+// PaginatedResponse is the JSON envelope written by WritePaginatedResponse.
+type PaginatedResponse struct {
+  Data interface{} `json:"data"`
+  Page int64 `json:"page"`
+  PerPage int64 `json:"per_page"`
+  // Total number of items. Omitted if the count was skipped.
+  Total *int64 `json:"total,omitempty"`
+  // URLs of the 'next', 'last', 'first', and 'prev' pages, if they exist.
+  Links map[string]string `json:"links"`
+}
+
+// WritePaginatedResponse writes the page data in a JSON envelope that
+// includes the pagination information, for clients that can't read the
+// Link headers. E.g.:
+// {"data": [...], "page": 2, "per_page": 20, "total": 55,
+//  "links": {"next": "...", "last": "...", "first": "...", "prev": "..."}}
+func WritePaginatedResponse(w http.ResponseWriter, r *http.Request,
+                            page PaginationResult, data interface{}) error {
+  response := PaginatedResponse{
+    Data: data,
+    Page: page.Page,
+    PerPage: page.PerPage,
+    Links: map[string]string{},
+  }
+  if !page.CountSkipped {
+    response.Total = &page.QueryCount
+  }
+  for _, l := range paginationLinks(page) {
+    response.Links[l.rel] = l.url
+  }
+
+  output, err := json.Marshal(response)
+  if err != nil {
+    return err
+  }
+  w.Header().Set("Content-Type", "application/json")
+  _, err = w.Write(output)
+  return err
+}
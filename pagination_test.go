@@ -0,0 +1,161 @@
+package ign
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// TestPaginateWithoutCount tests skipping the count of paginated queries.
+func TestPaginateWithoutCount(t *testing.T) {
+  db := newListItemsDB(t, listItem{Name: "a"}, listItem{Name: "b"},
+                       listItem{Name: "c"})
+  defer sqlDB(db).Close()
+
+  page := func(query string) ([]listItem, *PaginationResult, http.Header) {
+    r, _ := http.NewRequest("GET", "/items?" + query, nil)
+    pr, _ := NewPaginationRequest(r)
+    pr.SkipCount = true
+    var items []listItem
+    result, err := PaginateQuery(db.Model(&listItem{}).Order("id"), &items, *pr)
+    if err != nil {
+      t.Fatal("Query failed:", err)
+    }
+    rec := httptest.NewRecorder()
+    WritePaginationHeaders(*result, rec, r)
+    return items, result, rec.Header()
+  }
+
+  items, result, h := page("per_page=2")
+  if len(items) != 2 || !result.HasNext || !result.PageFound {
+    t.Fatal("Unexpected first page:", items, result)
+  }
+  if h.Get("X-Total-Count") != "" || !strings.Contains(h.Get("Link"), "next") ||
+     strings.Contains(h.Get("Link"), "last") {
+    t.Fatal("Unexpected headers:", h)
+  }
+
+  items, result, h = page("per_page=2&page=2")
+  if len(items) != 1 || result.HasNext || strings.Contains(h.Get("Link"), "next") ||
+     !strings.Contains(h.Get("Link"), `page=1&per_page=2>; rel="prev"`) {
+    t.Fatal("Unexpected last page:", items, result, h)
+  }
+
+  if _, result, _ = page("per_page=2&page=5"); result.PageFound {
+    t.Fatal("Page 5 should not be found")
+  }
+}
+
+// TestWritePaginatedResponse tests writing pages in a JSON envelope.
+func TestWritePaginatedResponse(t *testing.T) {
+  page := PaginationResult{Page: 2, PerPage: 2, QueryCount: 5,
+                           URL: "/items?page=2&per_page=2", PageFound: true}
+  r, _ := http.NewRequest("GET", page.URL, nil)
+  rec := httptest.NewRecorder()
+  if err := WritePaginatedResponse(rec, r, page, []string{"c", "d"}); err != nil {
+    t.Fatal("Unable to write the response:", err)
+  }
+
+  var got PaginatedResponse
+  if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+    t.Fatal("Invalid JSON:", err)
+  }
+  if len(got.Data.([]interface{})) != 2 || got.Page != 2 || got.PerPage != 2 ||
+     *got.Total != 5 || len(got.Links) != 4 ||
+     got.Links["last"] != "/items?page=3&per_page=2" ||
+     rec.Header().Get("Content-Type") != "application/json" {
+    t.Fatal("Unexpected response:", rec.Body.String())
+  }
+
+  page.CountSkipped = true
+  rec = httptest.NewRecorder()
+  WritePaginatedResponse(rec, r, page, []string{"c", "d"})
+  if strings.Contains(rec.Body.String(), "total") ||
+     strings.Contains(rec.Body.String(), "next") {
+    t.Fatal("Unexpected response:", rec.Body.String())
+  }
+}
+
+// TestPaginateSlice tests paginating in-memory slices.
+func TestPaginateSlice(t *testing.T) {
+  items := []string{"a", "b", "c", "d", "e"}
+  tests := []struct {
+    page int64
+    expected []string
+    found bool
+  }{
+    {1, []string{"a", "b"}, true},
+    {3, []string{"e"}, true},
+    {4, []string{}, false},
+  }
+  for _, test := range tests {
+    p := PaginationRequest{Page: test.page, PerPage: 2}
+    page, result, err := PaginateSlice(items, p)
+    if err != nil || !SameElements(page.([]string), test.expected) ||
+       result.PageFound != test.found || result.QueryCount != 5 {
+      t.Fatal("Unexpected page:", test.page, page, result, err)
+    }
+  }
+
+  if _, _, err := PaginateSlice("abc", PaginationRequest{Page: 1, PerPage: 2}); err == nil {
+    t.Fatal("PaginateSlice should fail with non slices")
+  }
+}
+
+// TestPaginationConfig tests the server and route page sizes.
+func TestPaginationConfig(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{Pagination: PaginationConfig{DefaultPageSize: 50,
+                                                 MaxPageSize: 500}}
+
+  perPage := func(query string, route *PaginationConfig) int64 {
+    r, _ := http.NewRequest("GET", "/items?" + query, nil)
+    var pr *PaginationRequest
+    handler := func(w http.ResponseWriter, r *http.Request) {
+      pr, _ = NewPaginationRequest(r)
+    }
+    if route != nil {
+      newPaginationMiddleware(*route)(httptest.NewRecorder(), r, handler)
+    } else {
+      handler(nil, r)
+    }
+    return pr.PerPage
+  }
+
+  route := &PaginationConfig{MaxPageSize: 20}
+  tests := []struct {
+    query string
+    route *PaginationConfig
+    expected int64
+  }{
+    {"", nil, 50},
+    {"per_page=400", nil, 400},
+    {"per_page=600", nil, 50},
+    {"", route, 20},
+    {"per_page=10", route, 10},
+    {"per_page=30", route, 20},
+    {"per_page=30", &PaginationConfig{MaxPageSize: 20,
+                                      OversizePolicy: PageSizeClamp}, 20},
+  }
+  for _, test := range tests {
+    if got := perPage(test.query, test.route); got != test.expected {
+      t.Fatal("Unexpected page size for", test.query, test.route, got)
+    }
+  }
+
+  gServer.Pagination.OversizePolicy = PageSizeClamp
+  if got := perPage("per_page=600", nil); got != 500 {
+    t.Fatal("Page size should be clamped:", got)
+  }
+
+  gServer.Pagination.OversizePolicy = PageSizeReject
+  r, _ := http.NewRequest("GET", "/items?per_page=600", nil)
+  _, em := NewPaginationRequest(r)
+  if em == nil || em.ErrCode != ErrorInvalidPaginationRequest ||
+     !SameElements(em.Extra, []string{"per_page", "max=500"}) {
+    t.Fatal("Large page sizes should be rejected:", em)
+  }
+}
@@ -0,0 +1,157 @@
+package ign
+
+import (
+  "bytes"
+  "errors"
+  "net/http"
+  "regexp"
+  "strings"
+  "github.com/gorilla/mux"
+)
+
+// Route URIs can declare the type or format of their parameters:
+//
+//   /models/{id:int}
+//   /{owner:[a-z0-9_-]+}/models/{name}
+//
+// Supported types are "int", "uuid" and "string". Other values are used as
+// regular expressions. The formats are part of the router templates, so
+// requests with parameters that don't match fall through to other routes,
+// or get a 404, as with plain gorilla/mux patterns.
+// Parameters declared in the Route.URIParams field are also validated once
+// the route matches: requests with invalid parameters are rejected before
+// invoking the handler, with ErrorIDWrongFormat for int and uuid
+// parameters, and ErrorNameWrongFormat otherwise. The parameter is included
+// in the error's Extra field.
+
+// uriParamTypes are the patterns of the predefined parameter types.
+var uriParamTypes = map[string]string{
+  "int": `-?[0-9]+`,
+  "uuid": `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+  "string": `[^/]+`,
+}
+
+// uriParam is a URI parameter with a declared format.
+type uriParam struct {
+  name string
+  re *regexp.Regexp
+  errCode int64
+}
+
+// URIParam describes a parameter of a route URI.
+type URIParam struct {
+  Name string `json:"name"`
+  ParamDetails Detail `json:"details"`
+}
+
+// parseURIParams returns the URI in the form expected by the router, with
+// the type aliases replaced by their patterns (eg. "/models/{id:int}" gives
+// "/models/{id:-?[0-9]+}"), and the declared parameters.
+func parseURIParams(uri string) (string, []URIParam, error) {
+  var clean bytes.Buffer
+  var params []URIParam
+  for i := 0; i < len(uri); i++ {
+    if uri[i] != '{' {
+      clean.WriteByte(uri[i])
+      continue
+    }
+    // Find the matching closing brace. Patterns can include braces.
+    depth, end := 0, -1
+    for j := i; j < len(uri) && end < 0; j++ {
+      switch uri[j] {
+      case '{':
+        depth++
+      case '}':
+        if depth--; depth == 0 {
+          end = j
+        }
+      }
+    }
+    if end < 0 {
+      return "", nil, errors.New("Unbalanced braces in URI " + uri)
+    }
+
+    param := uri[i+1:end]
+    name, format := param, ""
+    if colon := strings.Index(param, ":"); colon >= 0 {
+      name, format = param[:colon], param[colon+1:]
+    }
+    i = end
+
+    if format == "" {
+      clean.WriteString("{" + name + "}")
+      continue
+    }
+    detail := Detail{Type: "string", Required: true}
+    if pattern, ok := uriParamTypes[format]; ok {
+      detail.Type = format
+      clean.WriteString("{" + name + ":" + pattern + "}")
+    } else {
+      detail.Pattern = format
+      clean.WriteString("{" + name + ":" + format + "}")
+    }
+    params = append(params, URIParam{Name: name, ParamDetails: detail})
+  }
+  return clean.String(), params, nil
+}
+
+// declareURIParams returns a copy of the given routes, with the type
+// aliases of their URIs replaced by patterns, and the parameter
+// declarations added to their URIParams. It panics if a declaration is
+// invalid.
+func declareURIParams(routes Routes) Routes {
+  result := make(Routes, len(routes))
+  for i, route := range routes {
+    uri, params, err := parseURIParams(route.URI)
+    if err == nil {
+      route.URI = uri
+      route.URIParams = append(append([]URIParam{}, route.URIParams...),
+                               params...)
+      route.uriParams, err = compileURIParams(route.URIParams)
+    }
+    if err != nil {
+      panic("Invalid URI parameters in route " + route.Name + ": " + err.Error())
+    }
+    result[i] = route
+  }
+  return result
+}
+
+// compileURIParams compiles the validations of the given parameters.
+func compileURIParams(params []URIParam) ([]uriParam, error) {
+  var result []uriParam
+  for _, p := range params {
+    pattern := p.ParamDetails.Pattern
+    errCode := int64(ErrorNameWrongFormat)
+    if pattern == "" {
+      pattern = uriParamTypes[p.ParamDetails.Type]
+      if p.ParamDetails.Type == "int" || p.ParamDetails.Type == "uuid" {
+        errCode = ErrorIDWrongFormat
+      }
+    }
+    re, err := regexp.Compile("^(?:" + pattern + ")$")
+    if err != nil {
+      return nil, err
+    }
+    result = append(result, uriParam{p.Name, re, errCode})
+  }
+  return result, nil
+}
+
+/////////////////////////////////////////////////
+// newParamsMiddleware returns a middleware that validates the URI
+// parameters of a request.
+func newParamsMiddleware(params []uriParam) func(http.ResponseWriter,
+                                                 *http.Request, http.HandlerFunc) {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    vars := mux.Vars(r)
+    for _, p := range params {
+      if value, ok := vars[p.name]; ok && !p.re.MatchString(value) {
+        em := NewErrorMessageWithArgs(p.errCode, nil, []string{p.name})
+        reportJSONError(w, r, *em)
+        return
+      }
+    }
+    next(w, r)
+  }
+}
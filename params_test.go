@@ -0,0 +1,71 @@
+package ign
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "github.com/gorilla/mux"
+)
+
+// TestParseURIParams tests parsing parameter declarations.
+func TestParseURIParams(t *testing.T) {
+  uri, params, err := parseURIParams("/{owner:[a-z]{2,}}/models/{id:int}/files/{path}")
+  if err != nil || uri != "/{owner:[a-z]{2,}}/models/{id:-?[0-9]+}/files/{path}" {
+    t.Fatal("Unexpected URI:", uri, err)
+  }
+  if len(params) != 2 || params[0].Name != "owner" ||
+     params[0].ParamDetails.Pattern != "[a-z]{2,}" ||
+     params[1].Name != "id" || params[1].ParamDetails.Type != "int" {
+    t.Fatal("Unexpected params:", params)
+  }
+
+  if _, _, err := parseURIParams("/models/{id:int"); err == nil {
+    t.Fatal("Unbalanced braces should fail")
+  }
+}
+
+// TestParamsMiddleware tests matching URI parameters in the router, and
+// rejecting invalid parameters declared in URIParams.
+func TestParamsMiddleware(t *testing.T) {
+  routes := declareURIParams(Routes{
+    {Name: "file", URI: "/{owner:[a-z]+}/models/{id:int}"},
+    {Name: "sibling", URI: "/{owner}/models/{name}"},
+    {Name: "declared", URI: "/files/{id}", URIParams: []URIParam{
+      {Name: "id", ParamDetails: Detail{Type: "int"}}}},
+  })
+  router := mux.NewRouter()
+  for _, route := range routes {
+    name, params := route.Name, route.uriParams
+    router.Path(route.URI).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      newParamsMiddleware(params)(w, r, func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(name))
+      })
+    })
+  }
+
+  tests := map[string]string{
+    "/alice/models/12": "file",
+    // Parameters that don't match fall through to the next route
+    "/alice/models/box": "sibling",
+    "/Alice/models/12": "sibling",
+    "/files/12": "declared",
+    "/files/box": `"errcode":3001`,
+  }
+  for uri, exp := range tests {
+    req, _ := http.NewRequest("GET", uri, nil)
+    rec := httptest.NewRecorder()
+    router.ServeHTTP(rec, req)
+    if !strings.Contains(rec.Body.String(), exp) {
+      t.Fatal("Unexpected response for", uri, rec.Body.String())
+    }
+  }
+
+  router = mux.NewRouter()
+  router.Path(routes[0].URI).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+  rec := httptest.NewRecorder()
+  router.ServeHTTP(rec, httptest.NewRequest("GET", "/alice/models/box", nil))
+  if rec.Code != http.StatusNotFound {
+    t.Fatal("Parameters that don't match any route should get a 404:", rec.Code)
+  }
+}
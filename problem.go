@@ -0,0 +1,69 @@
+package ign
+
+import (
+  "encoding/json"
+  "net/http"
+  "strconv"
+)
+
+// Error output formats. See Server.ErrorFormat.
+const (
+  // ErrorFormatDefault writes errors as a JSON ErrMsg
+  // ({"errcode", "msg", "extra", "errid"}).
+  ErrorFormatDefault = "errmsg"
+  // ErrorFormatProblem writes errors as RFC 7807 application/problem+json
+  // documents.
+  ErrorFormatProblem = "problem"
+)
+
+// ProblemDetails is the RFC 7807 representation of an ErrMsg. The error
+// code, extra arguments and error ID are included as extension members.
+type ProblemDetails struct {
+  Type string `json:"type"`
+  Title string `json:"title"`
+  Status int `json:"status"`
+  Detail string `json:"detail,omitempty"`
+  Instance string `json:"instance"`
+  ErrCode int `json:"errcode"`
+  Extra []string `json:"extra,omitempty"`
+}
+
+// NewProblemDetails converts an ErrMsg to its RFC 7807 representation. The
+// type is the server's ProblemTypeBaseURL followed by the error code, or
+// "about:blank" if no base URL is set.
+func NewProblemDetails(errMsg ErrMsg) ProblemDetails {
+  p := ProblemDetails{
+    Type: "about:blank",
+    Title: errMsg.Msg,
+    Status: errMsg.StatusCode,
+    Instance: "urn:uuid:" + errMsg.ErrID,
+    ErrCode: errMsg.ErrCode,
+    Extra: errMsg.Extra,
+  }
+  if gServer != nil && gServer.ProblemTypeBaseURL != "" {
+    p.Type = gServer.ProblemTypeBaseURL + strconv.Itoa(errMsg.ErrCode)
+  }
+  if errMsg.BaseError != nil && errMsg.StatusCode < 500 {
+    p.Detail = errMsg.BaseError.Error()
+  }
+  return p
+}
+
+// useProblemFormat returns true if errors are written as problem+json.
+func useProblemFormat() bool {
+  return gServer != nil && gServer.ErrorFormat == ErrorFormatProblem
+}
+
+// writeProblem writes an ErrMsg as an application/problem+json response.
+func writeProblem(w http.ResponseWriter, errMsg ErrMsg) {
+  output, err := json.Marshal(NewProblemDetails(errMsg))
+  if err != nil {
+    reportError(w, "Unable to marshal JSON", http.StatusServiceUnavailable)
+    return
+  }
+  w.Header().Set("Content-Type", "application/problem+json")
+  w.Header().Set("X-Content-Type-Options", "nosniff")
+  w.WriteHeader(errMsg.StatusCode)
+  w.Write(output)
+  w.Write([]byte("\n"))
+}
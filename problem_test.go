@@ -0,0 +1,55 @@
+package ign
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+)
+
+// TestProblemErrorFormat tests writing errors as application/problem+json.
+func TestProblemErrorFormat(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{ErrorFormat: ErrorFormatProblem,
+                    ProblemTypeBaseURL: "https://example.com/errors/"}
+
+  rec := httptest.NewRecorder()
+  em := NewErrorMessageWithArgs(ErrorIDWrongFormat, errors.New("bad id"),
+                                []string{"id"})
+  req, _ := http.NewRequest("GET", "/models/x", nil)
+  reportJSONError(rec, req, *em)
+
+  if rec.Code != http.StatusBadRequest ||
+     rec.Header().Get("Content-Type") != "application/problem+json" {
+    t.Fatal("Unexpected response:", rec.Code, rec.Header())
+  }
+  var p ProblemDetails
+  if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+    t.Fatal("Invalid problem document:", err)
+  }
+  expected := ProblemDetails{
+    Type: "https://example.com/errors/3001",
+    Title: em.Msg,
+    Status: http.StatusBadRequest,
+    Detail: "bad id",
+    Instance: "urn:uuid:" + em.ErrID,
+    ErrCode: ErrorIDWrongFormat,
+    Extra: []string{"id"},
+  }
+  if p.Type != expected.Type || p.Title != expected.Title ||
+     p.Status != expected.Status || p.Detail != expected.Detail ||
+     p.Instance != expected.Instance || p.ErrCode != expected.ErrCode ||
+     len(p.Extra) != 1 {
+    t.Fatal("Unexpected problem document:", p)
+  }
+
+  // Details of server errors are not exposed
+  gServer.ProblemTypeBaseURL = ""
+  p = NewProblemDetails(*NewErrorMessageWithBase(ErrorDbSave,
+                                                 errors.New("secret")))
+  if p.Detail != "" || p.Type != "about:blank" {
+    t.Fatal("Unexpected problem document:", p)
+  }
+}
@@ -0,0 +1,100 @@
+package ign
+
+import (
+  "errors"
+  "net"
+  "net/http"
+  "strings"
+)
+
+// Servers running behind load balancers or reverse proxies receive the
+// requests from the proxy, and the address of the client is sent in the
+// X-Forwarded-For or X-Real-IP headers. As these headers can be set by any
+// client, they are only used when the peer is a trusted proxy. See
+// SetTrustedProxies and ClientIP.
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For and X-Real-IP
+// headers are used to get the client IP. Each entry is an IP address or a
+// CIDR range (eg. "10.0.0.0/8"). An empty list ignores those headers.
+func (s *Server) SetTrustedProxies(cidrs []string) error {
+  nets := make([]*net.IPNet, 0, len(cidrs))
+  for _, cidr := range cidrs {
+    cidr = strings.TrimSpace(cidr)
+    if !strings.Contains(cidr, "/") {
+      ip := net.ParseIP(cidr)
+      if ip == nil {
+        return errors.New("Invalid trusted proxy [" + cidr + "]")
+      }
+      bits := 8 * net.IPv6len
+      if ip.To4() != nil {
+        ip = ip.To4()
+        bits = 8 * net.IPv4len
+      }
+      nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+      continue
+    }
+    _, n, err := net.ParseCIDR(cidr)
+    if err != nil {
+      return errors.New("Invalid trusted proxy [" + cidr + "]")
+    }
+    nets = append(nets, n)
+  }
+  s.TrustedProxies = cidrs
+  s.trustedProxyNets = nets
+  return nil
+}
+
+// isTrustedProxy returns true if the given IP belongs to a trusted proxy.
+func (s *Server) isTrustedProxy(ip string) bool {
+  parsed := net.ParseIP(ip)
+  if parsed == nil {
+    return false
+  }
+  for _, n := range s.trustedProxyNets {
+    if n.Contains(parsed) {
+      return true
+    }
+  }
+  return false
+}
+
+// ClientIP returns the IP address of the client that sent the request. If
+// the peer is a trusted proxy, the X-Forwarded-For header is traversed from
+// the right, skipping trusted proxies, and the first untrusted address is
+// returned. X-Real-IP is used if there is no X-Forwarded-For header.
+func ClientIP(r *http.Request) string {
+  peer := remoteIP(r)
+  if gServer == nil || !gServer.isTrustedProxy(peer) {
+    return peer
+  }
+
+  if forwarded, ok := r.Header["X-Forwarded-For"]; ok {
+    ips := strings.Split(strings.Join(forwarded, ","), ",")
+    for i := len(ips) - 1; i >= 0; i-- {
+      ip := strings.TrimSpace(ips[i])
+      // Stop at malformed entries, and use the last trusted hop
+      if net.ParseIP(ip) == nil {
+        break
+      }
+      if !gServer.isTrustedProxy(ip) {
+        return ip
+      }
+      peer = ip
+    }
+    return peer
+  }
+
+  if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+    return ip
+  }
+  return peer
+}
+
+// remoteIP returns the IP address of the peer that sent the request.
+func remoteIP(r *http.Request) string {
+  host, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    return r.RemoteAddr
+  }
+  return host
+}
@@ -0,0 +1,49 @@
+package ign
+
+import (
+  "net/http"
+  "testing"
+)
+
+// TestClientIP tests reading the client IP from trusted proxies only.
+func TestClientIP(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+  if err := gServer.SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+    t.Fatal("Unexpected error:", err)
+  }
+
+  testData := []struct {
+    remote string
+    forwarded string
+    realIP string
+    exp string
+  }{
+    {"203.0.113.5:1234", "", "", "203.0.113.5"},
+    // Headers from untrusted peers are ignored
+    {"203.0.113.5:1234", "198.51.100.1", "198.51.100.2", "203.0.113.5"},
+    {"10.1.2.3:1234", "198.51.100.1", "", "198.51.100.1"},
+    // Trusted hops are skipped, and spoofed entries on the left ignored
+    {"10.1.2.3:1234", "1.1.1.1, 198.51.100.1, 192.168.1.1", "", "198.51.100.1"},
+    {"10.1.2.3:1234", "198.51.100.1, garbage, 10.0.0.2", "", "10.0.0.2"},
+    {"192.168.1.1:1234", "", "198.51.100.2", "198.51.100.2"},
+  }
+  for _, test := range testData {
+    req, _ := http.NewRequest("GET", "/1.0/models", nil)
+    req.RemoteAddr = test.remote
+    if test.forwarded != "" {
+      req.Header.Set("X-Forwarded-For", test.forwarded)
+    }
+    if test.realIP != "" {
+      req.Header.Set("X-Real-IP", test.realIP)
+    }
+    if ip := ClientIP(req); ip != test.exp {
+      t.Fatal("Unexpected client IP. Exp:", test.exp, "Got:", ip, "Test:", test)
+    }
+  }
+
+  if err := gServer.SetTrustedProxies([]string{"not-an-ip"}); err == nil {
+    t.Fatal("Expected an error with an invalid trusted proxy")
+  }
+}
@@ -0,0 +1,335 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "io"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+  "github.com/codegangsta/negroni"
+  "gorm.io/gorm"
+  "github.com/redis/go-redis/v9"
+)
+
+// Routes can limit the usage of each client by setting the Route.Quota
+// field. Unlike the rate limit, quotas count the requests (and uploaded
+// bytes) made by each client in fixed time windows (eg. 1000 requests per
+// day), and the counters can be shared by several server instances using a
+// database or Redis QuotaStore. Clients are identified like in rate limits.
+//
+// Responses include the X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (unix time of the window end) headers of the requests
+// quota, and X-Quota-Bytes-Limit and X-Quota-Bytes-Remaining of the bytes
+// quota. Requests exceeding the quota are rejected with ErrorQuotaExceeded
+// (HTTP 429) or ErrorUploadQuotaExceeded (HTTP 413).
+
+// Quota configures the usage quota of a route.
+type Quota struct {
+  // Name of the counters. Routes with the same name share the quota.
+  // Defaults to the route name.
+  Name string `json:"name,omitempty"`
+  // Max number of requests per window. Zero means no limit.
+  Requests int64 `json:"requests,omitempty"`
+  // Max number of uploaded bytes (request bodies) per window. Zero means
+  // no limit.
+  Bytes int64 `json:"bytes,omitempty"`
+  // Window is the duration of the quota period (eg. 24h). It must be
+  // positive.
+  Window time.Duration `json:"window"`
+}
+
+// QuotaUsage is the usage of a client in a quota window.
+type QuotaUsage struct {
+  Requests int64
+  Bytes int64
+}
+
+// QuotaStore keeps the quota usage counters.
+type QuotaStore interface {
+  // Add increments the counters of key in the window that starts at the
+  // given time, and returns the updated usage. Counters can be discarded
+  // once the window ends.
+  Add(ctx context.Context, key string, start time.Time, window time.Duration,
+      usage QuotaUsage) (QuotaUsage, error)
+}
+
+// defaultQuotaStore is used when Server.QuotaStore is not set.
+var defaultQuotaStore = NewMemoryQuotaStore()
+
+// NewQuotaStoreFromEnvVars creates the QuotaStore selected with the
+// IGN_QUOTA_STORE env var. Supported values are "memory", "db" (the server
+// database) and "redis" (using the given Redis client, see
+// NewRedisFromEnvVars). It returns nil if IGN_QUOTA_STORE is not set.
+func NewQuotaStoreFromEnvVars(r *Redis) (QuotaStore, error) {
+  kind, err := ReadEnvVar("IGN_QUOTA_STORE")
+  if err != nil {
+    return nil, nil
+  }
+
+  switch kind {
+  case "memory":
+    return NewMemoryQuotaStore(), nil
+  case "db":
+    return &DBQuotaStore{}, nil
+  case "redis":
+    if r == nil {
+      return nil, ErrNoRedis
+    }
+    return NewRedisQuotaStore(r), nil
+  }
+  return nil, errors.New("Unknown IGN_QUOTA_STORE [" + kind + "]")
+}
+
+// quotaStore returns the QuotaStore of the server.
+func quotaStore() QuotaStore {
+  if gServer != nil && gServer.QuotaStore != nil {
+    return gServer.QuotaStore
+  }
+  return defaultQuotaStore
+}
+
+// quotaKey returns the key of the counters of a window.
+func quotaKey(key string, start time.Time) string {
+  return key + ":" + strconv.FormatInt(start.Unix(), 10)
+}
+
+/////////////////////////////////////////////////
+
+// MemoryQuotaStore is a QuotaStore that keeps the counters in memory. It
+// is only suitable for a single server instance.
+type MemoryQuotaStore struct {
+  mutex sync.Mutex
+  usages map[string]*memoryQuotaUsage
+  lastSweep time.Time
+}
+
+// memoryQuotaUsage is a counter of MemoryQuotaStore.
+type memoryQuotaUsage struct {
+  QuotaUsage
+  end time.Time
+}
+
+// NewMemoryQuotaStore creates an empty MemoryQuotaStore.
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+  return &MemoryQuotaStore{usages: map[string]*memoryQuotaUsage{},
+                           lastSweep: clock().Now()}
+}
+
+// Add increments the counters, and removes the ones of ended windows.
+func (s *MemoryQuotaStore) Add(ctx context.Context, key string, start time.Time,
+                               window time.Duration,
+                               usage QuotaUsage) (QuotaUsage, error) {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  now := clock().Now()
+  if now.Sub(s.lastSweep) > rateLimitSweepInterval {
+    for k, u := range s.usages {
+      if now.After(u.end) {
+        delete(s.usages, k)
+      }
+    }
+    s.lastSweep = now
+  }
+
+  k := quotaKey(key, start)
+  u, ok := s.usages[k]
+  if !ok {
+    u = &memoryQuotaUsage{end: start.Add(window)}
+    s.usages[k] = u
+  }
+  u.Requests += usage.Requests
+  u.Bytes += usage.Bytes
+  return u.QuotaUsage, nil
+}
+
+/////////////////////////////////////////////////
+
+// DBQuotaStore is a QuotaStore that keeps the counters in the
+// ign_quota_usages database table, created if needed.
+type DBQuotaStore struct {
+  // DB is the database. If nil, the server database is used.
+  DB *gorm.DB
+  migrateOnce sync.Once
+  migrateErr error
+}
+
+// quotaUsage is a row of the ign_quota_usages table.
+type quotaUsage struct {
+  Name string `gorm:"primaryKey;size:255"`
+  Requests int64
+  Bytes int64
+  ExpiresAt time.Time `gorm:"index"`
+}
+
+// TableName returns the name of the quota usages table.
+func (quotaUsage) TableName() string {
+  return "ign_quota_usages"
+}
+
+// Add increments the counters with an UPDATE, inserting the row of the
+// window if needed. Expired rows are removed when a window starts.
+func (s *DBQuotaStore) Add(ctx context.Context, key string, start time.Time,
+                           window time.Duration,
+                           usage QuotaUsage) (QuotaUsage, error) {
+  db := s.DB
+  if db == nil {
+    db = dbFromContext(ctx)
+  }
+  if db == nil {
+    return QuotaUsage{}, errors.New("No database for the quota store")
+  }
+  s.migrateOnce.Do(func() {
+    s.migrateErr = db.AutoMigrate(&quotaUsage{})
+  })
+  if s.migrateErr != nil {
+    return QuotaUsage{}, s.migrateErr
+  }
+  db = db.WithContext(ctx)
+
+  k := quotaKey(key, start)
+  for attempt := 0; attempt < 2; attempt++ {
+    q := db.Model(&quotaUsage{}).Where("name = ?", k).Updates(map[string]interface{}{
+      "requests": gorm.Expr("requests + ?", usage.Requests),
+      "bytes": gorm.Expr("bytes + ?", usage.Bytes),
+    })
+    if q.Error != nil {
+      return QuotaUsage{}, q.Error
+    }
+    if q.RowsAffected == 0 {
+      db.Where("expires_at < ?", clock().Now()).Delete(&quotaUsage{})
+      row := quotaUsage{Name: k, Requests: usage.Requests, Bytes: usage.Bytes,
+                        ExpiresAt: start.Add(window)}
+      // The insert fails if another instance created the row first.
+      if err := db.Create(&row).Error; err != nil {
+        continue
+      }
+    }
+    var row quotaUsage
+    if err := db.Where("name = ?", k).First(&row).Error; err != nil {
+      return QuotaUsage{}, err
+    }
+    return QuotaUsage{Requests: row.Requests, Bytes: row.Bytes}, nil
+  }
+  return QuotaUsage{}, errors.New("Unable to create quota usage [" + k + "]")
+}
+
+/////////////////////////////////////////////////
+
+// RedisQuotaStore is a QuotaStore that keeps the counters in Redis hashes
+// that expire with their window.
+type RedisQuotaStore struct {
+  Client redis.UniversalClient
+  // Prefix of the Redis keys (eg. "ign:quota:").
+  Prefix string
+}
+
+// NewRedisQuotaStore creates a RedisQuotaStore using the given client.
+func NewRedisQuotaStore(client redis.UniversalClient) *RedisQuotaStore {
+  return &RedisQuotaStore{Client: client, Prefix: "ign:quota:"}
+}
+
+// Add increments the counters in a transaction.
+func (s *RedisQuotaStore) Add(ctx context.Context, key string, start time.Time,
+                              window time.Duration,
+                              usage QuotaUsage) (QuotaUsage, error) {
+  k := s.Prefix + quotaKey(key, start)
+  var requests, bytes *redis.IntCmd
+  _, err := s.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+    requests = p.HIncrBy(ctx, k, "requests", usage.Requests)
+    bytes = p.HIncrBy(ctx, k, "bytes", usage.Bytes)
+    p.ExpireAt(ctx, k, start.Add(window))
+    return nil
+  })
+  if err != nil {
+    return QuotaUsage{}, err
+  }
+  return QuotaUsage{Requests: requests.Val(), Bytes: bytes.Val()}, nil
+}
+
+/////////////////////////////////////////////////
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+  io.ReadCloser
+  n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+  n, err := c.ReadCloser.Read(p)
+  c.n += int64(n)
+  return n, err
+}
+
+/////////////////////////////////////////////////
+
+// newQuotaMiddleware returns a middleware that enforces the given quota. It
+// must run after the JWT middleware, to be able to identify authenticated
+// users. The bytes of bodies with unknown length are counted once read.
+// It panics if the quota window is not positive, as every request would
+// get its own counters.
+func newQuotaMiddleware(quota Quota, routeName string) negroni.HandlerFunc {
+  if quota.Window <= 0 {
+    panic("Invalid quota window in route " + routeName + ": " +
+          quota.Window.String())
+  }
+  if quota.Name == "" {
+    quota.Name = routeName
+  }
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    store := quotaStore()
+    now := clock().Now()
+    start := now.Truncate(quota.Window)
+    key := quota.Name + ":" + rateLimitKey(r)
+
+    var length int64
+    if quota.Bytes > 0 && r.ContentLength > 0 {
+      length = r.ContentLength
+    }
+    usage, err := store.Add(r.Context(), key, start, quota.Window,
+                            QuotaUsage{Requests: 1, Bytes: length})
+    if err != nil {
+      // Don't block the service if the store is unavailable
+      gLogger.Error("Unable to update quota", Fields{"quota": quota.Name,
+                                                     "error": err})
+      next(w, r)
+      return
+    }
+
+    h := w.Header()
+    h.Set("X-RateLimit-Reset", strconv.FormatInt(start.Add(quota.Window).Unix(), 10))
+    if quota.Requests > 0 {
+      h.Set("X-RateLimit-Limit", strconv.FormatInt(quota.Requests, 10))
+      h.Set("X-RateLimit-Remaining",
+            strconv.FormatInt(Max(0, quota.Requests - usage.Requests), 10))
+    }
+    if quota.Bytes > 0 {
+      h.Set("X-Quota-Bytes-Limit", strconv.FormatInt(quota.Bytes, 10))
+      h.Set("X-Quota-Bytes-Remaining",
+            strconv.FormatInt(Max(0, quota.Bytes - usage.Bytes), 10))
+    }
+
+    if quota.Requests > 0 && usage.Requests > quota.Requests {
+      reportJSONError(w, r, ErrorMessage(ErrorQuotaExceeded))
+      return
+    }
+    if quota.Bytes > 0 && usage.Bytes > quota.Bytes {
+      // Rejected uploads don't consume the quota
+      store.Add(r.Context(), key, start, quota.Window, QuotaUsage{Bytes: -length})
+      reportJSONError(w, r, ErrorMessage(ErrorUploadQuotaExceeded))
+      return
+    }
+
+    if quota.Bytes > 0 && r.ContentLength < 0 && r.Body != nil {
+      body := &countingReader{ReadCloser: r.Body}
+      r.Body = body
+      defer func() {
+        store.Add(r.Context(), key, start, quota.Window,
+                  QuotaUsage{Bytes: body.n})
+      }()
+    }
+    next(w, r)
+  }
+}
@@ -0,0 +1,99 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+  "github.com/codegangsta/negroni"
+)
+
+// TestMemoryQuotaStore tests the counters of the in-memory quota store.
+func TestMemoryQuotaStore(t *testing.T) {
+  s := NewMemoryQuotaStore()
+  ctx := context.Background()
+  start := time.Now().Truncate(time.Hour)
+
+  s.Add(ctx, "a", start, time.Hour, QuotaUsage{Requests: 1, Bytes: 10})
+  u, _ := s.Add(ctx, "a", start, time.Hour, QuotaUsage{Requests: 1, Bytes: 5})
+  if u.Requests != 2 || u.Bytes != 15 {
+    t.Fatal("Unexpected usage:", u)
+  }
+  // Other windows and keys have their own counters
+  u, _ = s.Add(ctx, "a", start.Add(time.Hour), time.Hour, QuotaUsage{Requests: 1})
+  if u.Requests != 1 {
+    t.Fatal("A new window should start from zero. Got:", u)
+  }
+  u, _ = s.Add(ctx, "b", start, time.Hour, QuotaUsage{Requests: 1})
+  if u.Requests != 1 {
+    t.Fatal("A different key should start from zero. Got:", u)
+  }
+}
+
+// TestQuotaMiddleware tests the quota headers and the rejected requests.
+func TestQuotaMiddleware(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  clk := NewManualClock(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+  gServer = &Server{Clock: clk, QuotaStore: NewMemoryQuotaStore()}
+
+  quota := Quota{Requests: 2, Bytes: 8, Window: time.Hour}
+  handler := negroni.New(
+    newQuotaMiddleware(quota, "TestQuotaMiddleware"),
+    negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+  )
+
+  send := func(body string) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("POST", "/1.0/models", bytes.NewBufferString(body))
+    req.RemoteAddr = "10.0.0.1:1234"
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    return rec
+  }
+  errCode := func(rec *httptest.ResponseRecorder) int64 {
+    var errMsg ErrMsg
+    json.Unmarshal(rec.Body.Bytes(), &errMsg)
+    return errMsg.ErrCode
+  }
+
+  rec := send("12345")
+  if rec.Code != http.StatusOK {
+    t.Fatal("First request should succeed. Got:", rec.Code)
+  }
+  if rec.Header().Get("X-RateLimit-Limit") != "2" ||
+     rec.Header().Get("X-RateLimit-Remaining") != "1" ||
+     rec.Header().Get("X-RateLimit-Reset") == "" ||
+     rec.Header().Get("X-Quota-Bytes-Remaining") != "3" {
+    t.Fatal("Unexpected quota headers:", rec.Header())
+  }
+  // The upload exceeds the remaining bytes
+  rec = send("12345")
+  if rec.Code != http.StatusRequestEntityTooLarge ||
+     errCode(rec) != ErrorUploadQuotaExceeded {
+    t.Fatal("Upload should exceed the quota. Got:", rec.Code, rec.Body.String())
+  }
+  // Rejected uploads don't consume bytes, but count as requests
+  rec = send("123")
+  if rec.Code != http.StatusTooManyRequests || errCode(rec) != ErrorQuotaExceeded {
+    t.Fatal("Request should exceed the quota. Got:", rec.Code, rec.Body.String())
+  }
+  if rec.Header().Get("X-Quota-Bytes-Remaining") != "0" {
+    t.Fatal("Unexpected bytes remaining:", rec.Header().Get("X-Quota-Bytes-Remaining"))
+  }
+
+  // The counters start again in the next window
+  clk.Add(time.Hour)
+  if rec = send("123"); rec.Code != http.StatusOK {
+    t.Fatal("The quota should be reset in a new window. Got:", rec.Code)
+  }
+
+  defer func() {
+    if recover() == nil {
+      t.Fatal("Quotas without window should be rejected")
+    }
+  }()
+  newQuotaMiddleware(Quota{Requests: 10}, "TestQuotaMiddleware")
+}
@@ -0,0 +1,231 @@
+package ign
+
+import (
+  "context"
+  "math"
+  "net/http"
+  "strconv"
+  "sync"
+  "sync/atomic"
+  "time"
+  "github.com/redis/go-redis/v9"
+)
+
+// Routes can be rate limited by setting the Route.RateLimit field. Requests
+// are limited using a token bucket per client. Clients are identified by the
+// JWT subject when the request is authenticated, or by their IP (see
+// ClientIP) otherwise. Anonymous IDs are not used, as clients can get a new
+// one by changing their User-Agent.
+// Requests exceeding the limit are rejected with ErrorRateLimited (HTTP 429)
+// and a Retry-After header.
+//
+// The buckets are kept in memory, or in Redis if Server.Redis is set, so all
+// the server instances share the limit. Redis buckets use the Redis clock, so
+// the instances agree on the refill even if their clocks are skewed. If
+// Redis fails, the in-memory bucket is used.
+//
+// All the methods of a named route share a limit. Routes without name have
+// a limit per method and URI.
+
+// rateLimitSweepInterval is how often idle buckets are removed.
+const rateLimitSweepInterval = time.Minute
+
+// rateLimitWarnInterval is the min time between two warnings about Redis
+// failures of a rate limiter.
+const rateLimitWarnInterval = time.Minute
+
+// RateLimit configures the rate limit of a route.
+type RateLimit struct {
+  // Number of requests per second allowed for each client.
+  Rate float64 `json:"rate"`
+  // Max number of requests a client can make at once. Defaults to Rate
+  // (rounded up, and at least 1).
+  Burst int `json:"burst,omitempty"`
+}
+
+// tokenBucket holds the available tokens of a client.
+type tokenBucket struct {
+  tokens float64
+  last time.Time
+}
+
+// rateLimiter keeps a token bucket per client.
+type rateLimiter struct {
+  // name prefixes the keys of the Redis buckets.
+  name string
+  rate float64
+  burst float64
+  mutex sync.Mutex
+  buckets map[string]*tokenBucket
+  lastSweep time.Time
+  // lastWarn is the unix time, in nanoseconds, of the last Redis warning.
+  lastWarn int64
+}
+
+// newRateLimiter creates a rateLimiter from a RateLimit configuration.
+func newRateLimiter(name string, limit RateLimit) *rateLimiter {
+  burst := float64(limit.Burst)
+  if burst <= 0 {
+    burst = math.Max(1, math.Ceil(limit.Rate))
+  }
+  return &rateLimiter{
+    name: name,
+    rate: limit.Rate,
+    burst: burst,
+    buckets: make(map[string]*tokenBucket),
+    lastSweep: clock().Now(),
+  }
+}
+
+// allow takes a token from the bucket of the given client. If no token is
+// available it returns false, and the time to wait until the next token.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+  l.mutex.Lock()
+  defer l.mutex.Unlock()
+
+  if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+    l.sweep(now)
+  }
+
+  b, ok := l.buckets[key]
+  if !ok {
+    b = &tokenBucket{tokens: l.burst, last: now}
+    l.buckets[key] = b
+  } else {
+    b.tokens = l.refill(b, now)
+    b.last = now
+  }
+
+  if b.tokens >= 1 {
+    b.tokens--
+    return true, 0
+  }
+  if l.rate <= 0 {
+    return false, rateLimitSweepInterval
+  }
+  wait := (1 - b.tokens) / l.rate
+  return false, time.Duration(wait * float64(time.Second))
+}
+
+// redisTokenBucket takes a token from a bucket stored in a Redis hash. It
+// returns 1 and the remaining tokens if a token was taken, or 0 and the
+// available tokens otherwise. Idle buckets expire once they are full.
+var redisTokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+local b = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(b[1]) or burst
+local last = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(now))
+local ttl = 60
+if rate > 0 then
+  ttl = math.ceil(burst / rate) + 1
+end
+redis.call("EXPIRE", KEYS[1], ttl)
+return {allowed, tostring(tokens)}
+`)
+
+// allowRedis is like allow, but using a bucket stored in Redis, and the
+// Redis time.
+func (l *rateLimiter) allowRedis(ctx context.Context, client redis.Scripter,
+                                 key string) (bool, time.Duration, error) {
+  res, err := redisTokenBucket.Run(ctx, client,
+    []string{"ign:ratelimit:" + l.name + ":" + key},
+    strconv.FormatFloat(l.rate, 'f', -1, 64),
+    strconv.FormatFloat(l.burst, 'f', -1, 64)).Slice()
+  if err != nil {
+    return false, 0, err
+  }
+  if allowed, _ := res[0].(int64); allowed == 1 {
+    return true, 0, nil
+  }
+  if l.rate <= 0 {
+    return false, rateLimitSweepInterval, nil
+  }
+  s, _ := res[1].(string)
+  tokens, err := strconv.ParseFloat(s, 64)
+  if err != nil {
+    return false, 0, err
+  }
+  wait := (1 - tokens) / l.rate
+  return false, time.Duration(wait * float64(time.Second)), nil
+}
+
+// warnRedis logs a Redis failure, at most once per rateLimitWarnInterval,
+// so a Redis outage doesn't flood the logs.
+func (l *rateLimiter) warnRedis(err error, now time.Time) {
+  last := atomic.LoadInt64(&l.lastWarn)
+  if now.UnixNano() - last < int64(rateLimitWarnInterval) ||
+     !atomic.CompareAndSwapInt64(&l.lastWarn, last, now.UnixNano()) {
+    return
+  }
+  gLogger.Warn("Unable to use Redis rate limit. Using the in-memory limit.",
+               Fields{"error": err, "limiter": l.name})
+}
+
+// refill returns the tokens available in a bucket at the given time.
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+  elapsed := now.Sub(b.last).Seconds()
+  return math.Min(l.burst, b.tokens + elapsed * l.rate)
+}
+
+// sweep removes the buckets that are full, as they are equivalent to a new
+// bucket. This keeps memory bounded when many clients make few requests.
+func (l *rateLimiter) sweep(now time.Time) {
+  for key, b := range l.buckets {
+    if l.refill(b, now) >= l.burst {
+      delete(l.buckets, key)
+    }
+  }
+  l.lastSweep = now
+}
+
+// rateLimitKey returns the key used to identify the client of a request.
+func rateLimitKey(r *http.Request) string {
+  if identity, ok := GetUserIdentity(r); ok {
+    return "user:" + identity
+  }
+  return "ip:" + ClientIP(r)
+}
+
+/////////////////////////////////////////////////
+
+// newRateLimitMiddleware returns a middleware that rejects requests exceeding
+// the given rate limiter. It must run after the JWT middleware, to be able to
+// identify authenticated users.
+func newRateLimitMiddleware(l *rateLimiter) func(http.ResponseWriter,
+                                                *http.Request, http.HandlerFunc) {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    key := rateLimitKey(r)
+    now := clock().Now()
+    allowed, wait := false, time.Duration(0)
+    var err error
+    if gServer != nil && gServer.Redis != nil {
+      allowed, wait, err = l.allowRedis(r.Context(), gServer.Redis, key)
+      if err != nil {
+        l.warnRedis(err, now)
+      }
+    }
+    if gServer == nil || gServer.Redis == nil || err != nil {
+      allowed, wait = l.allow(key, now)
+    }
+    if !allowed {
+      seconds := int(math.Ceil(wait.Seconds()))
+      if seconds < 1 {
+        seconds = 1
+      }
+      w.Header().Set("Retry-After", strconv.Itoa(seconds))
+      reportJSONError(w, r, ErrorMessage(ErrorRateLimited))
+      return
+    }
+    next(w, r)
+  }
+}
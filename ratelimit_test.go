@@ -0,0 +1,111 @@
+package ign
+
+import (
+  "context"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+  "github.com/alicebob/miniredis/v2"
+  "github.com/codegangsta/negroni"
+  "github.com/redis/go-redis/v9"
+)
+
+// TestRateLimiter tests the token bucket refill and burst.
+func TestRateLimiter(t *testing.T) {
+  l := newRateLimiter("test", RateLimit{Rate: 2, Burst: 3})
+  now := time.Now()
+
+  for i := 0; i < 3; i++ {
+    if ok, _ := l.allow("a", now); !ok {
+      t.Fatal("Request within the burst was rejected:", i)
+    }
+  }
+  ok, wait := l.allow("a", now)
+  if ok || wait != 500 * time.Millisecond {
+    t.Fatal("Request exceeding the burst should wait 500ms. Got:", ok, wait)
+  }
+  // Other clients have their own bucket
+  if ok, _ := l.allow("b", now); !ok {
+    t.Fatal("Request from a different client was rejected")
+  }
+  // A token is available after 1/rate seconds
+  if ok, _ := l.allow("a", now.Add(500 * time.Millisecond)); !ok {
+    t.Fatal("Request was rejected after the bucket was refilled")
+  }
+  // Full buckets are removed
+  l.allow("a", now.Add(2 * rateLimitSweepInterval))
+  if len(l.buckets) != 1 {
+    t.Fatal("Idle buckets were not removed:", len(l.buckets))
+  }
+}
+
+// TestRateLimiterRedis tests the token bucket stored in Redis, which uses
+// the Redis clock.
+func TestRateLimiterRedis(t *testing.T) {
+  mr := miniredis.RunT(t)
+  client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+  defer client.Close()
+  now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+  mr.SetTime(now)
+  l := newRateLimiter("test", RateLimit{Rate: 2, Burst: 3})
+  ctx := context.Background()
+
+  for i := 0; i < 3; i++ {
+    if ok, _, err := l.allowRedis(ctx, client, "a"); !ok || err != nil {
+      t.Fatal("Request within the burst was rejected:", i, err)
+    }
+  }
+  ok, wait, err := l.allowRedis(ctx, client, "a")
+  if ok || err != nil || wait != 500 * time.Millisecond {
+    t.Fatal("Request exceeding the burst should wait 500ms. Got:", ok, wait,
+            err)
+  }
+  if ok, _, _ := l.allowRedis(ctx, client, "b"); !ok {
+    t.Fatal("Request from a different client was rejected")
+  }
+  mr.SetTime(now.Add(500 * time.Millisecond))
+  if ok, _, err := l.allowRedis(ctx, client, "a"); !ok || err != nil {
+    t.Fatal("Request was rejected after the bucket was refilled:", err)
+  }
+  if ttl := mr.TTL("ign:ratelimit:test:a"); ttl <= 0 {
+    t.Fatal("Buckets should expire:", ttl)
+  }
+}
+
+// TestRateLimitMiddleware tests that requests exceeding the limit get a 429.
+func TestRateLimitMiddleware(t *testing.T) {
+  limiter := newRateLimiter("test", RateLimit{Rate: 0.1})
+  handler := negroni.New(
+    negroni.HandlerFunc(newRateLimitMiddleware(limiter)),
+    negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+  )
+
+  send := func(addr string) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("GET", "/1.0/models", nil)
+    req.RemoteAddr = addr
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    return rec
+  }
+
+  if rec := send("10.0.0.1:1234"); rec.Code != http.StatusOK {
+    t.Fatal("First request should succeed. Got:", rec.Code)
+  }
+  rec := send("10.0.0.1:5678")
+  if rec.Code != http.StatusTooManyRequests {
+    t.Fatal("Second request should be rate limited. Got:", rec.Code)
+  }
+  if rec.Header().Get("Retry-After") != "10" {
+    t.Fatal("Unexpected Retry-After header:", rec.Header().Get("Retry-After"))
+  }
+  var errMsg ErrMsg
+  if err := json.Unmarshal(rec.Body.Bytes(), &errMsg); err != nil ||
+     errMsg.ErrCode != ErrorRateLimited {
+    t.Fatal("Unexpected error response:", rec.Body.String())
+  }
+  if rec := send("10.0.0.2:1234"); rec.Code != http.StatusOK {
+    t.Fatal("Request from another IP should succeed. Got:", rec.Code)
+  }
+}
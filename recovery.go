@@ -0,0 +1,39 @@
+package ign
+
+import (
+  "fmt"
+  "net/http"
+  "runtime/debug"
+)
+
+/////////////////////////////////////////////////
+// panicRecoveryMiddleware recovers from panics in the next handlers,
+// logging the stack trace and replying with an ErrorInternalPanic error.
+// The stack trace is included in the response only if Server.DebugErrors
+// is set.
+func panicRecoveryMiddleware(w http.ResponseWriter, r *http.Request,
+                             next http.HandlerFunc) {
+  defer func() {
+    p := recover()
+    if p == nil {
+      return
+    }
+    if p == http.ErrAbortHandler {
+      panic(p)
+    }
+    stack := string(debug.Stack())
+    em := NewErrorMessageWithBase(ErrorInternalPanic, fmt.Errorf("panic: %v", p))
+    gLogger.Error(fmt.Sprintf("PANIC: %v", p), Fields{
+      "errid": em.ErrID,
+      "request_id": RequestID(r),
+      "uri": r.RequestURI,
+      "stack": stack,
+    })
+    em.Extra = []string{"request_id: " + RequestID(r)}
+    if gServer != nil && gServer.DebugErrors {
+      em.Extra = append(em.Extra, stack)
+    }
+    reportJSONError(w, r, *em)
+  }()
+  next(w, r)
+}
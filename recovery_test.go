@@ -0,0 +1,68 @@
+package ign
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// TestPanicRecovery tests replying ErrorInternalPanic when a handler panics.
+func TestPanicRecovery(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+
+  send := func() (*httptest.ResponseRecorder, ErrMsg) {
+    req, _ := http.NewRequest("GET", "/models", nil)
+    req.Header.Set(RequestIDHeader, "req-1")
+    rec := httptest.NewRecorder()
+    requestIDMiddleware(rec, req, func(w http.ResponseWriter, r *http.Request) {
+      panicRecoveryMiddleware(w, r, func(w http.ResponseWriter, r *http.Request) {
+        panic("boom")
+      })
+    })
+    var em ErrMsg
+    json.Unmarshal(rec.Body.Bytes(), &em)
+    return rec, em
+  }
+
+  rec, em := send()
+  if rec.Code != http.StatusInternalServerError ||
+     em.ErrCode != ErrorInternalPanic || em.ErrID == "" ||
+     rec.Header().Get(RequestIDHeader) != "req-1" {
+    t.Fatal("Unexpected response:", rec.Code, rec.Body.String())
+  }
+  if len(em.Extra) != 1 || em.Extra[0] != "request_id: req-1" {
+    t.Fatal("The stack should not be included:", em.Extra)
+  }
+
+  gServer.DebugErrors = true
+  if _, em = send(); len(em.Extra) != 2 ||
+     !strings.Contains(em.Extra[1], "panic") {
+    t.Fatal("The stack should be included:", em.Extra)
+  }
+}
+
+// TestRequestID tests assigning IDs to requests.
+func TestRequestID(t *testing.T) {
+  ids := map[string]string{
+    "abc-123": "abc-123",
+    "bad id\n": "",
+    "": "",
+  }
+  for header, expected := range ids {
+    req, _ := http.NewRequest("GET", "/models", nil)
+    req.Header.Set(RequestIDHeader, header)
+    rec := httptest.NewRecorder()
+    var id string
+    requestIDMiddleware(rec, req, func(w http.ResponseWriter, r *http.Request) {
+      id = RequestID(r)
+    })
+    if id == "" || id != rec.Header().Get(RequestIDHeader) ||
+       (expected != "" && id != expected) || (expected == "" && id == header) {
+      t.Fatal("Unexpected request ID:", header, id)
+    }
+  }
+}
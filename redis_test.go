@@ -0,0 +1,50 @@
+package ign
+
+import (
+  "os"
+  "testing"
+  "time"
+)
+
+// TestNewRedisFromEnvVars tests the Redis pool settings.
+func TestNewRedisFromEnvVars(t *testing.T) {
+  os.Unsetenv("IGN_REDIS_URL")
+  if r, err := NewRedisFromEnvVars(); r != nil || err != nil {
+    t.Fatal("Redis should be disabled without IGN_REDIS_URL. Got:", r, err)
+  }
+
+  os.Setenv("IGN_REDIS_URL", "redis://localhost:6379/2")
+  os.Setenv("IGN_REDIS_POOL_SIZE", "20")
+  os.Setenv("IGN_REDIS_MIN_IDLE_CONNS", "3")
+  os.Setenv("IGN_REDIS_TIMEOUT", "2s")
+  defer os.Unsetenv("IGN_REDIS_URL")
+  defer os.Unsetenv("IGN_REDIS_POOL_SIZE")
+  defer os.Unsetenv("IGN_REDIS_MIN_IDLE_CONNS")
+  defer os.Unsetenv("IGN_REDIS_TIMEOUT")
+
+  r, err := NewRedisFromEnvVars()
+  if err != nil {
+    t.Fatal("Unexpected error:", err)
+  }
+  defer r.Close()
+  opts := r.Options()
+  if opts.DB != 2 || opts.PoolSize != 20 || opts.MinIdleConns != 3 ||
+     opts.ReadTimeout != 2 * time.Second || opts.WriteTimeout != 2 * time.Second {
+    t.Fatal("Unexpected options:", opts)
+  }
+
+  os.Setenv("IGN_REDIS_POOL_SIZE", "many")
+  if _, err := NewRedisFromEnvVars(); err == nil {
+    t.Fatal("An invalid pool size should fail")
+  }
+}
+
+// TestRedisStoresRequireClient tests that the Redis stores fail without a
+// Redis client.
+func TestRedisStoresRequireClient(t *testing.T) {
+  os.Setenv("IGN_QUOTA_STORE", "redis")
+  defer os.Unsetenv("IGN_QUOTA_STORE")
+  if _, err := NewQuotaStoreFromEnvVars(nil); err != ErrNoRedis {
+    t.Fatal("Expected ErrNoRedis. Got:", err)
+  }
+}
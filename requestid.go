@@ -0,0 +1,38 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "regexp"
+  "github.com/satori/go.uuid"
+)
+
+// RequestIDHeader is the header used to receive and return request IDs.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID matches the request IDs accepted from clients or proxies.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,128}$`)
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request. It is the X-Request-ID header of
+// the request, if valid, or a generated UUID. It returns an empty string if
+// the request did not go through the router.
+func RequestID(r *http.Request) string {
+  id, _ := r.Context().Value(requestIDKey{}).(string)
+  return id
+}
+
+/////////////////////////////////////////////////
+// requestIDMiddleware assigns an ID to the request, and returns it in the
+// X-Request-ID response header.
+func requestIDMiddleware(w http.ResponseWriter, r *http.Request,
+                         next http.HandlerFunc) {
+  id := r.Header.Get(RequestIDHeader)
+  if !validRequestID.MatchString(id) {
+    id = uuid.Must(uuid.NewV4()).String()
+  }
+  w.Header().Set(RequestIDHeader, id)
+  next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+}
@@ -0,0 +1,72 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "net/url"
+  "strconv"
+  "github.com/gorilla/mux"
+  "gorm.io/gorm"
+)
+
+// RouteParams bundles the inputs of a ContextHandler, so handlers don't
+// need the raw request or the global server.
+type RouteParams struct {
+  // Variables of the route URI (eg. {id}).
+  Vars map[string]string
+  // Arguments of the URL query.
+  Query url.Values
+  // Identity of the request. Nil if the request is not authenticated.
+  Identity *Identity
+  // The server database, bound to the request context. Nil if there is no
+  // database.
+  DB *gorm.DB
+  // Headers of the response, which can be set by the handler.
+  Header http.Header
+  // The original request.
+  Request *http.Request
+}
+
+// ContextHandler is a handler with a result that gets its inputs from a
+// RouteParams. The context is canceled when the client goes away. Use
+// WithParams to serve it using JSONResult, ProtoResult, etc. E.g.:
+//
+//   Handler: JSONResult(WithParams(getModel))
+type ContextHandler func(ctx context.Context, p RouteParams) (interface{}, *ErrMsg)
+
+// WithParams converts a ContextHandler to a HandlerWithResult.
+func WithParams(handler ContextHandler) HandlerWithResult {
+  return func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return handler(r.Context(), NewRouteParams(w, r))
+  }
+}
+
+// NewRouteParams creates the RouteParams of a request.
+func NewRouteParams(w http.ResponseWriter, r *http.Request) RouteParams {
+  p := RouteParams{
+    Vars: mux.Vars(r),
+    Query: r.URL.Query(),
+    Request: r,
+  }
+  if p.Vars == nil {
+    p.Vars = map[string]string{}
+  }
+  if identity, ok := IdentityFromRequest(r); ok {
+    p.Identity = identity
+  }
+  p.DB = DBFromRequest(r)
+  if w != nil {
+    p.Header = w.Header()
+  }
+  return p
+}
+
+// IntVar returns the value of an integer URI variable. It returns
+// ErrorIDWrongFormat if the variable is missing or not an integer.
+func (p RouteParams) IntVar(name string) (int64, *ErrMsg) {
+  value, err := strconv.ParseInt(p.Vars[name], 10, 64)
+  if err != nil {
+    return 0, NewErrorMessageWithArgs(ErrorIDWrongFormat, err, []string{name})
+  }
+  return value, nil
+}
@@ -0,0 +1,51 @@
+package ign
+
+import (
+  "context"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "github.com/dgrijalva/jwt-go"
+  "github.com/gorilla/mux"
+)
+
+// TestWithParams tests serving a ContextHandler.
+func TestWithParams(t *testing.T) {
+  handler := func(ctx context.Context, p RouteParams) (interface{}, *ErrMsg) {
+    id, em := p.IntVar("id")
+    if em != nil {
+      return nil, em
+    }
+    p.Header.Set("X-Test", "1")
+    return map[string]interface{}{
+      "id": id,
+      "owner": p.Vars["owner"],
+      "q": p.Query.Get("q"),
+      "user": p.Identity.Subject,
+    }, nil
+  }
+
+  send := func(id string) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("GET", "/osrf/models/" + id + "?q=box", nil)
+    token := &jwt.Token{Claims: jwt.MapClaims{"sub": "alice"}}
+    req = req.WithContext(context.WithValue(req.Context(), "user", token))
+    req = mux.SetURLVars(req, map[string]string{"owner": "osrf", "id": id})
+    rec := httptest.NewRecorder()
+    JSONResult(WithParams(handler)).ServeHTTP(rec, req)
+    return rec
+  }
+
+  rec := send("7")
+  var got map[string]interface{}
+  json.Unmarshal(rec.Body.Bytes(), &got)
+  if rec.Code != http.StatusOK || got["id"] != 7.0 || got["owner"] != "osrf" ||
+     got["q"] != "box" || got["user"] != "alice" ||
+     rec.Header().Get("X-Test") != "1" {
+    t.Fatal("Unexpected response:", rec.Code, rec.Body.String())
+  }
+
+  if rec = send("x"); rec.Code != http.StatusBadRequest {
+    t.Fatal("Invalid ids should be rejected:", rec.Code)
+  }
+}
@@ -1,21 +1,24 @@
 package ign
 
 import (
+  "bytes"
+  "context"
+  "encoding/csv"
   "encoding/json"
+  "encoding/xml"
+  "errors"
   "fmt"
-  "log"
   "net/http"
   "reflect"
-  "regexp"
-  "sort"
+  "strconv"
   "strings"
   "time"
   "github.com/auth0/go-jwt-middleware"
   "github.com/codegangsta/negroni"
-  "github.com/dgrijalva/jwt-go"
+  "github.com/golang/protobuf/jsonpb"
   "github.com/golang/protobuf/proto"
   "github.com/gorilla/mux"
-  "github.com/jpillora/go-ogle-analytics"
+  "github.com/vmihailenco/msgpack/v4"
 )
 
 // Detail stores information about a paramter.
@@ -23,6 +26,8 @@ type Detail struct {
   Type        string `json:"type"`
   Description string `json:"description"`
   Required    bool   `json:"required"`
+  // Optional regular expression that values must match
+  Pattern     string `json:"pattern,omitempty"`
 }
 
 // Header stores the information about headers included in a request.
@@ -56,6 +61,22 @@ type TypeJSONResult struct {
 // ProtoResult provides protobuf serialization for handler results
 type ProtoResult HandlerWithResult
 
+// ProtoJSONResult provides JSON serialization, following the protobuf JSON
+// mapping, for handler results that are protobuf messages.
+type ProtoJSONResult HandlerWithResult
+
+// XMLResult provides XML serialization for handler results
+type XMLResult HandlerWithResult
+
+// MsgPackResult provides MessagePack serialization for handler results
+type MsgPackResult HandlerWithResult
+
+// TypeCSVResult represents a function result that can be exported to CSV
+type TypeCSVResult struct {
+  wrapperField string
+  fn HandlerWithResult
+}
+
 // FormatHandlers is a slice of FormatHandler values.
 type FormatHandlers []FormatHandler
 
@@ -71,6 +92,9 @@ type Method struct {
 
   // A slice of hanlders used to process this method.
   Handlers FormatHandlers `json:"handler"`
+
+  // Scopes (or permissions) the JWT must grant to use this method
+  RequiredScopes []string `json:"required_scopes,omitempty"`
 }
 
 // Methods is a slice of Method.
@@ -94,11 +118,52 @@ type Route struct {
   // Headers required by the route
   Headers []Header `json:"headers"`
 
+  // Parameters of the URI. Parameters can also be declared in the URI
+  // (eg. "/models/{id:int}"). Requests with invalid parameters are rejected.
+  URIParams []URIParam `json:"uri_params,omitempty"`
+
+  // Compiled URIParams validations
+  uriParams []uriParam
+
+  // True for the admin routes, which ignore the maintenance mode and the
+  // database health
+  admin bool
+
   // HTTP methods supported by the route
   Methods Methods `json:"methods"`
 
   // Secure HTTP methods supported by the route
   SecureMethods SecureMethods `json:"secure_methods"`
+
+  // Optional rate limit applied to each client of the route
+  RateLimit *RateLimit `json:"rate_limit,omitempty"`
+
+  // Optional usage quota applied to each client of the route
+  Quota *Quota `json:"quota,omitempty"`
+
+  // Optional circuit breaker, for routes that depend on unreliable services
+  CircuitBreaker *CircuitBreaker `json:"circuit_breaker,omitempty"`
+
+  // Optional max size, in bytes, of request bodies. It overrides
+  // Server.MaxRequestBodySize. A negative value means no limit.
+  MaxBodySize int64 `json:"max_body_size,omitempty"`
+
+  // Optional page sizes used by NewPaginationRequest. They override
+  // Server.Pagination.
+  Pagination *PaginationConfig `json:"pagination,omitempty"`
+
+  // Optional middleware run before the route handlers. See Server.Use for
+  // the complete middleware chain.
+  Middleware []negroni.Handler `json:"-"`
+
+  // Optional API versions. If set, the route is served under
+  // /{version}/URI for each version. See NewRouterWithOptions.
+  Versions []string `json:"versions,omitempty"`
+
+  // DebugBodies logs the sanitized request and response bodies of every
+  // request. Without it, bodies are only logged for requests with the
+  // X-Debug header and a token granted Server.DebugScope.
+  DebugBodies bool `json:"-"`
 }
 
 // Routes is an array of Route
@@ -127,38 +192,116 @@ var AuthHeadersOptional = []Header {
   },
 }
 
+// defaultCORSMaxAge is the default time browsers cache CORS preflight
+// responses.
+const defaultCORSMaxAge = 10 * time.Minute
+
+// RouterOptions are the options used by NewRouterWithOptions.
+type RouterOptions struct {
+  // Version used to redirect requests without a version prefix. Only routes
+  // that declare this version are redirected. See Route.Versions.
+  DefaultVersion string
+  // Deprecated API versions.
+  DeprecatedVersions []string
+  // If set, a GET route with this path (eg. "/routes") lists all the
+  // routes as JSON.
+  IndexPath string
+  // Value of the Access-Control-Max-Age header of CORS preflight responses.
+  // Defaults to 10 minutes.
+  CORSMaxAge time.Duration
+  // Handler used when no route matches the request. Defaults to a handler
+  // that replies with ErrorRouteNotFound.
+  NotFoundHandler http.Handler
+  // Handler used when a route matches the request URL, but not its method.
+  // Defaults to a handler that replies with ErrorMethodNotAllowed.
+  MethodNotAllowedHandler http.Handler
+}
+
 // NewRouter creates a new Gorilla/mux router
 func NewRouter(routes Routes) *mux.Router {
+  return NewRouterWithOptions(routes, RouterOptions{})
+}
+
+// NewRouterWithOptions creates a new Gorilla/mux router, using the given
+// options.
+func NewRouterWithOptions(routes Routes, opts RouterOptions) *mux.Router {
 
   // We need to set StrictSlash to "false" (default) to avoid getting
   // routes redirected automatically.
   router := mux.NewRouter().StrictSlash(false)
 
+  // Reply with JSON errors to unmatched requests
+  router.NotFoundHandler = opts.NotFoundHandler
+  if router.NotFoundHandler == nil {
+    router.NotFoundHandler = errorHandler(ErrorRouteNotFound)
+  }
+  router.MethodNotAllowedHandler = opts.MethodNotAllowedHandler
+  if router.MethodNotAllowedHandler == nil {
+    router.MethodNotAllowedHandler = errorHandler(ErrorMethodNotAllowed)
+  }
+
+  routes = declareURIParams(routes)
+  unversioned := routes
+  routes = expandVersions(routes, opts)
+
   // Process the routes defined in routes.go
   for routeIndex, route := range routes {
 
-    var allowedOptions []string
+    // All the handlers of a named route share the same rate limiter.
+    // Routes without name get a limiter per method.
+    var limiter *rateLimiter
+    if route.RateLimit != nil && route.Name != "" {
+      limiter = newRateLimiter(route.Name, *route.RateLimit)
+    }
+    methodLimiter := func(method Method) *rateLimiter {
+      if route.RateLimit == nil || route.Name != "" {
+        return limiter
+      }
+      return newRateLimiter(method.Type + " " + route.URI, *route.RateLimit)
+    }
+    var breaker *circuitBreaker
+    if route.CircuitBreaker != nil {
+      breaker = newCircuitBreaker(route.Name, *route.CircuitBreaker)
+    }
 
     // Process unsecure routes
     for _, method := range route.Methods {
-      for _, formatHandler := range method.Handlers {
-        createRouteHelper(router, &routes, routeIndex, method.Type, false,
-                          &allowedOptions, formatHandler)
+      limiter := methodLimiter(method)
+      for _, formatHandler := range negotiateHandlers(method.Handlers) {
+        createRouteHelper(router, &routes, routeIndex, method, false,
+                          formatHandler, limiter, breaker)
       }
     }
 
     // Process secure routes
     for _, method := range route.SecureMethods {
-      for _, formatHandler := range method.Handlers {
-        createRouteHelper(router, &routes, routeIndex, method.Type, true,
-                          &allowedOptions, formatHandler)
+      limiter := methodLimiter(method)
+      for _, formatHandler := range negotiateHandlers(method.Handlers) {
+        createRouteHelper(router, &routes, routeIndex, method, true,
+                          formatHandler, limiter, breaker)
+      }
+    }
+
+    // Handle CORS preflight requests
+    createOptionsRoutes(router, route, opts)
+  }
+
+  // Register the routes index
+  if opts.IndexPath != "" {
+    router.Methods("GET").Path(opts.IndexPath).Name("routesIndex").
+      Handler(logger(routesIndexHandler(routes), "routesIndex"))
+  }
+
+  // Redirect unversioned URIs to the default version. This is done after
+  // creating all routes, so that unversioned routes take precedence.
+  if opts.DefaultVersion != "" {
+    redirect := defaultVersionRedirect(opts.DefaultVersion)
+    for _, route := range unversioned {
+      for _, uri := range defaultVersionURIs(route, opts.DefaultVersion) {
+        router.Path(uri).Handler(redirect)
       }
     }
   }
-  // NOTE: sortedREs and corsMap are private vars defined below
-  // Sorting corsMap is needed to correctly resolve OPTION requests
-  // that need to match a regex.
-  sortedREs = getSortedREs(corsMap)
 
   return router
 }
@@ -174,10 +317,24 @@ func JSONListResult(wrapper string, handler HandlerWithResult) TypeJSONResult {
   return TypeJSONResult{wrapper, handler}
 }
 
+// CSVResult provides CSV serialization for handler results. The result must
+// be a [][]string, or a slice of structs. For structs, the header row
+// contains the field names (or their `csv` or `json` tag names), and values
+// are formatted using fmt.
+func CSVResult(handler HandlerWithResult) TypeCSVResult {
+  return TypeCSVResult{"", handler}
+}
+
+// CSVListResult provides CSV serialization for handler results that wrap a
+// slice of structs in the given field.
+func CSVListResult(wrapper string, handler HandlerWithResult) TypeCSVResult {
+  return TypeCSVResult{wrapper, handler}
+}
+
 /////////////////////////////////////////////////
 func (fn Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
   if err := fn(w, r); err != nil {
-    reportJSONError(w, *err)
+    reportJSONError(w, r, *err)
   }
 }
 
@@ -185,7 +342,7 @@ func (fn Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (t TypeJSONResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
   result, err := t.fn(w, r)
   if err != nil {
-    reportJSONError(w, *err)
+    reportJSONError(w, r, *err)
     return
   }
 
@@ -207,7 +364,7 @@ func (t TypeJSONResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
   // Marshal the response into a JSON
   if err := json.NewEncoder(w).Encode(data); err != nil {
     em := NewErrorMessageWithBase(ErrorMarshalJSON, err)
-    reportJSONError(w, *em)
+    reportJSONError(w, r, *em)
     return
   }
 }
@@ -216,7 +373,7 @@ func (t TypeJSONResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (fn ProtoResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
   result, err := fn(w, r)
   if err != nil {
-    reportJSONError(w, *err)
+    reportJSONError(w, r, *err)
     return
   }
 
@@ -225,7 +382,7 @@ func (fn ProtoResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
   data, e := proto.Marshal(pm)
   if e != nil {
     em := NewErrorMessageWithBase(ErrorMarshalProto, e)
-    reportJSONError(w, *em)
+    reportJSONError(w, r, *em)
     return
   }
   w.Header().Set("Content-Type", "application/arraybuffer")
@@ -233,13 +390,97 @@ func (fn ProtoResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 /////////////////////////////////////////////////
-// Private members
+func (fn ProtoJSONResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  result, err := fn(w, r)
+  if err != nil {
+    reportJSONError(w, r, *err)
+    return
+  }
+
+  pm, ok := result.(proto.Message)
+  if !ok {
+    em := NewErrorMessageWithBase(ErrorMarshalProto,
+                                  errors.New("Result is not a protobuf message"))
+    reportJSONError(w, r, *em)
+    return
+  }
+  var buf bytes.Buffer
+  if e := (&jsonpb.Marshaler{}).Marshal(&buf, pm); e != nil {
+    em := NewErrorMessageWithBase(ErrorMarshalProto, e)
+    reportJSONError(w, r, *em)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.Write(buf.Bytes())
+}
+
 /////////////////////////////////////////////////
+func (fn MsgPackResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  result, err := fn(w, r)
+  if err != nil {
+    reportJSONError(w, r, *err)
+    return
+  }
 
-var corsMap = map[string]int{}
-// sortedREs keeps a sorted list of registered routes in corsMap.
-// It allows us to iterate the corsMap in 'order'.
-var sortedREs []string
+  data, e := msgpack.Marshal(result)
+  if e != nil {
+    em := NewErrorMessageWithBase(ErrorMarshalMsgPack, e)
+    reportJSONError(w, r, *em)
+    return
+  }
+  w.Header().Set("Content-Type", "application/x-msgpack")
+  w.Write(data)
+}
+
+/////////////////////////////////////////////////
+func (fn XMLResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  result, err := fn(w, r)
+  if err != nil {
+    reportJSONError(w, r, *err)
+    return
+  }
+
+  // Marshal the data before writing, to be able to report errors.
+  data, e := xml.Marshal(result)
+  if e != nil {
+    em := NewErrorMessageWithBase(ErrorMarshalXML, e)
+    reportJSONError(w, r, *em)
+    return
+  }
+  w.Header().Set("Content-Type", "application/xml")
+  w.Write([]byte(xml.Header))
+  w.Write(data)
+}
+
+/////////////////////////////////////////////////
+func (t TypeCSVResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  result, err := t.fn(w, r)
+  if err != nil {
+    reportJSONError(w, r, *err)
+    return
+  }
+
+  data := result
+  if t.wrapperField != "" {
+    data = reflect.Indirect(reflect.ValueOf(result)).
+      FieldByName(t.wrapperField).Interface()
+  }
+
+  records, e := csvRecords(data)
+  if e != nil {
+    em := NewErrorMessageWithBase(ErrorMarshalCSV, e)
+    reportJSONError(w, r, *em)
+    return
+  }
+  var buf bytes.Buffer
+  csv.NewWriter(&buf).WriteAll(records)
+  w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+  w.Write(buf.Bytes())
+}
+
+/////////////////////////////////////////////////
+// Private members
+/////////////////////////////////////////////////
 
 var pemKeyString string
 
@@ -251,87 +492,146 @@ var jwtOptionalMiddleware = jwtmiddleware.New(
     // See https://github.com/auth0/go-jwt-middleware
     CredentialsOptional: true,
 
-    SigningMethod:       jwt.SigningMethodRS256,
+    Extractor:           jwtTokenExtractor,
 
-    ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-      // This method must return a public key or a secret, depending on the
-      // chosen signing method
-      return jwt.ParseRSAPublicKeyFromPEM([]byte(pemKeyString))
-    },
+    // The signing method is checked by jwtValidationKey
+    ValidationKeyGetter: jwtValidationKey,
 })
 
 var jwtRequiredMiddleware = jwtmiddleware.New(jwtmiddleware.Options{
   Debug: false,
-  SigningMethod: jwt.SigningMethodRS256,
   CredentialsOptional: false,
-  ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-    // This method must return a public key or a secret, depending on the
-    // chosen signing method
-    return jwt.ParseRSAPublicKeyFromPEM([]byte(pemKeyString))
-  },
+  Extractor: jwtTokenExtractor,
+  ValidationKeyGetter: jwtValidationKey,
 })
 
 /////////////////////////////////////////////////
-// sortRE is an internal []string wrapper type used to sort by
-// the number of "[^/]+" string occurrences found in a regex (ie. count).
-// If the same count is found then the larger string will take precedence.
-type sortRE []string
-func (s sortRE) Len() int {
-  return len(s)
-}
-func (s sortRE) Swap(i, j int) {
-  s[i], s[j] = s[j], s[i]
-}
-func (s sortRE) Less(i, j int) bool {
-  ci := strings.Count(s[i], "[^/]+")
-  cj := strings.Count(s[j], "[^/]+")
-  if ci == cj {
-    return len(s[i]) > len(s[j])
+// csvRecords converts a [][]string, or a slice of structs (or pointers to
+// structs), into CSV records. A header record is added for structs.
+func csvRecords(data interface{}) ([][]string, error) {
+  if records, ok := data.([][]string); ok {
+    return records, nil
+  }
+
+  value := reflect.Indirect(reflect.ValueOf(data))
+  if value.Kind() != reflect.Slice {
+    return nil, errors.New("CSV results must be slices")
+  }
+  elemType := value.Type().Elem()
+  if elemType.Kind() == reflect.Ptr {
+    elemType = elemType.Elem()
+  }
+  if elemType.Kind() != reflect.Struct {
+    return nil, errors.New("CSV results must be slices of structs")
   }
-  return ci < cj
-}
 
-func getSortedREs(m map[string]int) []string {
-  var keys []string
-  for k := range m {
-    keys = append(keys, k)
+  // Header
+  var fields []int
+  var header []string
+  for i := 0; i < elemType.NumField(); i++ {
+    field := elemType.Field(i)
+    if field.PkgPath != "" {
+      continue
+    }
+    name := field.Name
+    tag := field.Tag.Get("csv")
+    if tag == "" {
+      tag = field.Tag.Get("json")
+    }
+    if tag = strings.Split(tag, ",")[0]; tag == "-" {
+      continue
+    } else if tag != "" {
+      name = tag
+    }
+    fields = append(fields, i)
+    header = append(header, name)
   }
-  sort.Sort(sortRE(keys))
-  return keys
+
+  records := [][]string{header}
+  for i := 0; i < value.Len(); i++ {
+    elem := reflect.Indirect(value.Index(i))
+    record := make([]string, len(fields))
+    if elem.IsValid() {
+      for j, f := range fields {
+        fv := elem.Field(f)
+        if fv.Kind() == reflect.Ptr {
+          if fv.IsNil() {
+            continue
+          }
+          fv = fv.Elem()
+        }
+        record[j] = fmt.Sprint(fv.Interface())
+      }
+    }
+    records = append(records, record)
+  }
+  return records, nil
 }
 
 /////////////////////////////////////////////////
 // Helper function that creates a route
 func createRouteHelper(router *mux.Router, routes *Routes,
-                       routeIndex int, methodType string, secure bool,
-                       allowedOptions *[]string, formatHandler FormatHandler) {
+                       routeIndex int, method Method, secure bool,
+                       formatHandler FormatHandler, limiter *rateLimiter,
+                       breaker *circuitBreaker) {
 
-  *allowedOptions = append(*allowedOptions, methodType)
   handler := formatHandler.Handler
 
   // Configure auth middleware
   var authMiddleware negroni.HandlerFunc
   if !secure {
-    authMiddleware = negroni.HandlerFunc(jwtOptionalMiddleware.HandlerWithNext)
+    authMiddleware = newAuthMiddleware(jwtOptionalMiddleware.HandlerWithNext, true)
   } else {
-    authMiddleware = negroni.HandlerFunc(jwtRequiredMiddleware.HandlerWithNext)
+    authMiddleware = newAuthMiddleware(jwtRequiredMiddleware.HandlerWithNext, false)
   }
 
   routeName := (*routes)[routeIndex].Name
 
-  recovery := negroni.NewRecovery()
-  // PrintStack is set to false to avoid sending stacktrace to client.
-  recovery.PrintStack = false
-
   // Configure middlewares chain
-  handler = negroni.New(
-    recovery,
-    negroni.HandlerFunc(requireDBMiddleware),
-    negroni.HandlerFunc(addCORSheadersMiddleware),
-    authMiddleware,
-    negroni.HandlerFunc(newGaEventTracking(routeName)),
-    negroni.Wrap(http.Handler(handler)),
+  n := negroni.New(
+    negroni.HandlerFunc(requestIDMiddleware),
+    negroni.HandlerFunc(panicRecoveryMiddleware),
+    negroni.HandlerFunc(newTracingMiddleware(routeName)),
   )
+  // Operators must be able to use the admin routes during maintenance or
+  // while the database is down.
+  if !(*routes)[routeIndex].admin {
+    n.Use(negroni.HandlerFunc(maintenanceMiddleware))
+    n.Use(negroni.HandlerFunc(requireDBMiddleware))
+  }
+  n.Use(negroni.HandlerFunc(addCORSheadersMiddleware))
+  n.Use(negroni.HandlerFunc(newBodyLimitMiddleware((*routes)[routeIndex].MaxBodySize)))
+  if params := (*routes)[routeIndex].uriParams; len(params) > 0 {
+    n.Use(negroni.HandlerFunc(newParamsMiddleware(params)))
+  }
+  if pagination := (*routes)[routeIndex].Pagination; pagination != nil {
+    n.Use(newPaginationMiddleware(*pagination))
+  }
+  n.Use(authMiddleware)
+  n.Use(newDebugBodiesMiddleware((*routes)[routeIndex].DebugBodies, routeName))
+  n.Use(negroni.HandlerFunc(tenantMiddleware))
+  if len(method.RequiredScopes) > 0 {
+    n.Use(negroni.HandlerFunc(newScopesMiddleware(method.RequiredScopes)))
+  }
+  if secure {
+    n.Use(negroni.HandlerFunc(authorizerMiddleware))
+  }
+  if limiter != nil {
+    n.Use(negroni.HandlerFunc(newRateLimitMiddleware(limiter)))
+  }
+  if quota := (*routes)[routeIndex].Quota; quota != nil {
+    n.Use(newQuotaMiddleware(*quota, routeName))
+  }
+  if breaker != nil {
+    n.Use(newCircuitBreakerMiddleware(breaker))
+  }
+  n.Use(negroni.HandlerFunc(serverMiddleware))
+  for _, m := range (*routes)[routeIndex].Middleware {
+    n.Use(m)
+  }
+  n.Use(negroni.HandlerFunc(newGaEventTracking(routeName)))
+  n.UseHandler(handler)
+  handler = n
 
   // Last, wrap everything with a Logger middleware
   handler = logger(handler, routeName)
@@ -340,68 +640,87 @@ func createRouteHelper(router *mux.Router, routes *Routes,
 
   // Create the route handler.
   router.
-  Methods(methodType).
+  Methods(method.Type).
   Path(uriPath).
   Name(routeName + formatHandler.Extension).
   Handler(handler)
+}
 
-  // Setup a regular expression for "{_text_}" URL parameters.
-  re := regexp.MustCompile("{.+?}")
-
-  // Store route information for options
-  reString := re.ReplaceAllString(strings.Replace(uriPath, ".", "\\.", -1), "[^/]+")
-  corsMap[reString] = routeIndex
+/////////////////////////////////////////////////
+// createOptionsRoutes creates the OPTIONS handlers of a route, one per
+// format extension, used to answer CORS preflight requests. They reply with
+// 204 and the methods allowed by the route (with the given extension). If
+// the request explicitly accepts "application/json", the route definition is
+// returned as documentation instead.
+func createOptionsRoutes(router *mux.Router, route Route, opts RouterOptions) {
+  maxAge := opts.CORSMaxAge
+  if maxAge == 0 {
+    maxAge = defaultCORSMaxAge
+  }
 
-  // Create the OPTIONS route handler.
-  // Added the HTTP method "OPTIONS" to each route,
-  // to handle CORS preflight requests.
-  router.
-  Methods("OPTIONS").
-  Path(uriPath).
-  Name(routeName + formatHandler.Extension).
-  Handler(http.HandlerFunc(
-    func(w http.ResponseWriter, r *http.Request) {
-      index := 0
-      ok := false
-      // Find the matching URL
-      for _, key := range sortedREs {
-        // Make sure the regular expression matches the complete URL path
-        if regexp.MustCompile(key).FindString(r.URL.Path) == r.URL.Path {
-          ok = true
-          index = corsMap[key]
-          break
+  // Allowed methods by extension
+  allowed := map[string][]string{}
+  var extensions []string
+  for _, methods := range []Methods{route.Methods, Methods(route.SecureMethods)} {
+    for _, m := range methods {
+      for _, fh := range m.Handlers {
+        if _, ok := allowed[fh.Extension]; !ok {
+          extensions = append(extensions, fh.Extension)
+        }
+        if !StrSliceContains(allowed[fh.Extension], m.Type) {
+          allowed[fh.Extension] = append(allowed[fh.Extension], m.Type)
         }
       }
+    }
+  }
 
-      if (ok) {
-        if output, e := json.Marshal((*routes)[index]); e != nil {
-          err := NewErrorMessageWithBase(ErrorMarshalJSON, e)
-          reportJSONError(w, *err)
-        } else {
-          w.Header().Set("Allow", strings.Join((*allowedOptions)[:], ","))
-          w.Header().Set("Content-Type", "application/json")
-          addCORSheaders(w)
-          fmt.Fprintln(w, string(output))
+  for _, extension := range extensions {
+    methods := strings.Join(append(allowed[extension], "OPTIONS"), ", ")
+    router.
+    Methods("OPTIONS").
+    Path(route.URI + extension).
+    Name(route.Name + extension + "Options").
+    Handler(http.HandlerFunc(
+      func(w http.ResponseWriter, r *http.Request) {
+        addCORSheaders(w)
+        w.Header().Set("Allow", methods)
+        w.Header().Set("Access-Control-Allow-Methods", methods)
+        w.Header().Set("Access-Control-Max-Age",
+                       strconv.Itoa(int(maxAge / time.Second)))
+
+        if !StrSliceContains(parseAccept(r.Header.Get("Accept")),
+                             "application/json") {
+          w.WriteHeader(http.StatusNoContent)
+          return
         }
-        return
-      }
 
-      // Return error if a URL did not match
-      err := ErrorMessage(ErrorNameNotFound)
-      reportJSONError(w, err)
-    }))
+        output, e := json.Marshal(route)
+        if e != nil {
+          err := NewErrorMessageWithBase(ErrorMarshalJSON, e)
+          reportJSONError(w, r, *err)
+          return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        fmt.Fprintln(w, string(output))
+      }))
+  }
 }
 
-
 /////////////////////////////////////////////////
 // Middleware to ensure the DB instance exists.
 // By having this middleware, then any route handler can safely assume the DB
 // is present.
 func requireDBMiddleware(w http.ResponseWriter, r *http.Request,
                       next http.HandlerFunc) {
-  if gServer.Db == nil {
+  // Requests served with their own database (see WithDB) don't depend on
+  // the server database.
+  if requestDB(r.Context()) != nil {
+    next(w, r)
+    return
+  }
+  if gServer == nil || !gServer.DbHealthy() {
     errMsg := ErrorMessage(ErrorNoDatabase)
-    reportJSONError(w, errMsg)
+    reportJSONError(w, r, errMsg)
   } else {
     next(w, r)
   }
@@ -432,11 +751,18 @@ func addCORSheaders(w http.ResponseWriter) {
 
 /////////////////////////////////////////////////
 // ReportJSONError logs an error message and return an HTTP error including
-// JSON payload
-func reportJSONError(w http.ResponseWriter, errMsg ErrMsg) {
-  log.Println("Error in [" + Trace() + "]\n\t" + errMsg.LogString())
+// JSON payload. The error is also sent to the server's ErrorReporter.
+func reportJSONError(w http.ResponseWriter, r *http.Request, errMsg ErrMsg) {
+  fields := Fields{"trace": Trace(), "status": errMsg.StatusCode}
   if errMsg.BaseError != nil {
-    log.Printf("Base error: %v", errMsg.BaseError)
+    fields["base_error"] = errMsg.BaseError
+  }
+  gLogger.Error(errMsg.LogString(), fields)
+  reportToErrorReporter(errMsg, r)
+
+  if useProblemFormat() {
+    writeProblem(w, errMsg)
+    return
   }
 
   output, err := json.Marshal(errMsg);
@@ -448,34 +774,71 @@ func reportJSONError(w http.ResponseWriter, errMsg ErrMsg) {
   http.Error(w, string(output), errMsg.StatusCode)
 }
 
+/////////////////////////////////////////////////
+// errorHandler returns a handler that always replies with the given error,
+// including the CORS headers.
+func errorHandler(errCode int64) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    addCORSheaders(w)
+    reportJSONError(w, r, ErrorMessage(errCode))
+  })
+}
+
 /////////////////////////////////////////////////
 // reportError logs an error message and return an HTTP error
 func reportError(w http.ResponseWriter, msg string, errCode int) {
-  log.Println("Error in [" + Trace() + "]\n\t" + msg)
+  gLogger.Error(msg, Fields{"trace": Trace(), "status": errCode})
   http.Error(w, msg, errCode)
 }
 
+// serverWriterKey is the context key of the ResponseWriter given by the
+// http.Server.
+type serverWriterKey struct{}
+
+// responseController returns a ResponseController of the ResponseWriter
+// given by the http.Server to the request, or of w if it is unknown.
+func responseController(w http.ResponseWriter,
+                        r *http.Request) *http.ResponseController {
+  if sw, ok := r.Context().Value(serverWriterKey{}).(http.ResponseWriter); ok {
+    w = sw
+  }
+  return http.NewResponseController(w)
+}
+
 /////////////////////////////////////////////////
-// logger is a decorator used to output HTTP requests.
+// logger is a decorator used to output HTTP requests, including the
+// response status and size.
 func logger(inner http.Handler, name string) http.Handler {
   return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
     start := time.Now()
 
-    inner.ServeHTTP(w, r)
+    // Let the database logger know the route of the queries
+    ctx := context.WithValue(r.Context(), routeNameKey{}, name)
+    // The negroni writers can't be unwrapped by http.ResponseController
+    r = r.WithContext(context.WithValue(ctx, serverWriterKey{}, w))
+    rw := negroni.NewResponseWriter(w)
+    inner.ServeHTTP(rw, r)
 
-    log.Printf(
-      "%s\t%s\t%s\t%s",
-      r.Method,
-      r.RequestURI,
-      name,
-      time.Since(start),
-    )
+    status := rw.Status()
+    if status == 0 {
+      status = http.StatusOK
+    }
+    logAccess(accessLogEntry{
+      Method: r.Method,
+      URI: redactAccessToken(r.RequestURI),
+      Route: name,
+      Status: status,
+      Size: rw.Size(),
+      Duration: time.Since(start),
+      ClientIP: ClientIP(r),
+    })
   })
 }
 
 /////////////////////////////////////////////////
 // gaEventTracking is a middleware to send events to Google Analytics.
-// Events will be automatically created using route information.
+// Events will be automatically created using route information, and
+// delivered asynchronously by the server's gaTracker.
 // This middleware requires IGN_GA_TRACKING_ID and IGN_GA_APP_NAME
 // env vars.
 func newGaEventTracking(routeName string) negroni.HandlerFunc {
@@ -483,21 +846,20 @@ func newGaEventTracking(routeName string) negroni.HandlerFunc {
     next(w, r)
 
     // Track event with GA, if enabled
-    if gServer.GaAppName == "" || gServer.GaTrackingID == "" {
+    if gServer == nil || gServer.gaTracker == nil {
       return
     }
-    c, err := ga.NewClient(gServer.GaTrackingID)
-    if err != nil {
-      fmt.Println("Error creating GA client", err)
-      return
+    e := gaEvent{
+      category: gServer.GaCategoryPrefix + routeName,
+      action: r.Method,
+      label: redactAccessToken(r.URL.String()),
     }
-    c.DataSource(gServer.GaAppName)
-    c.ApplicationName(gServer.GaAppName)
-    cat := gServer.GaCategoryPrefix + routeName
-    action := r.Method
-    e := ga.NewEvent(cat, action).Label(r.URL.String())
-    if err := c.Send(e); err != nil {
-      fmt.Println("Error while sending event to GA", err)
+    // Let GA count anonymous users
+    e.clientID, _ = AnonymousID(r)
+    e.clientIP = ClientIP(r)
+    if !gServer.gaTracker.track(e) {
+      gLogger.Warn("GA event queue is full. Event dropped",
+                   Fields{"category": e.category})
     }
   }
 }
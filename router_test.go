@@ -0,0 +1,200 @@
+package ign
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "github.com/golang/protobuf/ptypes/wrappers"
+  "github.com/vmihailenco/msgpack/v4"
+)
+
+// Tests for the result serializers
+
+type testItem struct {
+  Name string `json:"name" xml:"name"`
+  Likes int `json:"likes" xml:"likes"`
+  Owner *string `json:"owner,omitempty" xml:"owner"`
+  Hidden string `json:"-" xml:"-"`
+}
+
+type testItems struct {
+  Items []testItem
+}
+
+// serveResult runs the given handler and returns its response.
+func serveResult(h http.Handler) *httptest.ResponseRecorder {
+  rec := httptest.NewRecorder()
+  req, _ := http.NewRequest("GET", "/items", nil)
+  h.ServeHTTP(rec, req)
+  return rec
+}
+
+// TestXMLResult tests the XML serializer.
+func TestXMLResult(t *testing.T) {
+  rec := serveResult(XMLResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return testItem{Name: "box", Likes: 2}, nil
+  }))
+  exp := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+         `<testItem><name>box</name><likes>2</likes></testItem>`
+  if rec.Body.String() != exp || rec.Header().Get("Content-Type") != "application/xml" {
+    t.Fatal("Unexpected XML result:", rec.Body.String())
+  }
+}
+
+// TestCSVResult tests the CSV serializer.
+func TestCSVResult(t *testing.T) {
+  owner := "alice"
+  items := []testItem{{"box", 2, &owner, "x"}, {"sphere, red", 0, nil, "y"}}
+  exp := "name,likes,owner\nbox,2,alice\n\"sphere, red\",0,\n"
+
+  rec := serveResult(CSVResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return &items, nil
+  }))
+  if rec.Body.String() != exp {
+    t.Fatal("Unexpected CSV result:", rec.Body.String())
+  }
+  if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+    t.Fatal("Unexpected content type:", rec.Header().Get("Content-Type"))
+  }
+
+  rec = serveResult(CSVListResult("Items", func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return &testItems{items}, nil
+  }))
+  if rec.Body.String() != exp {
+    t.Fatal("Unexpected CSV list result:", rec.Body.String())
+  }
+
+  rec = serveResult(CSVResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return "not a slice", nil
+  }))
+  if rec.Code != http.StatusInternalServerError ||
+     !strings.Contains(rec.Body.String(), "2700") {
+    t.Fatal("Expected a CSV marshal error. Got:", rec.Code, rec.Body.String())
+  }
+}
+
+// TestProtoJSONResult tests the protobuf JSON serializer.
+func TestProtoJSONResult(t *testing.T) {
+  rec := serveResult(ProtoJSONResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return &wrappers.Int64Value{Value: 5}, nil
+  }))
+  if rec.Body.String() != `"5"` || rec.Header().Get("Content-Type") != "application/json" {
+    t.Fatal("Unexpected protobuf JSON result:", rec.Body.String())
+  }
+
+  rec = serveResult(ProtoJSONResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return testItem{}, nil
+  }))
+  if rec.Code != http.StatusInternalServerError {
+    t.Fatal("Expected an error for non protobuf results. Got:", rec.Code)
+  }
+}
+
+// TestMsgPackResult tests the MessagePack serializer.
+func TestMsgPackResult(t *testing.T) {
+  rec := serveResult(MsgPackResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return testItem{Name: "box", Likes: 2}, nil
+  }))
+  if rec.Header().Get("Content-Type") != "application/x-msgpack" {
+    t.Fatal("Unexpected content type:", rec.Header().Get("Content-Type"))
+  }
+  var item testItem
+  if err := msgpack.Unmarshal(rec.Body.Bytes(), &item); err != nil ||
+     item.Name != "box" || item.Likes != 2 {
+    t.Fatal("Unexpected MessagePack result:", err, item)
+  }
+}
+
+// TestNotFoundHandlers tests the JSON replies to unmatched requests.
+func TestNotFoundHandlers(t *testing.T) {
+  router := NewRouterWithOptions(versionTestRoutes(), RouterOptions{})
+
+  tests := []struct {
+    method string
+    uri string
+    status int
+    errCode string
+  }{
+    {"GET", "/unknown", http.StatusNotFound, "3019"},
+    {"DELETE", "/1.0/models", http.StatusMethodNotAllowed, "3020"},
+  }
+  for _, test := range tests {
+    req, _ := http.NewRequest(test.method, test.uri, nil)
+    rec := httptest.NewRecorder()
+    router.ServeHTTP(rec, req)
+    if rec.Code != test.status ||
+       !strings.Contains(rec.Body.String(), `"errcode":` + test.errCode) {
+      t.Fatal("Unexpected response for", test.uri, rec.Code, rec.Body.String())
+    }
+    if rec.Header().Get("Access-Control-Allow-Origin") == "" {
+      t.Fatal("Missing CORS headers for", test.uri)
+    }
+  }
+}
+
+// TestRoutesIndex tests listing the routes.
+func TestRoutesIndex(t *testing.T) {
+  router := NewRouterWithOptions(versionTestRoutes(),
+                                 RouterOptions{IndexPath: "/routes"})
+
+  req, _ := http.NewRequest("GET", "/routes", nil)
+  rec := httptest.NewRecorder()
+  router.ServeHTTP(rec, req)
+
+  var routes []Route
+  if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+    t.Fatal("Unable to parse the routes index:", err, rec.Body.String())
+  }
+  if len(routes) != 2 || routes[0].URI != "/1.0/models" ||
+     routes[1].URI != "/2.0/models" || len(routes[0].Methods) != 1 {
+    t.Fatal("Unexpected routes index:", rec.Body.String())
+  }
+}
+
+// TestOptionsRoutes tests the CORS preflight responses.
+func TestOptionsRoutes(t *testing.T) {
+  ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+  router := NewRouter(Routes{
+    Route{
+      Name: "models",
+      URI: "/1.0/models",
+      Methods: Methods{
+        Method{Type: "GET", Handlers: FormatHandlers{{"", ok}, {".proto", ok}}},
+      },
+      SecureMethods: SecureMethods{
+        Method{Type: "POST", Handlers: FormatHandlers{{"", ok}}},
+      },
+    },
+  })
+
+  options := func(uri, accept string) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("OPTIONS", uri, nil)
+    req.Header.Set("Accept", accept)
+    rec := httptest.NewRecorder()
+    router.ServeHTTP(rec, req)
+    return rec
+  }
+
+  rec := options("/1.0/models", "*/*")
+  if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+    t.Fatal("Preflight should reply 204 without body:", rec.Code, rec.Body.String())
+  }
+  if rec.Header().Get("Access-Control-Allow-Methods") != "GET, POST, OPTIONS" ||
+     rec.Header().Get("Access-Control-Max-Age") != "600" {
+    t.Fatal("Unexpected preflight headers:", rec.Header())
+  }
+
+  rec = options("/1.0/models.proto", "*/*")
+  if rec.Header().Get("Allow") != "GET, OPTIONS" {
+    t.Fatal("Unexpected allowed methods:", rec.Header().Get("Allow"))
+  }
+
+  rec = options("/1.0/models", "application/json")
+  var route Route
+  if err := json.Unmarshal(rec.Body.Bytes(), &route); err != nil ||
+     rec.Code != http.StatusOK || route.Name != "models" {
+    t.Fatal("Expected the route documentation:", rec.Code, rec.Body.String())
+  }
+}
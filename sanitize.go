@@ -0,0 +1,91 @@
+package ign
+
+import (
+  "errors"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+  "unicode"
+)
+
+// User provided file names (eg. uploaded files or archive entries) must not
+// be joined into disk paths directly, as they can escape the destination
+// directory ("../x"), contain control characters, or use names reserved by
+// some file systems. Use SanitizeFileName for a single name, and SafeJoin
+// for relative paths.
+
+// maxFileNameLength is the max length, in bytes, of a file name.
+const maxFileNameLength = 255
+
+// ErrUnsafePath is the error of the names and paths rejected by
+// SanitizeFileName and SafeJoin.
+var ErrUnsafePath = errors.New("Unsafe file name or path")
+
+// windowsReservedNames are the device names that can't be used as file
+// names on Windows, with or without extension.
+var windowsReservedNames = map[string]bool{
+  "CON": true, "PRN": true, "AUX": true, "NUL": true,
+  "COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+  "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+  "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+  "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName validates a user provided file name, without directory.
+// Surrounding spaces are removed. It returns ErrUnsafePath if the name is
+// empty, "." or "..", contains path separators or control characters, is
+// longer than 255 bytes, ends with a dot, or is a reserved device name (eg.
+// "CON" or "nul.txt").
+func SanitizeFileName(name string) (string, error) {
+  name = strings.TrimSpace(name)
+  if name == "" || name == "." || name == ".." ||
+     len(name) > maxFileNameLength || strings.HasSuffix(name, ".") ||
+     strings.ContainsAny(name, `/\`) {
+    return "", ErrUnsafePath
+  }
+  for _, r := range name {
+    if unicode.IsControl(r) || r == unicode.ReplacementChar {
+      return "", ErrUnsafePath
+    }
+  }
+  base := name
+  if i := strings.Index(base, "."); i >= 0 {
+    base = base[:i]
+  }
+  if windowsReservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+    return "", ErrUnsafePath
+  }
+  return name, nil
+}
+
+// SafeJoin joins a user provided relative path, using forward slashes (eg.
+// "meshes/box.dae"), to the base directory. Every element of the path is
+// validated with SanitizeFileName. It returns ErrUnsafePath if the path is
+// absolute, has invalid elements, or points outside base.
+func SafeJoin(base, userPath string) (string, error) {
+  if userPath == "" || strings.HasPrefix(userPath, "/") ||
+     filepath.IsAbs(userPath) || strings.Contains(userPath, `\`) {
+    return "", ErrUnsafePath
+  }
+  clean := path.Clean(userPath)
+  if clean == "." {
+    return "", ErrUnsafePath
+  }
+  for _, elem := range strings.Split(clean, "/") {
+    if s, err := SanitizeFileName(elem); err != nil || s != elem {
+      return "", ErrUnsafePath
+    }
+  }
+
+  base = filepath.Clean(base)
+  prefix := base
+  if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+    prefix += string(os.PathSeparator)
+  }
+  joined := filepath.Join(base, filepath.FromSlash(clean))
+  if !strings.HasPrefix(joined, prefix) {
+    return "", ErrUnsafePath
+  }
+  return joined, nil
+}
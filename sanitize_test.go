@@ -0,0 +1,34 @@
+package ign
+
+import (
+  "path/filepath"
+  "testing"
+)
+
+// TestSanitizeFileName tests the accepted and rejected file names.
+func TestSanitizeFileName(t *testing.T) {
+  if name, err := SanitizeFileName(" model.sdf "); err != nil || name != "model.sdf" {
+    t.Fatal("Unexpected result:", name, err)
+  }
+  for _, name := range []string{"", ".", "..", "a/b", `a\b`, "a\x00b", "a\nb",
+                                "name.", "CON", "nul.txt", "Com1.dae"} {
+    if _, err := SanitizeFileName(name); err != ErrUnsafePath {
+      t.Errorf("[%q] should be rejected", name)
+    }
+  }
+}
+
+// TestSafeJoin tests joining user paths to a base directory.
+func TestSafeJoin(t *testing.T) {
+  base := filepath.Join("data", "models")
+  p, err := SafeJoin(base, "meshes/./box.dae")
+  if err != nil || p != filepath.Join(base, "meshes", "box.dae") {
+    t.Fatal("Unexpected path:", p, err)
+  }
+  for _, userPath := range []string{"", ".", "../x", "a/../../x", "/etc/passwd",
+                                    `a\..\x`, "a/aux/b", "a/b\x01"} {
+    if _, err := SafeJoin(base, userPath); err != ErrUnsafePath {
+      t.Errorf("[%q] should be rejected", userPath)
+    }
+  }
+}
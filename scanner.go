@@ -0,0 +1,197 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "encoding/binary"
+  "errors"
+  "io"
+  "io/ioutil"
+  "net"
+  "os"
+  "strings"
+  "time"
+)
+
+// Uploaded files can be scanned for malware by setting Server.UploadScanner
+// (eg. with a ClamAVScanner). BindMultipart scans each file before passing
+// it to the sink, and the Unzip functions scan each extracted file. Unsafe
+// files are rejected with ErrorUnsafeContent. If the scanner fails, the
+// upload is rejected too.
+
+// ErrUnsafeContent is the error of the scanners when they find malware.
+var ErrUnsafeContent = errors.New("Unsafe content")
+
+// UnsafeContentError is the error returned when a scanner finds malware.
+type UnsafeContentError struct {
+  // Name of the scanned file.
+  Name string
+  // Threat is the name of the malware found (eg. "Eicar-Test-Signature").
+  Threat string
+}
+
+// Error is part of the error interface.
+func (e *UnsafeContentError) Error() string {
+  return "Unsafe content [" + e.Name + "]: " + e.Threat
+}
+
+// Is makes errors.Is(err, ErrUnsafeContent) true.
+func (e *UnsafeContentError) Is(target error) bool {
+  return target == ErrUnsafeContent
+}
+
+// IsUnsafeContent returns true if err was caused by a scanner finding
+// malware.
+func IsUnsafeContent(err error) bool {
+  return errors.Is(err, ErrUnsafeContent)
+}
+
+// UploadScanner scans uploaded files.
+type UploadScanner interface {
+  // Scan reads the whole content. It returns an *UnsafeContentError if the
+  // content is unsafe, or another error if the scan failed.
+  Scan(ctx context.Context, name string, content io.Reader) error
+}
+
+// NewUploadScannerFromEnvVars creates a ClamAVScanner if IGN_CLAMAV_ADDRESS
+// is set (eg. "localhost:3310"). IGN_CLAMAV_TIMEOUT optionally sets its
+// timeout (eg. "30s"). It returns nil if no scanner is configured.
+func NewUploadScannerFromEnvVars() (UploadScanner, error) {
+  address, err := ReadEnvVar("IGN_CLAMAV_ADDRESS")
+  if err != nil || address == "" {
+    return nil, nil
+  }
+  scanner := &ClamAVScanner{Address: address}
+  if v, err := ReadEnvVar("IGN_CLAMAV_TIMEOUT"); err == nil {
+    if scanner.Timeout, err = time.ParseDuration(v); err != nil {
+      return nil, errors.New("Invalid IGN_CLAMAV_TIMEOUT: " + err.Error())
+    }
+  }
+  return scanner, nil
+}
+
+// uploadScanner returns the scanner of the server, if any.
+func uploadScanner() UploadScanner {
+  if gServer == nil {
+    return nil
+  }
+  return gServer.UploadScanner
+}
+
+// spoolAndScan copies content to a temporary file and scans it. It returns
+// the file, positioned at the start, which the caller must close and
+// remove.
+func spoolAndScan(ctx context.Context, scanner UploadScanner, name string,
+                  content io.Reader) (*os.File, error) {
+  f, err := ioutil.TempFile("", "ign-upload-")
+  if err != nil {
+    return nil, err
+  }
+  fail := func(err error) (*os.File, error) {
+    f.Close()
+    os.Remove(f.Name())
+    return nil, err
+  }
+  if _, err := io.Copy(f, content); err != nil {
+    return fail(err)
+  }
+  if _, err := f.Seek(0, io.SeekStart); err != nil {
+    return fail(err)
+  }
+  if err := scanner.Scan(ctx, name, f); err != nil {
+    return fail(err)
+  }
+  if _, err := f.Seek(0, io.SeekStart); err != nil {
+    return fail(err)
+  }
+  return f, nil
+}
+
+/////////////////////////////////////////////////
+
+// clamAVChunkSize is the size of the chunks sent to clamd.
+const clamAVChunkSize = 64 << 10
+
+// ClamAVScanner is an UploadScanner that uses a clamd daemon, with the
+// INSTREAM command.
+type ClamAVScanner struct {
+  // Address of clamd (eg. "localhost:3310"), or the path of its Unix
+  // socket.
+  Address string
+  // Timeout of a scan. Defaults to 1 minute.
+  Timeout time.Duration
+}
+
+// Scan is part of the UploadScanner interface.
+func (c *ClamAVScanner) Scan(ctx context.Context, name string,
+                             content io.Reader) error {
+  timeout := c.Timeout
+  if timeout <= 0 {
+    timeout = time.Minute
+  }
+  ctx, cancel := context.WithTimeout(ctx, timeout)
+  defer cancel()
+
+  network := "tcp"
+  if strings.HasPrefix(c.Address, "/") {
+    network = "unix"
+  }
+  var dialer net.Dialer
+  conn, err := dialer.DialContext(ctx, network, c.Address)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+  if deadline, ok := ctx.Deadline(); ok {
+    conn.SetDeadline(deadline)
+  }
+
+  if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+    return err
+  }
+  buf := make([]byte, clamAVChunkSize)
+  size := make([]byte, 4)
+  for {
+    n, err := content.Read(buf)
+    if n > 0 {
+      binary.BigEndian.PutUint32(size, uint32(n))
+      if _, err := conn.Write(size); err != nil {
+        return err
+      }
+      if _, err := conn.Write(buf[:n]); err != nil {
+        return err
+      }
+    }
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return err
+    }
+  }
+  // A zero length chunk ends the stream.
+  binary.BigEndian.PutUint32(size, 0)
+  if _, err := conn.Write(size); err != nil {
+    return err
+  }
+
+  reply, err := ioutil.ReadAll(conn)
+  if err != nil {
+    return err
+  }
+  return parseClamAVReply(name, string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamAVReply converts a clamd reply (eg. "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND") to an error.
+func parseClamAVReply(name, reply string) error {
+  result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+  switch {
+  case result == "OK":
+    return nil
+  case strings.HasSuffix(result, " FOUND"):
+    return &UnsafeContentError{Name: name,
+                               Threat: strings.TrimSuffix(result, " FOUND")}
+  }
+  return errors.New("ClamAV scan failed: " + reply)
+}
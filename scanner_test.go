@@ -0,0 +1,168 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "encoding/binary"
+  "errors"
+  "io"
+  "io/ioutil"
+  "mime/multipart"
+  "net"
+  "net/http"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// eicar is the standard antivirus test file.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeScanner rejects the content that contains the EICAR test string.
+type fakeScanner struct{}
+
+func (fakeScanner) Scan(ctx context.Context, name string, content io.Reader) error {
+  data, err := ioutil.ReadAll(content)
+  if err != nil {
+    return err
+  }
+  if bytes.Contains(data, []byte("EICAR")) {
+    return &UnsafeContentError{Name: name, Threat: "Eicar-Test-Signature"}
+  }
+  return nil
+}
+
+// contextScanner fails if the scan context is done.
+type contextScanner struct{}
+
+func (contextScanner) Scan(ctx context.Context, name string, content io.Reader) error {
+  return ctx.Err()
+}
+
+// serveFakeClamd serves a single INSTREAM scan, with the clamd protocol.
+func serveFakeClamd(t *testing.T) string {
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal("Unable to listen:", err)
+  }
+  go func() {
+    defer l.Close()
+    conn, err := l.Accept()
+    if err != nil {
+      return
+    }
+    defer conn.Close()
+    cmd := make([]byte, len("zINSTREAM\x00"))
+    if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+      conn.Write([]byte("UNKNOWN COMMAND\x00"))
+      return
+    }
+    var data []byte
+    for {
+      var size uint32
+      if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+        return
+      }
+      if size == 0 {
+        break
+      }
+      chunk := make([]byte, size)
+      if _, err := io.ReadFull(conn, chunk); err != nil {
+        return
+      }
+      data = append(data, chunk...)
+    }
+    if bytes.Contains(data, []byte("EICAR")) {
+      conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+    } else {
+      conn.Write([]byte("stream: OK\x00"))
+    }
+  }()
+  return l.Addr().String()
+}
+
+// TestClamAVScanner tests the clamd INSTREAM protocol.
+func TestClamAVScanner(t *testing.T) {
+  scanner := &ClamAVScanner{Address: serveFakeClamd(t)}
+  if err := scanner.Scan(context.Background(), "model.sdf",
+                         strings.NewReader("<sdf/>")); err != nil {
+    t.Fatal("Clean content should be accepted:", err)
+  }
+
+  scanner = &ClamAVScanner{Address: serveFakeClamd(t)}
+  err := scanner.Scan(context.Background(), "evil.sdf", strings.NewReader(eicar))
+  unsafe, ok := err.(*UnsafeContentError)
+  if !ok || !IsUnsafeContent(err) || unsafe.Threat != "Eicar-Test-Signature" {
+    t.Fatal("Malware should be rejected:", err)
+  }
+
+  if err := parseClamAVReply("x", "INSTREAM size limit exceeded. ERROR");
+     err == nil || IsUnsafeContent(err) {
+    t.Fatal("Scan errors should not be reported as unsafe content:", err)
+  }
+}
+
+// TestScanUploads tests scanning multipart files and extracted archives.
+func TestScanUploads(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{UploadScanner: fakeScanner{}}
+
+  newRequest := func(content string) *http.Request {
+    var body bytes.Buffer
+    mw := multipart.NewWriter(&body)
+    mw.WriteField("name", "box")
+    fw, _ := mw.CreateFormFile("file", "model.sdf")
+    fw.Write([]byte(content))
+    mw.Close()
+    r, _ := http.NewRequest("POST", "/models", &body)
+    r.Header.Set("Content-Type", mw.FormDataContentType())
+    return r
+  }
+  received := ""
+  sink := func(field, filename string, content io.Reader) error {
+    data, err := ioutil.ReadAll(content)
+    received = string(data)
+    return err
+  }
+
+  if em := BindMultipart(newRequest("<sdf/>"), &uploadForm{}, sink); em != nil ||
+     received != "<sdf/>" {
+    t.Fatal("Clean files should be passed to the sink:", em, received)
+  }
+  received = ""
+  if em := BindMultipart(newRequest(eicar), &uploadForm{}, sink); em == nil ||
+     em.ErrCode != ErrorUnsafeContent || received != "" {
+    t.Fatal("Unsafe files should be rejected before the sink:", em, received)
+  }
+
+  dir, _ := ioutil.TempDir("", "scan")
+  defer os.RemoveAll(dir)
+  ioutil.WriteFile(filepath.Join(dir, "keep.txt"), []byte("keep"), 0644)
+  _, reader := newZip(map[string]string{"evil.sdf": eicar,
+                                        "meshes/box.dae": "dae"})
+  err := UnzipWithOptions(reader, dir, UnzipOptions{})
+  if unzipErr, ok := err.(*UnzipError); !ok || !IsUnsafeContent(err) ||
+     unzipErr.Entry != "evil.sdf" {
+    t.Fatal("Unsafe archive entries should be rejected:", err)
+  }
+  for _, name := range []string{"evil.sdf", "meshes"} {
+    if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+      t.Fatal("The extracted entries should be removed:", name, err)
+    }
+  }
+  if _, err := os.Stat(filepath.Join(dir, "keep.txt")); err != nil {
+    t.Fatal("Existing files should be kept:", err)
+  }
+
+  // The scanner gets the context of the options
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  _, reader = newZip(map[string]string{"model.sdf": "<sdf/>"})
+  err = UnzipWithOptions(reader, dir, UnzipOptions{Context: ctx,
+                                                   Scanner: contextScanner{}})
+  if !errors.Is(err, context.Canceled) {
+    t.Fatal("The scan should use the context of the options:", err)
+  }
+}
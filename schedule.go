@@ -0,0 +1,230 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "os"
+  "sync"
+  "sync/atomic"
+  "time"
+  "github.com/robfig/cron/v3"
+  "github.com/satori/go.uuid"
+  "gorm.io/gorm"
+)
+
+// Scheduled tasks are periodic jobs (eg. removing expired tokens) run by
+// the server, registered with Server.Schedule. Each run of a task starts
+// when its cron spec fires. A run is skipped if the previous one is still
+// running.
+// Exclusive tasks (see ScheduleOptions) are run by only one of the server
+// instances sharing the database: on each run, the instances compete for a
+// lock stored in the ign_schedule_locks table.
+
+// ScheduledFunc is the function of a scheduled task. The context is
+// cancelled when the scheduler is stopped.
+type ScheduledFunc func(ctx context.Context) error
+
+// ScheduleOptions are the options of a scheduled task.
+type ScheduleOptions struct {
+  // Exclusive makes the task run in only one server instance. It requires
+  // a database connection.
+  Exclusive bool
+}
+
+// scheduledTask is a task registered with Schedule.
+type scheduledTask struct {
+  name string
+  schedule cron.Schedule
+  fn ScheduledFunc
+  opts ScheduleOptions
+  // 1 while a run is in progress.
+  running int32
+}
+
+// scheduler runs the scheduled tasks of a Server.
+type scheduler struct {
+  mutex sync.Mutex
+  tasks map[string]*scheduledTask
+  ctx context.Context
+  cancel context.CancelFunc
+  wg sync.WaitGroup
+  // ID of this server instance, used as owner of the locks.
+  instanceID string
+}
+
+// scheduleLock is the database lock of an exclusive task.
+type scheduleLock struct {
+  Name string `gorm:"primaryKey;size:255"`
+  Owner string
+  ExpiresAt time.Time
+}
+
+// TableName returns the name of the locks table.
+func (scheduleLock) TableName() string {
+  return "ign_schedule_locks"
+}
+
+// Schedule registers a task that runs fn every time the cron spec fires.
+// The spec uses the standard 5 fields (eg. "*/5 * * * *") or descriptors
+// such as "@hourly" and "@every 10m". The task starts immediately.
+// It returns an error if the spec is invalid or the name is in use.
+func (s *Server) Schedule(name, spec string, fn ScheduledFunc) error {
+  return s.ScheduleWithOptions(name, spec, fn, ScheduleOptions{})
+}
+
+// ScheduleWithOptions is like Schedule, using the given options.
+func (s *Server) ScheduleWithOptions(name, spec string, fn ScheduledFunc,
+                                     opts ScheduleOptions) error {
+  sched, err := cron.ParseStandard(spec)
+  if err != nil {
+    return err
+  }
+  if opts.Exclusive {
+    db := s.Database()
+    if db == nil {
+      return errors.New("Exclusive scheduled tasks require a database")
+    }
+    if err := db.AutoMigrate(&scheduleLock{}); err != nil {
+      return err
+    }
+  }
+
+  s.schedulerMutex.Lock()
+  defer s.schedulerMutex.Unlock()
+  if s.scheduler == nil {
+    s.scheduler = newScheduler()
+  }
+  sc := s.scheduler
+  sc.mutex.Lock()
+  defer sc.mutex.Unlock()
+  if _, ok := sc.tasks[name]; ok {
+    return errors.New("Scheduled task [" + name + "] already exists")
+  }
+  task := &scheduledTask{name: name, schedule: sched, fn: fn, opts: opts}
+  sc.tasks[name] = task
+  sc.wg.Add(1)
+  go s.runTask(sc, task)
+  return nil
+}
+
+// StopScheduler stops all the scheduled tasks, cancelling the context of
+// the running ones, and waits for them to return.
+func (s *Server) StopScheduler() {
+  s.schedulerMutex.Lock()
+  sc := s.scheduler
+  s.scheduler = nil
+  s.schedulerMutex.Unlock()
+  if sc == nil {
+    return
+  }
+  sc.cancel()
+  sc.wg.Wait()
+}
+
+// newScheduler creates an empty scheduler.
+func newScheduler() *scheduler {
+  ctx, cancel := context.WithCancel(context.Background())
+  host, _ := os.Hostname()
+  return &scheduler{
+    tasks: map[string]*scheduledTask{},
+    ctx: ctx,
+    cancel: cancel,
+    instanceID: host + "-" + uuid.Must(uuid.NewV4()).String(),
+  }
+}
+
+// runTask waits for the next activation of the task until the scheduler
+// is stopped.
+func (s *Server) runTask(sc *scheduler, task *scheduledTask) {
+  defer sc.wg.Done()
+  for {
+    c := clock()
+    now := c.Now()
+    next := task.schedule.Next(now)
+    select {
+    case <-sc.ctx.Done():
+      return
+    case <-c.After(next.Sub(now)):
+    }
+
+    if !atomic.CompareAndSwapInt32(&task.running, 0, 1) {
+      gLogger.Warn("Scheduled task still running, run skipped",
+                   Fields{"task": task.name})
+      continue
+    }
+    if task.opts.Exclusive &&
+       !s.acquireScheduleLock(task.name, sc.instanceID, lockExpiry(task, next)) {
+      atomic.StoreInt32(&task.running, 0)
+      continue
+    }
+    sc.wg.Add(1)
+    go func() {
+      defer sc.wg.Done()
+      defer atomic.StoreInt32(&task.running, 0)
+      runScheduledFunc(sc.ctx, task)
+    }()
+  }
+}
+
+// runScheduledFunc runs the function of a task, logging its errors and
+// panics.
+func runScheduledFunc(ctx context.Context, task *scheduledTask) {
+  start := time.Now()
+  defer func() {
+    if p := recover(); p != nil {
+      gLogger.Error("Scheduled task panicked", Fields{"task": task.name,
+                                                      "panic": p})
+    }
+  }()
+  if err := task.fn(ctx); err != nil {
+    gLogger.Error("Scheduled task failed", Fields{"task": task.name,
+                                                  "error": err})
+    return
+  }
+  gLogger.Debug("Scheduled task done", Fields{"task": task.name,
+                                              "duration": time.Since(start)})
+}
+
+// lockExpiry returns the expiration of the lock taken for the run of a
+// task. The lock expires slightly before the following run, so clock drift
+// between instances doesn't block it.
+func lockExpiry(task *scheduledTask, run time.Time) time.Time {
+  following := task.schedule.Next(run)
+  margin := following.Sub(run) / 10
+  if margin > time.Second {
+    margin = time.Second
+  }
+  return following.Add(-margin)
+}
+
+// acquireScheduleLock tries to take the lock of a task until the given
+// time. It returns false if another instance holds the lock, or the
+// database fails.
+func (s *Server) acquireScheduleLock(name, owner string,
+                                     until time.Time) bool {
+  db := s.Database()
+  if db == nil {
+    return false
+  }
+  now := clock().Now()
+  q := db.Model(&scheduleLock{}).
+    Where("name = ? AND expires_at < ?", name, now).
+    Updates(map[string]interface{}{"owner": owner, "expires_at": until})
+  if q.Error != nil {
+    gLogger.Error("Unable to update schedule lock", Fields{"task": name,
+                                                           "error": q.Error})
+    return false
+  }
+  if q.RowsAffected == 1 {
+    return true
+  }
+  // Create the lock if it doesn't exist yet. If another instance creates
+  // it at the same time, the insert fails.
+  var lock scheduleLock
+  err := db.Where("name = ?", name).First(&lock).Error
+  if !errors.Is(err, gorm.ErrRecordNotFound) {
+    return false
+  }
+  lock = scheduleLock{Name: name, Owner: owner, ExpiresAt: until}
+  return db.Create(&lock).Error == nil
+}
@@ -0,0 +1,109 @@
+package ign
+
+import (
+  "context"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+// waitClockWaiters waits until n goroutines are blocked on the clock.
+func waitClockWaiters(t *testing.T, clk *ManualClock, n int) {
+  for i := 0; clk.Waiters() < n; i++ {
+    if i == 1000 {
+      t.Fatal("Timeout waiting for the clock waiters:", clk.Waiters())
+    }
+    time.Sleep(5 * time.Millisecond)
+  }
+}
+
+// TestSchedule tests running scheduled tasks, and skipping overlapping runs.
+func TestSchedule(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  clk := NewManualClock(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+  s := &Server{Clock: clk}
+  gServer = s
+  defer s.StopScheduler()
+
+  var quick, slow int32
+  release := make(chan struct{})
+  err := s.Schedule("quick", "@every 1s", func(ctx context.Context) error {
+    atomic.AddInt32(&quick, 1)
+    return nil
+  })
+  if err != nil {
+    t.Fatal("Unable to schedule:", err)
+  }
+  s.Schedule("slow", "@every 1s", func(ctx context.Context) error {
+    atomic.AddInt32(&slow, 1)
+    select {
+    case <-ctx.Done():
+    case <-release:
+    }
+    return nil
+  })
+
+  if err := s.Schedule("quick", "@hourly", nil); err == nil {
+    t.Fatal("Duplicated names should be rejected")
+  }
+  if err := s.Schedule("bad", "* *", nil); err == nil {
+    t.Fatal("Invalid specs should be rejected")
+  }
+  if err := s.ScheduleWithOptions("exclusive", "@hourly", nil,
+                                  ScheduleOptions{Exclusive: true}); err == nil {
+    t.Fatal("Exclusive tasks should require a database")
+  }
+
+  // The slow task is still running on the second activation
+  for i := 0; i < 2; i++ {
+    waitClockWaiters(t, clk, 2)
+    clk.Add(time.Second)
+  }
+  waitClockWaiters(t, clk, 2)
+  s.StopScheduler()
+  close(release)
+  if n := atomic.LoadInt32(&quick); n != 2 {
+    t.Fatal("Expected 2 runs of the quick task. Got:", n)
+  }
+  if n := atomic.LoadInt32(&slow); n != 1 {
+    t.Fatal("Overlapping runs should be skipped. Got:", n)
+  }
+}
+
+// TestScheduleExclusive tests that exclusive tasks run in a single instance.
+func TestScheduleExclusive(t *testing.T) {
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  sqlDB(db).SetMaxOpenConns(1)
+
+  prev := gServer
+  defer func() { gServer = prev }()
+  clk := NewManualClock(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+  gServer = &Server{Clock: clk}
+  instances := []*Server{{Db: db}, {Db: db}}
+
+  var runs int32
+  for _, s := range instances {
+    err := s.ScheduleWithOptions("cleanup", "@every 1s",
+      func(ctx context.Context) error {
+        atomic.AddInt32(&runs, 1)
+        return nil
+      }, ScheduleOptions{Exclusive: true})
+    if err != nil {
+      t.Fatal("Unable to schedule:", err)
+    }
+  }
+
+  for i := 0; i < 2; i++ {
+    waitClockWaiters(t, clk, 2)
+    clk.Add(time.Second)
+  }
+  waitClockWaiters(t, clk, 2)
+  for _, s := range instances {
+    s.StopScheduler()
+  }
+  if n := atomic.LoadInt32(&runs); n != 2 {
+    t.Fatal("Expected 2 runs of the exclusive task. Got:", n)
+  }
+}
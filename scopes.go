@@ -0,0 +1,29 @@
+package ign
+
+import (
+  "net/http"
+)
+
+// Methods can declare RequiredScopes. Requests are rejected with
+// ErrorUnauthorized unless their token grants all the required scopes,
+// either in the "scope" claim (space separated, as issued by Auth0 for API
+// scopes) or in the "permissions" claim (as issued by Auth0 RBAC).
+
+/////////////////////////////////////////////////
+// newScopesMiddleware returns a middleware that requires the given scopes.
+// It must run after the JWT middleware.
+func newScopesMiddleware(required []string) func(http.ResponseWriter,
+                                                 *http.Request, http.HandlerFunc) {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    identity, ok := IdentityFromRequest(r)
+    for _, scope := range required {
+      if !ok || !identity.HasScope(scope) {
+        em := NewErrorMessageWithArgs(ErrorUnauthorized, nil,
+                                      []string{"missing scope: " + scope})
+        reportJSONError(w, r, *em)
+        return
+      }
+    }
+    next(w, r)
+  }
+}
@@ -0,0 +1,41 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// TestScopesMiddleware tests requiring scopes and permissions.
+func TestScopesMiddleware(t *testing.T) {
+  mw := newScopesMiddleware([]string{"read:models", "write:models"})
+  send := func(claims jwt.MapClaims) int {
+    req, _ := http.NewRequest("POST", "/models", nil)
+    if claims != nil {
+      req = req.WithContext(context.WithValue(req.Context(), "user",
+                                              &jwt.Token{Claims: claims}))
+    }
+    rec := httptest.NewRecorder()
+    mw(rec, req, func(w http.ResponseWriter, r *http.Request) {})
+    return rec.Code
+  }
+
+  if code := send(nil); code != http.StatusUnauthorized {
+    t.Fatal("Anonymous requests should be rejected:", code)
+  }
+  if code := send(jwt.MapClaims{"scope": "read:models"}); code != http.StatusUnauthorized {
+    t.Fatal("Requests missing a scope should be rejected:", code)
+  }
+  if code := send(jwt.MapClaims{"scope": "openid read:models write:models"}); code != http.StatusOK {
+    t.Fatal("Requests with all the scopes should succeed:", code)
+  }
+  claims := jwt.MapClaims{
+    "scope": "read:models",
+    "permissions": []interface{}{"write:models"},
+  }
+  if code := send(claims); code != http.StatusOK {
+    t.Fatal("Permissions should be accepted as scopes:", code)
+  }
+}
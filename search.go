@@ -0,0 +1,82 @@
+package ign
+
+import (
+  "net/http"
+  "strings"
+  "unicode/utf8"
+  "gorm.io/gorm"
+)
+
+// Search is requested using the "q" argument of the URL query (eg.
+// ?q=robot). The typical usage is the following:
+//
+//   sr, em := ParseSearchRequest(r)
+//   q := sr.ApplySearch(db.Model(&Model{}), []string{"name", "description"})
+//   pagResult, err := PaginateQuery(q, &models, *pagRequest)
+//
+// Links written by WritePaginationHeaders keep the "q" argument.
+
+const (
+  searchArgName = "q"
+  // maxSearchLength is the max number of characters of a search.
+  maxSearchLength = 256
+)
+
+// Search modes. See SearchRequest.Mode.
+const (
+  // SearchLike matches the rows that contain the search text in any of the
+  // columns, using LIKE.
+  SearchLike = "like"
+  // SearchFullText uses MySQL MATCH ... AGAINST, in natural language mode.
+  // The columns must have a FULLTEXT index.
+  SearchFullText = "fulltext"
+)
+
+// SearchRequest is the search requested in the URL query.
+type SearchRequest struct {
+  // The search text. Empty if the request has no search.
+  Query string
+  // Search mode used by ApplySearch. Defaults to SearchLike.
+  Mode string
+}
+
+// ParseSearchRequest reads the search of the given request. It returns
+// ErrorFormInvalidValue if the search is too long.
+func ParseSearchRequest(r *http.Request) (*SearchRequest, *ErrMsg) {
+  query := strings.TrimSpace(r.URL.Query().Get(searchArgName))
+  if utf8.RuneCountInString(query) > maxSearchLength {
+    return nil, NewErrorMessageWithArgs(ErrorFormInvalidValue, nil,
+                                        []string{searchArgName})
+  }
+  return &SearchRequest{Query: query, Mode: SearchLike}, nil
+}
+
+// ApplySearch adds the search condition on the given columns to the query.
+// The query is returned unchanged if there is no search.
+func (s *SearchRequest) ApplySearch(q *gorm.DB, columns []string) *gorm.DB {
+  if s == nil || s.Query == "" || len(columns) == 0 {
+    return q
+  }
+
+  if s.Mode == SearchFullText {
+    return q.Where("MATCH (" + strings.Join(columns, ", ") +
+                   ") AGAINST (? IN NATURAL LANGUAGE MODE)", s.Query)
+  }
+
+  pattern := "%" + escapeLike(s.Query) + "%"
+  conditions := make([]string, len(columns))
+  args := make([]interface{}, len(columns))
+  for i, c := range columns {
+    conditions[i] = c + " LIKE ? ESCAPE '!'"
+    args[i] = pattern
+  }
+  return q.Where("(" + strings.Join(conditions, " OR ") + ")", args...)
+}
+
+// likeEscaper escapes the LIKE wildcards, using '!' as escape character.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// escapeLike escapes the LIKE wildcards found in the given text.
+func escapeLike(text string) string {
+  return likeEscaper.Replace(text)
+}
@@ -0,0 +1,63 @@
+package ign
+
+import (
+  "net/http"
+  "strings"
+  "testing"
+)
+
+// TestSearchRequest tests searching using the "q" argument.
+func TestSearchRequest(t *testing.T) {
+  db := newListItemsDB(t,
+    listItem{Name: "red box", Owner: "osrf"},
+    listItem{Name: "blue box", Owner: "nasa"},
+    listItem{Name: "sphere", Owner: "box_maker"},
+    listItem{Name: "100% cube", Owner: "osrf"},
+  )
+  defer sqlDB(db).Close()
+
+  search := func(query string) ([]string, *PaginationResult) {
+    r, _ := http.NewRequest("GET", "/items?" + query, nil)
+    sr, em := ParseSearchRequest(r)
+    if em != nil {
+      t.Fatal("Unexpected error:", em)
+    }
+    pr, _ := NewPaginationRequest(r)
+    q := sr.ApplySearch(db.Model(&listItem{}), []string{"name", "owner"})
+    var items []listItem
+    page, err := PaginateQuery(q.Order("id"), &items, *pr)
+    if err != nil {
+      t.Fatal("Query failed:", err)
+    }
+    var names []string
+    for _, i := range items {
+      names = append(names, i.Name)
+    }
+    return names, page
+  }
+
+  tests := map[string][]string{
+    "": {"red box", "blue box", "sphere", "100% cube"},
+    "q=box": {"red box", "blue box", "sphere"},
+    "q=+osrf+": {"red box", "100% cube"},
+    "q=%25": {"100% cube"},
+    "q=x_m": {"sphere"},
+    "q=d_b": nil,
+  }
+  for query, expected := range tests {
+    if names, _ := search(query); !SameElements(names, expected) {
+      t.Fatal("Unexpected result for", query, names)
+    }
+  }
+
+  // Pagination counts only the matching rows
+  names, page := search("q=box&per_page=2&page=2")
+  if page.QueryCount != 3 || len(names) != 1 || names[0] != "sphere" {
+    t.Fatal("Unexpected page:", names, page)
+  }
+
+  r, _ := http.NewRequest("GET", "/items?q=" + strings.Repeat("a", 300), nil)
+  if _, em := ParseSearchRequest(r); em == nil {
+    t.Fatal("Long searches should be rejected")
+  }
+}
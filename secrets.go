@@ -0,0 +1,265 @@
+package ign
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "strings"
+  "github.com/aws/aws-sdk-go-v2/aws"
+  "github.com/aws/aws-sdk-go-v2/config"
+  "github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// SecretsProvider is the interface used to read secrets, such as the
+// database credentials, from a secrets backend.
+type SecretsProvider interface {
+  // Get returns the value of the secret with the given name.
+  Get(name string) (string, error)
+}
+
+// MultiSecretsProvider is implemented by the SecretsProviders that can read
+// several secrets with a single request to their backend.
+type MultiSecretsProvider interface {
+  SecretsProvider
+  // GetMany returns the values of the secrets with the given names.
+  GetMany(names []string) (map[string]string, error)
+}
+
+// DatabaseSecretNames contains the secret names used to populate a
+// DatabaseConfig from a SecretsProvider. Empty names are skipped.
+// The database name is not considered a secret.
+type DatabaseSecretNames struct {
+  UserName string
+  Password string
+  Address string
+}
+
+// DefaultDatabaseSecretNames are the secret names used by default. They
+// match the env vars used to configure the database. The address is not
+// read from the secrets by default.
+var DefaultDatabaseSecretNames = DatabaseSecretNames{
+  UserName: "IGN_DB_USERNAME",
+  Password: "IGN_DB_PASSWORD",
+}
+
+// LoadFromSecrets populates the database credentials using the given
+// SecretsProvider. MultiSecretsProviders are read with a single request.
+func (c *DatabaseConfig) LoadFromSecrets(p SecretsProvider,
+                                         names DatabaseSecretNames) error {
+  fields := []struct {
+    name string
+    dst *string
+  }{
+    {names.UserName, &c.UserName},
+    {names.Password, &c.Password},
+    {names.Address, &c.Address},
+  }
+  var keys []string
+  for _, f := range fields {
+    if f.name != "" {
+      keys = append(keys, f.name)
+    }
+  }
+  values, err := getSecrets(p, keys)
+  if err != nil {
+    return err
+  }
+  for _, f := range fields {
+    if f.name != "" {
+      *f.dst = values[f.name]
+    }
+  }
+  return nil
+}
+
+// getSecrets returns the values of the secrets with the given names.
+func getSecrets(p SecretsProvider, names []string) (map[string]string, error) {
+  if multi, ok := p.(MultiSecretsProvider); ok {
+    return multi.GetMany(names)
+  }
+  values := map[string]string{}
+  for _, name := range names {
+    value, err := p.Get(name)
+    if err != nil {
+      return nil, err
+    }
+    values[name] = value
+  }
+  return values, nil
+}
+
+// NewSecretsProviderFromEnvVars creates the SecretsProvider selected with the
+// IGN_SECRETS_PROVIDER env var. Supported values are "env", "aws" and
+// "vault". It returns nil if IGN_SECRETS_PROVIDER is not set.
+func NewSecretsProviderFromEnvVars() (SecretsProvider, error) {
+  kind, err := ReadEnvVar("IGN_SECRETS_PROVIDER")
+  if err != nil {
+    return nil, nil
+  }
+
+  switch kind {
+  case "env":
+    return EnvSecretsProvider{}, nil
+  case "aws":
+    secretID, err := ReadEnvVar("IGN_AWS_SECRET_ID")
+    if err != nil {
+      return nil, err
+    }
+    return NewAWSSecretsProvider(secretID)
+  case "vault":
+    var p VaultSecretsProvider
+    if p.Address, err = ReadEnvVar("IGN_VAULT_ADDR"); err != nil {
+      return nil, err
+    }
+    if p.Token, err = ReadEnvVar("IGN_VAULT_TOKEN"); err != nil {
+      return nil, err
+    }
+    if p.Path, err = ReadEnvVar("IGN_VAULT_SECRET_PATH"); err != nil {
+      return nil, err
+    }
+    return &p, nil
+  }
+  return nil, errors.New("Unknown IGN_SECRETS_PROVIDER [" + kind + "]")
+}
+
+/////////////////////////////////////////////////
+
+// EnvSecretsProvider is a SecretsProvider that reads secrets from env vars.
+type EnvSecretsProvider struct{}
+
+// Get returns the value of the env var with the given name.
+func (EnvSecretsProvider) Get(name string) (string, error) {
+  return ReadEnvVar(name)
+}
+
+/////////////////////////////////////////////////
+
+// AWSSecretsProvider is a SecretsProvider that reads secrets from a JSON
+// secret stored in AWS Secrets Manager (eg. {"IGN_DB_PASSWORD": "..."}).
+// AWS credentials and region are read using the default AWS configuration
+// chain (eg. AWS_REGION and AWS_ACCESS_KEY_ID env vars, or instance roles).
+type AWSSecretsProvider struct {
+  // SecretID is the name or ARN of the secret.
+  SecretID string
+  client *secretsmanager.Client
+}
+
+// NewAWSSecretsProvider creates an AWSSecretsProvider for the given secret.
+func NewAWSSecretsProvider(secretID string) (*AWSSecretsProvider, error) {
+  cfg, err := config.LoadDefaultConfig(context.Background())
+  if err != nil {
+    return nil, err
+  }
+  return &AWSSecretsProvider{
+    SecretID: secretID,
+    client: secretsmanager.NewFromConfig(cfg),
+  }, nil
+}
+
+// Get returns the value of the given field of the secret. The secret is
+// fetched on every call, so rotated values are always used.
+func (p *AWSSecretsProvider) Get(name string) (string, error) {
+  values, err := p.GetMany([]string{name})
+  if err != nil {
+    return "", err
+  }
+  return values[name], nil
+}
+
+// GetMany returns the values of the given fields of the secret, fetching
+// it once.
+func (p *AWSSecretsProvider) GetMany(names []string) (map[string]string, error) {
+  out, err := p.client.GetSecretValue(context.Background(),
+    &secretsmanager.GetSecretValueInput{SecretId: aws.String(p.SecretID)})
+  if err != nil {
+    return nil, err
+  }
+  if out.SecretString == nil {
+    return nil, errors.New("Secret [" + p.SecretID + "] is not a string")
+  }
+  return secretFields(p.SecretID, []byte(*out.SecretString), names)
+}
+
+/////////////////////////////////////////////////
+
+// VaultSecretsProvider is a SecretsProvider that reads secrets from a
+// HashiCorp Vault KV (version 2) secret.
+type VaultSecretsProvider struct {
+  // Address of the Vault server (eg. https://vault.example.org:8200)
+  Address string
+  // Token used to authenticate with Vault.
+  Token string
+  // Path of the secret, including the mount (eg. secret/data/fuel)
+  Path string
+  // Client is the HTTP client used to contact Vault. If nil, a client with
+  // 3 attempts per request and a 10 seconds timeout is used.
+  Client *HTTPClient
+}
+
+// Get returns the value of the given field of the secret.
+func (p *VaultSecretsProvider) Get(name string) (string, error) {
+  values, err := p.GetMany([]string{name})
+  if err != nil {
+    return "", err
+  }
+  return values[name], nil
+}
+
+// GetMany returns the values of the given fields of the secret, fetching
+// it once.
+func (p *VaultSecretsProvider) GetMany(names []string) (map[string]string, error) {
+  url := strings.TrimSuffix(p.Address, "/") + "/v1/" +
+         strings.TrimPrefix(p.Path, "/")
+  req, err := http.NewRequest("GET", url, nil)
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("X-Vault-Token", p.Token)
+
+  client := p.Client
+  if client == nil {
+    client = NewHTTPClient()
+  }
+  resp, err := client.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("Unable to read Vault secret [%s]: %s", p.Path,
+                           resp.Status)
+  }
+
+  var body struct {
+    Data struct {
+      Data json.RawMessage `json:"data"`
+    } `json:"data"`
+  }
+  if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+    return nil, err
+  }
+  return secretFields(p.Path, body.Data.Data, names)
+}
+
+/////////////////////////////////////////////////
+
+// secretFields returns the values of the fields of a secret stored as a
+// JSON object.
+func secretFields(secret string, data []byte,
+                  names []string) (map[string]string, error) {
+  var fields map[string]interface{}
+  if err := json.Unmarshal(data, &fields); err != nil {
+    return nil, errors.New("Secret [" + secret + "] is not a JSON object")
+  }
+  values := map[string]string{}
+  for _, name := range names {
+    value, ok := fields[name]
+    if !ok {
+      return nil, errors.New("Missing [" + name + "] in secret [" + secret + "]")
+    }
+    values[name] = fmt.Sprint(value)
+  }
+  return values, nil
+}
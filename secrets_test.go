@@ -0,0 +1,77 @@
+package ign
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "sync/atomic"
+  "testing"
+)
+
+// Tests for secrets providers
+
+// TestEnvSecretsProvider tests loading database credentials from env vars.
+func TestEnvSecretsProvider(t *testing.T) {
+  os.Setenv("TEST_SECRET_DB_USER", "fuel")
+  os.Setenv("TEST_SECRET_DB_PASS", "s3cr3t")
+  defer os.Unsetenv("TEST_SECRET_DB_USER")
+  defer os.Unsetenv("TEST_SECRET_DB_PASS")
+
+  var c DatabaseConfig
+  names := DatabaseSecretNames{UserName: "TEST_SECRET_DB_USER",
+                               Password: "TEST_SECRET_DB_PASS"}
+  if err := c.LoadFromSecrets(EnvSecretsProvider{}, names); err != nil {
+    t.Fatal(err)
+  }
+  if c.UserName != "fuel" || c.Password != "s3cr3t" || c.Address != "" {
+    t.Fatal("Unexpected database config", c)
+  }
+
+  names.Address = "TEST_SECRET_MISSING"
+  if err := c.LoadFromSecrets(EnvSecretsProvider{}, names); err == nil {
+    t.Fatal("Expected an error with a missing secret")
+  }
+}
+
+// TestVaultSecretsProvider tests reading secrets from a Vault KV v2 server.
+func TestVaultSecretsProvider(t *testing.T) {
+  var requests int64
+  vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    atomic.AddInt64(&requests, 1)
+    if r.Header.Get("X-Vault-Token") != "token" {
+      w.WriteHeader(http.StatusForbidden)
+      return
+    }
+    if r.URL.Path != "/v1/secret/data/fuel" {
+      w.WriteHeader(http.StatusNotFound)
+      return
+    }
+    w.Write([]byte(`{"data": {"data": {"IGN_DB_USERNAME": "fuel",
+                                       "IGN_DB_PASSWORD": "s3cr3t"}}}`))
+  }))
+  defer vault.Close()
+
+  p := VaultSecretsProvider{Address: vault.URL, Token: "token",
+                            Path: "secret/data/fuel"}
+  if value, err := p.Get("IGN_DB_PASSWORD"); err != nil || value != "s3cr3t" {
+    t.Fatal("Unexpected secret value", value, err)
+  }
+  if _, err := p.Get("IGN_DB_ADDRESS"); err == nil {
+    t.Fatal("Expected an error with a missing secret field")
+  }
+
+  // The secret is fetched once for all the fields
+  atomic.StoreInt64(&requests, 0)
+  var c DatabaseConfig
+  if err := c.LoadFromSecrets(&p, DefaultDatabaseSecretNames); err != nil ||
+     c.UserName != "fuel" || c.Password != "s3cr3t" {
+    t.Fatal("Unable to load the database credentials:", c, err)
+  }
+  if n := atomic.LoadInt64(&requests); n != 1 {
+    t.Fatal("The secret should be fetched once. Requests:", n)
+  }
+  p.Token = "invalid"
+  if _, err := p.Get("IGN_DB_PASSWORD"); err == nil {
+    t.Fatal("Expected an error with an invalid token")
+  }
+}
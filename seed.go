@@ -0,0 +1,168 @@
+package ign
+
+import (
+  "encoding/json"
+  "errors"
+  "io/ioutil"
+  "path/filepath"
+  "sort"
+  "strings"
+  "gopkg.in/yaml.v2"
+  "gorm.io/gorm"
+)
+
+// Fixture files contain the rows of a table, in YAML or JSON. E.g.:
+//
+//   table: models
+//   depends_on: [users]
+//   rows:
+//     - id: 1
+//       name: box
+//       owner_id: 1
+//
+// Tables are loaded after the tables they depend on, so foreign keys are
+// satisfied. Rows are inserted as is, without model hooks.
+
+// Fixture contains the rows of a table.
+type Fixture struct {
+  // Name of the table.
+  Table string `json:"table" yaml:"table"`
+  // Tables that must be loaded before this one.
+  DependsOn []string `json:"depends_on" yaml:"depends_on"`
+  // Rows, as column/value maps.
+  Rows []map[string]interface{} `json:"rows" yaml:"rows"`
+}
+
+// SeedOptions configures Seed.
+type SeedOptions struct {
+  // Truncate removes the existing rows of the fixture tables before
+  // loading them.
+  Truncate bool
+}
+
+// Seed loads the fixture files matching the given glob patterns (eg.
+// "fixtures/*.yaml") into the server database, in a single transaction.
+func (s *Server) Seed(patterns []string, opts SeedOptions) error {
+  db := s.Database()
+  if db == nil {
+    return ErrNoDatabase
+  }
+  return SeedDB(db, patterns, opts)
+}
+
+// SeedDB loads the fixture files matching the given glob patterns into the
+// given database. See Server.Seed.
+func SeedDB(db *gorm.DB, patterns []string, opts SeedOptions) error {
+  var fixtures []Fixture
+  for _, pattern := range patterns {
+    paths, err := filepath.Glob(pattern)
+    if err != nil {
+      return err
+    }
+    sort.Strings(paths)
+    for _, path := range paths {
+      f, err := ReadFixtureFile(path)
+      if err != nil {
+        return err
+      }
+      fixtures = append(fixtures, *f)
+    }
+  }
+  return LoadFixtures(db, fixtures, opts)
+}
+
+// ReadFixtureFile parses the given YAML or JSON fixture file. The format is
+// chosen based on the file extension (.json, .yaml or .yml).
+func ReadFixtureFile(path string) (*Fixture, error) {
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  var f Fixture
+  switch strings.ToLower(filepath.Ext(path)) {
+  case ".json":
+    err = json.Unmarshal(data, &f)
+  case ".yaml", ".yml":
+    err = yaml.UnmarshalStrict(data, &f)
+  default:
+    return nil, errors.New("Unknown fixture file format [" + path + "]")
+  }
+  if err != nil {
+    return nil, err
+  }
+  if f.Table == "" {
+    return nil, errors.New("Missing table in fixture file [" + path + "]")
+  }
+  return &f, nil
+}
+
+// LoadFixtures loads the given fixtures into the database, in dependency
+// order and in a single transaction.
+func LoadFixtures(db *gorm.DB, fixtures []Fixture, opts SeedOptions) error {
+  ordered, err := sortFixtures(fixtures)
+  if err != nil {
+    return err
+  }
+
+  return db.Transaction(func(tx *gorm.DB) error {
+    if opts.Truncate {
+      // Dependent tables are emptied first
+      for i := len(ordered) - 1; i >= 0; i-- {
+        if err := tx.Exec("DELETE FROM " +
+                          tx.Statement.Quote(ordered[i].Table)).Error; err != nil {
+          return err
+        }
+      }
+    }
+    for _, f := range ordered {
+      if len(f.Rows) == 0 {
+        continue
+      }
+      if err := tx.Table(f.Table).Create(f.Rows).Error; err != nil {
+        return errors.New("Unable to load fixture [" + f.Table + "]: " +
+                          err.Error())
+      }
+    }
+    return nil
+  })
+}
+
+// sortFixtures sorts the fixtures so tables come after their dependencies.
+// Dependencies without fixture are ignored. The original order is kept
+// otherwise.
+func sortFixtures(fixtures []Fixture) ([]Fixture, error) {
+  byTable := map[string][]int{}
+  for i, f := range fixtures {
+    byTable[f.Table] = append(byTable[f.Table], i)
+  }
+
+  ordered := make([]Fixture, 0, len(fixtures))
+  state := make([]int, len(fixtures)) // 0: pending, 1: visiting, 2: done
+  var visit func(i int) error
+  visit = func(i int) error {
+    switch state[i] {
+    case 1:
+      return errors.New("Circular fixture dependency [" + fixtures[i].Table + "]")
+    case 2:
+      return nil
+    }
+    state[i] = 1
+    for _, dep := range fixtures[i].DependsOn {
+      for _, j := range byTable[dep] {
+        if err := visit(j); err != nil {
+          return err
+        }
+      }
+    }
+    state[i] = 2
+    ordered = append(ordered, fixtures[i])
+    return nil
+  }
+  for i := range fixtures {
+    if err := visit(i); err != nil {
+      return nil, err
+    }
+  }
+  return ordered, nil
+}
@@ -0,0 +1,54 @@
+package ign
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+// TestSeedDB tests loading fixture files in dependency order.
+func TestSeedDB(t *testing.T) {
+  db := newListItemsDB(t, listItem{Name: "old"})
+  defer sqlDB(db).Close()
+  type owner struct {
+    ID uint
+    Name string
+  }
+  if err := db.AutoMigrate(&owner{}); err != nil {
+    t.Fatal(err)
+  }
+
+  dir, err := ioutil.TempDir("", "fixtures")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  // The items fixture comes first, but depends on the owners one
+  ioutil.WriteFile(filepath.Join(dir, "1_items.yaml"), []byte(`
+table: list_items
+depends_on: [owners]
+rows:
+  - name: box
+    owner: alice
+`), 0644)
+  ioutil.WriteFile(filepath.Join(dir, "2_owners.json"),
+    []byte(`{"table": "owners", "rows": [{"id": 1, "name": "alice"}]}`), 0644)
+
+  err = SeedDB(db, []string{filepath.Join(dir, "*")}, SeedOptions{Truncate: true})
+  if err != nil {
+    t.Fatal("Unable to seed:", err)
+  }
+  var items []listItem
+  db.Find(&items)
+  if len(items) != 1 || items[0].Name != "box" || items[0].Owner != "alice" {
+    t.Fatal("Unexpected items:", items)
+  }
+
+  // Circular dependencies are rejected
+  _, err = sortFixtures([]Fixture{{Table: "a", DependsOn: []string{"b"}},
+                                  {Table: "b", DependsOn: []string{"a"}}})
+  if err == nil {
+    t.Fatal("Expected an error with circular dependencies")
+  }
+}
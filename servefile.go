@@ -0,0 +1,37 @@
+package ign
+
+import (
+  "fmt"
+  "mime"
+  "net/http"
+  "os"
+)
+
+// ServeFile sends the file found at path as an attachment with the given
+// name, supporting resumable downloads: Range and If-Range requests get
+// partial content (206), and the response includes the Accept-Ranges,
+// Content-Length, Last-Modified and ETag headers. Partial downloads are
+// logged. It returns ErrorFileNotFound if the path is not a file.
+func ServeFile(w http.ResponseWriter, r *http.Request, path, name string) *ErrMsg {
+  file, err := os.Open(path)
+  if err != nil {
+    return NewErrorMessageWithBase(ErrorFileNotFound, err)
+  }
+  defer file.Close()
+  info, err := file.Stat()
+  if err != nil || info.IsDir() {
+    return NewErrorMessageWithBase(ErrorFileNotFound, err)
+  }
+
+  // The ETag allows clients to resume the download using If-Range
+  w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(),
+                                     info.Size()))
+  w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+                 map[string]string{"filename": name}))
+  if rng := r.Header.Get("Range"); rng != "" {
+    gLogger.Info("Partial download", Fields{"file": name, "range": rng,
+                                            "size": info.Size()})
+  }
+  http.ServeContent(w, r, name, info.ModTime(), file)
+  return nil
+}
@@ -0,0 +1,61 @@
+package ign
+
+import (
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+// TestServeFile tests serving files with range requests.
+func TestServeFile(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "servefile")
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "model.zip")
+  ioutil.WriteFile(path, []byte("0123456789"), 0644)
+
+  send := func(headers map[string]string) *httptest.ResponseRecorder {
+    r, _ := http.NewRequest("GET", "/models/box.zip", nil)
+    for k, v := range headers {
+      r.Header.Set(k, v)
+    }
+    rec := httptest.NewRecorder()
+    if em := ServeFile(rec, r, path, "box.zip"); em != nil {
+      t.Fatal("Unable to serve the file:", em)
+    }
+    return rec
+  }
+
+  rec := send(nil)
+  etag := rec.Header().Get("ETag")
+  if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" ||
+     rec.Header().Get("Accept-Ranges") != "bytes" ||
+     rec.Header().Get("Content-Length") != "10" || etag == "" ||
+     rec.Header().Get("Content-Disposition") != "attachment; filename=box.zip" {
+    t.Fatal("Unexpected response:", rec.Code, rec.Header())
+  }
+
+  rec = send(map[string]string{"Range": "bytes=4-"})
+  if rec.Code != http.StatusPartialContent || rec.Body.String() != "456789" ||
+     rec.Header().Get("Content-Range") != "bytes 4-9/10" {
+    t.Fatal("Unexpected partial response:", rec.Code, rec.Body.String())
+  }
+
+  // If-Range with an old ETag returns the whole file
+  rec = send(map[string]string{"Range": "bytes=4-", "If-Range": `"old"`})
+  if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+    t.Fatal("Unexpected response:", rec.Code, rec.Body.String())
+  }
+  rec = send(map[string]string{"Range": "bytes=4-", "If-Range": etag})
+  if rec.Code != http.StatusPartialContent {
+    t.Fatal("Unexpected response:", rec.Code)
+  }
+
+  r, _ := http.NewRequest("GET", "/models/none.zip", nil)
+  if em := ServeFile(httptest.NewRecorder(), r, dir, "none.zip"); em == nil ||
+     em.ErrCode != ErrorFileNotFound {
+    t.Fatal("Directories should not be served:", em)
+  }
+}
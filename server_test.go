@@ -0,0 +1,120 @@
+package ign
+
+import (
+  "io/ioutil"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+  "github.com/gorilla/mux"
+)
+
+/////////////////////////////////////////////////
+// Test the HTTP to HTTPS redirect handler
+func TestHTTPSRedirectHandler(t *testing.T) {
+  s := Server{SSLport: ":4430", RedirectExemptPaths: []string{"/healthz"}}
+  s.Router = mux.NewRouter()
+  s.Router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+    w.WriteHeader(http.StatusOK)
+  })
+  handler := s.httpsRedirectHandler()
+
+  req, _ := http.NewRequest("GET", "http://fuel.example.org:8000/1.0/models?page=2", nil)
+  rec := httptest.NewRecorder()
+  handler.ServeHTTP(rec, req)
+  if rec.Code != http.StatusMovedPermanently {
+    t.Fatal("Expected a redirect, got:", rec.Code)
+  }
+  exp := "https://fuel.example.org:4430/1.0/models?page=2"
+  if got := rec.Header().Get("Location"); got != exp {
+    t.Fatal("Unexpected redirect location. Exp:", exp, "Got:", got)
+  }
+
+  // Exempt paths are served over plain HTTP
+  req, _ = http.NewRequest("GET", "http://fuel.example.org:8000/healthz", nil)
+  rec = httptest.NewRecorder()
+  handler.ServeHTTP(rec, req)
+  if rec.Code != http.StatusOK {
+    t.Fatal("Expected exempt path to be served, got:", rec.Code)
+  }
+}
+
+/////////////////////////////////////////////////
+// Test that RunWithError returns listener errors instead of exiting
+func TestRunWithError(t *testing.T) {
+  s := Server{HTTPPort: "invalid-port", Router: mux.NewRouter()}
+  if err := s.RunWithError(); err == nil {
+    t.Fatal("Expected an error with an invalid port")
+  }
+}
+
+/////////////////////////////////////////////////
+// Test that RunWithError shuts down the other listeners when one fails
+func TestRunWithErrorShutdown(t *testing.T) {
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  addr := l.Addr().String()
+  l.Close()
+
+  // The TLS listener fails, as the certificate doesn't exist
+  s := Server{HTTPPort: addr, SSLport: "127.0.0.1:0", SSLCert: "missing.pem",
+              SSLKey: "missing.key", RedirectHTTPToHTTPS: true,
+              Router: mux.NewRouter()}
+  if err := s.RunWithError(); err == nil {
+    t.Fatal("Expected an error with a missing certificate")
+  }
+  // The http listener should release its port
+  for i := 0; i < 50; i++ {
+    if l, err = net.Listen("tcp", addr); err == nil {
+      l.Close()
+      return
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+  t.Fatal("The http listener should be shut down:", err)
+}
+
+/////////////////////////////////////////////////
+// Test serving requests on a Unix domain socket
+func TestUnixSocket(t *testing.T) {
+  dir, err := ioutil.TempDir("", "ign-socket")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  s := Server{UnixSocket: filepath.Join(dir, "ign.sock")}
+  s.Router = mux.NewRouter()
+  s.Router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+    w.WriteHeader(http.StatusNoContent)
+  })
+  go s.RunWithError()
+
+  client := http.Client{
+    Transport: &http.Transport{
+      Dial: func(network, addr string) (net.Conn, error) {
+        return net.Dial("unix", s.UnixSocket)
+      },
+    },
+  }
+  // Wait for the listener to be ready
+  var resp *http.Response
+  for i := 0; i < 50; i++ {
+    if resp, err = client.Get("http://unix/healthz"); err == nil {
+      break
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+  if err != nil {
+    t.Fatal("Unable to reach the server on the Unix socket", err)
+  }
+  resp.Body.Close()
+  if resp.StatusCode != http.StatusNoContent {
+    t.Fatal("Unexpected status code", resp.StatusCode)
+  }
+}
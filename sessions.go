@@ -0,0 +1,442 @@
+package ign
+
+import (
+  "context"
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+  "github.com/redis/go-redis/v9"
+)
+
+// Web front-ends that can't safely keep JWTs (eg. in localStorage) can use
+// server-side sessions instead. Once the user is authenticated, the service
+// calls Sessions.Create, which replies an HttpOnly cookie. Requests with a
+// valid session cookie and no JWT are authenticated by the auth middleware
+// using the Identity of the session, so IdentityFromRequest, scopes and the
+// Authorizer work as with JWTs.
+//
+// Cookies contain the session ID signed with HMAC-SHA256. If Sessions.Store
+// is nil the whole session is kept in the signed cookie, encrypted with
+// AES-256-GCM, and it can't be revoked before it expires.
+//
+// As browsers send the cookie with any request to the service, requests
+// authenticated with a session and an unsafe method (eg. POST or DELETE)
+// must have the X-CSRF-Token header, with the token returned by
+// Sessions.CSRFToken. Requests without a valid token are not authenticated
+// with their session.
+
+// ErrSessionNotFound is returned when a request has no valid session.
+var ErrSessionNotFound = errors.New("Session not found")
+
+// CSRFHeader is the request header with the CSRF token of the session.
+const CSRFHeader = "X-CSRF-Token"
+
+// Session is a server-side session of an authenticated user.
+type Session struct {
+  ID string `json:"id"`
+  Identity Identity `json:"identity"`
+  CreatedAt time.Time `json:"created_at"`
+  ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SessionStore keeps sessions.
+type SessionStore interface {
+  // Save stores a session until it expires.
+  Save(ctx context.Context, session *Session) error
+  // Get returns a session. It returns ErrSessionNotFound if the session
+  // does not exist or has expired.
+  Get(ctx context.Context, id string) (*Session, error)
+  // Delete removes a session. Deleting a missing session is not an error.
+  Delete(ctx context.Context, id string) error
+}
+
+// Sessions creates, looks up and revokes sessions.
+type Sessions struct {
+  // Store keeps the sessions. If nil, sessions are kept in the cookie.
+  Store SessionStore
+  // CookieName defaults to "ign_session".
+  CookieName string
+  // Path and Domain of the cookie. Path defaults to "/".
+  Path string
+  Domain string
+  // MaxAge of sessions. Defaults to 24h.
+  MaxAge time.Duration
+  // Insecure allows sending the cookie over plain HTTP (eg. in development).
+  Insecure bool
+  // SameSite policy of the cookie. Defaults to http.SameSiteLaxMode, which
+  // keeps the cookie out of cross-site POST requests.
+  SameSite http.SameSite
+
+  // Key used to sign the cookies.
+  secret []byte
+  // aead encrypts the cookies of sessions without store.
+  aead cipher.AEAD
+}
+
+// sessionKey is the context key of the request Session.
+type sessionKey struct{}
+
+// NewSessions creates a Sessions using the given store (nil keeps the
+// sessions in the cookies) and secret to sign and encrypt the cookies.
+func NewSessions(store SessionStore, secret []byte) (*Sessions, error) {
+  if len(secret) < 32 {
+    return nil, errors.New("The session secret must be at least 32 bytes")
+  }
+  s := &Sessions{Store: store, secret: secret}
+  // The encryption key is derived from the secret, so it differs from the
+  // signing key.
+  block, err := aes.NewCipher(s.mac("ign session encryption"))
+  if err != nil {
+    return nil, err
+  }
+  if s.aead, err = cipher.NewGCM(block); err != nil {
+    return nil, err
+  }
+  return s, nil
+}
+
+// NewSessionsFromEnvVars creates the Sessions signed with the
+// IGN_SESSION_SECRET env var, using the store selected with
+// IGN_SESSION_STORE. Supported stores are "cookie" (default), "memory" and
+// "redis" (using the given Redis client, see NewRedisFromEnvVars). It returns
+// nil if IGN_SESSION_SECRET is not set.
+func NewSessionsFromEnvVars(r *Redis) (*Sessions, error) {
+  secret, err := ReadEnvVar("IGN_SESSION_SECRET")
+  if err != nil {
+    return nil, nil
+  }
+
+  var store SessionStore
+  kind, _ := ReadEnvVar("IGN_SESSION_STORE")
+  switch kind {
+  case "", "cookie":
+  case "memory":
+    store = NewMemorySessionStore()
+  case "redis":
+    if r == nil {
+      return nil, ErrNoRedis
+    }
+    store = NewRedisSessionStore(r)
+  default:
+    return nil, errors.New("Unknown IGN_SESSION_STORE [" + kind + "]")
+  }
+
+  s, err := NewSessions(store, []byte(secret))
+  if err != nil {
+    return nil, err
+  }
+  if v, err := ReadEnvVar("IGN_SESSION_INSECURE"); err == nil {
+    s.Insecure = v == "true"
+  }
+  return s, nil
+}
+
+// SessionFromRequest returns the session of a request authenticated with a
+// session cookie.
+func SessionFromRequest(r *http.Request) (*Session, bool) {
+  session, ok := r.Context().Value(sessionKey{}).(*Session)
+  return session, ok
+}
+
+// Create creates a session for the given identity, and sets its cookie in
+// the response.
+func (s *Sessions) Create(w http.ResponseWriter, r *http.Request,
+                          identity Identity) (*Session, error) {
+  id := make([]byte, 32)
+  if _, err := rand.Read(id); err != nil {
+    return nil, err
+  }
+  now := clock().Now().UTC()
+  session := &Session{
+    ID: base64.RawURLEncoding.EncodeToString(id),
+    Identity: identity,
+    CreatedAt: now,
+    ExpiresAt: now.Add(s.maxAge()),
+  }
+
+  payload := session.ID
+  if s.Store != nil {
+    if err := s.Store.Save(r.Context(), session); err != nil {
+      return nil, err
+    }
+  } else {
+    data, err := json.Marshal(session)
+    if err != nil {
+      return nil, err
+    }
+    nonce := make([]byte, s.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+      return nil, err
+    }
+    payload = base64.RawURLEncoding.EncodeToString(
+      s.aead.Seal(nonce, nonce, data, nil))
+  }
+
+  http.SetCookie(w, s.cookie(payload + "." + s.sign(payload), session.ExpiresAt))
+  return session, nil
+}
+
+// Lookup returns the session of the request cookie. It returns
+// ErrSessionNotFound if there is no cookie, or the session is invalid or
+// expired.
+func (s *Sessions) Lookup(r *http.Request) (*Session, error) {
+  c, err := r.Cookie(s.cookieName())
+  if err != nil {
+    return nil, ErrSessionNotFound
+  }
+  parts := strings.Split(c.Value, ".")
+  if len(parts) != 2 ||
+     !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+    return nil, ErrSessionNotFound
+  }
+
+  var session *Session
+  if s.Store != nil {
+    if session, err = s.Store.Get(r.Context(), parts[0]); err != nil {
+      return nil, err
+    }
+  } else {
+    data, err := base64.RawURLEncoding.DecodeString(parts[0])
+    if err != nil || len(data) < s.aead.NonceSize() {
+      return nil, ErrSessionNotFound
+    }
+    size := s.aead.NonceSize()
+    if data, err = s.aead.Open(nil, data[:size], data[size:], nil); err != nil {
+      return nil, ErrSessionNotFound
+    }
+    session = &Session{}
+    if err := json.Unmarshal(data, session); err != nil {
+      return nil, ErrSessionNotFound
+    }
+  }
+  if clock().Now().After(session.ExpiresAt) {
+    return nil, ErrSessionNotFound
+  }
+  return session, nil
+}
+
+// Revoke deletes the session of the request, if any, and clears its cookie.
+func (s *Sessions) Revoke(w http.ResponseWriter, r *http.Request) error {
+  http.SetCookie(w, s.cookie("", time.Unix(0, 0)))
+  if s.Store == nil {
+    return nil
+  }
+  session, err := s.Lookup(r)
+  if err == ErrSessionNotFound {
+    return nil
+  } else if err != nil {
+    return err
+  }
+  return s.RevokeByID(r.Context(), session.ID)
+}
+
+// RevokeByID deletes a session (eg. when the user logs out everywhere).
+// Sessions kept in cookies can't be revoked.
+func (s *Sessions) RevokeByID(ctx context.Context, id string) error {
+  if s.Store == nil {
+    return errors.New("Cookie sessions can't be revoked")
+  }
+  return s.Store.Delete(ctx, id)
+}
+
+// CSRFToken returns the CSRF token of a session, which the front-end must
+// send in the X-CSRF-Token header of the requests with unsafe methods.
+func (s *Sessions) CSRFToken(session *Session) string {
+  return base64.RawURLEncoding.EncodeToString(s.mac("csrf:" + session.ID))
+}
+
+// checkCSRF returns true if the request has a safe method, or the CSRF
+// token of the session.
+func (s *Sessions) checkCSRF(r *http.Request, session *Session) bool {
+  switch r.Method {
+  case "GET", "HEAD", "OPTIONS", "TRACE":
+    return true
+  }
+  return hmac.Equal([]byte(r.Header.Get(CSRFHeader)),
+                    []byte(s.CSRFToken(session)))
+}
+
+// sign returns the signature of a cookie payload.
+func (s *Sessions) sign(payload string) string {
+  return base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+// mac returns the HMAC-SHA256 of data with the session secret.
+func (s *Sessions) mac(data string) []byte {
+  mac := hmac.New(sha256.New, s.secret)
+  mac.Write([]byte(data))
+  return mac.Sum(nil)
+}
+
+// cookie returns the session cookie with the given value.
+func (s *Sessions) cookie(value string, expires time.Time) *http.Cookie {
+  path := s.Path
+  if path == "" {
+    path = "/"
+  }
+  sameSite := s.SameSite
+  if sameSite == 0 {
+    sameSite = http.SameSiteLaxMode
+  }
+  return &http.Cookie{
+    Name: s.cookieName(),
+    Value: value,
+    Path: path,
+    Domain: s.Domain,
+    Expires: expires,
+    HttpOnly: true,
+    Secure: !s.Insecure,
+    SameSite: sameSite,
+  }
+}
+
+// cookieName returns the name of the session cookie.
+func (s *Sessions) cookieName() string {
+  if s.CookieName == "" {
+    return "ign_session"
+  }
+  return s.CookieName
+}
+
+// maxAge returns the duration of new sessions.
+func (s *Sessions) maxAge() time.Duration {
+  if s.MaxAge <= 0 {
+    return 24 * time.Hour
+  }
+  return s.MaxAge
+}
+
+// sessionAuth attaches the identity of the request session, if any, to
+// the request. It returns false if the request has no valid session, or it
+// has an unsafe method and no valid CSRF token.
+func sessionAuth(r *http.Request) (*http.Request, bool) {
+  if gServer == nil || gServer.Sessions == nil {
+    return r, false
+  }
+  session, err := gServer.Sessions.Lookup(r)
+  if err != nil {
+    if err != ErrSessionNotFound {
+      gLogger.Error("Unable to get session", Fields{"error": err})
+    }
+    return r, false
+  }
+  if !gServer.Sessions.checkCSRF(r, session) {
+    gLogger.Debug("Missing or invalid CSRF token",
+                  Fields{"method": r.Method, "path": r.URL.Path})
+    return r, false
+  }
+  identity := session.Identity
+  ctx := context.WithValue(r.Context(), sessionKey{}, session)
+  ctx = context.WithValue(ctx, identityKey{}, &identity)
+  return r.WithContext(ctx), true
+}
+
+/////////////////////////////////////////////////
+
+// MemorySessionStore is a SessionStore that keeps the sessions in memory.
+// It is only suitable for a single server instance.
+type MemorySessionStore struct {
+  mutex sync.Mutex
+  sessions map[string]*Session
+  lastSweep time.Time
+}
+
+// NewMemorySessionStore creates an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+  return &MemorySessionStore{sessions: map[string]*Session{},
+                             lastSweep: clock().Now()}
+}
+
+// Save stores a session, and removes the expired ones.
+func (s *MemorySessionStore) Save(ctx context.Context, session *Session) error {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  now := clock().Now()
+  if now.Sub(s.lastSweep) > rateLimitSweepInterval {
+    for id, other := range s.sessions {
+      if now.After(other.ExpiresAt) {
+        delete(s.sessions, id)
+      }
+    }
+    s.lastSweep = now
+  }
+  stored := *session
+  s.sessions[session.ID] = &stored
+  return nil
+}
+
+// Get returns a copy of a session.
+func (s *MemorySessionStore) Get(ctx context.Context, id string) (*Session, error) {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+
+  session, ok := s.sessions[id]
+  if !ok || clock().Now().After(session.ExpiresAt) {
+    return nil, ErrSessionNotFound
+  }
+  found := *session
+  return &found, nil
+}
+
+// Delete removes a session.
+func (s *MemorySessionStore) Delete(ctx context.Context, id string) error {
+  s.mutex.Lock()
+  defer s.mutex.Unlock()
+  delete(s.sessions, id)
+  return nil
+}
+
+/////////////////////////////////////////////////
+
+// RedisSessionStore is a SessionStore that keeps the sessions in Redis, as
+// JSON values that expire with the session.
+type RedisSessionStore struct {
+  Client redis.UniversalClient
+  // Prefix of the Redis keys (eg. "ign:session:").
+  Prefix string
+}
+
+// NewRedisSessionStore creates a RedisSessionStore using the given client.
+func NewRedisSessionStore(client redis.UniversalClient) *RedisSessionStore {
+  return &RedisSessionStore{Client: client, Prefix: "ign:session:"}
+}
+
+// Save stores a session.
+func (s *RedisSessionStore) Save(ctx context.Context, session *Session) error {
+  data, err := json.Marshal(session)
+  if err != nil {
+    return err
+  }
+  return s.Client.Set(ctx, s.Prefix + session.ID, data,
+                      session.ExpiresAt.Sub(clock().Now())).Err()
+}
+
+// Get returns a session.
+func (s *RedisSessionStore) Get(ctx context.Context, id string) (*Session, error) {
+  data, err := s.Client.Get(ctx, s.Prefix + id).Bytes()
+  if err == redis.Nil {
+    return nil, ErrSessionNotFound
+  } else if err != nil {
+    return nil, err
+  }
+  var session Session
+  if err := json.Unmarshal(data, &session); err != nil {
+    return nil, err
+  }
+  return &session, nil
+}
+
+// Delete removes a session.
+func (s *RedisSessionStore) Delete(ctx context.Context, id string) error {
+  return s.Client.Del(ctx, s.Prefix + id).Err()
+}
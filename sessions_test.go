@@ -0,0 +1,135 @@
+package ign
+
+import (
+  "encoding/base64"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+// sessionRequest returns a request with the cookies set in the given
+// response.
+func sessionRequest(rec *httptest.ResponseRecorder) *http.Request {
+  req, _ := http.NewRequest("GET", "/models", nil)
+  for _, c := range rec.Result().Cookies() {
+    req.AddCookie(c)
+  }
+  return req
+}
+
+// TestSessions tests creating, looking up and revoking sessions.
+func TestSessions(t *testing.T) {
+  secret := []byte(strings.Repeat("s", 32))
+  if _, err := NewSessions(nil, []byte("short")); err == nil {
+    t.Fatal("Expected an error with a short secret")
+  }
+
+  for _, store := range []SessionStore{nil, NewMemorySessionStore()} {
+    sessions, _ := NewSessions(store, secret)
+    create, _ := http.NewRequest("POST", "/login", nil)
+    rec := httptest.NewRecorder()
+    created, err := sessions.Create(rec, create, Identity{Subject: "alice",
+                                                         Scopes: []string{"read"}})
+    if err != nil {
+      t.Fatal("Unable to create session:", err)
+    }
+    cookie := rec.Result().Cookies()[0]
+    if !cookie.HttpOnly || !cookie.Secure {
+      t.Fatal("Session cookies should be HttpOnly and Secure")
+    }
+    // Cookie sessions are encrypted
+    data, _ := base64.RawURLEncoding.DecodeString(strings.Split(cookie.Value, ".")[0])
+    if strings.Contains(string(data), "alice") {
+      t.Fatal("The session should not be readable from the cookie")
+    }
+
+    session, err := sessions.Lookup(sessionRequest(rec))
+    if err != nil || session.ID != created.ID ||
+       session.Identity.Subject != "alice" || !session.Identity.HasScope("read") {
+      t.Fatal("Unexpected session:", session, err)
+    }
+
+    // Tampered cookies are rejected
+    tampered, _ := http.NewRequest("GET", "/models", nil)
+    tampered.AddCookie(&http.Cookie{Name: cookie.Name, Value: "x" + cookie.Value})
+    if _, err := sessions.Lookup(tampered); err != ErrSessionNotFound {
+      t.Fatal("Tampered cookie should be rejected. Got:", err)
+    }
+
+    if err := sessions.Revoke(httptest.NewRecorder(), sessionRequest(rec)); err != nil {
+      t.Fatal("Unable to revoke session:", err)
+    }
+    if _, err := sessions.Lookup(sessionRequest(rec)); store != nil &&
+       err != ErrSessionNotFound {
+      t.Fatal("Revoked session should not be found. Got:", err)
+    }
+  }
+
+  // Sessions expire using the server clock
+  prev := gServer
+  defer func() { gServer = prev }()
+  clk := NewManualClock(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+  gServer = &Server{Clock: clk}
+  for _, store := range []SessionStore{nil, NewMemorySessionStore()} {
+    sessions, _ := NewSessions(store, secret)
+    create, _ := http.NewRequest("POST", "/login", nil)
+    rec := httptest.NewRecorder()
+    if _, err := sessions.Create(rec, create, Identity{Subject: "alice"}); err != nil {
+      t.Fatal("Unable to create session:", err)
+    }
+    clk.Add(sessions.maxAge() + time.Second)
+    if _, err := sessions.Lookup(sessionRequest(rec)); err != ErrSessionNotFound {
+      t.Fatal("Expired session should not be found. Got:", err)
+    }
+  }
+}
+
+// TestSessionAuth tests authenticating requests without JWT using their
+// session.
+func TestSessionAuth(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  sessions, _ := NewSessions(NewMemorySessionStore(), []byte(strings.Repeat("s", 32)))
+  gServer = &Server{Sessions: sessions}
+
+  login, _ := http.NewRequest("POST", "/login", nil)
+  rec := httptest.NewRecorder()
+  sessions.Create(rec, login, Identity{Subject: "alice"})
+
+  // The JWT middleware must not run for requests with a session
+  jwtMiddleware := func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    t.Fatal("JWT middleware should be skipped")
+  }
+  called := false
+  newAuthMiddleware(jwtMiddleware, false)(httptest.NewRecorder(), sessionRequest(rec),
+    func(w http.ResponseWriter, r *http.Request) {
+      called = true
+      if sub, ok := GetUserIdentity(r); !ok || sub != "alice" {
+        t.Fatal("Unexpected user identity:", sub)
+      }
+      if _, ok := SessionFromRequest(r); !ok {
+        t.Fatal("Missing request session")
+      }
+    })
+  if !called {
+    t.Fatal("Request with a session was not authenticated")
+  }
+
+  // Unsafe methods need the CSRF token of the session
+  post := sessionRequest(rec)
+  post.Method = "POST"
+  if _, ok := sessionAuth(post); ok {
+    t.Fatal("Requests without CSRF token should not use the session")
+  }
+  post.Header.Set(CSRFHeader, "x")
+  if _, ok := sessionAuth(post); ok {
+    t.Fatal("Requests with an invalid CSRF token should not use the session")
+  }
+  session, _ := sessions.Lookup(post)
+  post.Header.Set(CSRFHeader, sessions.CSRFToken(session))
+  if _, ok := sessionAuth(post); !ok {
+    t.Fatal("Requests with the CSRF token should use the session")
+  }
+}
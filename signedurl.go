@@ -0,0 +1,178 @@
+package ign
+
+import (
+  "context"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "errors"
+  "net/http"
+  "net/url"
+  "path"
+  "strconv"
+  "strings"
+  "time"
+  "github.com/aws/aws-sdk-go-v2/aws"
+  "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Signed URLs allow clients to upload or download large files directly
+// from the storage, without proxying the bytes through the API server.
+// S3Storage creates S3 presigned URLs. LocalStorage creates URLs signed
+// with HMAC-SHA256, that are served by LocalStorage.ServeHTTP.
+
+// uploadURLSigner is implemented by the storages that can create signed
+// upload URLs.
+type uploadURLSigner interface {
+  SignedUploadURL(ctx context.Context, key string,
+                  expiry time.Duration) (string, error)
+}
+
+// SignedDownloadURL returns a URL that can be used to download the content
+// stored with the given key using a GET request, until the expiry elapses.
+// It returns ErrSignedURLNotSupported if the storage can't sign URLs.
+func SignedDownloadURL(ctx context.Context, s Storage, key string,
+                       expiry time.Duration) (string, error) {
+  return s.SignedURL(ctx, key, expiry)
+}
+
+// SignedUploadURL returns a URL that can be used to upload content with the
+// given key using a PUT request, until the expiry elapses.
+// It returns ErrSignedURLNotSupported if the storage can't sign URLs.
+func SignedUploadURL(ctx context.Context, s Storage, key string,
+                     expiry time.Duration) (string, error) {
+  signer, ok := s.(uploadURLSigner)
+  if !ok {
+    return "", ErrSignedURLNotSupported
+  }
+  return signer.SignedUploadURL(ctx, key, expiry)
+}
+
+/////////////////////////////////////////////////
+
+// SignedUploadURL returns a presigned PutObject URL.
+func (s *S3Storage) SignedUploadURL(ctx context.Context, key string,
+                                    expiry time.Duration) (string, error) {
+  objKey, err := s.objectKey(key)
+  if err != nil {
+    return "", err
+  }
+  req, err := s.presign.PresignPutObject(ctx, &s3.PutObjectInput{
+    Bucket: aws.String(s.Bucket),
+    Key: objKey,
+  }, s3.WithPresignExpires(expiry))
+  if err != nil {
+    return "", err
+  }
+  return req.URL, nil
+}
+
+/////////////////////////////////////////////////
+
+// SignedURL returns an HMAC signed GET URL. It requires the BaseURL and
+// SigningKey of the storage.
+func (s *LocalStorage) SignedURL(ctx context.Context, key string,
+                                 expiry time.Duration) (string, error) {
+  return s.signURL("GET", key, expiry)
+}
+
+// SignedUploadURL returns an HMAC signed PUT URL. It requires the BaseURL
+// and SigningKey of the storage.
+func (s *LocalStorage) SignedUploadURL(ctx context.Context, key string,
+                                       expiry time.Duration) (string, error) {
+  return s.signURL("PUT", key, expiry)
+}
+
+// signURL returns the BaseURL of the key with the expires and signature
+// query params.
+func (s *LocalStorage) signURL(method, key string,
+                               expiry time.Duration) (string, error) {
+  if s.BaseURL == "" || len(s.SigningKey) == 0 {
+    return "", ErrSignedURLNotSupported
+  }
+  key, err := storageKey(key)
+  if err != nil {
+    return "", err
+  }
+  expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+  q := url.Values{}
+  q.Set("expires", expires)
+  q.Set("signature", s.signature(method, key, expires))
+  escaped := (&url.URL{Path: key}).EscapedPath()
+  return strings.TrimSuffix(s.BaseURL, "/") + "/" + escaped + "?" + q.Encode(),
+         nil
+}
+
+// signature returns the hex encoded HMAC of a signed URL.
+func (s *LocalStorage) signature(method, key, expires string) string {
+  mac := hmac.New(sha256.New, s.SigningKey)
+  mac.Write([]byte(method + "\n" + key + "\n" + expires))
+  return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyURL checks the signature and expiration of a signed URL request,
+// and returns the requested key.
+func (s *LocalStorage) verifyURL(r *http.Request) (string, error) {
+  if len(s.SigningKey) == 0 {
+    return "", ErrSignedURLNotSupported
+  }
+  base, err := url.Parse(s.BaseURL)
+  if err != nil {
+    return "", err
+  }
+  key := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(base.Path, "/") + "/")
+  if key, err = storageKey(key); err != nil {
+    return "", err
+  }
+
+  expires := r.URL.Query().Get("expires")
+  exp, err := strconv.ParseInt(expires, 10, 64)
+  if err != nil || time.Now().Unix() > exp {
+    return "", errors.New("Expired signed URL")
+  }
+  method := r.Method
+  if method == "HEAD" {
+    method = "GET"
+  }
+  expected := s.signature(method, key, expires)
+  if !hmac.Equal([]byte(expected), []byte(r.URL.Query().Get("signature"))) {
+    return "", errors.New("Invalid signed URL signature")
+  }
+  return key, nil
+}
+
+/////////////////////////////////////////////////
+
+// ServeHTTP serves the signed URLs created by LocalStorage. GET (and HEAD)
+// requests download the file, and PUT requests upload it. It must be
+// registered in the path of the BaseURL
+// (eg. router.PathPrefix("/storage/").Handler(s)).
+// Invalid or expired URLs are rejected with ErrorUnauthorized. Uploads are
+// limited to Server.MaxRequestBodySize, as the handler is not behind the
+// route middlewares.
+func (s *LocalStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  key, err := s.verifyURL(r)
+  if err != nil {
+    reportJSONError(w, r, *NewErrorMessageWithBase(ErrorUnauthorized, err))
+    return
+  }
+
+  switch r.Method {
+  case "GET", "HEAD":
+    p, _ := s.path(key)
+    if em := ServeFile(w, r, p, path.Base(key)); em != nil {
+      reportJSONError(w, r, *em)
+    }
+  case "PUT":
+    limit := newBodyLimitMiddleware(0)
+    limit(w, r, func(w http.ResponseWriter, r *http.Request) {
+      if em := StoreRequestBody(r, s, key); em != nil {
+        reportJSONError(w, r, *em)
+        return
+      }
+      w.WriteHeader(http.StatusCreated)
+    })
+  default:
+    reportJSONError(w, r, *NewErrorMessage(ErrorMethodNotAllowed))
+  }
+}
@@ -0,0 +1,94 @@
+package ign
+
+import (
+  "context"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+// TestLocalSignedURLs tests uploading and downloading files using HMAC
+// signed URLs.
+func TestLocalSignedURLs(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "storage")
+  defer os.RemoveAll(dir)
+  s, _ := NewLocalStorage(dir)
+  ctx := context.Background()
+
+  if _, err := SignedUploadURL(ctx, s, "box/model.sdf", time.Minute);
+     err != ErrSignedURLNotSupported {
+    t.Fatal("Signed URLs need a signing key:", err)
+  }
+  s.BaseURL = "http://example.org/storage"
+  s.SigningKey = []byte("secret")
+
+  send := func(method, url, body string) *httptest.ResponseRecorder {
+    r := httptest.NewRequest(method, url, strings.NewReader(body))
+    w := httptest.NewRecorder()
+    s.ServeHTTP(w, r)
+    return w
+  }
+
+  upload, err := SignedUploadURL(ctx, s, "box/my model.sdf", time.Minute)
+  if err != nil {
+    t.Fatal("Unable to sign the upload URL:", err)
+  }
+  if !strings.HasPrefix(upload, "http://example.org/storage/box/my%20model.sdf?") {
+    t.Fatal("Unexpected upload URL:", upload)
+  }
+  if w := send("PUT", upload, "<sdf/>"); w.Code != http.StatusCreated {
+    t.Fatal("Unable to upload:", w.Code, w.Body.String())
+  }
+  if w := send("GET", upload, ""); w.Code != http.StatusUnauthorized {
+    t.Fatal("Upload URLs should not allow downloads:", w.Code)
+  }
+
+  download, err := SignedDownloadURL(ctx, s, "box/my model.sdf", time.Minute)
+  if err != nil {
+    t.Fatal("Unable to sign the download URL:", err)
+  }
+  if w := send("GET", download, ""); w.Code != http.StatusOK ||
+     w.Body.String() != "<sdf/>" {
+    t.Fatal("Unable to download:", w.Code, w.Body.String())
+  }
+  if w := send("PUT", download, "x"); w.Code != http.StatusUnauthorized {
+    t.Fatal("Download URLs should not allow uploads:", w.Code)
+  }
+
+  tampered := strings.Replace(download, "my%20model", "other", 1)
+  if w := send("GET", tampered, ""); w.Code != http.StatusUnauthorized {
+    t.Fatal("Tampered URLs should be rejected:", w.Code)
+  }
+  expired, _ := SignedDownloadURL(ctx, s, "box/my model.sdf", -time.Minute)
+  if w := send("GET", expired, ""); w.Code != http.StatusUnauthorized {
+    t.Fatal("Expired URLs should be rejected:", w.Code)
+  }
+
+  // Uploads are limited to the max request body size
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{MaxRequestBodySize: 4}
+  large, _ := SignedUploadURL(ctx, s, "box/large.sdf", time.Minute)
+  r := httptest.NewRequest("PUT", large, strings.NewReader("<sdf/>"))
+  // Unknown length, so the body is read until the limit
+  r.ContentLength = -1
+  w := httptest.NewRecorder()
+  s.ServeHTTP(w, r)
+  if w.Code != http.StatusRequestEntityTooLarge {
+    t.Fatal("Large uploads should be rejected:", w.Code, w.Body.String())
+  }
+  if _, err := os.Stat(filepath.Join(dir, "box", "large.sdf")); !os.IsNotExist(err) {
+    t.Fatal("Rejected uploads should not be stored:", err)
+  }
+
+  // Storages without upload signing
+  if _, err := SignedUploadURL(ctx, struct{ Storage }{s}, "box/x", time.Minute);
+     err != ErrSignedURLNotSupported {
+    t.Fatal("Unexpected error:", err)
+  }
+}
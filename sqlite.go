@@ -0,0 +1,12 @@
+// +build sqlite
+
+package ign
+
+import (
+  "gorm.io/driver/sqlite"
+)
+
+// Registers the SQLite driver used when IGN_TEST_SQLITE is set.
+func init() {
+  sqliteDialector = sqlite.Open
+}
@@ -0,0 +1,130 @@
+package ign
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "reflect"
+  "strings"
+  "time"
+)
+
+// SSEHeartbeatInterval is the interval between the heartbeat comments sent
+// to Server-Sent Events clients. Heartbeats keep idle connections open
+// through proxies and load balancers.
+var SSEHeartbeatInterval = 15 * time.Second
+
+// SSEEvent is an event sent to a Server-Sent Events client.
+type SSEEvent struct {
+  // Optional event id.
+  ID string
+  // Optional event type. Clients receive "message" events by default.
+  Event string
+  // Event data. Strings are sent as is, other values are encoded to JSON.
+  Data interface{}
+}
+
+// SSEResult provides Server-Sent Events (text/event-stream) responses. The
+// handler must return a channel, and each value received from it is sent to
+// the client as an event. Values that are not SSEEvent are sent as the data
+// of an unnamed event. The response ends when the channel is closed or the
+// client disconnects; producers should stop when the request context is
+// done. The write deadline of the server (see http.Server.WriteTimeout) is
+// disabled for the response, so long streams are not cut.
+type SSEResult HandlerWithResult
+
+/////////////////////////////////////////////////
+func (fn SSEResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  result, err := fn(w, r)
+  if err != nil {
+    reportJSONError(w, r, *err)
+    return
+  }
+
+  ch := reflect.ValueOf(result)
+  if ch.Kind() != reflect.Chan || ch.Type().ChanDir() & reflect.RecvDir == 0 {
+    em := NewErrorMessageWithBase(ErrorInvalidResult,
+                                  errors.New("SSE results must be channels"))
+    reportJSONError(w, r, *em)
+    return
+  }
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    reportError(w, "Streaming is not supported", http.StatusInternalServerError)
+    return
+  }
+
+  if err := responseController(w, r).SetWriteDeadline(time.Time{}); err != nil {
+    gLogger.Warn("Unable to disable the write deadline of the SSE response",
+                 Fields{"error": err})
+  }
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.Header().Set("Connection", "keep-alive")
+  // Disable response buffering in nginx
+  w.Header().Set("X-Accel-Buffering", "no")
+  w.WriteHeader(http.StatusOK)
+  flusher.Flush()
+
+  heartbeat := time.NewTicker(SSEHeartbeatInterval)
+  defer heartbeat.Stop()
+
+  cases := []reflect.SelectCase{
+    {Dir: reflect.SelectRecv, Chan: ch},
+    {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(heartbeat.C)},
+    {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.Context().Done())},
+  }
+  for {
+    chosen, value, ok := reflect.Select(cases)
+    switch chosen {
+    case 0:
+      if !ok {
+        return
+      }
+      if e := writeSSEEvent(w, value.Interface()); e != nil {
+        gLogger.Error("Error while sending SSE event", Fields{"error": e})
+        return
+      }
+    case 1:
+      fmt.Fprint(w, ": heartbeat\n\n")
+    default:
+      // Client disconnected
+      return
+    }
+    flusher.Flush()
+  }
+}
+
+// writeSSEEvent writes an event using the text/event-stream format.
+func writeSSEEvent(w http.ResponseWriter, value interface{}) error {
+  event, ok := value.(SSEEvent)
+  if !ok {
+    event = SSEEvent{Data: value}
+  }
+
+  data, ok := event.Data.(string)
+  if !ok {
+    encoded, err := json.Marshal(event.Data)
+    if err != nil {
+      return err
+    }
+    data = string(encoded)
+  }
+
+  var msg bytes.Buffer
+  if event.ID != "" {
+    msg.WriteString("id: " + event.ID + "\n")
+  }
+  if event.Event != "" {
+    msg.WriteString("event: " + event.Event + "\n")
+  }
+  for _, line := range strings.Split(data, "\n") {
+    msg.WriteString("data: " + line + "\n")
+  }
+  msg.WriteString("\n")
+  _, err := w.Write(msg.Bytes())
+  return err
+}
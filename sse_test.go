@@ -0,0 +1,94 @@
+package ign
+
+import (
+  "context"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+// TestSSEResult tests sending events and heartbeats.
+func TestSSEResult(t *testing.T) {
+  prev := SSEHeartbeatInterval
+  defer func() { SSEHeartbeatInterval = prev }()
+  SSEHeartbeatInterval = 10 * time.Millisecond
+
+  ch := make(chan interface{})
+  go func() {
+    ch <- SSEEvent{ID: "1", Event: "status", Data: "running\nstep 2"}
+    time.Sleep(25 * time.Millisecond)
+    ch <- testItem{Name: "box"}
+    close(ch)
+  }()
+  rec := serveResult(SSEResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return ch, nil
+  }))
+
+  body := rec.Body.String()
+  if rec.Header().Get("Content-Type") != "text/event-stream" {
+    t.Fatal("Unexpected content type:", rec.Header().Get("Content-Type"))
+  }
+  if !strings.HasPrefix(body, "id: 1\nevent: status\ndata: running\ndata: step 2\n\n") {
+    t.Fatal("Unexpected first event:", body)
+  }
+  if !strings.Contains(body, ": heartbeat\n\n") {
+    t.Fatal("Missing heartbeat:", body)
+  }
+  if !strings.HasSuffix(body, `data: {"name":"box","likes":0}` + "\n\n") {
+    t.Fatal("Unexpected last event:", body)
+  }
+}
+
+// TestSSEResultDisconnect tests that the response ends when the client
+// disconnects.
+func TestSSEResultDisconnect(t *testing.T) {
+  ctx, cancel := context.WithCancel(context.Background())
+  req, _ := http.NewRequest("GET", "/events", nil)
+  req = req.WithContext(ctx)
+
+  done := make(chan struct{})
+  go func() {
+    SSEResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+      return make(chan SSEEvent), nil
+    }).ServeHTTP(httptest.NewRecorder(), req)
+    close(done)
+  }()
+  cancel()
+  select {
+  case <-done:
+  case <-time.After(time.Second):
+    t.Fatal("SSE response did not end after the client disconnected")
+  }
+}
+
+// TestSSEResultWriteTimeout tests that streams last longer than the write
+// timeout of the server.
+func TestSSEResultWriteTimeout(t *testing.T) {
+  ch := make(chan interface{})
+  go func() {
+    time.Sleep(150 * time.Millisecond)
+    ch <- "done"
+    close(ch)
+  }()
+  srv := httptest.NewUnstartedServer(logger(SSEResult(
+    func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+      return ch, nil
+    }), "events"))
+  srv.Config.WriteTimeout = 50 * time.Millisecond
+  srv.Start()
+  defer srv.Close()
+
+  resp, err := http.Get(srv.URL)
+  if err != nil {
+    t.Fatal("Unable to get the events:", err)
+  }
+  defer resp.Body.Close()
+  body, err := ioutil.ReadAll(resp.Body)
+  if err != nil || string(body) != "data: done\n\n" {
+    t.Fatal("The stream should not be cut by the write timeout:", err,
+            string(body))
+  }
+}
@@ -0,0 +1,382 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+  "github.com/aws/aws-sdk-go-v2/aws"
+  "github.com/aws/aws-sdk-go-v2/config"
+  "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+  "github.com/aws/aws-sdk-go-v2/service/s3"
+  "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Storage is the interface used to store blobs, such as model files.
+// Keys are slash separated paths (eg. "models/box/model.sdf").
+type Storage interface {
+  // Put stores the content read from r with the given key, replacing any
+  // previous content.
+  Put(ctx context.Context, key string, r io.Reader) error
+  // Get returns the content stored with the given key. It returns
+  // ErrStorageNotFound if the key doesn't exist. The caller must close the
+  // returned reader.
+  Get(ctx context.Context, key string) (io.ReadCloser, error)
+  // Delete removes the content stored with the given key. Deleting a key
+  // that doesn't exist is not an error.
+  Delete(ctx context.Context, key string) error
+  // List returns the sorted keys that start with the given prefix.
+  List(ctx context.Context, prefix string) ([]string, error)
+  // SignedURL returns a URL that can be used to download the content stored
+  // with the given key, without credentials, until the expiry elapses.
+  SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// ErrStorageNotFound is returned by Storage.Get when the key doesn't exist.
+var ErrStorageNotFound = errors.New("Storage key not found")
+
+// ErrSignedURLNotSupported is returned by Storage.SignedURL when the storage
+// can't create signed URLs.
+var ErrSignedURLNotSupported = errors.New("Signed URLs are not supported")
+
+// NewStorageFromEnvVars creates the Storage selected with the
+// IGN_STORAGE_PROVIDER env var. Supported values are "local" and "s3".
+// It returns nil if IGN_STORAGE_PROVIDER is not set.
+func NewStorageFromEnvVars() (Storage, error) {
+  kind, err := ReadEnvVar("IGN_STORAGE_PROVIDER")
+  if err != nil {
+    return nil, nil
+  }
+
+  switch kind {
+  case "local":
+    dir, err := ReadEnvVar("IGN_STORAGE_LOCAL_DIR")
+    if err != nil {
+      return nil, err
+    }
+    s, err := NewLocalStorage(dir)
+    if err != nil {
+      return nil, err
+    }
+    s.BaseURL = os.Getenv("IGN_STORAGE_LOCAL_URL")
+    s.SigningKey = []byte(os.Getenv("IGN_STORAGE_SIGNING_KEY"))
+    return s, nil
+  case "s3":
+    bucket, err := ReadEnvVar("IGN_STORAGE_S3_BUCKET")
+    if err != nil {
+      return nil, err
+    }
+    return NewS3Storage(bucket, os.Getenv("IGN_STORAGE_S3_PREFIX"))
+  }
+  return nil, errors.New("Unknown IGN_STORAGE_PROVIDER [" + kind + "]")
+}
+
+// storageKey validates and cleans a storage key. Keys can't be empty,
+// absolute or point outside the storage (eg. "../x").
+func storageKey(key string) (string, error) {
+  clean := path.Clean(key)
+  if key == "" || strings.HasPrefix(key, "/") || clean == "." ||
+     clean == ".." || strings.HasPrefix(clean, "../") {
+    return "", errors.New("Invalid storage key [" + key + "]")
+  }
+  return clean, nil
+}
+
+/////////////////////////////////////////////////
+
+// LocalStorage is a Storage that keeps the content in a local directory.
+// Each key is stored as a file in the Root directory.
+type LocalStorage struct {
+  // Root is the directory where the content is stored.
+  Root string
+  // BaseURL is the URL where the storage is served (see ServeHTTP), used
+  // to create signed URLs (eg. https://api.example.org/storage).
+  BaseURL string
+  // SigningKey is the secret used to sign URLs. Signed URLs are not
+  // supported if empty.
+  SigningKey []byte
+}
+
+// NewLocalStorage creates a LocalStorage in the given directory, creating
+// it if needed.
+func NewLocalStorage(root string) (*LocalStorage, error) {
+  root, err := filepath.Abs(root)
+  if err != nil {
+    return nil, err
+  }
+  if err := os.MkdirAll(root, 0755); err != nil {
+    return nil, err
+  }
+  return &LocalStorage{Root: root}, nil
+}
+
+// path returns the file path of the given key.
+func (s *LocalStorage) path(key string) (string, error) {
+  key, err := storageKey(key)
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(s.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the content to a temporary file that is renamed once
+// complete, so readers never see partial content.
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) error {
+  p, err := s.path(key)
+  if err != nil {
+    return err
+  }
+  if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+    return err
+  }
+  tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())
+
+  if _, err := io.Copy(tmp, r); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Close(); err != nil {
+    return err
+  }
+  return os.Rename(tmp.Name(), p)
+}
+
+// Get opens the file of the given key.
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+  p, err := s.path(key)
+  if err != nil {
+    return nil, err
+  }
+  f, err := os.Open(p)
+  if os.IsNotExist(err) {
+    return nil, ErrStorageNotFound
+  }
+  return f, err
+}
+
+// Delete removes the file of the given key.
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+  p, err := s.path(key)
+  if err != nil {
+    return err
+  }
+  if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+    return err
+  }
+  return nil
+}
+
+// List walks the Root directory looking for keys with the given prefix.
+// Temporary files of ongoing uploads are skipped.
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]string, error) {
+  keys := []string{}
+  err := filepath.Walk(s.Root, func(p string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+    if info.IsDir() || strings.HasPrefix(info.Name(), ".upload-") {
+      return nil
+    }
+    rel, err := filepath.Rel(s.Root, p)
+    if err != nil {
+      return err
+    }
+    if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+      keys = append(keys, key)
+    }
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+  sort.Strings(keys)
+  return keys, nil
+}
+
+/////////////////////////////////////////////////
+
+// S3Storage is a Storage that keeps the content in an AWS S3 bucket.
+// AWS credentials and region are read using the default AWS configuration
+// chain (eg. AWS_REGION and AWS_ACCESS_KEY_ID env vars, or instance roles).
+type S3Storage struct {
+  // Bucket is the name of the S3 bucket.
+  Bucket string
+  // Prefix is prepended to all the keys (eg. "fuel/"), so several services
+  // can share a bucket.
+  Prefix string
+  client *s3.Client
+  presign *s3.PresignClient
+  uploader *manager.Uploader
+}
+
+// S3HTTPClient, if not nil, is the HTTP client used by the S3Storages
+// created afterwards, instead of the default AWS client. Tests use it to
+// record and replay the S3 requests (see igntest.NewRecorder).
+var S3HTTPClient aws.HTTPClient
+
+// NewS3Storage creates an S3Storage for the given bucket and key prefix.
+func NewS3Storage(bucket, prefix string) (*S3Storage, error) {
+  cfg, err := config.LoadDefaultConfig(context.Background())
+  if err != nil {
+    return nil, err
+  }
+  if S3HTTPClient != nil {
+    cfg.HTTPClient = S3HTTPClient
+  }
+  return newS3StorageWithConfig(cfg, bucket, prefix), nil
+}
+
+// newS3StorageWithConfig creates an S3Storage with the given AWS
+// configuration and S3 options.
+func newS3StorageWithConfig(cfg aws.Config, bucket, prefix string,
+                            optFns ...func(*s3.Options)) *S3Storage {
+  client := s3.NewFromConfig(cfg, optFns...)
+  return &S3Storage{
+    Bucket: bucket,
+    Prefix: prefix,
+    client: client,
+    presign: s3.NewPresignClient(client),
+    uploader: manager.NewUploader(client),
+  }
+}
+
+// objectKey returns the S3 object key of the given storage key.
+func (s *S3Storage) objectKey(key string) (*string, error) {
+  key, err := storageKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return aws.String(s.Prefix + key), nil
+}
+
+// Put uploads the content with the S3 upload manager. The content is read
+// in parts of 5MB, so its size doesn't need to be known: small contents are
+// uploaded with a single PutObject request, and larger ones with a
+// multipart upload, keeping a few parts in memory at a time.
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader) error {
+  objKey, err := s.objectKey(key)
+  if err != nil {
+    return err
+  }
+  _, err = s.uploader.Upload(ctx, &s3.PutObjectInput{
+    Bucket: aws.String(s.Bucket),
+    Key: objKey,
+    Body: r,
+  })
+  return err
+}
+
+// Get downloads the object of the given key.
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+  objKey, err := s.objectKey(key)
+  if err != nil {
+    return nil, err
+  }
+  out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+    Bucket: aws.String(s.Bucket),
+    Key: objKey,
+  })
+  var noKey *types.NoSuchKey
+  if errors.As(err, &noKey) {
+    return nil, ErrStorageNotFound
+  }
+  if err != nil {
+    return nil, err
+  }
+  return out.Body, nil
+}
+
+// Delete removes the object of the given key.
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+  objKey, err := s.objectKey(key)
+  if err != nil {
+    return err
+  }
+  _, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+    Bucket: aws.String(s.Bucket),
+    Key: objKey,
+  })
+  return err
+}
+
+// List returns the keys of the objects with the given prefix, without
+// the S3Storage.Prefix.
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+  keys := []string{}
+  pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+    Bucket: aws.String(s.Bucket),
+    Prefix: aws.String(s.Prefix + prefix),
+  })
+  for pages.HasMorePages() {
+    page, err := pages.NextPage(ctx)
+    if err != nil {
+      return nil, err
+    }
+    for _, obj := range page.Contents {
+      keys = append(keys, strings.TrimPrefix(aws.ToString(obj.Key), s.Prefix))
+    }
+  }
+  sort.Strings(keys)
+  return keys, nil
+}
+
+// SignedURL returns a presigned GetObject URL.
+func (s *S3Storage) SignedURL(ctx context.Context, key string,
+                              expiry time.Duration) (string, error) {
+  objKey, err := s.objectKey(key)
+  if err != nil {
+    return "", err
+  }
+  req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+    Bucket: aws.String(s.Bucket),
+    Key: objKey,
+  }, s3.WithPresignExpires(expiry))
+  if err != nil {
+    return "", err
+  }
+  return req.URL, nil
+}
+
+/////////////////////////////////////////////////
+
+// StorageSink returns a FileSink, to be used with BindMultipart, that
+// streams the uploaded files to the storage. Each file is stored with the
+// key prefix + "/" + filename.
+func StorageSink(ctx context.Context, s Storage, prefix string) FileSink {
+  return func(field, filename string, content io.Reader) error {
+    return s.Put(ctx, path.Join(prefix, path.Base(filename)), content)
+  }
+}
+
+// StoreRequestBody streams the body of the request (eg. a PUT of a single
+// file) to the storage using the given key. It returns ErrorPayloadTooLarge
+// if the body exceeds the route limit, ErrorChecksumMismatch if the body
+// doesn't match the Content-MD5 or Digest headers, and ErrorCreatingFile if
+// the storage fails.
+func StoreRequestBody(r *http.Request, s Storage, key string) *ErrMsg {
+  body, err := NewVerifyingReader(r.Body, r.Header)
+  if err != nil {
+    return NewErrorMessageWithBase(ErrorChecksumMismatch, err)
+  }
+  if err := s.Put(r.Context(), key, body); err != nil {
+    if IsBodyTooLarge(err) {
+      return NewErrorMessageWithBase(ErrorPayloadTooLarge, err)
+    }
+    if IsChecksumMismatch(err) {
+      return NewErrorMessageWithArgs(ErrorChecksumMismatch, err, []string{key})
+    }
+    return NewErrorMessageWithArgs(ErrorCreatingFile, err, []string{key})
+  }
+  return nil
+}
@@ -0,0 +1,203 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "io"
+  "io/ioutil"
+  "mime/multipart"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "reflect"
+  "strings"
+  "sync"
+  "testing"
+  "github.com/aws/aws-sdk-go-v2/aws"
+  "github.com/aws/aws-sdk-go-v2/credentials"
+  "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestLocalStorage tests storing, listing and deleting local blobs.
+func TestLocalStorage(t *testing.T) {
+  dir, err := ioutil.TempDir("", "storage")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  s, err := NewLocalStorage(dir)
+  if err != nil {
+    t.Fatal("Unable to create the storage:", err)
+  }
+  ctx := context.Background()
+
+  for _, key := range []string{"models/box/model.sdf", "models/box/mesh.dae",
+                               "worlds/empty.world"} {
+    if err := s.Put(ctx, key, strings.NewReader(key)); err != nil {
+      t.Fatal("Unable to put", key, err)
+    }
+  }
+
+  rc, err := s.Get(ctx, "models/box/model.sdf")
+  if err != nil {
+    t.Fatal("Unable to get:", err)
+  }
+  data, _ := ioutil.ReadAll(rc)
+  rc.Close()
+  if string(data) != "models/box/model.sdf" {
+    t.Fatal("Unexpected content:", string(data))
+  }
+  if _, err := s.Get(ctx, "models/none"); err != ErrStorageNotFound {
+    t.Fatal("Missing keys should return ErrStorageNotFound:", err)
+  }
+
+  keys, err := s.List(ctx, "models/")
+  if err != nil {
+    t.Fatal("Unable to list:", err)
+  }
+  if !reflect.DeepEqual(keys, []string{"models/box/mesh.dae",
+                                      "models/box/model.sdf"}) {
+    t.Fatal("Unexpected keys:", keys)
+  }
+
+  if err := s.Delete(ctx, "models/box/mesh.dae"); err != nil {
+    t.Fatal("Unable to delete:", err)
+  }
+  if err := s.Delete(ctx, "models/box/mesh.dae"); err != nil {
+    t.Fatal("Deleting a missing key should not fail:", err)
+  }
+  if keys, _ := s.List(ctx, ""); len(keys) != 2 {
+    t.Fatal("Unexpected keys after delete:", keys)
+  }
+
+  for _, key := range []string{"", "/etc/passwd", "../x", "a/../../x", "."} {
+    if err := s.Put(ctx, key, strings.NewReader("x")); err == nil {
+      t.Fatal("Invalid key should be rejected:", key)
+    }
+  }
+  if _, err := s.SignedURL(ctx, "worlds/empty.world", 0);
+     err != ErrSignedURLNotSupported {
+    t.Fatal("Unexpected SignedURL error:", err)
+  }
+}
+
+// TestStorageUploads tests streaming request uploads to a storage.
+func TestStorageUploads(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "storage")
+  defer os.RemoveAll(dir)
+  s, _ := NewLocalStorage(dir)
+
+  var body bytes.Buffer
+  mw := multipart.NewWriter(&body)
+  mw.WriteField("name", "box")
+  fw, _ := mw.CreateFormFile("file", "../model.sdf")
+  fw.Write([]byte("<sdf/>"))
+  mw.Close()
+  r, _ := http.NewRequest("POST", "/models", &body)
+  r.Header.Set("Content-Type", mw.FormDataContentType())
+
+  var form uploadForm
+  if em := BindMultipart(r, &form, StorageSink(r.Context(), s, "box")); em != nil {
+    t.Fatal("Unable to upload the form:", em)
+  }
+  if keys, _ := s.List(r.Context(), ""); !reflect.DeepEqual(keys,
+                                                             []string{"box/model.sdf"}) {
+    t.Fatal("Unexpected keys:", keys)
+  }
+
+  r, _ = http.NewRequest("PUT", "/models/box/mesh.dae", strings.NewReader("mesh"))
+  if em := StoreRequestBody(r, s, "box/mesh.dae"); em != nil {
+    t.Fatal("Unable to store the body:", em)
+  }
+  r, _ = http.NewRequest("PUT", "/models/x", strings.NewReader("x"))
+  if em := StoreRequestBody(r, s, "../x"); em == nil ||
+     em.ErrCode != ErrorCreatingFile {
+    t.Fatal("Invalid keys should be rejected:", em)
+  }
+}
+
+// TestNewStorageFromEnvVars tests selecting the storage with env vars.
+func TestNewStorageFromEnvVars(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "storage")
+  defer os.RemoveAll(dir)
+  defer os.Unsetenv("IGN_STORAGE_PROVIDER")
+  defer os.Unsetenv("IGN_STORAGE_LOCAL_DIR")
+
+  os.Unsetenv("IGN_STORAGE_PROVIDER")
+  if s, err := NewStorageFromEnvVars(); s != nil || err != nil {
+    t.Fatal("No storage expected:", s, err)
+  }
+
+  os.Setenv("IGN_STORAGE_PROVIDER", "local")
+  os.Setenv("IGN_STORAGE_LOCAL_DIR", dir)
+  if s, err := NewStorageFromEnvVars(); err != nil {
+    t.Fatal("Unable to create the local storage:", err)
+  } else if _, ok := s.(*LocalStorage); !ok {
+    t.Fatal("Unexpected storage:", s)
+  }
+
+  os.Setenv("IGN_STORAGE_PROVIDER", "ftp")
+  if _, err := NewStorageFromEnvVars(); err == nil {
+    t.Fatal("Unknown providers should fail")
+  }
+}
+
+// TestS3StoragePut tests uploading a stream of unknown length to a fake S3
+// endpoint.
+func TestS3StoragePut(t *testing.T) {
+  var mutex sync.Mutex
+  objects := map[string][]byte{}
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+                                                  r *http.Request) {
+    mutex.Lock()
+    defer mutex.Unlock()
+    switch r.Method {
+    case "PUT":
+      if r.ContentLength < 0 {
+        http.Error(w, "MissingContentLength", http.StatusLengthRequired)
+        return
+      }
+      data, _ := ioutil.ReadAll(r.Body)
+      objects[r.URL.Path] = data
+      w.Header().Set("ETag", `"etag"`)
+    case "GET":
+      data, ok := objects[r.URL.Path]
+      if !ok {
+        http.Error(w, "NoSuchKey", http.StatusNotFound)
+        return
+      }
+      w.Write(data)
+    default:
+      http.Error(w, "NotImplemented", http.StatusNotImplemented)
+    }
+  }))
+  defer srv.Close()
+
+  cfg := aws.Config{
+    Region: "us-east-1",
+    Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+  }
+  storage := newS3StorageWithConfig(cfg, "bucket", "models/",
+    func(o *s3.Options) {
+      o.BaseEndpoint = aws.String(srv.URL)
+      o.UsePathStyle = true
+    })
+
+  // The reader hides the Seek method of strings.Reader
+  content := struct{ io.Reader }{strings.NewReader("<sdf/>")}
+  if err := storage.Put(context.Background(), "box/model.sdf",
+                        content); err != nil {
+    t.Fatal("Unable to upload a stream:", err)
+  }
+  if string(objects["/bucket/models/box/model.sdf"]) != "<sdf/>" {
+    t.Fatal("Unexpected uploaded objects:", objects)
+  }
+  r, err := storage.Get(context.Background(), "box/model.sdf")
+  if err != nil {
+    t.Fatal("Unable to get the object:", err)
+  }
+  defer r.Close()
+  if data, _ := ioutil.ReadAll(r); string(data) != "<sdf/>" {
+    t.Fatal("Unexpected object content:", string(data))
+  }
+}
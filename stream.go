@@ -0,0 +1,130 @@
+package ign
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "reflect"
+)
+
+// streamFlushItems is the number of items written between flushes.
+const streamFlushItems = 100
+
+// Iterator iterates over the items of a collection.
+type Iterator interface {
+  // Next returns the next item. It returns false when there are no more
+  // items. Next should not block once the request context is done.
+  Next() (item interface{}, ok bool, err error)
+}
+
+// IteratorFunc is an adapter to use a function as an Iterator.
+type IteratorFunc func() (interface{}, bool, error)
+
+// Next is part of the Iterator interface.
+func (f IteratorFunc) Next() (interface{}, bool, error) {
+  return f()
+}
+
+// StreamJSONResult provides JSON serialization for handler results that are
+// large collections. The handler must return a channel (closed by the
+// producer when done) or an Iterator. Items are encoded as a JSON array one
+// at a time, and the response is flushed periodically, so the collection is
+// never held in memory.
+// Errors found after the response started are logged, and the response is
+// truncated.
+// If the client disconnects, the response ends without reading more items.
+// Producers must stop sending to the channel when the request context is
+// done (eg. with a select on r.Context().Done()), or they will block
+// forever.
+type StreamJSONResult HandlerWithResult
+
+/////////////////////////////////////////////////
+func (fn StreamJSONResult) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  result, err := fn(w, r)
+  if err != nil {
+    reportJSONError(w, r, *err)
+    return
+  }
+
+  next, e := streamIterator(r, result)
+  if e != nil {
+    em := NewErrorMessageWithBase(ErrorMarshalJSON, e)
+    reportJSONError(w, r, *em)
+    return
+  }
+
+  flusher, _ := w.(http.Flusher)
+  count := 0
+  for {
+    // Stop if the client went away
+    select {
+    case <-r.Context().Done():
+      return
+    default:
+    }
+
+    item, ok, e := next.Next()
+    if r.Context().Err() != nil {
+      return
+    }
+    var data []byte
+    if e == nil && ok {
+      data, e = json.Marshal(item)
+    }
+    if e != nil {
+      // Errors can only be reported before writing the response.
+      if count == 0 {
+        em := NewErrorMessageWithBase(ErrorMarshalJSON, e)
+        reportJSONError(w, r, *em)
+      } else {
+        gLogger.Error("Error while streaming JSON result",
+                      Fields{"error": e, "items": count})
+      }
+      return
+    }
+
+    if count == 0 {
+      w.Header().Set("Content-Type", "application/json")
+      w.Write([]byte("["))
+    }
+    if !ok {
+      w.Write([]byte("]\n"))
+      return
+    }
+    if count > 0 {
+      w.Write([]byte(","))
+    }
+    w.Write(data)
+    count++
+    if flusher != nil && count % streamFlushItems == 0 {
+      flusher.Flush()
+    }
+  }
+}
+
+// streamIterator returns an Iterator for a StreamJSONResult result. Reading
+// from a channel stops when the request context is done.
+func streamIterator(r *http.Request, result interface{}) (Iterator, error) {
+  if it, ok := result.(Iterator); ok {
+    return it, nil
+  }
+  ch := reflect.ValueOf(result)
+  if ch.Kind() != reflect.Chan || ch.Type().ChanDir() & reflect.RecvDir == 0 {
+    return nil, errors.New("Stream results must be channels or Iterators")
+  }
+  cases := []reflect.SelectCase{
+    {Dir: reflect.SelectRecv, Chan: ch},
+    {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.Context().Done())},
+  }
+  return IteratorFunc(func() (interface{}, bool, error) {
+    chosen, v, ok := reflect.Select(cases)
+    if chosen != 0 {
+      // Client disconnected
+      return nil, false, r.Context().Err()
+    }
+    if !ok {
+      return nil, false, nil
+    }
+    return v.Interface(), true, nil
+  }), nil
+}
@@ -0,0 +1,84 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+)
+
+// TestStreamJSONResult tests streaming channels and iterators.
+func TestStreamJSONResult(t *testing.T) {
+  ch := make(chan testItem)
+  go func() {
+    ch <- testItem{Name: "box", Likes: 1}
+    ch <- testItem{Name: "sphere", Likes: 2}
+    close(ch)
+  }()
+  rec := serveResult(StreamJSONResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return ch, nil
+  }))
+  exp := `[{"name":"box","likes":1},{"name":"sphere","likes":2}]` + "\n"
+  if rec.Body.String() != exp {
+    t.Fatal("Unexpected channel stream:", rec.Body.String())
+  }
+
+  // Empty iterator
+  rec = serveResult(StreamJSONResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return IteratorFunc(func() (interface{}, bool, error) { return nil, false, nil }), nil
+  }))
+  if rec.Body.String() != "[]\n" {
+    t.Fatal("Unexpected empty stream:", rec.Body.String())
+  }
+
+  // Error before the first item
+  rec = serveResult(StreamJSONResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return IteratorFunc(func() (interface{}, bool, error) {
+      return nil, false, errors.New("db error")
+    }), nil
+  }))
+  if rec.Code != http.StatusInternalServerError {
+    t.Fatal("Expected an error response. Got:", rec.Code)
+  }
+
+  // Invalid result
+  rec = serveResult(StreamJSONResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return []testItem{}, nil
+  }))
+  if rec.Code != http.StatusInternalServerError {
+    t.Fatal("Expected an error for non channel results. Got:", rec.Code)
+  }
+}
+
+// TestStreamJSONResultDisconnect tests that streaming stops when the client
+// disconnects, even if the producer is blocked.
+func TestStreamJSONResultDisconnect(t *testing.T) {
+  ctx, cancel := context.WithCancel(context.Background())
+  ch := make(chan testItem)
+  go func() {
+    ch <- testItem{Name: "box", Likes: 1}
+    // The client goes away while the producer has nothing to send
+    cancel()
+  }()
+  handler := StreamJSONResult(func(w http.ResponseWriter, r *http.Request) (interface{}, *ErrMsg) {
+    return ch, nil
+  })
+
+  done := make(chan struct{})
+  rec := httptest.NewRecorder()
+  go func() {
+    req, _ := http.NewRequest("GET", "/items", nil)
+    handler.ServeHTTP(rec, req.WithContext(ctx))
+    close(done)
+  }()
+  select {
+  case <-done:
+  case <-time.After(5 * time.Second):
+    t.Fatal("The stream should end when the client disconnects")
+  }
+  if rec.Body.String() != `[{"name":"box","likes":1}` {
+    t.Fatal("Unexpected truncated stream:", rec.Body.String())
+  }
+}
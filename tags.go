@@ -0,0 +1,86 @@
+package ign
+
+import (
+  "regexp"
+  "strings"
+  "unicode"
+  "unicode/utf8"
+)
+
+// TagListOptions configures ParseTagList. The zero value splits by commas,
+// without other rules.
+type TagListOptions struct {
+  // Delimiters are the characters that separate tags. Defaults to ",".
+  Delimiters string
+  // Lowercase converts the tags to lower case.
+  Lowercase bool
+  // MaxLength is the max number of characters of a tag. Zero means no
+  // limit.
+  MaxLength int
+  // Pattern, if set, must match the whole tag (eg. `^[a-z0-9_ -]+$`).
+  Pattern *regexp.Regexp
+}
+
+// TagError is the error returned by ParseTagList when a tag is invalid.
+type TagError struct {
+  // Tag is the invalid tag, after trimming spaces.
+  Tag string
+  // Msg describes the broken rule.
+  Msg string
+}
+
+// Error is part of the error interface.
+func (e *TagError) Error() string {
+  return "Invalid tag [" + e.Tag + "]: " + e.Msg
+}
+
+// ParseTagList splits a list of tags (eg. " tag1, tag2  b,,tag1 "). Spaces
+// around tags are removed, inner whitespace is reduced to a single space,
+// and empty and duplicated tags are removed, keeping the order of the first
+// occurrence. It returns a *TagError with the first tag that breaks the
+// rules of opts, or has control characters.
+func ParseTagList(s string, opts TagListOptions) ([]string, error) {
+  delimiters := opts.Delimiters
+  if delimiters == "" {
+    delimiters = ","
+  }
+  fields := strings.FieldsFunc(s, func(r rune) bool {
+    return strings.ContainsRune(delimiters, r)
+  })
+
+  tags := make([]string, 0, len(fields))
+  seen := map[string]bool{}
+  for _, field := range fields {
+    tag := strings.Join(strings.Fields(field), " ")
+    if tag == "" {
+      continue
+    }
+    if opts.Lowercase {
+      tag = strings.ToLower(tag)
+    }
+    if err := checkTag(tag, opts); err != nil {
+      return nil, err
+    }
+    if !seen[tag] {
+      seen[tag] = true
+      tags = append(tags, tag)
+    }
+  }
+  return tags, nil
+}
+
+// checkTag returns an error if the tag breaks the rules of opts.
+func checkTag(tag string, opts TagListOptions) error {
+  for _, r := range tag {
+    if unicode.IsControl(r) || r == utf8.RuneError {
+      return &TagError{Tag: tag, Msg: "invalid character"}
+    }
+  }
+  if opts.MaxLength > 0 && utf8.RuneCountInString(tag) > opts.MaxLength {
+    return &TagError{Tag: tag, Msg: "too long"}
+  }
+  if opts.Pattern != nil && !opts.Pattern.MatchString(tag) {
+    return &TagError{Tag: tag, Msg: "invalid characters"}
+  }
+  return nil
+}
@@ -0,0 +1,33 @@
+package ign
+
+import (
+  "reflect"
+  "regexp"
+  "testing"
+)
+
+// TestParseTagList tests splitting, normalizing and validating tags.
+func TestParseTagList(t *testing.T) {
+  tags, err := ParseTagList(" tag\tmiddle  space,  b ,   , a,b,  ", TagListOptions{})
+  if err != nil || !reflect.DeepEqual(tags, []string{"tag middle space", "b", "a"}) {
+    t.Fatal("Unexpected tags:", tags, err)
+  }
+
+  opts := TagListOptions{Delimiters: ",;", Lowercase: true, MaxLength: 5,
+                         Pattern: regexp.MustCompile(`^[a-z0-9_]+$`)}
+  tags, err = ParseTagList("Box;box, CAR", opts)
+  if err != nil || !reflect.DeepEqual(tags, []string{"box", "car"}) {
+    t.Fatal("Unexpected tags:", tags, err)
+  }
+
+  for input, bad := range map[string]string{
+    "ok, toolong": "toolong",
+    "ok, a-b": "a-b",
+    "ok, a\x00": "a\x00",
+  } {
+    _, err := ParseTagList(input, opts)
+    if tagErr, ok := err.(*TagError); !ok || tagErr.Tag != bad {
+      t.Errorf("Expected an error for [%q]. Got: %v", bad, err)
+    }
+  }
+}
@@ -0,0 +1,165 @@
+package ign
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "net"
+  "net/http"
+  "strings"
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+)
+
+// A single deployment can serve several isolated organizations (tenants)
+// by setting Server.Tenancy. The tenant of each request is resolved from a
+// JWT claim and/or the subdomain of the request host, and is available
+// using TenantFromRequest. Handlers scope their queries with TenantScoped:
+//
+//   var models []Model
+//   TenantScoped(DBFromRequest(r), r).Find(&models)
+//
+// Rows created with DBFromRequest get the tenant column set automatically.
+
+// ErrNoTenant is the error of the queries scoped to a request without
+// tenant.
+var ErrNoTenant = errors.New("No tenant in request")
+
+// Tenancy configures how the tenant of requests is resolved.
+type Tenancy struct {
+  // Claim of the JWT that contains the tenant ID (eg. "org_id").
+  Claim string
+  // BaseDomain, if set, makes the subdomain of the request host the tenant
+  // ID (eg. "acme" in acme.example.com, with BaseDomain "example.com").
+  // If both Claim and BaseDomain are set, they must match. The host is only
+  // used for anonymous requests: authenticated requests must have the
+  // claim, as the client controls the Host header.
+  BaseDomain string
+  // Column of the tenant ID in the database tables. Defaults to
+  // "tenant_id".
+  Column string
+  // Required rejects the requests whose tenant can't be resolved, with
+  // ErrorTenantRequired.
+  Required bool
+}
+
+// tenantKey is the context key of the request tenant.
+type tenantKey struct{}
+
+// TenantFromRequest returns the tenant ID of the request. It returns false
+// if tenancy is disabled or the tenant could not be resolved.
+func TenantFromRequest(r *http.Request) (string, bool) {
+  tenant, ok := r.Context().Value(tenantKey{}).(string)
+  return tenant, ok
+}
+
+// TenantScoped adds the tenant condition of the request to the query. If
+// the request has no tenant, the query fails with ErrNoTenant, so data is
+// never leaked across tenants.
+func TenantScoped(db *gorm.DB, r *http.Request) *gorm.DB {
+  tenant, ok := TenantFromRequest(r)
+  if !ok {
+    db = db.Session(&gorm.Session{})
+    db.AddError(ErrNoTenant)
+    return db
+  }
+  return db.Where(clause.Eq{
+    Column: clause.Column{Table: clause.CurrentTable, Name: tenantColumn()},
+    Value: tenant,
+  })
+}
+
+// tenantColumn returns the name of the tenant column.
+func tenantColumn() string {
+  if gServer == nil || gServer.Tenancy == nil || gServer.Tenancy.Column == "" {
+    return "tenant_id"
+  }
+  return gServer.Tenancy.Column
+}
+
+// resolve returns the tenant of a request, or an error code if the tenant
+// is invalid.
+func (t *Tenancy) resolve(r *http.Request) (string, int64) {
+  var fromClaim, fromHost string
+  authenticated := false
+  if t.Claim != "" {
+    if identity, ok := IdentityFromRequest(r); ok {
+      authenticated = true
+      if v, ok := identity.Claims[t.Claim]; ok && v != nil {
+        fromClaim = fmt.Sprint(v)
+      }
+    }
+  }
+  if t.BaseDomain != "" {
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil {
+      host = h
+    }
+    sub := strings.TrimSuffix(strings.ToLower(host), "." + strings.ToLower(t.BaseDomain))
+    if sub != strings.ToLower(host) && sub != "" && !strings.Contains(sub, ".") {
+      fromHost = sub
+    }
+  }
+
+  switch {
+  case fromClaim != "" && fromHost != "" && fromClaim != fromHost:
+    return "", ErrorTenantMismatch
+  case authenticated && fromClaim == "" && fromHost != "":
+    // Otherwise users could pick any tenant with the Host header
+    return "", ErrorTenantMismatch
+  case authenticated && fromClaim == "":
+    return "", ErrorTenantRequired
+  case fromClaim != "":
+    return fromClaim, 0
+  case fromHost != "":
+    return fromHost, 0
+  }
+  if t.Required {
+    return "", ErrorTenantRequired
+  }
+  return "", 0
+}
+
+/////////////////////////////////////////////////
+// tenantMiddleware attaches the tenant of the request, if tenancy is
+// enabled. It must run after the auth middleware.
+func tenantMiddleware(w http.ResponseWriter, r *http.Request,
+                      next http.HandlerFunc) {
+  if gServer == nil || gServer.Tenancy == nil {
+    next(w, r)
+    return
+  }
+  tenant, errCode := gServer.Tenancy.resolve(r)
+  if errCode != 0 {
+    reportJSONError(w, r, ErrorMessage(errCode))
+    return
+  }
+  if tenant != "" {
+    r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+  }
+  next(w, r)
+}
+
+/////////////////////////////////////////////////
+
+// RegisterTenantCallbacks registers the callback that sets the tenant
+// column of new rows, using the tenant of the query context, in the given
+// database.
+func RegisterTenantCallbacks(db *gorm.DB) error {
+  return db.Callback().Create().Before("gorm:create").
+    Register("ign:tenant_create", tenantCreate)
+}
+
+// tenantCreate sets the tenant column of new rows.
+func tenantCreate(db *gorm.DB) {
+  if db.Error != nil || db.Statement.Schema == nil {
+    return
+  }
+  tenant, ok := db.Statement.Context.Value(tenantKey{}).(string)
+  if !ok {
+    return
+  }
+  if field := db.Statement.Schema.LookUpField(tenantColumn()); field != nil {
+    db.Statement.SetColumn(field.Name, tenant, true)
+  }
+}
@@ -0,0 +1,95 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+)
+
+// tenantItem is the model used by the tenancy test.
+type tenantItem struct {
+  ID uint
+  TenantID string
+  Name string
+}
+
+// TestTenantMiddleware tests resolving the tenant from claims and hosts.
+func TestTenantMiddleware(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{Tenancy: &Tenancy{Claim: "org", BaseDomain: "example.com",
+                                      Required: true}}
+
+  resolve := func(host, org string) (string, int) {
+    req, _ := http.NewRequest("GET", "/models", nil)
+    req.Host = host
+    if org != "" {
+      claims := map[string]interface{}{"org": org}
+      if org == "-" {
+        // Authenticated without the tenant claim
+        claims = map[string]interface{}{}
+      }
+      identity := &Identity{Claims: claims}
+      req = req.WithContext(context.WithValue(req.Context(), identityKey{}, identity))
+    }
+    var tenant string
+    rec := httptest.NewRecorder()
+    tenantMiddleware(rec, req, func(w http.ResponseWriter, r *http.Request) {
+      tenant, _ = TenantFromRequest(r)
+    })
+    return tenant, rec.Code
+  }
+
+  if tenant, _ := resolve("acme.example.com:8000", ""); tenant != "acme" {
+    t.Fatal("Expected the subdomain tenant. Got:", tenant)
+  }
+  if tenant, _ := resolve("localhost", "acme"); tenant != "acme" {
+    t.Fatal("Expected the claim tenant. Got:", tenant)
+  }
+  if _, code := resolve("other.example.com", "acme"); code != http.StatusForbidden {
+    t.Fatal("Mismatched tenants should be rejected. Got:", code)
+  }
+  if _, code := resolve("example.com", ""); code != http.StatusBadRequest {
+    t.Fatal("Requests without tenant should be rejected. Got:", code)
+  }
+  if _, code := resolve("other.example.com", "-"); code != http.StatusForbidden {
+    t.Fatal("Authenticated requests can't pick the tenant with the host. Got:",
+            code)
+  }
+  if _, code := resolve("localhost", "-"); code != http.StatusBadRequest {
+    t.Fatal("Authenticated requests without the claim should be rejected. Got:",
+            code)
+  }
+}
+
+// TestTenantScoped tests scoping queries and creates to the tenant.
+func TestTenantScoped(t *testing.T) {
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{Tenancy: &Tenancy{}}
+
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  if err := RegisterTenantCallbacks(db); err != nil {
+    t.Fatal(err)
+  }
+  db.AutoMigrate(&tenantItem{})
+
+  req, _ := http.NewRequest("GET", "/models", nil)
+  acme := req.WithContext(context.WithValue(req.Context(), tenantKey{}, "acme"))
+  other := req.WithContext(context.WithValue(req.Context(), tenantKey{}, "other"))
+  db.WithContext(acme.Context()).Create(&tenantItem{Name: "box"})
+  db.WithContext(other.Context()).Create(&tenantItem{Name: "sphere"})
+
+  var items []tenantItem
+  if err := TenantScoped(db, acme).Find(&items).Error; err != nil {
+    t.Fatal("Query failed:", err)
+  }
+  if len(items) != 1 || items[0].Name != "box" || items[0].TenantID != "acme" {
+    t.Fatal("Unexpected items:", items)
+  }
+  if err := TenantScoped(db, req).Find(&items).Error; err != ErrNoTenant {
+    t.Fatal("Queries without tenant should fail. Got:", err)
+  }
+}
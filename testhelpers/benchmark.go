@@ -0,0 +1,135 @@
+package igntest
+
+import (
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "runtime"
+  "sort"
+  "sync"
+  "testing"
+  "time"
+)
+
+// BenchmarkResult are the stats reported by Benchmark.
+type BenchmarkResult struct {
+  Requests int
+  // Errors is the number of responses with a status code >= 400.
+  Errors int
+  Duration time.Duration
+  // Latency percentiles.
+  P50 time.Duration
+  P90 time.Duration
+  P99 time.Duration
+  Max time.Duration
+  // AllocsPerRequest and BytesPerRequest are the mean heap allocations of a
+  // request, including the ones of the middlewares.
+  AllocsPerRequest uint64
+  BytesPerRequest uint64
+}
+
+// RequestsPerSecond returns the throughput of the benchmark.
+func (r BenchmarkResult) RequestsPerSecond() float64 {
+  if r.Duration <= 0 {
+    return 0
+  }
+  return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// String returns a one line summary of the result.
+func (r BenchmarkResult) String() string {
+  return fmt.Sprintf("%d requests (%d errors) in %v, %.0f req/s, " +
+                     "p50 %v, p90 %v, p99 %v, max %v, %d allocs/req, " +
+                     "%d B/req", r.Requests, r.Errors, r.Duration,
+                     r.RequestsPerSecond(), r.P50, r.P90, r.P99, r.Max,
+                     r.AllocsPerRequest, r.BytesPerRequest)
+}
+
+// Benchmark sends GET requests to the given route of the router (see
+// SetupTest) from concurrency goroutines during the given duration, and
+// logs and returns the latencies and allocations per request. The router
+// runs in-process, so the result measures the cost of the handlers and
+// middlewares, without network.
+func Benchmark(t testing.TB, route string, concurrency int,
+               duration time.Duration) BenchmarkResult {
+  t.Helper()
+  if router == nil {
+    t.Fatal("Benchmark needs a router. Call SetupTest first")
+  }
+  return BenchmarkHandler(t, router, func() *http.Request {
+    return httptest.NewRequest("GET", route, nil)
+  }, concurrency, duration)
+}
+
+// BenchmarkHandler is like Benchmark, for any handler and requests.
+// newRequest is called to create each request.
+func BenchmarkHandler(t testing.TB, handler http.Handler,
+                      newRequest func() *http.Request, concurrency int,
+                      duration time.Duration) BenchmarkResult {
+  t.Helper()
+  if concurrency < 1 {
+    concurrency = 1
+  }
+
+  latencies := make([][]time.Duration, concurrency)
+  errorCounts := make([]int, concurrency)
+  var wg sync.WaitGroup
+
+  var before, after runtime.MemStats
+  runtime.GC()
+  runtime.ReadMemStats(&before)
+  start := time.Now()
+  deadline := start.Add(duration)
+  for i := 0; i < concurrency; i++ {
+    wg.Add(1)
+    go func(worker int) {
+      defer wg.Done()
+      for time.Now().Before(deadline) {
+        req := newRequest()
+        rec := httptest.NewRecorder()
+        reqStart := time.Now()
+        handler.ServeHTTP(rec, req)
+        latencies[worker] = append(latencies[worker], time.Since(reqStart))
+        if rec.Code >= http.StatusBadRequest {
+          errorCounts[worker]++
+        }
+      }
+    }(i)
+  }
+  wg.Wait()
+  elapsed := time.Since(start)
+  runtime.ReadMemStats(&after)
+
+  result := BenchmarkResult{Duration: elapsed}
+  var all []time.Duration
+  for i := range latencies {
+    all = append(all, latencies[i]...)
+    result.Errors += errorCounts[i]
+  }
+  result.Requests = len(all)
+  if result.Requests == 0 {
+    t.Log("Benchmark: no requests were sent")
+    return result
+  }
+
+  sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+  result.P50 = percentile(all, 50)
+  result.P90 = percentile(all, 90)
+  result.P99 = percentile(all, 99)
+  result.Max = all[len(all) - 1]
+  n := uint64(result.Requests)
+  result.AllocsPerRequest = (after.Mallocs - before.Mallocs) / n
+  result.BytesPerRequest = (after.TotalAlloc - before.TotalAlloc) / n
+
+  t.Log("Benchmark:", result)
+  return result
+}
+
+// percentile returns the p-th percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+  i := (len(sorted) * p + 99) / 100 - 1
+  if i < 0 {
+    i = 0
+  }
+  return sorted[i]
+}
@@ -0,0 +1,92 @@
+package igntest
+
+import (
+  "bytes"
+  "encoding/json"
+  "flag"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "regexp"
+  "testing"
+)
+
+// updateGolden makes AssertGolden write the golden files instead of
+// comparing them. Use `go test ./... -update`.
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+// GoldenDir is the folder of the golden files, relative to the package of
+// the test.
+var GoldenDir = filepath.Join("testdata", "golden")
+
+// timestampMask replaces the timestamps of golden JSON files.
+const timestampMask = "<timestamp>"
+
+// timestampRegexp matches the RFC 3339 timestamps masked in golden JSON
+// files (eg. "2020-05-01T10:00:00Z" or "2020-05-01T10:00:00.123+02:00").
+var timestampRegexp = regexp.MustCompile(
+  `^\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?$`)
+
+// AssertGolden compares got with the golden file GoldenDir/<name>.golden,
+// and fails the test if they are different. If got is JSON (eg. a route
+// response), it is normalized first: keys are sorted, it is indented, and
+// the timestamps are replaced by "<timestamp>". When the tests run with the
+// -update flag, the golden file is written with got instead.
+func AssertGolden(t testing.TB, name string, got []byte) {
+  t.Helper()
+  got = normalizeGolden(got)
+  path := filepath.Join(GoldenDir, name + ".golden")
+
+  if *updateGolden {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+      t.Fatal("Unable to create the golden folder:", err)
+    }
+    if err := ioutil.WriteFile(path, got, 0644); err != nil {
+      t.Fatal("Unable to write the golden file:", err)
+    }
+    return
+  }
+
+  exp, err := ioutil.ReadFile(path)
+  if err != nil {
+    t.Fatalf("Unable to read the golden file [%s]: %v. Run the tests with " +
+             "-update to create it", path, err)
+  }
+  if !bytes.Equal(exp, got) {
+    t.Fatalf("Doesn't match the golden file [%s] (run with -update if the " +
+             "change is expected).\nExp:\n%s\nGot:\n%s", path, exp, got)
+  }
+}
+
+// normalizeGolden returns the normalized JSON of data, or data if it is not
+// JSON.
+func normalizeGolden(data []byte) []byte {
+  var value interface{}
+  if err := json.Unmarshal(data, &value); err != nil {
+    return data
+  }
+  normalized, err := json.MarshalIndent(maskTimestamps(value), "", "  ")
+  if err != nil {
+    return data
+  }
+  return append(normalized, '\n')
+}
+
+// maskTimestamps replaces the timestamps of a decoded JSON value.
+func maskTimestamps(value interface{}) interface{} {
+  switch v := value.(type) {
+  case map[string]interface{}:
+    for key, item := range v {
+      v[key] = maskTimestamps(item)
+    }
+  case []interface{}:
+    for i, item := range v {
+      v[i] = maskTimestamps(item)
+    }
+  case string:
+    if timestampRegexp.MatchString(v) {
+      return timestampMask
+    }
+  }
+  return value
+}
@@ -0,0 +1,36 @@
+package igntest
+
+import (
+  "net/http"
+  "testing"
+)
+
+// TestNormalizeGolden tests normalizing JSON responses for golden files.
+func TestNormalizeGolden(t *testing.T) {
+  got := string(normalizeGolden([]byte(
+    `{"b":1,"a":{"created_at":"2020-05-01T10:00:00.123Z","name":"box"}}`)))
+  exp := "{\n  \"a\": {\n    \"created_at\": \"" + timestampMask +
+         "\",\n    \"name\": \"box\"\n  },\n  \"b\": 1\n}\n"
+  if got != exp {
+    t.Fatal("Unexpected normalized JSON:", got)
+  }
+  if got := string(normalizeGolden([]byte("not json"))); got != "not json" {
+    t.Fatal("Other content should not change:", got)
+  }
+}
+
+// TestPaginationHelpers tests parsing the pagination headers.
+func TestPaginationHelpers(t *testing.T) {
+  links := ParseLinkHeader(`</items?page=2>; rel="next", </items?page=5>; rel="last"`)
+  if links["next"] != "/items?page=2" || links["last"] != "/items?page=5" {
+    t.Fatal("Unexpected links:", links)
+  }
+  h := http.Header{}
+  if _, ok := TotalCount(h); ok {
+    t.Fatal("A missing count should not be returned")
+  }
+  h.Set("X-Total-Count", "42")
+  if count, ok := TotalCount(h); !ok || count != 42 {
+    t.Fatal("Unexpected count:", count)
+  }
+}
@@ -0,0 +1,134 @@
+package igntest
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "regexp"
+  "strconv"
+  "testing"
+)
+
+// maxWalkedPages is the max number of pages followed by WalkPages, to stop
+// tests when the "next" links never end.
+const maxWalkedPages = 1000
+
+// linkRegexp matches the links of a Link header, as in
+// `<url>; rel="next"`.
+var linkRegexp = regexp.MustCompile(`<([^>]*)>\s*;\s*rel="?([^",;]+)"?`)
+
+// ParseLinkHeader returns the URLs of a Link header by relation (eg.
+// "next", "last", "first" and "prev").
+func ParseLinkHeader(header string) map[string]string {
+  links := map[string]string{}
+  for _, m := range linkRegexp.FindAllStringSubmatch(header, -1) {
+    links[m[2]] = m[1]
+  }
+  return links
+}
+
+// TotalCount returns the value of the X-Total-Count header. It returns false
+// if the header is missing or invalid.
+func TotalCount(h http.Header) (int64, bool) {
+  count, err := strconv.ParseInt(h.Get("X-Total-Count"), 10, 64)
+  return count, err == nil
+}
+
+// WalkPages sends a GET request to uri and follows the "next" links of the
+// responses, calling fn with each page. The test fails if a page is not
+// returned with status 200, or if a page is visited twice. It returns the
+// number of pages.
+func WalkPages(t testing.TB, handler http.Handler, uri string,
+               fn func(page *httptest.ResponseRecorder)) int {
+  t.Helper()
+  visited := map[string]bool{}
+  pages := 0
+  for uri != "" {
+    if visited[uri] || pages == maxWalkedPages {
+      t.Fatal("The next links don't end. Page:", uri)
+    }
+    visited[uri] = true
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest("GET", uri, nil))
+    if rec.Code != http.StatusOK {
+      t.Fatalf("Page [%s] returned %d: %s", uri, rec.Code, rec.Body.String())
+    }
+    pages++
+    if fn != nil {
+      fn(rec)
+    }
+    uri = ParseLinkHeader(rec.Header().Get("Link"))["next"]
+  }
+  return pages
+}
+
+// AssertPages walks all the pages of uri (see WalkPages), and returns the
+// items of all the pages. Pages must be JSON arrays, or objects with a
+// "data" array (see ign.WritePaginatedResponse). The test fails if:
+//   - an item appears in more than one page, using the idField of the items
+//     (eg. "id") or the whole item if idField is empty,
+//   - the number of items doesn't match the X-Total-Count header,
+//   - the "last" link of the first page is not the last page walked.
+func AssertPages(t testing.TB, handler http.Handler, uri,
+                 idField string) []map[string]interface{} {
+  t.Helper()
+  var items []map[string]interface{}
+  seen := map[string]int{}
+  total, hasTotal := int64(-1), false
+  var last, current string
+  page := 0
+
+  WalkPages(t, handler, uri, func(rec *httptest.ResponseRecorder) {
+    page++
+    current = uri
+    if next, ok := ParseLinkHeader(rec.Header().Get("Link"))["next"]; ok {
+      uri = next
+    }
+    if page == 1 {
+      total, hasTotal = TotalCount(rec.Header())
+      last = ParseLinkHeader(rec.Header().Get("Link"))["last"]
+    }
+
+    pageItems, err := decodePageItems(rec.Body.Bytes())
+    if err != nil {
+      t.Fatalf("Unable to decode page %d: %v", page, err)
+    }
+    for _, item := range pageItems {
+      key := fmt.Sprint(item)
+      if idField != "" {
+        key = fmt.Sprint(item[idField])
+      }
+      if prev, ok := seen[key]; ok {
+        t.Fatalf("Item [%s] found in pages %d and %d", key, prev, page)
+      }
+      seen[key] = page
+      items = append(items, item)
+    }
+  })
+
+  if hasTotal && int64(len(items)) != total {
+    t.Fatalf("X-Total-Count is %d, but the pages have %d items", total,
+             len(items))
+  }
+  if last != "" && page > 1 && last != current {
+    t.Fatalf("The last link [%s] is not the last page [%s]", last, current)
+  }
+  return items
+}
+
+// decodePageItems returns the items of a page, which is a JSON array or an
+// object with a "data" array.
+func decodePageItems(body []byte) ([]map[string]interface{}, error) {
+  var items []map[string]interface{}
+  if err := json.Unmarshal(body, &items); err == nil {
+    return items, nil
+  }
+  var envelope struct {
+    Data []map[string]interface{} `json:"data"`
+  }
+  if err := json.Unmarshal(body, &envelope); err != nil {
+    return nil, err
+  }
+  return envelope.Data, nil
+}
@@ -0,0 +1,200 @@
+package igntest
+
+import (
+  "bytes"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "sync"
+  "testing"
+  "bitbucket.org/ignitionrobotics/ign-go"
+)
+
+// recordFixtures makes the recorders send the real requests and save them
+// in the fixtures. Use `go test ./... -record`.
+var recordFixtures = flag.Bool("record", false,
+                               "record the HTTP fixtures of the recorders")
+
+// RecorderDir is the folder of the HTTP fixtures, relative to the package
+// of the test.
+var RecorderDir = filepath.Join("testdata", "http")
+
+// Interaction is a recorded HTTP request and its response. The request
+// headers and body are not saved, as they may contain credentials.
+type Interaction struct {
+  Method string `json:"method"`
+  URL string `json:"url"`
+  Status int `json:"status"`
+  Header http.Header `json:"header,omitempty"`
+  Body []byte `json:"body,omitempty"`
+}
+
+// Recorder is an http.RoundTripper that replays the responses of the
+// outbound HTTP requests (eg. Google Analytics, Auth0 JWKS or S3) from a
+// fixture file, so the tests don't need network access. When the tests run
+// with the -record flag, the real requests are sent instead, and saved in
+// the fixture file when the test ends. Review the fixtures before adding
+// them to the repository, as responses may contain tokens.
+type Recorder struct {
+  path string
+  recording bool
+  // real is the transport used to send the requests when recording.
+  real http.RoundTripper
+
+  mutex sync.Mutex
+  interactions []Interaction
+  // replayed tells which interactions were already replayed.
+  replayed []bool
+  // missing are the requests without a recorded response.
+  missing []string
+}
+
+// NewRecorder creates a Recorder with the fixture file RecorderDir/<name>.json
+// and installs it as the transport of http.DefaultTransport and
+// ign.S3HTTPClient, so the HTTP clients of ign (and any client with the
+// default transport) use it. The previous transports are restored when the
+// test ends, and the test fails if a request had no recorded response.
+// Recorders change global settings, so tests using them can't run in
+// parallel.
+func NewRecorder(t testing.TB, name string) *Recorder {
+  t.Helper()
+  r := &Recorder{
+    path: filepath.Join(RecorderDir, name + ".json"),
+    recording: *recordFixtures,
+    real: http.DefaultTransport,
+  }
+  if !r.recording {
+    data, err := ioutil.ReadFile(r.path)
+    if err != nil {
+      t.Fatalf("Unable to read the HTTP fixture [%s]: %v. Run the tests " +
+               "with -record to create it", r.path, err)
+    }
+    if err := json.Unmarshal(data, &r.interactions); err != nil {
+      t.Fatalf("Invalid HTTP fixture [%s]: %v", r.path, err)
+    }
+    r.replayed = make([]bool, len(r.interactions))
+  }
+
+  prevS3Client := ign.S3HTTPClient
+  http.DefaultTransport = r
+  ign.S3HTTPClient = &http.Client{Transport: r}
+  t.Cleanup(func() {
+    http.DefaultTransport = r.real
+    ign.S3HTTPClient = prevS3Client
+    if err := r.save(); err != nil {
+      t.Error("Unable to save the HTTP fixture:", err)
+    }
+    for _, req := range r.Missing() {
+      t.Error("No recorded response for", req)
+    }
+  })
+  return r
+}
+
+// RoundTrip is part of the http.RoundTripper interface.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+  if r.recording {
+    return r.record(req)
+  }
+  return r.replay(req)
+}
+
+// record sends the request and saves the response.
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+  resp, err := r.real.RoundTrip(req)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+  body, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return nil, err
+  }
+
+  r.mutex.Lock()
+  r.interactions = append(r.interactions, Interaction{
+    Method: req.Method,
+    URL: req.URL.String(),
+    Status: resp.StatusCode,
+    Header: resp.Header,
+    Body: body,
+  })
+  r.mutex.Unlock()
+
+  resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+  return resp, nil
+}
+
+// replay returns the recorded response of the request. Interactions with
+// the same method and URL are replayed in order, and the last one is
+// repeated when all of them were replayed.
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+  if req.Body != nil {
+    req.Body.Close()
+  }
+  url := req.URL.String()
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+
+  found := -1
+  for i, in := range r.interactions {
+    if in.Method == req.Method && in.URL == url {
+      found = i
+      if !r.replayed[i] {
+        break
+      }
+    }
+  }
+  if found < 0 {
+    r.missing = append(r.missing, req.Method + " " + url)
+    return nil, fmt.Errorf("igntest: no recorded response for %s %s",
+                           req.Method, url)
+  }
+  r.replayed[found] = true
+
+  in := r.interactions[found]
+  header := http.Header{}
+  for key, values := range in.Header {
+    header[key] = append([]string(nil), values...)
+  }
+  return &http.Response{
+    Status: fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+    StatusCode: in.Status,
+    Proto: "HTTP/1.1",
+    ProtoMajor: 1,
+    ProtoMinor: 1,
+    Header: header,
+    Body: ioutil.NopCloser(bytes.NewReader(in.Body)),
+    ContentLength: int64(len(in.Body)),
+    Request: req,
+  }, nil
+}
+
+// Missing returns the requests that had no recorded response.
+func (r *Recorder) Missing() []string {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  return append([]string(nil), r.missing...)
+}
+
+// save writes the recorded interactions in the fixture file. It does
+// nothing when replaying.
+func (r *Recorder) save() error {
+  if !r.recording {
+    return nil
+  }
+  r.mutex.Lock()
+  data, err := json.MarshalIndent(r.interactions, "", "  ")
+  r.mutex.Unlock()
+  if err != nil {
+    return err
+  }
+  if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+    return err
+  }
+  return ioutil.WriteFile(r.path, append(data, '\n'), 0644)
+}
@@ -1,16 +1,18 @@
 package igntest
 
-// Important note: functions in this module should NOT include
-// references to parent package 'ign', to avoid circular dependencies.
-// These functions should be independent.
+// Important note: the tests of the parent package 'ign' must NOT import
+// this package, as the test server helpers (see testserver.go) use 'ign'.
+// The other functions should be independent.
 
 import (
   "encoding/json"
+  "fmt"
   "net/http"
   "bytes"
   "io"
   "log"
   "mime/multipart"
+  "net/textproto"
   "net/http/httptest"
   "os"
   "path/filepath"
@@ -39,30 +41,39 @@ func SetupTest(_router *mux.Router) {
   router = _router
 }
 
+// MultipartFile describes a file sent by SendMultipartRequest.
+type MultipartFile struct {
+  // Field is the name of the form field. Defaults to "file".
+  Field string
+  // Name is the file name sent in the form.
+  Name string
+  // Contents of the file. Ignored if Source is set.
+  Contents string
+  // Source is the path of a file on disk, whose content is streamed.
+  Source string
+  // ContentType of the file part. Defaults to "application/octet-stream".
+  ContentType string
+}
+
+// quoteEscaper escapes the file names of the Content-Disposition headers.
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
 // SendMultipartPOST executes a multipart POST request with the given form
 // fields and multipart files, and returns the received http status code,
-// the response body, and a success flag.
+// the response body, and a success flag. The form is buffered, so the
+// request has a ContentLength.
 func SendMultipartPOST(testName string, t *testing.T, uri string, jwt string,
   params map[string]string, files []FileDesc) (respCode int,
   bslice *[]byte, ok bool) {
 
+  multipartFiles := make([]MultipartFile, len(files))
+  for i, fd := range files {
+    multipartFiles[i] = MultipartFile{Name: fd.Path, Contents: fd.Contents}
+  }
   body := &bytes.Buffer{}
   writer := multipart.NewWriter(body)
-  for _, fd := range files {
-    // Remove base path
-    part, err := writer.CreateFormFile("file", fd.Path)
-    if err != nil {
-      t.Fatal("Could not create FormFile. TestName: ", testName, fd.Path, err)
-      return
-    }
-    _, err = io.WriteString(part, fd.Contents)
-  }
-
-  for key, val := range params {
-    _ = writer.WriteField(key, val)
-  }
-  if err := writer.Close(); err != nil {
-    t.Fatal("Could not close multipart form writer. TestName: ", testName, err)
+  if err := writeMultipartForm(writer, params, multipartFiles); err != nil {
+    t.Fatal("Could not write the multipart form. TestName: ", testName, err)
     return
   }
 
@@ -71,6 +82,46 @@ func SendMultipartPOST(testName string, t *testing.T, uri string, jwt string,
     t.Fatal("Could not create POST request. TestName", testName, err)
     return
   }
+  return sendMultipart(testName, t, req, writer, jwt)
+}
+
+// SendMultipartRequest executes a multipart request (eg. POST, PUT or PATCH)
+// with the given form fields and files, and returns the received http
+// status code, the response body, and a success flag. The form is streamed
+// to the router, so large files on disk can be sent. The request has no
+// ContentLength.
+func SendMultipartRequest(testName string, t *testing.T, method, uri string,
+  jwt string, params map[string]string, files []MultipartFile) (respCode int,
+  bslice *[]byte, ok bool) {
+
+  pr, pw := io.Pipe()
+  writer := multipart.NewWriter(pw)
+  go func() {
+    pw.CloseWithError(writeMultipartForm(writer, params, files))
+  }()
+
+  req, err := http.NewRequest(method, uri, pr)
+  if err != nil {
+    pr.Close()
+    t.Fatal("Could not create", method, "request. TestName", testName, err)
+    return
+  }
+  respCode, bslice, ok = sendMultipart(testName, t, req, writer, jwt)
+
+  // The rest of the form is drained, so the writer goroutine ends even if
+  // the handler didn't read it.
+  if _, err := io.Copy(ioutil.Discard, pr); err != nil {
+    t.Fatal("Could not write the multipart form. TestName: ", testName, err)
+    return
+  }
+  return
+}
+
+// sendMultipart processes a multipart request written by writer, and returns
+// the received http status code, the response body, and a success flag.
+func sendMultipart(testName string, t *testing.T, req *http.Request,
+                   writer *multipart.Writer, jwt string) (respCode int,
+                   bslice *[]byte, ok bool) {
   // Adds the "Content-Type: multipart/form-data" header.
   req.Header.Add("Content-Type", writer.FormDataContentType())
 
@@ -81,7 +132,7 @@ func SendMultipartPOST(testName string, t *testing.T, uri string, jwt string,
 
   // Process the request
   respRec := httptest.NewRecorder()
-  router.ServeHTTP(respRec, req)
+  serve(respRec, req)
 
   // Process results
   respCode = respRec.Code
@@ -99,6 +150,57 @@ func SendMultipartPOST(testName string, t *testing.T, uri string, jwt string,
   return
 }
 
+// writeMultipartForm writes the files and fields of a multipart form.
+func writeMultipartForm(writer *multipart.Writer, params map[string]string,
+                        files []MultipartFile) error {
+  for _, f := range files {
+    field := f.Field
+    if field == "" {
+      field = "file"
+    }
+    contentType := f.ContentType
+    if contentType == "" {
+      contentType = "application/octet-stream"
+    }
+    h := make(textproto.MIMEHeader)
+    h.Set("Content-Disposition",
+          fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+                      quoteEscaper.Replace(field), quoteEscaper.Replace(f.Name)))
+    h.Set("Content-Type", contentType)
+    part, err := writer.CreatePart(h)
+    if err != nil {
+      return err
+    }
+
+    if f.Source == "" {
+      _, err = io.WriteString(part, f.Contents)
+    } else {
+      err = copyFile(part, f.Source)
+    }
+    if err != nil {
+      return err
+    }
+  }
+
+  for key, val := range params {
+    if err := writer.WriteField(key, val); err != nil {
+      return err
+    }
+  }
+  return writer.Close()
+}
+
+// copyFile copies the content of the file at path to w.
+func copyFile(w io.Writer, path string) error {
+  f, err := os.Open(path)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  _, err = io.Copy(w, f)
+  return err
+}
+
 
 // CreateTmpFolderWithContents creates a tmp folder with the given files and
 // returns the path to the created folder. See type fileDesc above.
@@ -194,7 +296,7 @@ func AssertRouteMultipleArgs(method string, route string, body *bytes.Buffer, co
 
   // Process the request
   respRec := httptest.NewRecorder()
-  router.ServeHTTP(respRec, req)
+  serve(respRec, req)
 
   // Read the result
   var er error
@@ -0,0 +1,139 @@
+package igntest
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "sync"
+  "sync/atomic"
+  "testing"
+  "bitbucket.org/ignitionrobotics/ign-go"
+)
+
+// TestServer is an ign.Server with its own database, created with
+// NewTestServer. Requests must be sent using its ServeHTTP (or Request)
+// method, which makes the handlers use the test database through
+// ign.DBFromRequest. Other ign settings are global, so tests that change
+// them can't run in parallel.
+type TestServer struct {
+  *ign.Server
+  // dropDb removes the test database, if it is not in memory.
+  dropDb func()
+  cleanupOnce sync.Once
+}
+
+// TestServerOptions configures NewTestServer.
+type TestServerOptions struct {
+  // Models are migrated in the test database (see gorm's AutoMigrate).
+  Models []interface{}
+  // Fixtures are glob patterns of the fixture files loaded in the test
+  // database. See ign.Server.Seed.
+  Fixtures []string
+}
+
+// testServerCount is used to name the test databases.
+var testServerCount int64
+
+// NewTestServer creates a server for the given routes, with a new database
+// for the test. If IGN_TEST_SQLITE is "true", the database is an in-memory
+// SQLite database (the tests must be built with -tags sqlite). Otherwise a
+// MySQL database is created using the IGN_DB_* settings, with a unique
+// name. The database is removed when the test ends, or by calling Cleanup.
+// Tests using different test servers can run in parallel (t.Parallel).
+func NewTestServer(t testing.TB, routes ign.Routes,
+                   opts TestServerOptions) *TestServer {
+  t.Helper()
+  config, err := ign.ReadDatabaseConfig("IGN")
+  if err != nil {
+    t.Fatal("Invalid database settings:", err)
+  }
+  config.MaxAttempts = 1
+  name := fmt.Sprintf("igntest_%d_%d", os.Getpid(),
+                      atomic.AddInt64(&testServerCount, 1))
+
+  s := &TestServer{Server: &ign.Server{IsTest: true}}
+  if useSQLite, _ := ign.ReadEnvVar("IGN_TEST_SQLITE"); useSQLite == "true" {
+    config.Dialect = ign.DialectSQLite
+    config.Name = "file:" + name + "?mode=memory&cache=shared"
+  } else {
+    if s.dropDb, err = createTestDatabase(config, name); err != nil {
+      t.Fatal("Unable to create the test database:", err)
+    }
+    config.Name = name
+  }
+  s.DbConfig = config
+  t.Cleanup(s.Cleanup)
+
+  if err := s.ConnectDatabase(context.Background()); err != nil {
+    t.Fatal("Unable to connect to the test database:", err)
+  }
+  if len(opts.Models) > 0 {
+    if err := s.Db.AutoMigrate(opts.Models...); err != nil {
+      t.Fatal("Unable to migrate the test database:", err)
+    }
+  }
+  if len(opts.Fixtures) > 0 {
+    if err := s.Seed(opts.Fixtures, ign.SeedOptions{}); err != nil {
+      t.Fatal("Unable to load the fixtures:", err)
+    }
+  }
+  s.Router = ign.NewRouter(routes)
+  return s
+}
+
+// createTestDatabase creates a MySQL database with the given name, and
+// returns the function that removes it.
+func createTestDatabase(config ign.DatabaseConfig, name string) (func(), error) {
+  admin := &ign.Server{}
+  admin.DbConfig = config
+  admin.DbConfig.Name = ""
+  if err := admin.ConnectDatabase(context.Background()); err != nil {
+    return nil, err
+  }
+  closeAdmin := func() {
+    if sqlDB, err := admin.Db.DB(); err == nil {
+      sqlDB.Close()
+    }
+  }
+  if err := admin.Db.Exec("CREATE DATABASE `" + name + "`").Error; err != nil {
+    closeAdmin()
+    return nil, err
+  }
+  return func() {
+    admin.Db.Exec("DROP DATABASE IF EXISTS `" + name + "`")
+    closeAdmin()
+  }, nil
+}
+
+// ServeHTTP serves a request with the routes and the database of the test
+// server.
+func (s *TestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  s.Router.ServeHTTP(w, ign.WithDB(r, s.Db))
+}
+
+// Request sends a request to the test server, and returns the recorded
+// response. The body can be nil.
+func (s *TestServer) Request(method, uri string, body io.Reader) *httptest.ResponseRecorder {
+  rec := httptest.NewRecorder()
+  s.ServeHTTP(rec, httptest.NewRequest(method, uri, body))
+  return rec
+}
+
+// Cleanup closes the database connection and removes the test database.
+// It is called automatically when the test ends, and can be called more
+// than once.
+func (s *TestServer) Cleanup() {
+  s.cleanupOnce.Do(func() {
+    if s.Db != nil {
+      if sqlDB, err := s.Db.DB(); err == nil {
+        sqlDB.Close()
+      }
+    }
+    if s.dropDb != nil {
+      s.dropDb()
+    }
+  })
+}
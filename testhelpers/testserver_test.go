@@ -0,0 +1,85 @@
+package igntest
+
+import (
+  "net/http"
+  "strings"
+  "testing"
+  "bitbucket.org/ignitionrobotics/ign-go"
+  "gorm.io/gorm"
+)
+
+// item is the model served by the test routes.
+type item struct {
+  ID uint
+  Name string
+}
+
+// itemsRoutes returns a route that lists the items of the request database.
+func itemsRoutes() ign.Routes {
+  list := func(w http.ResponseWriter, r *http.Request) (interface{}, *ign.ErrMsg) {
+    var items []item
+    if err := ign.DBFromRequest(r).Order("id").Find(&items).Error; err != nil {
+      return nil, ign.ErrMsgFromDB(err)
+    }
+    return items, nil
+  }
+  return ign.Routes{
+    {
+      Name: "items",
+      Description: "Lists the items",
+      URI: "/items",
+      Methods: ign.Methods{
+        ign.Method{
+          Type: "GET",
+          Description: "Get the items",
+          Handlers: ign.FormatHandlers{
+            ign.FormatHandler{Handler: ign.JSONResult(list)},
+          },
+        },
+      },
+    },
+  }
+}
+
+// requireTestDatabase skips the test if no test database is configured.
+func requireTestDatabase(t *testing.T) {
+  if v, _ := ign.ReadEnvVar("IGN_TEST_SQLITE"); v == "true" {
+    return
+  }
+  if _, err := ign.ReadEnvVar("IGN_DB_ADDRESS"); err == nil {
+    return
+  }
+  t.Skip("Set IGN_TEST_SQLITE=true (with -tags sqlite) or IGN_DB_ADDRESS")
+}
+
+// TestTestServer tests serving a route with the database of a test server,
+// and rolling back transactions.
+func TestTestServer(t *testing.T) {
+  requireTestDatabase(t)
+  s := NewTestServer(t, itemsRoutes(),
+                     TestServerOptions{Models: []interface{}{&item{}}})
+  if err := s.Db.Create(&item{Name: "box"}).Error; err != nil {
+    t.Fatal("Unable to create an item:", err)
+  }
+
+  rec := s.Request("GET", "/items", nil)
+  if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Name":"box"`) {
+    t.Fatal("The route should use the test database:", rec.Code, rec.Body.String())
+  }
+
+  s.WithTx(t, func(tx *gorm.DB) {
+    tx.Create(&item{Name: "sphere"})
+    if rec := s.Request("GET", "/items", nil); !strings.Contains(rec.Body.String(),
+                                                                 "sphere") {
+      t.Fatal("The route should use the transaction:", rec.Body.String())
+    }
+  })
+  if rec := s.Request("GET", "/items", nil); strings.Contains(rec.Body.String(),
+                                                              "sphere") {
+    t.Fatal("The transaction should be rolled back:", rec.Body.String())
+  }
+
+  if rec := s.Request("GET", "/none", nil); rec.Code != http.StatusNotFound {
+    t.Fatal("Unknown routes should return 404:", rec.Code)
+  }
+}
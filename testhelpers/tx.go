@@ -0,0 +1,93 @@
+package igntest
+
+import (
+  "net/http"
+  "sync"
+  "testing"
+  "bitbucket.org/ignitionrobotics/ign-go"
+  "gorm.io/gorm"
+)
+
+// testDb is the database used by WithTx. See SetupTestDB.
+var testDb *gorm.DB
+
+// txMutex protects currentTx.
+var txMutex sync.Mutex
+
+// currentTx is the transaction of the running WithTx, used by the requests
+// sent with the helpers of this package.
+var currentTx *gorm.DB
+
+// SetupTestDB sets the database used by WithTx (eg. the Db of the server
+// returned by ign.Init).
+func SetupTestDB(db *gorm.DB) {
+  testDb = db
+}
+
+// WithTx runs fn inside a transaction of the test database (see
+// SetupTestDB), which is rolled back when fn returns, so the test leaves the
+// database as it was without truncating the tables. The requests sent with
+// the helpers of this package (eg. AssertRoute or SendMultipartPOST) are
+// served using the transaction. Transactions started by the handlers become
+// savepoints. WithTx calls can't be nested or run in parallel, and the
+// requests must be sent one at a time.
+func WithTx(t testing.TB, fn func(tx *gorm.DB)) {
+  t.Helper()
+  if testDb == nil {
+    t.Fatal("WithTx needs a database. Call SetupTestDB first")
+  }
+  txMutex.Lock()
+  if currentTx != nil {
+    txMutex.Unlock()
+    t.Fatal("WithTx calls can't be nested or run in parallel")
+  }
+  tx := beginTestTx(t, testDb)
+  currentTx = tx
+  txMutex.Unlock()
+
+  defer func() {
+    txMutex.Lock()
+    currentTx = nil
+    txMutex.Unlock()
+    tx.Rollback()
+  }()
+  fn(tx)
+}
+
+// WithTx runs fn inside a transaction of the test server database, which is
+// rolled back when fn returns. The Db of the server is the transaction while
+// fn runs, so requests sent to the server (see ServeHTTP) use it. The
+// requests must be sent one at a time.
+func (s *TestServer) WithTx(t testing.TB, fn func(tx *gorm.DB)) {
+  t.Helper()
+  db := s.Db
+  tx := beginTestTx(t, db)
+  s.Db = tx
+  defer func() {
+    s.Db = db
+    tx.Rollback()
+  }()
+  fn(tx)
+}
+
+// beginTestTx starts a transaction, failing the test on error.
+func beginTestTx(t testing.TB, db *gorm.DB) *gorm.DB {
+  t.Helper()
+  tx := db.Begin()
+  if tx.Error != nil {
+    t.Fatal("Unable to begin the test transaction:", tx.Error)
+  }
+  return tx
+}
+
+// serve serves a request with the router (see SetupTest), using the
+// transaction of the running WithTx, if any.
+func serve(w http.ResponseWriter, r *http.Request) {
+  txMutex.Lock()
+  tx := currentTx
+  txMutex.Unlock()
+  if tx != nil {
+    r = ign.WithDB(r, tx)
+  }
+  router.ServeHTTP(w, r)
+}
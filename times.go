@@ -0,0 +1,120 @@
+package ign
+
+import (
+  "database/sql/driver"
+  "errors"
+  "strings"
+  "time"
+)
+
+// Times are stored and serialized in UTC, as the database connections use
+// loc=UTC. Use NowUTC instead of time.Now for the times saved in the
+// database, and NullTime for nullable time columns and JSON fields.
+
+// iso8601Layouts are the layouts accepted by ParseISO8601. Times without
+// time zone are in UTC.
+var iso8601Layouts = []string{
+  time.RFC3339Nano,
+  "2006-01-02T15:04:05",
+  "2006-01-02T15:04",
+  "2006-01-02 15:04:05Z07:00",
+  "2006-01-02 15:04:05",
+  "20060102T150405Z0700",
+  "20060102T150405Z",
+  "2006-01-02",
+}
+
+// NowUTC returns the current time in UTC, truncated to microseconds, which
+// is the max precision of MySQL. This way the time doesn't change after a
+// database round trip.
+func NowUTC() time.Time {
+  return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// ParseISO8601 parses an ISO 8601 date or time (eg. "2020-05-01",
+// "2020-05-01T10:00:00Z" or "2020-05-01T12:00:00+02:00"). Times without time
+// zone are considered UTC. The result is in UTC.
+func ParseISO8601(s string) (time.Time, error) {
+  s = strings.TrimSpace(s)
+  for _, layout := range iso8601Layouts {
+    if t, err := time.Parse(layout, s); err == nil {
+      return t.UTC(), nil
+    }
+  }
+  return time.Time{}, errors.New("Invalid ISO 8601 time [" + s + "]")
+}
+
+// NullTime is a nullable time that is always stored and serialized in UTC.
+// In JSON it is an RFC 3339 string (eg. "2020-05-01T10:00:00Z"), or null.
+type NullTime struct {
+  Time time.Time
+  // Valid is false if the time is null.
+  Valid bool
+}
+
+// NewNullTime returns a valid NullTime with the given time.
+func NewNullTime(t time.Time) NullTime {
+  return NullTime{Time: t.UTC(), Valid: true}
+}
+
+// Scan is part of the sql.Scanner interface.
+func (n *NullTime) Scan(value interface{}) error {
+  switch v := value.(type) {
+  case nil:
+    *n = NullTime{}
+  case time.Time:
+    *n = NewNullTime(v)
+  case []byte:
+    return n.parse(string(v))
+  case string:
+    return n.parse(v)
+  default:
+    return errors.New("Unable to scan NullTime")
+  }
+  return nil
+}
+
+// parse sets the time from an ISO 8601 string.
+func (n *NullTime) parse(s string) error {
+  t, err := ParseISO8601(s)
+  if err != nil {
+    return err
+  }
+  *n = NewNullTime(t)
+  return nil
+}
+
+// Value is part of the driver.Valuer interface.
+func (n NullTime) Value() (driver.Value, error) {
+  if !n.Valid {
+    return nil, nil
+  }
+  return n.Time.UTC(), nil
+}
+
+// GormDataType returns the column type used by gorm.
+func (NullTime) GormDataType() string {
+  return "time"
+}
+
+// MarshalJSON is part of the json.Marshaler interface.
+func (n NullTime) MarshalJSON() ([]byte, error) {
+  if !n.Valid {
+    return []byte("null"), nil
+  }
+  return []byte(`"` + n.Time.UTC().Format(time.RFC3339Nano) + `"`), nil
+}
+
+// UnmarshalJSON is part of the json.Unmarshaler interface. Any time
+// accepted by ParseISO8601 is valid.
+func (n *NullTime) UnmarshalJSON(data []byte) error {
+  s := string(data)
+  if s == "null" {
+    *n = NullTime{}
+    return nil
+  }
+  if len(s) < 2 || s[0] != '"' || s[len(s) - 1] != '"' {
+    return errors.New("NullTime must be a string or null")
+  }
+  return n.parse(s[1:len(s) - 1])
+}
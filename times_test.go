@@ -0,0 +1,66 @@
+package ign
+
+import (
+  "encoding/json"
+  "testing"
+  "time"
+)
+
+// TestParseISO8601 tests the accepted time formats.
+func TestParseISO8601(t *testing.T) {
+  want := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+  for _, s := range []string{"2020-05-01T10:00:00Z", "2020-05-01T12:00:00+02:00",
+                             "2020-05-01T10:00:00", "2020-05-01 10:00:00",
+                             "20200501T100000Z"} {
+    got, err := ParseISO8601(s)
+    if err != nil || !got.Equal(want) || got.Location() != time.UTC {
+      t.Errorf("Unexpected time for [%s]: %v %v", s, got, err)
+    }
+  }
+  if _, err := ParseISO8601("05/01/2020"); err == nil {
+    t.Error("Expected an error")
+  }
+  if now := NowUTC(); now.Location() != time.UTC || now.Nanosecond() % 1000 != 0 {
+    t.Error("Unexpected NowUTC:", now)
+  }
+}
+
+// TestNullTime tests the JSON and database conversions of NullTime.
+func TestNullTime(t *testing.T) {
+  var v struct {
+    At NullTime `json:"at"`
+    Deleted NullTime `json:"deleted"`
+  }
+  local := time.FixedZone("CEST", 2 * 3600)
+  v.At = NewNullTime(time.Date(2020, 5, 1, 12, 0, 0, 0, local))
+  data, _ := json.Marshal(v)
+  if string(data) != `{"at":"2020-05-01T10:00:00Z","deleted":null}` {
+    t.Fatal("Unexpected JSON:", string(data))
+  }
+
+  v.At, v.Deleted = NullTime{}, NullTime{}
+  if err := json.Unmarshal([]byte(`{"at":"2020-05-01T12:00:00+02:00","deleted":null}`),
+                           &v); err != nil {
+    t.Fatal("Unable to unmarshal:", err)
+  }
+  if !v.At.Valid || v.At.Time.Location() != time.UTC || v.Deleted.Valid {
+    t.Fatal("Unexpected times:", v)
+  }
+
+  // Sub-second precision is kept
+  precise := NewNullTime(time.Date(2020, 5, 1, 10, 0, 0, 123456789, time.UTC))
+  data, _ = json.Marshal(precise)
+  var decoded NullTime
+  if err := json.Unmarshal(data, &decoded); err != nil ||
+     !decoded.Time.Equal(precise.Time) {
+    t.Fatal("The time should round-trip:", string(data), err)
+  }
+
+  var n NullTime
+  if err := n.Scan([]byte("2020-05-01 10:00:00")); err != nil || !n.Time.Equal(v.At.Time) {
+    t.Fatal("Unexpected scanned time:", n, err)
+  }
+  if value, _ := (NullTime{}).Value(); value != nil {
+    t.Fatal("Null times should be stored as NULL")
+  }
+}
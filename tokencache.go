@@ -0,0 +1,165 @@
+package ign
+
+import (
+  "container/list"
+  "crypto/sha256"
+  "sync"
+  "time"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// Validated tokens are kept in a small LRU cache, so the signature of a
+// token is verified only once while the token is used. Entries expire with
+// the token ("exp" claim), or after tokenCacheMaxAge. Tokens without "exp"
+// are not cached, and the "exp" and "nbf" claims are checked again on every
+// hit. Each request gets its own copy of the cached token, so handlers can't
+// change the claims seen by other requests.
+
+const (
+  // defaultTokenCacheSize is the default number of cached tokens.
+  defaultTokenCacheSize = 1000
+  // tokenCacheMaxAge is the max time a token is cached.
+  tokenCacheMaxAge = 5 * time.Minute
+)
+
+// gTokenCache is the cache used by the auth middleware. Nil if disabled.
+var gTokenCache = newTokenCache(defaultTokenCacheSize)
+
+// tokenCache is an LRU cache of validated tokens, keyed by token hash.
+type tokenCache struct {
+  size int
+  mutex sync.Mutex
+  entries map[[sha256.Size]byte]*list.Element
+  lru *list.List
+}
+
+// tokenCacheEntry is a cached token.
+type tokenCacheEntry struct {
+  key [sha256.Size]byte
+  token *jwt.Token
+  expires time.Time
+}
+
+// newTokenCache creates a cache with the given size. It returns nil if the
+// size is not positive.
+func newTokenCache(size int) *tokenCache {
+  if size <= 0 {
+    return nil
+  }
+  return &tokenCache{
+    size: size,
+    entries: map[[sha256.Size]byte]*list.Element{},
+    lru: list.New(),
+  }
+}
+
+// SetTokenCacheSize sets the number of validated tokens kept in memory. Zero
+// disables the cache.
+func (s *Server) SetTokenCacheSize(size int) {
+  gTokenCache = newTokenCache(size)
+}
+
+// get returns a copy of the validated token, if cached and still valid.
+func (c *tokenCache) get(raw string) (*jwt.Token, bool) {
+  if c == nil {
+    return nil, false
+  }
+  key := sha256.Sum256([]byte(raw))
+
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  elem, ok := c.entries[key]
+  if !ok {
+    return nil, false
+  }
+  entry := elem.Value.(*tokenCacheEntry)
+  now := clock().Now()
+  claims := entry.token.Claims.(jwt.MapClaims)
+  if now.After(entry.expires) || !claims.VerifyExpiresAt(now.Unix(), true) {
+    c.lru.Remove(elem)
+    delete(c.entries, key)
+    return nil, false
+  }
+  if !claims.VerifyNotBefore(now.Unix(), false) {
+    return nil, false
+  }
+  c.lru.MoveToFront(elem)
+  return copyToken(entry.token), true
+}
+
+// add caches a validated token. Tokens without "exp" claim are not cached.
+func (c *tokenCache) add(raw string, token *jwt.Token) {
+  if c == nil {
+    return
+  }
+  claims, ok := token.Claims.(jwt.MapClaims)
+  if !ok {
+    return
+  }
+  exp, ok := claims["exp"].(float64)
+  if !ok {
+    return
+  }
+  expires := clock().Now().Add(tokenCacheMaxAge)
+  if t := time.Unix(int64(exp), 0); t.Before(expires) {
+    expires = t
+  }
+  key := sha256.Sum256([]byte(raw))
+  token = copyToken(token)
+
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  if elem, ok := c.entries[key]; ok {
+    c.lru.Remove(elem)
+  }
+  c.entries[key] = c.lru.PushFront(&tokenCacheEntry{key, token, expires})
+  if c.lru.Len() > c.size {
+    oldest := c.lru.Back()
+    c.lru.Remove(oldest)
+    delete(c.entries, oldest.Value.(*tokenCacheEntry).key)
+  }
+}
+
+// copyToken returns a copy of a token, including its header and claims.
+func copyToken(token *jwt.Token) *jwt.Token {
+  cp := *token
+  cp.Header = copyJSONValue(token.Header).(map[string]interface{})
+  if claims, ok := token.Claims.(jwt.MapClaims); ok {
+    copied := copyJSONValue(map[string]interface{}(claims))
+    cp.Claims = jwt.MapClaims(copied.(map[string]interface{}))
+  }
+  return &cp
+}
+
+// copyJSONValue returns a deep copy of a decoded JSON value.
+func copyJSONValue(v interface{}) interface{} {
+  switch v := v.(type) {
+  case map[string]interface{}:
+    if v == nil {
+      return v
+    }
+    cp := make(map[string]interface{}, len(v))
+    for k, e := range v {
+      cp[k] = copyJSONValue(e)
+    }
+    return cp
+  case []interface{}:
+    cp := make([]interface{}, len(v))
+    for i, e := range v {
+      cp[i] = copyJSONValue(e)
+    }
+    return cp
+  }
+  return v
+}
+
+// clear removes all the cached tokens. Used when the validation keys change.
+func (c *tokenCache) clear() {
+  if c == nil {
+    return
+  }
+  c.mutex.Lock()
+  defer c.mutex.Unlock()
+  c.entries = map[[sha256.Size]byte]*list.Element{}
+  c.lru.Init()
+}
@@ -0,0 +1,95 @@
+package ign
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "strconv"
+  "testing"
+  "time"
+  "github.com/dgrijalva/jwt-go"
+)
+
+// TestTokenCache tests the LRU eviction and expiration of cached tokens.
+func TestTokenCache(t *testing.T) {
+  c := newTokenCache(2)
+  token := func(exp time.Time) *jwt.Token {
+    return &jwt.Token{Claims: jwt.MapClaims{"exp": float64(exp.Unix())}}
+  }
+  later := time.Now().Add(time.Hour)
+
+  c.add("a", token(later))
+  c.add("b", token(later))
+  c.get("a")
+  c.add("c", token(later))
+  if _, ok := c.get("b"); ok {
+    t.Fatal("The least recently used token should be evicted")
+  }
+  if _, ok := c.get("a"); !ok {
+    t.Fatal("Recently used token was evicted")
+  }
+
+  c.add("expired", token(time.Now().Add(-time.Second)))
+  if _, ok := c.get("expired"); ok {
+    t.Fatal("Expired tokens should not be returned")
+  }
+
+  c.add("no-exp", &jwt.Token{Claims: jwt.MapClaims{"sub": "alice"}})
+  if _, ok := c.get("no-exp"); ok {
+    t.Fatal("Tokens without exp should not be cached")
+  }
+  c.add("not-yet", &jwt.Token{Claims: jwt.MapClaims{
+    "exp": float64(later.Unix()),
+    "nbf": float64(later.Add(-time.Minute).Unix()),
+  }})
+  if _, ok := c.get("not-yet"); ok {
+    t.Fatal("Tokens should not be used before nbf")
+  }
+
+  // Each hit gets its own copy of the claims
+  cached, _ := c.get("a")
+  cached.Claims.(jwt.MapClaims)["sub"] = "mallory"
+  if cached, _ = c.get("a"); cached.Claims.(jwt.MapClaims)["sub"] != nil {
+    t.Fatal("Changing the claims of a request should not change the cache")
+  }
+
+  c.clear()
+  if _, ok := c.get("a"); ok {
+    t.Fatal("Cache was not cleared")
+  }
+  if newTokenCache(0) != nil {
+    t.Fatal("A zero size should disable the cache")
+  }
+}
+
+// TestAuthMiddlewareTokenCache tests skipping the validation of cached
+// tokens.
+func TestAuthMiddlewareTokenCache(t *testing.T) {
+  prev := gTokenCache
+  defer func() { gTokenCache = prev }()
+  gTokenCache = newTokenCache(10)
+
+  validations := 0
+  jwtMiddleware := func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    validations++
+    token := &jwt.Token{Claims: jwt.MapClaims{
+      "sub": "alice",
+      "exp": float64(time.Now().Add(time.Hour).Unix()),
+    }}
+    next(w, r.WithContext(context.WithValue(r.Context(), "user", token)))
+  }
+  mw := newAuthMiddleware(jwtMiddleware, false)
+
+  for i := 0; i < 3; i++ {
+    req, _ := http.NewRequest("GET", "/models", nil)
+    req.Header.Set("Authorization", "Bearer token" + strconv.Itoa(i % 2))
+    mw(httptest.NewRecorder(), req, func(w http.ResponseWriter, r *http.Request) {
+      if sub, ok := GetUserIdentity(r); !ok || sub != "alice" {
+        t.Fatal("Missing identity:", sub)
+      }
+    })
+  }
+  if validations != 2 {
+    t.Fatal("Cached tokens should not be validated again:", validations)
+  }
+}
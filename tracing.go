@@ -0,0 +1,92 @@
+package ign
+
+import (
+  "net/http"
+  "time"
+  "github.com/codegangsta/negroni"
+  "go.opentelemetry.io/otel/attribute"
+  "go.opentelemetry.io/otel/codes"
+  "go.opentelemetry.io/otel/propagation"
+  "go.opentelemetry.io/otel/trace"
+)
+
+// Distributed tracing is optional, and it is enabled by setting an
+// OpenTelemetry TracerProvider with Server.SetTracerProvider.
+// When enabled, a span named after the Route.Name is started for each
+// request. W3C trace context headers (traceparent, tracestate) found in the
+// request are used as the parent of the span.
+// Handlers can trace database calls with TraceDB.
+
+// tracerName is the name of the tracer used by this package.
+const tracerName = "bitbucket.org/ignitionrobotics/ign-go"
+
+// tracePropagator reads the W3C trace context from request headers.
+var tracePropagator = propagation.TraceContext{}
+
+// SetTracerProvider enables distributed tracing using the given provider.
+// A nil provider disables tracing.
+func (s *Server) SetTracerProvider(tp trace.TracerProvider) {
+  if tp == nil {
+    s.tracer = nil
+    return
+  }
+  s.tracer = tp.Tracer(tracerName)
+}
+
+// TraceDB runs the given database function inside a span named "db: name",
+// child of the request span. The span records the time spent in the
+// database and the returned error. If tracing is disabled, fn is just
+// called.
+func TraceDB(r *http.Request, name string, fn func() error) error {
+  if gServer == nil || gServer.tracer == nil {
+    return fn()
+  }
+  _, span := gServer.tracer.Start(r.Context(), "db: " + name,
+                                  trace.WithSpanKind(trace.SpanKindClient))
+  defer span.End()
+
+  start := time.Now()
+  err := fn()
+  elapsed := time.Since(start)
+  span.SetAttributes(attribute.Float64("db.duration_ms",
+                     float64(elapsed) / float64(time.Millisecond)))
+  if err != nil {
+    span.RecordError(err)
+    span.SetStatus(codes.Error, err.Error())
+  }
+  return err
+}
+
+/////////////////////////////////////////////////
+// newTracingMiddleware creates a middleware that starts a span for each
+// request of the given route.
+func newTracingMiddleware(routeName string) negroni.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+    if gServer == nil || gServer.tracer == nil {
+      next(w, r)
+      return
+    }
+
+    ctx := tracePropagator.Extract(r.Context(),
+                                   propagation.HeaderCarrier(r.Header))
+    ctx, span := gServer.tracer.Start(ctx, routeName,
+      trace.WithSpanKind(trace.SpanKindServer),
+      trace.WithAttributes(
+        attribute.String("http.method", r.Method),
+        attribute.String("http.target",
+                         redactAccessToken(r.URL.RequestURI())),
+      ))
+    defer span.End()
+
+    next(w, r.WithContext(ctx))
+
+    status := http.StatusOK
+    if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+      status = rw.Status()
+    }
+    span.SetAttributes(attribute.Int("http.status_code", status))
+    if status >= http.StatusInternalServerError {
+      span.SetStatus(codes.Error, http.StatusText(status))
+    }
+  }
+}
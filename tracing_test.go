@@ -0,0 +1,68 @@
+package ign
+
+import (
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "github.com/codegangsta/negroni"
+  "go.opentelemetry.io/otel/attribute"
+  "go.opentelemetry.io/otel/codes"
+  sdktrace "go.opentelemetry.io/otel/sdk/trace"
+  "go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Tests for the tracing middleware
+
+// TestTracingMiddleware tests that a span is created per request, using the
+// W3C trace context found in the request headers.
+func TestTracingMiddleware(t *testing.T) {
+  recorder := tracetest.NewSpanRecorder()
+  tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+  gServer.SetTracerProvider(tp)
+
+  handler := negroni.New(
+    negroni.HandlerFunc(newTracingMiddleware("modelIndex")),
+    negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      TraceDB(r, "find models", func() error { return errors.New("db error") })
+      w.WriteHeader(http.StatusInternalServerError)
+    })),
+  )
+
+  traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+  req, _ := http.NewRequest("GET", "/1.0/models", nil)
+  req.Header.Set("traceparent", "00-" + traceID + "-00f067aa0ba902b7-01")
+  handler.ServeHTTP(httptest.NewRecorder(), req)
+
+  spans := recorder.Ended()
+  if len(spans) != 2 {
+    t.Fatal("Expected a request span and a db span, got:", len(spans))
+  }
+  db, root := spans[0], spans[1]
+  if root.Name() != "modelIndex" || db.Name() != "db: find models" {
+    t.Fatal("Unexpected span names", root.Name(), db.Name())
+  }
+  if root.SpanContext().TraceID().String() != traceID {
+    t.Fatal("Request trace context was not propagated",
+            root.SpanContext().TraceID())
+  }
+  if db.Parent().SpanID() != root.SpanContext().SpanID() {
+    t.Fatal("The db span should be a child of the request span")
+  }
+  if root.Status().Code != codes.Error || db.Status().Code != codes.Error {
+    t.Fatal("Spans should have an error status")
+  }
+  found := false
+  for _, a := range root.Attributes() {
+    if a.Key == attribute.Key("http.status_code") && a.Value.AsInt64() == 500 {
+      found = true
+    }
+  }
+  if !found {
+    t.Fatal("Missing http.status_code attribute", root.Attributes())
+  }
+}
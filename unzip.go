@@ -0,0 +1,321 @@
+package ign
+
+import (
+  "archive/zip"
+  "bytes"
+  "context"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "unicode/utf8"
+  "golang.org/x/text/encoding/charmap"
+)
+
+// Default extraction limits of the Unzip functions. See UnzipOptions.
+const (
+  // DefaultUnzipMaxSize is the default max total uncompressed size: 4GB.
+  DefaultUnzipMaxSize = 4 << 30
+  // DefaultUnzipMaxEntries is the default max number of archive entries.
+  DefaultUnzipMaxEntries = 100000
+)
+
+// UnzipOptions are the extraction limits of the Unzip functions. Zero
+// values use the defaults.
+type UnzipOptions struct {
+  // Max total size, in bytes, of the extracted files.
+  MaxSize int64
+  // Max number of entries (files and directories) of the archive.
+  MaxEntries int
+  // Log the created files and directories.
+  Verbose bool
+  // Scanner checks each extracted file. Defaults to the
+  // Server.UploadScanner.
+  Scanner UploadScanner
+  // Context of the scans (eg. the request context). Defaults to
+  // context.Background().
+  Context context.Context
+}
+
+// UnzipError is the error returned by the Unzip functions. It identifies
+// the archive entry that could not be extracted, if any.
+type UnzipError struct {
+  // Name of the offending entry. Empty if the error is about the whole
+  // archive.
+  Entry string
+  // Description of the error.
+  Msg string
+  // The root cause error, if any.
+  Err error
+}
+
+// Error is part of the error interface.
+func (e *UnzipError) Error() string {
+  if e.Entry == "" {
+    return "unzip: " + e.Msg
+  }
+  return "unzip: " + e.Msg + " [" + e.Entry + "]"
+}
+
+// Unwrap returns the root cause error.
+func (e *UnzipError) Unwrap() error {
+  return e.Err
+}
+
+// Unzip a memory buffer
+func Unzip(buff bytes.Buffer, size int64, dest string, verbose bool) error {
+  reader, err := zip.NewReader(bytes.NewReader(buff.Bytes()), size)
+  if err != nil {
+    return &UnzipError{Msg: "Unable to read byte buffer", Err: err}
+  }
+  return UnzipImpl(reader, dest, verbose)
+}
+
+// UnzipFile extracts a compressed .zip file
+func UnzipFile(zipfile string, dest string, verbose bool) error {
+  reader, err := zip.OpenReader(zipfile)
+  if err != nil {
+    return &UnzipError{Msg: "Unable to open", Entry: zipfile, Err: err}
+  }
+  defer reader.Close()
+  return UnzipImpl(&reader.Reader, dest, verbose)
+}
+
+// UnzipStream extracts an archive read from r, without loading it in
+// memory. Size is the size of the archive. See UnzipWithOptions.
+func UnzipStream(r io.ReaderAt, size int64, dest string, opts UnzipOptions) error {
+  reader, err := zip.NewReader(r, size)
+  if err != nil {
+    return &UnzipError{Msg: "Unable to read archive", Err: err}
+  }
+  return UnzipWithOptions(reader, dest, opts)
+}
+
+// UnzipReader extracts an archive read from a non seekable reader (eg. an
+// upload). The archive is first copied to a temporary file, which is
+// removed afterwards. The archive can't be larger than the max
+// uncompressed size. See UnzipWithOptions.
+func UnzipReader(r io.Reader, dest string, opts UnzipOptions) error {
+  tmp, err := ioutil.TempFile("", "ign-unzip-")
+  if err != nil {
+    return &UnzipError{Msg: "Unable to create temporary file", Err: err}
+  }
+  defer os.Remove(tmp.Name())
+  defer tmp.Close()
+
+  maxSize := opts.MaxSize
+  if maxSize <= 0 {
+    maxSize = DefaultUnzipMaxSize
+  }
+  size, err := io.CopyN(tmp, r, maxSize + 1)
+  if err != nil && err != io.EOF {
+    return &UnzipError{Msg: "Unable to read archive", Err: err}
+  }
+  if size > maxSize {
+    return &UnzipError{Msg: "Archive exceeds the max uncompressed size"}
+  }
+  return UnzipStream(tmp, size, dest, opts)
+}
+
+// UnzipImpl is a helper unzip implementation. It uses the default limits.
+func UnzipImpl(reader *zip.Reader, dest string, verbose bool) error {
+  return UnzipWithOptions(reader, dest, UnzipOptions{Verbose: verbose})
+}
+
+// UnzipWithOptions extracts the archive into dest. Entries with unsafe paths
+// (eg. "../x", absolute paths or reserved names) are rejected, as well as
+// archives exceeding the size or entry limits. Names without the UTF-8 flag
+// are decoded as CP437, and backslashes are used as path separators, as
+// done by Windows tools. IsUnsafeContent is true for the returned error if
+// the scanner rejects a file. If the extraction fails, the files and
+// directories it created are removed. The returned error is an *UnzipError.
+func UnzipWithOptions(reader *zip.Reader, dest string, opts UnzipOptions) error {
+  maxSize := opts.MaxSize
+  if maxSize <= 0 {
+    maxSize = DefaultUnzipMaxSize
+  }
+  maxEntries := opts.MaxEntries
+  if maxEntries <= 0 {
+    maxEntries = DefaultUnzipMaxEntries
+  }
+  if len(reader.File) > maxEntries {
+    return &UnzipError{Msg: "Too many entries in archive"}
+  }
+
+  scanner := opts.Scanner
+  if scanner == nil {
+    scanner = uploadScanner()
+  }
+  ctx := opts.Context
+  if ctx == nil {
+    ctx = context.Background()
+  }
+
+  var created unzipCreated
+  if err := extractZip(ctx, reader, filepath.Clean(dest), maxSize, scanner,
+                       opts.Verbose, &created); err != nil {
+    created.remove()
+    return err
+  }
+  return nil
+}
+
+// extractZip extracts the entries of the archive, recording the created
+// files and directories.
+func extractZip(ctx context.Context, reader *zip.Reader, dest string,
+                maxSize int64, scanner UploadScanner, verbose bool,
+                created *unzipCreated) error {
+  remaining := maxSize
+  for _, f := range reader.File {
+    name := unzipEntryName(f)
+    path, err := unzipPath(dest, name)
+    if err != nil {
+      return err
+    }
+
+    if f.FileInfo().IsDir() || strings.HasSuffix(name, `\`) {
+      if err := created.mkdirAll(path, f.Mode()); err != nil {
+        return &UnzipError{Msg: "Unable to create folder", Entry: f.Name,
+                           Err: err}
+      }
+      if verbose {
+        gLogger.Debug("Creating directory", Fields{"path": path})
+      }
+      continue
+    }
+
+    written, err := unzipFile(f, path, remaining, created)
+    if err != nil {
+      return err
+    }
+    remaining -= written
+    if scanner != nil {
+      if err := unzipScan(ctx, scanner, name, path); err != nil {
+        return err
+      }
+    }
+    if verbose {
+      gLogger.Debug("Decompressing", Fields{"path": path})
+    }
+  }
+  return nil
+}
+
+// unzipCreated are the paths created by an extraction, in creation order.
+type unzipCreated []string
+
+// mkdirAll creates a directory and its missing parents, recording them.
+func (c *unzipCreated) mkdirAll(path string, perm os.FileMode) error {
+  var missing []string
+  for p := path; ; p = filepath.Dir(p) {
+    if _, err := os.Lstat(p); err == nil || filepath.Dir(p) == p {
+      break
+    }
+    missing = append(missing, p)
+  }
+  if err := os.MkdirAll(path, perm); err != nil {
+    return err
+  }
+  for i := len(missing) - 1; i >= 0; i-- {
+    *c = append(*c, missing[i])
+  }
+  return nil
+}
+
+// remove removes the created paths, in reverse order, so directories are
+// empty when they are removed.
+func (c unzipCreated) remove() {
+  for i := len(c) - 1; i >= 0; i-- {
+    os.Remove(c[i])
+  }
+}
+
+// unzipEntryName returns the name of an entry. Names of entries without the
+// UTF-8 flag that are not valid UTF-8 are decoded as CP437, the encoding
+// used by the original zip format.
+func unzipEntryName(f *zip.File) string {
+  const utf8Flag = 0x800
+  if f.Flags & utf8Flag != 0 || utf8.ValidString(f.Name) {
+    return f.Name
+  }
+  name, err := charmap.CodePage437.NewDecoder().String(f.Name)
+  if err != nil {
+    return f.Name
+  }
+  return name
+}
+
+// unzipPath returns the extraction path of an entry, checking that it is
+// inside dest. Backslashes are converted to slashes before the check. See
+// SafeJoin.
+func unzipPath(dest, name string) (string, error) {
+  slashed := strings.Replace(name, `\`, "/", -1)
+  if filepath.IsAbs(slashed) || strings.HasPrefix(slashed, "/") {
+    return "", &UnzipError{Msg: "Absolute path in archive", Entry: name}
+  }
+  path, err := SafeJoin(dest, slashed)
+  if err != nil {
+    return "", &UnzipError{Msg: "Unsafe path in archive", Entry: name, Err: err}
+  }
+  return path, nil
+}
+
+// unzipScan scans an extracted file.
+func unzipScan(ctx context.Context, scanner UploadScanner,
+               name, path string) error {
+  f, err := os.Open(path)
+  if err != nil {
+    return &UnzipError{Msg: "Unable to scan", Entry: name, Err: err}
+  }
+  err = scanner.Scan(ctx, name, f)
+  f.Close()
+  if IsUnsafeContent(err) {
+    return &UnzipError{Msg: "Unsafe content in archive", Entry: name, Err: err}
+  }
+  if err != nil {
+    return &UnzipError{Msg: "Unable to scan", Entry: name, Err: err}
+  }
+  return nil
+}
+
+// unzipFile extracts a file entry, writing at most maxSize bytes. It
+// returns the number of written bytes.
+func unzipFile(f *zip.File, path string, maxSize int64,
+               created *unzipCreated) (int64, error) {
+  zipped, err := f.Open()
+  if err != nil {
+    return 0, &UnzipError{Msg: "Unable to open", Entry: f.Name, Err: err}
+  }
+  defer zipped.Close()
+
+  // Ensure we create the parent folder
+  if err := created.mkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+    return 0, &UnzipError{Msg: "Unable to create parent folder",
+                          Entry: f.Name, Err: err}
+  }
+
+  _, err = os.Lstat(path)
+  existed := err == nil
+  writer, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+                             f.Mode().Perm())
+  if err != nil {
+    return 0, &UnzipError{Msg: "Unable to create", Entry: f.Name, Err: err}
+  }
+  if !existed {
+    *created = append(*created, path)
+  }
+  defer writer.Close()
+
+  // The declared sizes can't be trusted, so the written bytes are counted
+  written, err := io.CopyN(writer, zipped, maxSize + 1)
+  if err != nil && err != io.EOF {
+    return written, &UnzipError{Msg: "Unable to create content",
+                                Entry: f.Name, Err: err}
+  }
+  if written > maxSize {
+    return written, &UnzipError{Msg: "Archive exceeds the max uncompressed size",
+                                Entry: f.Name}
+  }
+  return written, nil
+}
@@ -0,0 +1,129 @@
+package ign
+
+import (
+  "archive/zip"
+  "bytes"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// newZip creates an archive with the given entries.
+func newZip(entries map[string]string) (bytes.Buffer, *zip.Reader) {
+  var buf bytes.Buffer
+  zw := zip.NewWriter(&buf)
+  for name, contents := range entries {
+    w, _ := zw.Create(name)
+    w.Write([]byte(contents))
+  }
+  zw.Close()
+  reader, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+  return buf, reader
+}
+
+// TestUnzip tests extracting archives and their limits.
+func TestUnzip(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "unzip")
+  defer os.RemoveAll(dir)
+
+  buf, _ := newZip(map[string]string{"model.sdf": "<sdf/>",
+                                     "meshes/box.dae": "dae"})
+  if err := Unzip(buf, int64(buf.Len()), dir, false); err != nil {
+    t.Fatal("Unable to unzip:", err)
+  }
+  if data, _ := ioutil.ReadFile(filepath.Join(dir, "meshes", "box.dae"));
+     string(data) != "dae" {
+    t.Fatal("Unexpected file contents:", string(data))
+  }
+
+  tests := []struct {
+    entries map[string]string
+    opts UnzipOptions
+    entry string
+  }{
+    {map[string]string{"../evil": "x"}, UnzipOptions{}, "../evil"},
+    {map[string]string{"a/../../evil": "x"}, UnzipOptions{}, "a/../../evil"},
+    {map[string]string{"/etc/evil": "x"}, UnzipOptions{}, "/etc/evil"},
+    {map[string]string{"meshes/CON.dae": "x"}, UnzipOptions{}, "meshes/CON.dae"},
+    // A folder entry with the path of an existing file
+    {map[string]string{"model.sdf/": ""}, UnzipOptions{}, "model.sdf/"},
+    {map[string]string{"big": strings.Repeat("x", 100)},
+     UnzipOptions{MaxSize: 50}, "big"},
+    {map[string]string{"a": "1", "b": "2", "c": "3"},
+     UnzipOptions{MaxEntries: 2}, ""},
+  }
+  for _, test := range tests {
+    _, reader := newZip(test.entries)
+    err := UnzipWithOptions(reader, dir, test.opts)
+    unzipErr, ok := err.(*UnzipError)
+    if !ok || unzipErr.Entry != test.entry {
+      t.Fatal("Expected an error for", test.entries, err)
+    }
+  }
+  if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "evil")); err == nil {
+    t.Fatal("A file was written outside the destination")
+  }
+}
+
+// TestUnzipNames tests extracting entries with CP437 names and backslash
+// separators, as created by Windows tools.
+func TestUnzipNames(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "unzip")
+  defer os.RemoveAll(dir)
+
+  newArchive := func(names ...string) *zip.Reader {
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    for _, name := range names {
+      // Without the UTF-8 flag
+      w, _ := zw.CreateHeader(&zip.FileHeader{Name: name, NonUTF8: true})
+      w.Write([]byte("x"))
+    }
+    zw.Close()
+    reader, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+    return reader
+  }
+
+  // 0x82 is "é" in CP437
+  reader := newArchive("caf\x82.sdf", `meshes\box.dae`)
+  if err := UnzipWithOptions(reader, dir, UnzipOptions{}); err != nil {
+    t.Fatal("Unable to unzip:", err)
+  }
+  for _, p := range []string{"café.sdf", "meshes/box.dae"} {
+    if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+      t.Fatal("Missing extracted file:", p, err)
+    }
+  }
+
+  err := UnzipWithOptions(newArchive(`..\..\evil`), dir, UnzipOptions{})
+  if unzipErr, ok := err.(*UnzipError); !ok || unzipErr.Entry != `..\..\evil` {
+    t.Fatal("Backslash traversals should be rejected:", err)
+  }
+}
+
+// TestUnzipReader tests extracting archives from readers.
+func TestUnzipReader(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "unzip")
+  defer os.RemoveAll(dir)
+
+  buf, _ := newZip(map[string]string{"world.sdf": "<world/>"})
+  data := buf.Bytes()
+  if err := UnzipReader(bytes.NewReader(data), dir, UnzipOptions{}); err != nil {
+    t.Fatal("Unable to unzip:", err)
+  }
+  if contents, _ := ioutil.ReadFile(filepath.Join(dir, "world.sdf"));
+     string(contents) != "<world/>" {
+    t.Fatal("Unexpected file contents:", string(contents))
+  }
+
+  err := UnzipReader(bytes.NewReader(data), dir, UnzipOptions{MaxSize: 10})
+  if _, ok := err.(*UnzipError); !ok {
+    t.Fatal("Large archives should be rejected:", err)
+  }
+  err = UnzipStream(bytes.NewReader([]byte("not a zip")), 9, dir, UnzipOptions{})
+  if _, ok := err.(*UnzipError); !ok {
+    t.Fatal("Invalid archives should be rejected:", err)
+  }
+}
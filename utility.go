@@ -2,12 +2,7 @@ package ign
 
 import (
   "net/http"
-  "github.com/dgrijalva/jwt-go"
-  "archive/zip"
-  "bytes"
   "errors"
-  "fmt"
-  "io"
   "math/rand"
   "os"
   "path/filepath"
@@ -20,20 +15,14 @@ import (
 )
 
 // GetUserIdentity returns the user identity found in the http request's JWT
-// token.
+// token. See IdentityFromRequest to get the other claims.
 func GetUserIdentity(r *http.Request) (identity string, ok bool) {
   // We use the claimed subject contained in the JWT as the ID.
-  jwtUser := r.Context().Value("user")
-  if jwtUser == nil {
-    return
+  id, ok := IdentityFromRequest(r)
+  if !ok || id.Subject == "" {
+    return "", false
   }
-  var sub interface {}
-  sub, ok = jwtUser.(*jwt.Token).Claims.(jwt.MapClaims)["sub"]
-  if !ok {
-    return
-  }
-  identity, ok = sub.(string)
-  return
+  return id.Subject, true
 }
 
 // ReadEnvVar reads an environment variable and return an error if not present
@@ -45,76 +34,72 @@ func ReadEnvVar(name string) (string, error) {
   return value, nil
 }
 
-// Unzip a memory buffer
-func Unzip(buff bytes.Buffer, size int64, dest string, verbose bool) error {
-  reader, err := zip.NewReader(bytes.NewReader(buff.Bytes()), size)
-  if err != nil {
-    return errors.New("unzip: Unable to read byte buffer")
-  }
-  return UnzipImpl(reader, dest, verbose)
+// maxStackDepth is the max number of frames returned by Stack.
+const maxStackDepth = 32
+
+// Frame is a function call of a stack trace.
+type Frame struct {
+  File string `json:"file"`
+  Line int `json:"line"`
+  Function string `json:"function"`
 }
 
-// UnzipFile extracts a compressed .zip file
-func UnzipFile(zipfile string, dest string, verbose bool) error {
-  reader, err := zip.OpenReader(zipfile)
-  if err != nil {
-    return errors.New("unzip: Unable to open [" + zipfile + "]")
+// String returns the base name of the file, line and function name of the
+// frame, as in "router.go:10 pkg.F".
+func (f Frame) String() string {
+  return filepath.Base(f.File) + ":" + strconv.Itoa(f.Line) + " " + f.Function
+}
+
+// Trace returns the filename, line and function name of the caller of the
+// function that calls Trace. Functions called through wrappers can skip
+// additional frames, eg. Trace(1) from a logging helper.
+// Ref: http://stackoverflow.com/questions/25927660/golang-get-current-scope-of-function-name
+func Trace(skip ...int) (string) {
+  frames := callerFrames(2 + sumSkip(skip), 1)
+  if len(frames) == 0 {
+    return "unknown"
   }
-  defer reader.Close()
-  return UnzipImpl(&reader.Reader, dest, verbose)
+  return frames[0].String()
 }
 
-// UnzipImpl is a helper unzip implementation
-func UnzipImpl(reader *zip.Reader, dest string, verbose bool) error {
-  for _, f := range reader.File {
-    zipped, err := f.Open()
-    if err != nil {
-      return errors.New("unzip: Unable to open [" + f.Name + "]")
-    }
+// Stack returns the call stack of its caller, starting with the caller, up
+// to 32 frames. Additional frames can be skipped, eg. Stack(1) from a
+// helper function.
+func Stack(skip ...int) []Frame {
+  return callerFrames(1 + sumSkip(skip), maxStackDepth)
+}
 
-    defer zipped.Close()
-
-    path := filepath.Join(dest, f.Name)
-    if f.FileInfo().IsDir() {
-      os.MkdirAll(path, f.Mode())
-      if verbose {
-        fmt.Println("Creating directory", path)
-      }
-    } else {
-      // Ensure we create the parent folder
-      err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
-      if err != nil {
-        return errors.New("unzip: Unable to create parent folder [" + path + "]")
-      }
-
-      writer, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, f.Mode())
-      if err != nil {
-        return errors.New("unzip: Unable to create [" + path + "]")
-      }
-
-      defer writer.Close()
-
-      if _, err = io.Copy(writer, zipped); err != nil {
-        return errors.New("unzip: Unable to create content in [" + path + "]")
-      }
-
-      if verbose {
-        fmt.Println("Decompressing : ", path)
-      }
+// sumSkip returns the frames to skip given to Trace and Stack.
+func sumSkip(skip []int) int {
+  total := 0
+  for _, s := range skip {
+    if s > 0 {
+      total += s
     }
   }
-  return nil
+  return total
 }
 
-// Trace returns the filename, line and function name of its caller.
-// Ref: http://stackoverflow.com/questions/25927660/golang-get-current-scope-of-function-name
-func Trace() (string) {
-  // At least one entry needed
-  pc := make([]uintptr, 10)
-  runtime.Callers(3, pc)
-  f := runtime.FuncForPC(pc[0])
-  file, line := f.FileLine(pc[0])
-  return filepath.Base(file) + ":" + strconv.Itoa(line) + " " + f.Name()
+// callerFrames returns up to max frames of the stack of the calling
+// function. A skip of 0 starts with the calling function, 1 with its caller,
+// and so on.
+func callerFrames(skip, max int) []Frame {
+  pc := make([]uintptr, max)
+  // Skip runtime.Callers and callerFrames
+  n := runtime.Callers(skip + 2, pc)
+  if n == 0 {
+    return nil
+  }
+  frames := make([]Frame, 0, n)
+  iter := runtime.CallersFrames(pc[:n])
+  for {
+    f, more := iter.Next()
+    frames = append(frames, Frame{File: f.File, Line: f.Line, Function: f.Function})
+    if !more || len(frames) == max {
+      break
+    }
+  }
+  return frames
 }
 
 // RandomString creates a random string of a given length.
@@ -154,6 +139,7 @@ func Max(x, y int64) int64 {
 // The input string contains tags separated with commas.
 // E.g. input string: " tag1, tag2,  tag3 ,   , "
 // E.g. output: ["tag1", "tag2", "tag3"]
+// See ParseTagList, which also removes duplicates and validates the tags.
 func StrToSlice(tagsStr string) ([]string) {
   if tagsStr == "" {
     return nil
@@ -199,3 +185,84 @@ func SameElements(a0, b0 []string) bool {
   }
   return true
 }
+
+// StrSliceContains returns true if the given string slice contains the value.
+func StrSliceContains(slice []string, value string) bool {
+  return Contains(slice, value)
+}
+
+// Contains returns true if the given string slice contains the value.
+func Contains(slice []string, value string) bool {
+  for _, s := range slice {
+    if s == value {
+      return true
+    }
+  }
+  return false
+}
+
+// UniqueStrings returns the elements of the slice without duplicates, in the
+// order of their first occurrence.
+func UniqueStrings(slice []string) []string {
+  seen := make(map[string]bool, len(slice))
+  result := make([]string, 0, len(slice))
+  for _, s := range slice {
+    if !seen[s] {
+      seen[s] = true
+      result = append(result, s)
+    }
+  }
+  return result
+}
+
+// Difference returns the elements of a that are not in b, in the order of
+// a. Duplicates of a are kept.
+func Difference(a, b []string) []string {
+  exclude := stringSet(b)
+  result := make([]string, 0, len(a))
+  for _, s := range a {
+    if !exclude[s] {
+      result = append(result, s)
+    }
+  }
+  return result
+}
+
+// Intersect returns the elements that are both in a and b, without
+// duplicates, in the order of a.
+func Intersect(a, b []string) []string {
+  include := stringSet(b)
+  result := make([]string, 0)
+  for _, s := range UniqueStrings(a) {
+    if include[s] {
+      result = append(result, s)
+    }
+  }
+  return result
+}
+
+// Chunk splits the slice into consecutive chunks of the given size (eg. to
+// limit the size of IN queries). The last chunk can be smaller. The chunks
+// share the memory of the slice. It returns nil if size is not positive.
+func Chunk(slice []string, size int) [][]string {
+  if size <= 0 {
+    return nil
+  }
+  chunks := make([][]string, 0, (len(slice) + size - 1) / size)
+  for size < len(slice) {
+    slice, chunks = slice[size:], append(chunks, slice[:size:size])
+  }
+  if len(slice) > 0 {
+    chunks = append(chunks, slice)
+  }
+  return chunks
+}
+
+// stringSet returns a set with the elements of the slice.
+func stringSet(slice []string) map[string]bool {
+  set := make(map[string]bool, len(slice))
+  for _, s := range slice {
+    set[s] = true
+  }
+  return set
+}
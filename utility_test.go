@@ -1,6 +1,7 @@
 package ign
 
 import (
+  "reflect"
   "strings"
   "testing"
 )
@@ -30,3 +31,65 @@ func TestStrToSlice(t *testing.T) {
     }
   }
 }
+
+// traceHelper returns the trace of its caller, as logging wrappers do.
+func traceHelper() string {
+  return Trace()
+}
+
+// wrappedTraceHelper calls Trace through traceWrapper.
+func wrappedTraceHelper() string {
+  return traceWrapper()
+}
+
+// traceWrapper is a wrapper of Trace that skips one more frame.
+func traceWrapper() string {
+  return Trace(1)
+}
+
+// TestTrace tests the caller reported by Trace and Stack.
+func TestTrace(t *testing.T) {
+  if trace := traceHelper(); !strings.HasPrefix(trace, "utility_test.go:") ||
+     !strings.HasSuffix(trace, ".TestTrace") {
+    t.Fatal("Unexpected trace:", trace)
+  }
+  if trace := wrappedTraceHelper(); !strings.HasSuffix(trace, ".TestTrace") {
+    t.Fatal("Skipped frames should be ignored:", trace)
+  }
+
+  frames := Stack()
+  if len(frames) == 0 || !strings.HasSuffix(frames[0].Function, ".TestTrace") ||
+     frames[0].Line == 0 || !strings.HasSuffix(frames[0].File, "utility_test.go") {
+    t.Fatal("Unexpected stack:", frames)
+  }
+  if skipped := Stack(1); skipped[0] != frames[1] {
+    t.Fatal("Unexpected skipped stack:", skipped[0], frames[1])
+  }
+}
+
+// TestSliceHelpers tests the string slice helpers.
+func TestSliceHelpers(t *testing.T) {
+  a := []string{"x", "y", "x", "z"}
+  b := []string{"z", "w", "x"}
+
+  if got := UniqueStrings(a); !reflect.DeepEqual(got, []string{"x", "y", "z"}) {
+    t.Error("Unexpected unique strings:", got)
+  }
+  if got := Difference(a, b); !reflect.DeepEqual(got, []string{"y"}) {
+    t.Error("Unexpected difference:", got)
+  }
+  if got := Intersect(a, b); !reflect.DeepEqual(got, []string{"x", "z"}) {
+    t.Error("Unexpected intersection:", got)
+  }
+  if !Contains(a, "y") || Contains(a, "w") || Contains(nil, "") {
+    t.Error("Unexpected Contains result")
+  }
+
+  chunks := Chunk([]string{"1", "2", "3", "4", "5"}, 2)
+  if !reflect.DeepEqual(chunks, [][]string{{"1", "2"}, {"3", "4"}, {"5"}}) {
+    t.Error("Unexpected chunks:", chunks)
+  }
+  if len(Chunk(nil, 2)) != 0 || Chunk(a, 0) != nil {
+    t.Error("Unexpected chunks of empty slices or sizes")
+  }
+}
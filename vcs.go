@@ -0,0 +1,224 @@
+package ign
+
+import (
+  "archive/zip"
+  "context"
+  "io"
+  "time"
+  "github.com/go-git/go-git/v5"
+  "github.com/go-git/go-git/v5/plumbing"
+  "github.com/go-git/go-git/v5/plumbing/filemode"
+  "github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// VCS is the interface used to manage the version control repository of a
+// resource, such as a model. Errors are returned as ErrMsg so handlers can
+// reply them directly: ErrorCreatingRepo if the repository can't be
+// created, ErrorNonExistentResource if a revision doesn't exist, and
+// ErrorRepo for other failures, including a canceled context.
+type VCS interface {
+  // InitRepo creates an empty repository.
+  InitRepo(ctx context.Context) *ErrMsg
+  // Commit adds all the files of the working directory and commits them.
+  // It returns the hash of the new commit.
+  Commit(ctx context.Context, author, message string) (string, *ErrMsg)
+  // Tag creates a tag pointing to the current commit.
+  Tag(ctx context.Context, tag string) *ErrMsg
+  // Walk calls fn for each file (and folder, if includeFolders is true) of
+  // the given revision, in lexical order. An empty rev means HEAD.
+  Walk(ctx context.Context, rev string, includeFolders bool,
+       fn VCSWalkFunc) *ErrMsg
+  // Zip writes a zip archive of the files of the given revision.
+  Zip(ctx context.Context, rev string, w io.Writer) *ErrMsg
+}
+
+// VCSWalkFunc is called by VCS.Walk for each file or folder. Paths are
+// slash separated and relative to the repository root. Returning an error
+// stops the walk.
+type VCSWalkFunc func(path string, isDir bool) error
+
+/////////////////////////////////////////////////
+
+// GoGitVCS is a VCS implemented with go-git, storing the repository in a
+// local directory.
+type GoGitVCS struct {
+  // Dir is the working directory of the repository.
+  Dir string
+}
+
+// NewGoGitVCS creates a GoGitVCS for the repository in the given directory.
+func NewGoGitVCS(dir string) *GoGitVCS {
+  return &GoGitVCS{Dir: dir}
+}
+
+// InitRepo creates an empty, non-bare, repository in Dir.
+func (v *GoGitVCS) InitRepo(ctx context.Context) *ErrMsg {
+  if em := vcsCanceled(ctx); em != nil {
+    return em
+  }
+  if _, err := git.PlainInit(v.Dir, false); err != nil {
+    return NewErrorMessageWithBase(ErrorCreatingRepo, err)
+  }
+  return nil
+}
+
+// Commit adds all the files, including removals, and commits them.
+func (v *GoGitVCS) Commit(ctx context.Context, author,
+                          message string) (string, *ErrMsg) {
+  repo, em := v.open(ctx)
+  if em != nil {
+    return "", em
+  }
+  wt, err := repo.Worktree()
+  if err != nil {
+    return "", NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  if err := wt.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+    return "", NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  if em := vcsCanceled(ctx); em != nil {
+    return "", em
+  }
+  hash, err := wt.Commit(message, &git.CommitOptions{
+    All: true,
+    Author: &object.Signature{Name: author, When: time.Now()},
+  })
+  if err != nil {
+    return "", NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  return hash.String(), nil
+}
+
+// Tag creates a lightweight tag pointing to HEAD.
+func (v *GoGitVCS) Tag(ctx context.Context, tag string) *ErrMsg {
+  repo, em := v.open(ctx)
+  if em != nil {
+    return em
+  }
+  head, err := repo.Head()
+  if err != nil {
+    return NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  if _, err := repo.CreateTag(tag, head.Hash(), nil); err != nil {
+    return NewErrorMessageWithArgs(ErrorRepo, err, []string{tag})
+  }
+  return nil
+}
+
+// Walk visits the tree of the given revision, which can be a tag, branch
+// or commit hash.
+func (v *GoGitVCS) Walk(ctx context.Context, rev string, includeFolders bool,
+                        fn VCSWalkFunc) *ErrMsg {
+  tree, em := v.tree(ctx, rev)
+  if em != nil {
+    return em
+  }
+  walker := object.NewTreeWalker(tree, true, nil)
+  defer walker.Close()
+  for {
+    if em := vcsCanceled(ctx); em != nil {
+      return em
+    }
+    name, entry, err := walker.Next()
+    if err == io.EOF {
+      return nil
+    }
+    if err != nil {
+      return NewErrorMessageWithBase(ErrorRepo, err)
+    }
+    isDir := entry.Mode == filemode.Dir
+    if isDir && !includeFolders {
+      continue
+    }
+    if err := fn(name, isDir); err != nil {
+      return NewErrorMessageWithBase(ErrorFileTree, err)
+    }
+  }
+}
+
+// Zip writes the files of the given revision, read from the repository
+// objects, so the working directory is not used.
+func (v *GoGitVCS) Zip(ctx context.Context, rev string, w io.Writer) *ErrMsg {
+  tree, em := v.tree(ctx, rev)
+  if em != nil {
+    return em
+  }
+  zw := zip.NewWriter(w)
+  err := tree.Files().ForEach(func(f *object.File) error {
+    if err := ctx.Err(); err != nil {
+      return err
+    }
+    writer, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name,
+                                                   Method: zip.Deflate})
+    if err != nil {
+      return err
+    }
+    reader, err := f.Reader()
+    if err != nil {
+      return err
+    }
+    defer reader.Close()
+    _, err = io.Copy(writer, reader)
+    return err
+  })
+  if err == nil {
+    err = zw.Close()
+  }
+  if err != nil {
+    return NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  return nil
+}
+
+// open opens the repository in Dir, unless the context is canceled.
+func (v *GoGitVCS) open(ctx context.Context) (*git.Repository, *ErrMsg) {
+  if em := vcsCanceled(ctx); em != nil {
+    return nil, em
+  }
+  repo, err := git.PlainOpen(v.Dir)
+  if err != nil {
+    return nil, NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  return repo, nil
+}
+
+// tree returns the tree of the given revision. An empty rev means HEAD.
+func (v *GoGitVCS) tree(ctx context.Context,
+                        rev string) (*object.Tree, *ErrMsg) {
+  repo, em := v.open(ctx)
+  if em != nil {
+    return nil, em
+  }
+  if rev == "" {
+    rev = "HEAD"
+  }
+  // Tags are looked up first, since versions such as "1" are also valid
+  // abbreviated hashes.
+  hash, err := repo.ResolveRevision(plumbing.Revision("refs/tags/" + rev))
+  if err != nil {
+    hash, err = repo.ResolveRevision(plumbing.Revision(rev))
+  }
+  if err != nil {
+    return nil, NewErrorMessageWithArgs(ErrorNonExistentResource, err,
+                                        []string{rev})
+  }
+  commit, err := repo.CommitObject(*hash)
+  if err != nil {
+    return nil, NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  tree, err := commit.Tree()
+  if err != nil {
+    return nil, NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  return tree, nil
+}
+
+// vcsCanceled returns an ErrorRepo if the context is canceled. go-git
+// operations on local repositories don't take a context, so it is checked
+// between steps.
+func vcsCanceled(ctx context.Context) *ErrMsg {
+  if err := ctx.Err(); err != nil {
+    return NewErrorMessageWithBase(ErrorRepo, err)
+  }
+  return nil
+}
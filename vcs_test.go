@@ -0,0 +1,102 @@
+package ign
+
+import (
+  "archive/zip"
+  "bytes"
+  "context"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "testing"
+)
+
+// TestGoGitVCS tests committing, tagging, walking and zipping revisions.
+func TestGoGitVCS(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "vcs")
+  defer os.RemoveAll(dir)
+  ctx := context.Background()
+  v := NewGoGitVCS(dir)
+
+  if em := v.InitRepo(ctx); em != nil {
+    t.Fatal("Unable to init the repo:", em)
+  }
+  if em := v.InitRepo(ctx); em == nil || em.ErrCode != ErrorCreatingRepo {
+    t.Fatal("Init of an existing repo should fail:", em)
+  }
+
+  os.MkdirAll(filepath.Join(dir, "meshes"), 0755)
+  ioutil.WriteFile(filepath.Join(dir, "model.sdf"), []byte("v1"), 0644)
+  ioutil.WriteFile(filepath.Join(dir, "meshes", "box.dae"), []byte("box"), 0644)
+  hash, em := v.Commit(ctx, "alice", "First version")
+  if em != nil || len(hash) != 40 {
+    t.Fatal("Unable to commit:", hash, em)
+  }
+  if em := v.Tag(ctx, "1"); em != nil {
+    t.Fatal("Unable to tag:", em)
+  }
+
+  ioutil.WriteFile(filepath.Join(dir, "model.sdf"), []byte("v2"), 0644)
+  os.Remove(filepath.Join(dir, "meshes", "box.dae"))
+  if _, em := v.Commit(ctx, "alice", "Second version"); em != nil {
+    t.Fatal("Unable to commit:", em)
+  }
+
+  walk := func(rev string, includeFolders bool) []string {
+    paths := []string{}
+    em := v.Walk(ctx, rev, includeFolders, func(path string, isDir bool) error {
+      if isDir {
+        path += "/"
+      }
+      paths = append(paths, path)
+      return nil
+    })
+    if em != nil {
+      t.Fatal("Unable to walk", rev, em)
+    }
+    return paths
+  }
+  if paths := walk("1", true); !reflect.DeepEqual(paths,
+                                 []string{"meshes/", "meshes/box.dae", "model.sdf"}) {
+    t.Fatal("Unexpected paths of tag 1:", paths)
+  }
+  if paths := walk("", false); !reflect.DeepEqual(paths, []string{"model.sdf"}) {
+    t.Fatal("Unexpected paths of HEAD:", paths)
+  }
+  if em := v.Walk(ctx, "none", false, nil); em == nil ||
+     em.ErrCode != ErrorNonExistentResource {
+    t.Fatal("Unknown revisions should fail:", em)
+  }
+
+  var buf bytes.Buffer
+  if em := v.Zip(ctx, "1", &buf); em != nil {
+    t.Fatal("Unable to zip:", em)
+  }
+  zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+  if err != nil {
+    t.Fatal("Invalid zip:", err)
+  }
+  contents := map[string]string{}
+  for _, f := range zr.File {
+    rc, _ := f.Open()
+    data, _ := ioutil.ReadAll(rc)
+    rc.Close()
+    contents[f.Name] = string(data)
+  }
+  if !reflect.DeepEqual(contents, map[string]string{"model.sdf": "v1",
+                                                    "meshes/box.dae": "box"}) {
+    t.Fatal("Unexpected zip contents:", contents)
+  }
+
+  if em := NewGoGitVCS(filepath.Join(dir, "none")).Tag(ctx, "1");
+     em == nil || em.ErrCode != ErrorRepo {
+    t.Fatal("Missing repos should fail:", em)
+  }
+
+  canceled, cancel := context.WithCancel(ctx)
+  cancel()
+  if em := v.Zip(canceled, "1", ioutil.Discard); em == nil ||
+     em.ErrCode != ErrorRepo {
+    t.Fatal("Canceled contexts should stop the operations:", em)
+  }
+}
@@ -0,0 +1,98 @@
+package ign
+
+import (
+  "net/http"
+)
+
+// API versions: routes that declare Versions are mounted once per version,
+// under /{version}. Eg. a route with URI "/models" and Versions
+// []string{"1.0", "2.0"} serves "/1.0/models" and "/2.0/models".
+// Requests without a version prefix can be redirected to a default version,
+// and responses of deprecated versions include a "Deprecation" header.
+
+// expandVersions returns the given routes, replacing each route that
+// declares Versions by a route per version.
+func expandVersions(routes Routes, opts RouterOptions) Routes {
+  deprecated := map[string]bool{}
+  for _, v := range opts.DeprecatedVersions {
+    deprecated[v] = true
+  }
+
+  var result Routes
+  for _, route := range routes {
+    if len(route.Versions) == 0 {
+      result = append(result, route)
+      continue
+    }
+    for _, version := range route.Versions {
+      r := route
+      r.URI = "/" + version + route.URI
+      r.Versions = nil
+      r.Methods = versionMethods(route.Methods, deprecated[version])
+      r.SecureMethods = SecureMethods(
+        versionMethods(Methods(route.SecureMethods), deprecated[version]))
+      result = append(result, r)
+    }
+  }
+  return result
+}
+
+// versionMethods copies the given methods. Handlers of deprecated versions
+// are wrapped to add the Deprecation header.
+func versionMethods(methods Methods, deprecated bool) Methods {
+  result := make(Methods, len(methods))
+  for i, m := range methods {
+    result[i] = m
+    result[i].Handlers = make(FormatHandlers, len(m.Handlers))
+    for j, fh := range m.Handlers {
+      if deprecated {
+        fh.Handler = deprecationHandler(fh.Handler)
+      }
+      result[i].Handlers[j] = fh
+    }
+  }
+  return result
+}
+
+// deprecationHandler adds the Deprecation header to the responses of the
+// given handler.
+func deprecationHandler(inner http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Deprecation", "true")
+    inner.ServeHTTP(w, r)
+  })
+}
+
+// defaultVersionURIs returns the unversioned URIs (one per format
+// extension) of a route that declares the default version.
+func defaultVersionURIs(route Route, defaultVersion string) []string {
+  if !StrSliceContains(route.Versions, defaultVersion) {
+    return nil
+  }
+
+  var uris []string
+  seen := map[string]bool{}
+  for _, methods := range []Methods{route.Methods, Methods(route.SecureMethods)} {
+    for _, m := range methods {
+      for _, fh := range m.Handlers {
+        if uri := route.URI + fh.Extension; !seen[uri] {
+          seen[uri] = true
+          uris = append(uris, uri)
+        }
+      }
+    }
+  }
+  return uris
+}
+
+// defaultVersionRedirect redirects requests to the same URI under the given
+// version. It uses 307 to keep the request method and body.
+func defaultVersionRedirect(version string) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    target := "/" + version + r.URL.Path
+    if r.URL.RawQuery != "" {
+      target += "?" + r.URL.RawQuery
+    }
+    http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+  })
+}
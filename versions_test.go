@@ -0,0 +1,65 @@
+package ign
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "github.com/gorilla/mux"
+)
+
+// versionTestRoutes returns a route served in versions 1.0 and 2.0.
+func versionTestRoutes() Routes {
+  ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+  return Routes{
+    Route{
+      Name: "models",
+      URI: "/models",
+      Versions: []string{"1.0", "2.0"},
+      Methods: Methods{
+        Method{Type: "GET", Handlers: FormatHandlers{{"", ok}, {".proto", ok}}},
+      },
+    },
+  }
+}
+
+// TestVersionedRoutes tests mounting routes under each version.
+func TestVersionedRoutes(t *testing.T) {
+  router := NewRouterWithOptions(versionTestRoutes(),
+                                 RouterOptions{DefaultVersion: "2.0"})
+
+  for _, uri := range []string{"/1.0/models", "/2.0/models.proto"} {
+    req, _ := http.NewRequest("GET", uri, nil)
+    var match mux.RouteMatch
+    if !router.Match(req, &match) || match.Route.GetName() == "" {
+      t.Fatal("Versioned route not found:", uri)
+    }
+  }
+
+  req, _ := http.NewRequest("GET", "/models.proto?page=2", nil)
+  rec := httptest.NewRecorder()
+  router.ServeHTTP(rec, req)
+  if rec.Code != http.StatusTemporaryRedirect ||
+     rec.Header().Get("Location") != "/2.0/models.proto?page=2" {
+    t.Fatal("Expected a redirect to the default version. Got:", rec.Code,
+            rec.Header().Get("Location"))
+  }
+}
+
+// TestDeprecatedVersions tests the Deprecation header.
+func TestDeprecatedVersions(t *testing.T) {
+  routes := expandVersions(versionTestRoutes(),
+                           RouterOptions{DeprecatedVersions: []string{"1.0"}})
+  if len(routes) != 2 || routes[0].URI != "/1.0/models" ||
+     routes[1].URI != "/2.0/models" {
+    t.Fatal("Unexpected versioned routes:", routes)
+  }
+
+  for i, exp := range []string{"true", ""} {
+    rec := httptest.NewRecorder()
+    routes[i].Methods[0].Handlers[0].Handler.ServeHTTP(rec, &http.Request{})
+    if rec.Header().Get("Deprecation") != exp {
+      t.Fatal("Unexpected Deprecation header for", routes[i].URI,
+              rec.Header().Get("Deprecation"))
+    }
+  }
+}
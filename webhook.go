@@ -0,0 +1,243 @@
+package ign
+
+import (
+  "bytes"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+  "gorm.io/gorm"
+  "github.com/satori/go.uuid"
+)
+
+// Webhooks notify subscribers of application events (eg. a model was
+// published) with a POST of a JSON payload. Applications register the
+// event names with WebhookDispatcher.RegisterEvent, subscribers are stored
+// in the database, and WebhookDispatcher.Dispatch delivers the event to
+// all its subscribers in the background.
+//
+// Each request includes the following headers:
+//   X-Ign-Event: name of the event.
+//   X-Ign-Delivery: unique ID of the event.
+//   X-Ign-Timestamp: unix time of the request.
+//   X-Ign-Signature: "sha256=" followed by the hex encoded HMAC-SHA256 of
+//     the timestamp, a dot and the body, using the subscription secret.
+// Failed deliveries (network errors, 429 and 5xx responses) are retried
+// with exponential backoff. Every attempt is stored as a WebhookDelivery.
+
+// WebhookSubscription is a subscriber of an event.
+type WebhookSubscription struct {
+  ID uint `gorm:"primaryKey" json:"id"`
+  CreatedAt time.Time `json:"created_at"`
+  Event string `gorm:"size:255;index" json:"event"`
+  URL string `gorm:"size:2048" json:"url"`
+  // Secret used to sign the payloads.
+  Secret string `json:"-"`
+}
+
+// WebhookDelivery is the log of a delivery attempt.
+type WebhookDelivery struct {
+  ID uint `gorm:"primaryKey" json:"id"`
+  CreatedAt time.Time `json:"created_at"`
+  SubscriptionID uint `gorm:"index" json:"subscription_id"`
+  // DeliveryID is the X-Ign-Delivery of the event.
+  DeliveryID string `gorm:"size:64" json:"delivery_id"`
+  Event string `gorm:"size:255" json:"event"`
+  Attempt int `json:"attempt"`
+  // StatusCode of the response. Zero if the request failed.
+  StatusCode int `json:"status_code"`
+  Error string `gorm:"size:1024" json:"error,omitempty"`
+  // Duration of the request, in milliseconds.
+  DurationMs int64 `json:"duration_ms"`
+}
+
+// WebhookPayload is the JSON body sent to subscribers.
+type WebhookPayload struct {
+  ID string `json:"id"`
+  Event string `json:"event"`
+  CreatedAt time.Time `json:"created_at"`
+  Data interface{} `json:"data"`
+}
+
+// WebhookDispatcher delivers events to their subscribers.
+type WebhookDispatcher struct {
+  // Client used to send the requests. Defaults to a client with a 10
+  // seconds timeout and a single attempt, as the dispatcher retries and logs
+  // each attempt.
+  Client *HTTPClient
+  // MaxAttempts is the number of attempts of each delivery. Defaults to 5.
+  MaxAttempts int
+  // RetryDelay is the delay before the first retry. It doubles after each
+  // attempt. Defaults to 5 seconds.
+  RetryDelay time.Duration
+
+  db *gorm.DB
+  events map[string]bool
+  mutex sync.RWMutex
+  pending sync.WaitGroup
+}
+
+// NewWebhookDispatcher creates a WebhookDispatcher that stores the
+// subscriptions and delivery logs in the given database, creating the
+// tables if needed.
+func NewWebhookDispatcher(db *gorm.DB) (*WebhookDispatcher, error) {
+  if err := db.AutoMigrate(&WebhookSubscription{},
+                           &WebhookDelivery{}); err != nil {
+    return nil, err
+  }
+  return &WebhookDispatcher{
+    Client: &HTTPClient{Client: &http.Client{Timeout: 10 * time.Second}},
+    MaxAttempts: 5,
+    RetryDelay: 5 * time.Second,
+    db: db,
+    events: map[string]bool{},
+  }, nil
+}
+
+// RegisterEvent declares an event name that can be subscribed to.
+func (d *WebhookDispatcher) RegisterEvent(name string) {
+  d.mutex.Lock()
+  defer d.mutex.Unlock()
+  d.events[name] = true
+}
+
+// Subscribe stores a subscription of the given URL to an event. It returns
+// an error if the event is not registered.
+func (d *WebhookDispatcher) Subscribe(event, url,
+                                      secret string) (*WebhookSubscription, error) {
+  d.mutex.RLock()
+  known := d.events[event]
+  d.mutex.RUnlock()
+  if !known {
+    return nil, errors.New("Unknown webhook event [" + event + "]")
+  }
+  sub := WebhookSubscription{Event: event, URL: url, Secret: secret}
+  if err := d.db.Create(&sub).Error; err != nil {
+    return nil, err
+  }
+  return &sub, nil
+}
+
+// Unsubscribe removes a subscription.
+func (d *WebhookDispatcher) Unsubscribe(id uint) error {
+  return d.db.Delete(&WebhookSubscription{ID: id}).Error
+}
+
+// Deliveries returns the delivery logs of a subscription, newest first.
+func (d *WebhookDispatcher) Deliveries(subscriptionID uint) ([]WebhookDelivery, error) {
+  var deliveries []WebhookDelivery
+  err := d.db.Where("subscription_id = ?", subscriptionID).
+    Order("id desc").Find(&deliveries).Error
+  return deliveries, err
+}
+
+// Dispatch sends the event, with the given data, to all its subscribers.
+// Deliveries are done in the background. It returns the delivery ID.
+func (d *WebhookDispatcher) Dispatch(event string,
+                                     data interface{}) (string, error) {
+  var subs []WebhookSubscription
+  if err := d.db.Where("event = ?", event).Find(&subs).Error; err != nil {
+    return "", err
+  }
+  payload := WebhookPayload{
+    ID: uuid.Must(uuid.NewV4()).String(),
+    Event: event,
+    CreatedAt: time.Now().UTC(),
+    Data: data,
+  }
+  body, err := json.Marshal(payload)
+  if err != nil {
+    return "", err
+  }
+  for _, sub := range subs {
+    d.pending.Add(1)
+    go func(sub WebhookSubscription) {
+      defer d.pending.Done()
+      d.deliver(sub, payload.ID, body)
+    }(sub)
+  }
+  return payload.ID, nil
+}
+
+// Wait blocks until the pending deliveries finish.
+func (d *WebhookDispatcher) Wait() {
+  d.pending.Wait()
+}
+
+// deliver sends the body to a subscriber, retrying failed attempts.
+func (d *WebhookDispatcher) deliver(sub WebhookSubscription, deliveryID string,
+                                    body []byte) {
+  delay := d.RetryDelay
+  for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+    status, err := d.send(sub, deliveryID, body, attempt)
+    if err == nil && status >= 200 && status < 300 {
+      return
+    }
+    // Other client errors won't be fixed by retrying.
+    if err == nil && status < 500 && status != http.StatusTooManyRequests {
+      break
+    }
+    if attempt < d.MaxAttempts {
+      time.Sleep(delay)
+      delay *= 2
+    }
+  }
+  gLogger.Error("Webhook delivery failed", Fields{"event": sub.Event,
+    "url": sub.URL, "delivery": deliveryID})
+}
+
+// send makes a delivery attempt and stores its log.
+func (d *WebhookDispatcher) send(sub WebhookSubscription, deliveryID string,
+                                 body []byte, attempt int) (int, error) {
+  start := time.Now()
+  log := WebhookDelivery{SubscriptionID: sub.ID, DeliveryID: deliveryID,
+                         Event: sub.Event, Attempt: attempt}
+
+  status, err := d.post(sub, deliveryID, body)
+  log.StatusCode = status
+  log.DurationMs = int64(time.Since(start) / time.Millisecond)
+  if err != nil {
+    log.Error = err.Error()
+  }
+  if dbErr := d.db.Create(&log).Error; dbErr != nil {
+    gLogger.Error("Unable to store webhook delivery", Fields{"error": dbErr})
+  }
+  return status, err
+}
+
+// post sends the signed request.
+func (d *WebhookDispatcher) post(sub WebhookSubscription, deliveryID string,
+                                 body []byte) (int, error) {
+  req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+  if err != nil {
+    return 0, err
+  }
+  timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("X-Ign-Event", sub.Event)
+  req.Header.Set("X-Ign-Delivery", deliveryID)
+  req.Header.Set("X-Ign-Timestamp", timestamp)
+  req.Header.Set("X-Ign-Signature",
+                 "sha256=" + WebhookSignature(sub.Secret, timestamp, body))
+
+  resp, err := d.Client.Do(req)
+  if err != nil {
+    return 0, err
+  }
+  resp.Body.Close()
+  return resp.StatusCode, nil
+}
+
+// WebhookSignature returns the hex encoded signature of a webhook request,
+// so subscribers can validate the X-Ign-Signature header.
+func WebhookSignature(secret, timestamp string, body []byte) string {
+  mac := hmac.New(sha256.New, []byte(secret))
+  mac.Write([]byte(timestamp + "."))
+  mac.Write(body)
+  return hex.EncodeToString(mac.Sum(nil))
+}
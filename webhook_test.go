@@ -0,0 +1,85 @@
+package ign
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "sync/atomic"
+  "testing"
+  "time"
+)
+
+// TestWebhookDispatcher tests delivering signed events with retries.
+func TestWebhookDispatcher(t *testing.T) {
+  db := newListItemsDB(t)
+  defer sqlDB(db).Close()
+  sqlDB(db).SetMaxOpenConns(1)
+
+  var calls int32
+  received := make(chan WebhookPayload, 1)
+  ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+                                                 r *http.Request) {
+    body, _ := ioutil.ReadAll(r.Body)
+    expected := "sha256=" + WebhookSignature("secret",
+                                             r.Header.Get("X-Ign-Timestamp"), body)
+    if r.Header.Get("X-Ign-Signature") != expected {
+      w.WriteHeader(http.StatusUnauthorized)
+      return
+    }
+    // Fail the first attempt
+    if atomic.AddInt32(&calls, 1) == 1 {
+      w.WriteHeader(http.StatusServiceUnavailable)
+      return
+    }
+    var payload WebhookPayload
+    json.Unmarshal(body, &payload)
+    received <- payload
+  }))
+  defer ts.Close()
+
+  d, err := NewWebhookDispatcher(db)
+  if err != nil {
+    t.Fatal("Unable to create the dispatcher:", err)
+  }
+  d.RetryDelay = time.Millisecond
+  if _, err := d.Subscribe("model.published", ts.URL, "secret"); err == nil {
+    t.Fatal("Unknown events should be rejected")
+  }
+  d.RegisterEvent("model.published")
+  sub, err := d.Subscribe("model.published", ts.URL, "secret")
+  if err != nil {
+    t.Fatal("Unable to subscribe:", err)
+  }
+  bad, _ := d.Subscribe("model.published", ts.URL, "wrong")
+
+  id, err := d.Dispatch("model.published", map[string]string{"name": "box"})
+  if err != nil {
+    t.Fatal("Unable to dispatch:", err)
+  }
+  d.Wait()
+
+  payload := <-received
+  if payload.ID != id || payload.Event != "model.published" ||
+     payload.Data.(map[string]interface{})["name"] != "box" {
+    t.Fatal("Unexpected payload:", payload)
+  }
+  logs, _ := d.Deliveries(sub.ID)
+  if len(logs) != 2 || logs[0].StatusCode != http.StatusOK ||
+     logs[1].StatusCode != http.StatusServiceUnavailable {
+    t.Fatal("Unexpected delivery logs:", logs)
+  }
+  // Client errors are not retried
+  if logs, _ := d.Deliveries(bad.ID); len(logs) != 1 ||
+     logs[0].StatusCode != http.StatusUnauthorized {
+    t.Fatal("Unexpected delivery logs:", logs)
+  }
+
+  d.Unsubscribe(bad.ID)
+  d.Unsubscribe(sub.ID)
+  d.Dispatch("model.published", nil)
+  d.Wait()
+  if n := atomic.LoadInt32(&calls); n != 2 {
+    t.Fatal("Unexpected number of deliveries:", n)
+  }
+}
@@ -0,0 +1,77 @@
+package ign
+
+import (
+  "net/http"
+  "net/url"
+  "strings"
+  "github.com/auth0/go-jwt-middleware"
+  "github.com/gorilla/websocket"
+)
+
+// WebSocket routes use a WebSocketHandler as a FormatHandler Handler. The
+// handshake request goes through the same middleware chain as other routes,
+// so routes defined in SecureMethods require a valid JWT, and routes in
+// Methods accept an optional JWT. Since browsers can't set headers on
+// WebSocket handshakes, the JWT can also be sent in the access_token query
+// parameter. That parameter is removed from the URLs of the access log, the
+// analytics events and the traces.
+
+// WebSocketUpgrader is used to upgrade WebSocket handshake requests. By
+// default only same origin requests are accepted; applications can set
+// CheckOrigin to accept other origins.
+var WebSocketUpgrader = websocket.Upgrader{
+  ReadBufferSize: 1024,
+  WriteBufferSize: 1024,
+}
+
+// WebSocketHandler is a route handler that upgrades the request to a
+// WebSocket connection. The identity is the JWT subject of the handshake
+// request, or empty if the request was not authenticated. The connection is
+// closed when the handler returns.
+type WebSocketHandler func(conn *websocket.Conn, r *http.Request, identity string)
+
+/////////////////////////////////////////////////
+func (fn WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  identity, _ := GetUserIdentity(r)
+
+  // Upgrade replies with an HTTP error on failure.
+  conn, err := WebSocketUpgrader.Upgrade(w, r, nil)
+  if err != nil {
+    gLogger.Warn("WebSocket upgrade failed", Fields{"error": err})
+    return
+  }
+  defer conn.Close()
+
+  fn(conn, r, identity)
+}
+
+// jwtTokenExtractor gets the JWT from the Authorization header, or from the
+// access_token query parameter on WebSocket handshakes.
+func jwtTokenExtractor(r *http.Request) (string, error) {
+  token, err := jwtmiddleware.FromAuthHeader(r)
+  if err != nil || token != "" {
+    return token, err
+  }
+  if websocket.IsWebSocketUpgrade(r) {
+    return r.URL.Query().Get("access_token"), nil
+  }
+  return "", nil
+}
+
+// redactAccessToken removes the access_token query parameter from a request
+// URI, so the JWT of WebSocket handshakes is not logged or tracked.
+func redactAccessToken(uri string) string {
+  i := strings.IndexByte(uri, '?')
+  if i < 0 || !strings.Contains(uri[i:], "access_token") {
+    return uri
+  }
+  q, err := url.ParseQuery(uri[i + 1:])
+  if err != nil {
+    return uri[:i]
+  }
+  q.Del("access_token")
+  if len(q) == 0 {
+    return uri[:i]
+  }
+  return uri[:i] + "?" + q.Encode()
+}
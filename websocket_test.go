@@ -0,0 +1,96 @@
+package ign
+
+import (
+  "bytes"
+  "context"
+  "log"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "strings"
+  "testing"
+  "github.com/dgrijalva/jwt-go"
+  "github.com/gorilla/websocket"
+)
+
+// TestWebSocketHandler tests upgrading a connection and getting the
+// identity of the handshake request.
+func TestWebSocketHandler(t *testing.T) {
+  ws := WebSocketHandler(func(conn *websocket.Conn, r *http.Request, identity string) {
+    _, msg, err := conn.ReadMessage()
+    if err != nil {
+      return
+    }
+    conn.WriteMessage(websocket.TextMessage, []byte(identity + ":" + string(msg)))
+  })
+  // Simulate the JWT middleware
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    token := &jwt.Token{Claims: jwt.MapClaims{"sub": "alice"}}
+    ws.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "user", token)))
+  }))
+  defer server.Close()
+
+  url := "ws" + strings.TrimPrefix(server.URL, "http")
+  conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+  if err != nil {
+    t.Fatal("Unable to connect:", err)
+  }
+  defer conn.Close()
+
+  conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+  if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "alice:hello" {
+    t.Fatal("Unexpected message:", string(msg), err)
+  }
+}
+
+// TestJWTTokenExtractor tests reading the JWT from WebSocket handshakes.
+func TestJWTTokenExtractor(t *testing.T) {
+  req, _ := http.NewRequest("GET", "/events?access_token=abc", nil)
+  if token, _ := jwtTokenExtractor(req); token != "" {
+    t.Fatal("Query tokens should only be used in WebSocket handshakes")
+  }
+
+  req.Header.Set("Connection", "Upgrade")
+  req.Header.Set("Upgrade", "websocket")
+  if token, _ := jwtTokenExtractor(req); token != "abc" {
+    t.Fatal("Unexpected WebSocket token:", token)
+  }
+
+  req.Header.Set("Authorization", "Bearer xyz")
+  if token, _ := jwtTokenExtractor(req); token != "xyz" {
+    t.Fatal("The Authorization header should take precedence:", token)
+  }
+}
+
+// TestAccessTokenRedacted tests that the JWT of WebSocket handshakes is not
+// written to the access log.
+func TestAccessTokenRedacted(t *testing.T) {
+  var buf bytes.Buffer
+  log.SetOutput(&buf)
+  defer log.SetOutput(os.Stderr)
+
+  prev := gServer
+  defer func() { gServer = prev }()
+  gServer = &Server{}
+
+  handler := logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+                    "events")
+  req, _ := http.NewRequest("GET", "/events?access_token=secret&since=1", nil)
+  req.RequestURI = "/events?access_token=secret&since=1"
+  handler.ServeHTTP(httptest.NewRecorder(), req)
+  if strings.Contains(buf.String(), "secret") ||
+     !strings.Contains(buf.String(), "/events?since=1") {
+    t.Fatal("The access token should be removed from the access log:",
+            buf.String())
+  }
+
+  for uri, exp := range map[string]string{
+    "/events": "/events",
+    "/events?access_token=secret": "/events",
+    "/events?page=2": "/events?page=2",
+  } {
+    if got := redactAccessToken(uri); got != exp {
+      t.Fatal("Unexpected redacted URI:", uri, got)
+    }
+  }
+}
@@ -0,0 +1,152 @@
+package ign
+
+import (
+  "archive/zip"
+  "bytes"
+  "io"
+  "mime"
+  "net/http"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// ZipEntry is an entry of a zip archive created by ZipFiles.
+type ZipEntry struct {
+  // Path of the entry in the archive. Paths ending with "/" are directories.
+  Path string
+  // Optional path of the file whose contents are archived.
+  SourcePath string
+  // Contents of the entry, used if SourcePath is empty.
+  Contents []byte
+}
+
+// ServeZip streams a zip archive of the rootDir directory tree to the
+// client, as an attachment named name + ".zip". Paths in the archive are
+// relative to rootDir. Symbolic links and other special files are skipped.
+// It returns ErrorZipNotAvailable if rootDir is not a directory. Errors
+// found after the response started are only logged, since the status was
+// already sent.
+func ServeZip(w http.ResponseWriter, rootDir, name string) *ErrMsg {
+  info, err := os.Stat(rootDir)
+  if err != nil || !info.IsDir() {
+    return NewErrorMessageWithBase(ErrorZipNotAvailable, err)
+  }
+
+  writeZipHeaders(w, name)
+  if err := ZipDir(w, rootDir); err != nil {
+    logZipError(err, name)
+  }
+  return nil
+}
+
+// ServeZipEntries streams a zip archive containing the given entries to
+// the client, as an attachment named name + ".zip". Errors are only logged,
+// since the status was already sent. See ZipFiles.
+func ServeZipEntries(w http.ResponseWriter, name string, entries []ZipEntry) {
+  writeZipHeaders(w, name)
+  if err := ZipFiles(w, entries); err != nil {
+    logZipError(err, name)
+  }
+}
+
+// ZipDir writes a zip archive of the srcDir directory tree. Paths in the
+// archive are relative to srcDir, and sorted. Symbolic links and other
+// special files are skipped.
+func ZipDir(w io.Writer, srcDir string) error {
+  zw := zip.NewWriter(w)
+  // Walk visits the files in lexical order, so the output is deterministic
+  err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+    rel, err := filepath.Rel(srcDir, path)
+    if err != nil || rel == "." {
+      return err
+    }
+    if !info.IsDir() && !info.Mode().IsRegular() {
+      return nil
+    }
+    return addZipEntry(zw, filepath.ToSlash(rel), path, info, nil)
+  })
+  if err != nil {
+    return err
+  }
+  return zw.Close()
+}
+
+// ZipFiles writes a zip archive containing the given entries, sorted by
+// path so the output is deterministic.
+func ZipFiles(w io.Writer, entries []ZipEntry) error {
+  sorted := append([]ZipEntry(nil), entries...)
+  sort.SliceStable(sorted, func(i, j int) bool {
+    return sorted[i].Path < sorted[j].Path
+  })
+
+  zw := zip.NewWriter(w)
+  for _, e := range sorted {
+    var info os.FileInfo
+    if e.SourcePath != "" {
+      var err error
+      if info, err = os.Stat(e.SourcePath); err != nil {
+        return err
+      }
+    }
+    if err := addZipEntry(zw, e.Path, e.SourcePath, info, e.Contents); err != nil {
+      return err
+    }
+  }
+  return zw.Close()
+}
+
+// writeZipHeaders writes the headers of a zip attachment.
+func writeZipHeaders(w http.ResponseWriter, name string) {
+  w.Header().Set("Content-Type", "application/zip")
+  w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+                 map[string]string{"filename": name + ".zip"}))
+  w.WriteHeader(http.StatusOK)
+}
+
+// addZipEntry adds a file or directory to the archive. The contents are
+// read from sourcePath if set, or taken from contents.
+func addZipEntry(zw *zip.Writer, path, sourcePath string, info os.FileInfo,
+                 contents []byte) error {
+  header := &zip.FileHeader{Name: path}
+  if info != nil {
+    var err error
+    if header, err = zip.FileInfoHeader(info); err != nil {
+      return err
+    }
+    header.Name = path
+  }
+  if (info != nil && info.IsDir()) || strings.HasSuffix(path, "/") {
+    header.Name = strings.TrimSuffix(path, "/") + "/"
+    header.Method = zip.Store
+    _, err := zw.CreateHeader(header)
+    return err
+  }
+
+  header.Method = zip.Deflate
+  writer, err := zw.CreateHeader(header)
+  if err != nil {
+    return err
+  }
+  if sourcePath == "" {
+    _, err = io.Copy(writer, bytes.NewReader(contents))
+    return err
+  }
+  file, err := os.Open(sourcePath)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  _, err = io.Copy(writer, file)
+  return err
+}
+
+// logZipError logs the error that interrupted a zip download.
+func logZipError(err error, name string) {
+  gLogger.Error("Unable to stream zip archive", Fields{"name": name,
+                                                       "error": err})
+}
@@ -0,0 +1,107 @@
+package ign
+
+import (
+  "archive/zip"
+  "bytes"
+  "io/ioutil"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// readZip returns the contents of the entries of a zip archive.
+func readZip(t *testing.T, data []byte) map[string]string {
+  zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+  if err != nil {
+    t.Fatal("Invalid zip archive:", err)
+  }
+  files := map[string]string{}
+  for _, f := range zr.File {
+    rc, _ := f.Open()
+    contents, _ := ioutil.ReadAll(rc)
+    rc.Close()
+    files[f.Name] = string(contents)
+  }
+  return files
+}
+
+// TestServeZip tests streaming zip archives.
+func TestServeZip(t *testing.T) {
+  dir, _ := ioutil.TempDir("", "zip")
+  defer os.RemoveAll(dir)
+  os.MkdirAll(filepath.Join(dir, "meshes"), 0755)
+  ioutil.WriteFile(filepath.Join(dir, "model.sdf"), []byte("<sdf/>"), 0644)
+  ioutil.WriteFile(filepath.Join(dir, "meshes", "box.dae"), []byte("dae"), 0644)
+
+  rec := httptest.NewRecorder()
+  if em := ServeZip(rec, dir, "box"); em != nil {
+    t.Fatal("Unable to serve the zip:", em)
+  }
+  if rec.Header().Get("Content-Type") != "application/zip" ||
+     rec.Header().Get("Content-Disposition") != `attachment; filename=box.zip` {
+    t.Fatal("Unexpected headers:", rec.Header())
+  }
+  files := readZip(t, rec.Body.Bytes())
+  if len(files) != 3 || files["model.sdf"] != "<sdf/>" ||
+     files["meshes/box.dae"] != "dae" {
+    t.Fatal("Unexpected archive contents:", files)
+  }
+
+  if em := ServeZip(httptest.NewRecorder(), filepath.Join(dir, "none"), "x");
+     em == nil || em.ErrCode != ErrorZipNotAvailable {
+    t.Fatal("Missing directories should fail:", em)
+  }
+
+  rec = httptest.NewRecorder()
+  ServeZipEntries(rec, "box", []ZipEntry{
+    {Path: "docs/"},
+    {Path: "docs/readme.md", Contents: []byte("# Box")},
+    {Path: "model.sdf", SourcePath: filepath.Join(dir, "model.sdf")},
+  })
+  files = readZip(t, rec.Body.Bytes())
+  if len(files) != 3 || files["docs/readme.md"] != "# Box" ||
+     files["model.sdf"] != "<sdf/>" {
+    t.Fatal("Unexpected archive contents:", files)
+  }
+}
+
+// TestZipDeterministic tests that the zip helpers sort their entries.
+func TestZipDeterministic(t *testing.T) {
+  entries := []ZipEntry{
+    {Path: "b.txt", Contents: []byte("b")},
+    {Path: "a/"},
+    {Path: "a/c.txt", Contents: []byte("c")},
+  }
+  var first, second bytes.Buffer
+  if err := ZipFiles(&first, entries); err != nil {
+    t.Fatal("Unable to zip files:", err)
+  }
+  entries[0], entries[2] = entries[2], entries[0]
+  ZipFiles(&second, entries)
+  if !bytes.Equal(first.Bytes(), second.Bytes()) {
+    t.Fatal("Archives should not depend on the entries order")
+  }
+
+  zr, _ := zip.NewReader(bytes.NewReader(first.Bytes()), int64(first.Len()))
+  var names []string
+  for _, f := range zr.File {
+    names = append(names, f.Name)
+  }
+  if strings.Join(names, " ") != "a/ a/c.txt b.txt" {
+    t.Fatal("Unexpected entries order:", names)
+  }
+
+  dir, _ := ioutil.TempDir("", "zipdir")
+  defer os.RemoveAll(dir)
+  ioutil.WriteFile(filepath.Join(dir, "z.txt"), []byte("z"), 0644)
+  ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+  var buf bytes.Buffer
+  if err := ZipDir(&buf, dir); err != nil {
+    t.Fatal("Unable to zip dir:", err)
+  }
+  if files := readZip(t, buf.Bytes()); len(files) != 2 || files["z.txt"] != "z" {
+    t.Fatal("Unexpected archive contents:", files)
+  }
+}